@@ -0,0 +1,6 @@
+// Package slrsv1 is the Go code protoc generates from messages.proto, the
+// board's gRPC API: the message types and a MessageService client and
+// server. Other languages generate theirs from the same file.
+package slrsv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative slrs/v1/messages.proto
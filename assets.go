@@ -0,0 +1,32 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// embedded carries the templates and static assets inside the binary so it
+// runs from any working directory.
+//
+//go:embed templates static
+var embedded embed.FS
+
+// The OpenAPI document is maintained by hand next to the handlers; update
+// it whenever an /api route changes.
+//
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+// docsPage renders the spec with Swagger UI, loaded from a CDN.
+//
+//go:embed api/docs.html
+var docsPage []byte
+
+// assetFS returns dir on disk when set, otherwise the embedded copy of sub.
+func assetFS(dir, sub string) (fs.FS, error) {
+	if dir != "" {
+		return os.DirFS(dir), nil
+	}
+	return fs.Sub(embedded, sub)
+}
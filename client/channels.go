@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Channel is a channel of the board.
+type Channel struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Created     time.Time  `json:"created"`
+	Archived    *time.Time `json:"archived,omitempty"`
+	Private     bool       `json:"private"`
+	Users       []string   `json:"users,omitempty"`
+	Roles       []string   `json:"roles,omitempty"`
+}
+
+// Channels returns the channels the caller may see.
+func (c *Client) Channels(ctx context.Context) ([]Channel, error) {
+	var cs []Channel
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/channels"}, &cs)
+	return cs, err
+}
+
+// Channel returns channel name.
+func (c *Client) Channel(ctx context.Context, name string) (*Channel, error) {
+	var ch Channel
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/channels/" + url.PathEscape(name)}, &ch); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
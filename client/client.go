@@ -0,0 +1,315 @@
+// Package client calls the board's REST API (api/openapi.json) from Go, so
+// that tools need not write their own HTTP code: typed methods for the
+// messages, channels and tokens, an API key or token sent with each call,
+// retries of what can safely be retried, iterators over the pages of a
+// listing and a subscription to the live event stream.
+//
+//	c, err := client.New("https://board.example.com", client.WithAPIKey(os.Getenv("SLRS_API_KEY")))
+//	...
+//	msg, err := c.CreateMessage(ctx, client.NewMessage{Author: "deploy-bot", Content: "v1.4.0 is out"})
+//	for m, err := range c.ListAllMessages(ctx, client.ListOptions{Tag: "release"}) {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries   = 3
+	defaultBackoff   = 500 * time.Millisecond
+	defaultUserAgent = "slrs-go-client"
+)
+
+// Client calls one board. It is safe for concurrent use.
+type Client struct {
+	base      *url.URL
+	http      *http.Client
+	apiKey    string
+	token     string
+	userAgent string
+	retries   int
+	backoff   time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey sends secret as X-API-Key.
+func WithAPIKey(secret string) Option {
+	return func(c *Client) { c.apiKey = secret }
+}
+
+// WithToken sends token, as POST /api/v1/token issues them, as a bearer
+// token. It takes precedence over an API key.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient makes the calls through hc rather than a client of the
+// client's own. hc should have no Timeout, which would cut Subscribe's
+// stream off; give the calls contexts with deadlines instead.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetries sets how many times a failed call is retried, 3 unless
+// given, and the delay before the first retry, which doubles with each
+// one; 0 retries turns them off.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = n, backoff }
+}
+
+// WithUserAgent names the tool in the User-Agent of its calls.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New returns a client for the board at baseURL, e.g.
+// https://board.example.com.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("base URL %q is not an http or https URL", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	c := &Client{
+		base:      u,
+		http:      &http.Client{},
+		userAgent: defaultUserAgent,
+		retries:   defaultRetries,
+		backoff:   defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Error is an error response from the board.
+type Error struct {
+	StatusCode int
+	Message    string // the response's "error"
+	// Fields holds what is wrong with each invalid field of a request
+	// rejected with 422.
+	Fields map[string]string
+	// RetryAfter is how long the board asked the caller to wait, for 429
+	// and 503 responses.
+	RetryAfter time.Duration
+	body       []byte
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	if len(e.Fields) > 0 {
+		var fields []string
+		for f, problem := range e.Fields {
+			fields = append(fields, f+" "+problem)
+		}
+		msg += " (" + strings.Join(fields, "; ") + ")"
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is a 404 from the board.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// request is one API call: method on path with query, and in, if not nil,
+// as the JSON body.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	in     any
+	header http.Header
+}
+
+// do makes the call, retrying it as retryable allows, and decodes a 2xx
+// response into out unless out is nil. Any other status is an *Error.
+func (c *Client) do(ctx context.Context, req request, out any) (*http.Response, error) {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("%s %s: decoding response: %w", req.method, req.path, err)
+		}
+	}
+	return resp, nil
+}
+
+// send makes the call, with retries, and returns the 2xx response with its
+// body unread.
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var body []byte
+	if req.in != nil {
+		var err error
+		if body, err = json.Marshal(req.in); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, req, body)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+		if err == nil {
+			err = responseError(resp)
+		}
+		if ctx.Err() != nil || attempt >= c.retries || !retryable(req, err) {
+			return nil, err
+		}
+		delay := c.backoff << attempt
+		delay += rand.N(delay/4 + 1)
+		var e *Error
+		if errors.As(err, &e) && e.RetryAfter > 0 {
+			delay = e.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, req request, body []byte) (*http.Response, error) {
+	u := *c.base
+	u.Path += req.path
+	u.RawQuery = req.query.Encode()
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	hr, err := http.NewRequestWithContext(ctx, req.method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.header {
+		hr.Header[k] = v
+	}
+	if body != nil {
+		hr.Header.Set("Content-Type", "application/json")
+	}
+	if hr.Header.Get("Accept") == "" {
+		hr.Header.Set("Accept", "application/json")
+	}
+	hr.Header.Set("User-Agent", c.userAgent)
+	switch {
+	case c.token != "":
+		hr.Header.Set("Authorization", "Bearer "+c.token)
+	case c.apiKey != "":
+		hr.Header.Set("X-API-Key", c.apiKey)
+	}
+	return c.http.Do(hr)
+}
+
+// responseError reads resp, which it closes, into an *Error.
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	e := &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+	e.body, _ = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var body struct {
+		Error  string            `json:"error"`
+		Errors map[string]string `json:"errors"`
+	}
+	if err := json.Unmarshal(e.body, &body); err == nil && body.Error != "" {
+		e.Message, e.Fields = body.Error, body.Errors
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+// retryable reports whether req, which failed with err, may be made again.
+// The board acts on nothing it answers 429, so those are retried whatever
+// the method; failed connections and gateway errors only for the calls
+// that may be repeated. A 409 for an Idempotency-Key means the first
+// attempt is still running; the retry gets what it created.
+func retryable(req request, err error) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return req.idempotent()
+	}
+	switch e.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return req.idempotent()
+	case http.StatusConflict:
+		return req.header.Get("Idempotency-Key") != ""
+	}
+	return false
+}
+
+// idempotent reports whether req may be repeated: by its method, or as a
+// post with an Idempotency-Key, which the board creates at most once.
+func (req request) idempotent() bool {
+	switch req.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.header.Get("Idempotency-Key") != ""
+}
+
+// Token is a bearer token from POST /api/v1/token.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"` // seconds
+}
+
+// Token signs username in with password and returns a token for them; with
+// no username it exchanges the client's API key for a token with its
+// scopes instead. Pass the token to WithToken.
+func (c *Client) Token(ctx context.Context, username, password string) (*Token, error) {
+	req := request{method: http.MethodPost, path: "/api/v1/token"}
+	if username != "" {
+		req.in = map[string]string{"username": username, "password": password}
+	}
+	var t Token
+	if _, err := c.do(ctx, req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// BuildInfo is the board's version, as GET /api/v1/version reports it.
+type BuildInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	Modified   bool   `json:"modified,omitempty"`
+	BuildTime  string `json:"build_time,omitempty"`
+	GoVersion  string `json:"go_version"`
+}
+
+// Version returns the version of the board.
+func (c *Client) Version(ctx context.Context) (*BuildInfo, error) {
+	var b BuildInfo
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/version"}, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
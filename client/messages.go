@@ -0,0 +1,426 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a message as the API returns it.
+type Message struct {
+	ID          int             `json:"id"`
+	Channel     string          `json:"channel"`
+	Author      string          `json:"author"`
+	Content     string          `json:"content"`
+	Created     time.Time       `json:"created"`
+	Updated     *time.Time      `json:"updated,omitempty"`
+	ParentID    int             `json:"parent_id,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	Pinned      bool            `json:"pinned,omitempty"`
+	Revisions   int             `json:"revisions,omitempty"`
+	Version     int             `json:"version"` // goes up with every edit
+	Attachments []Attachment    `json:"attachments,omitempty"`
+	Reactions   []ReactionCount `json:"reactions,omitempty"`
+	Avatar      string          `json:"avatar,omitempty"`
+	PublishAt   *time.Time      `json:"publish_at,omitempty"`
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+}
+
+// Attachment is a file attached to a message; the board serves it at
+// /attachments/{id}.
+type Attachment struct {
+	ID          int       `json:"id"`
+	MessageID   int       `json:"message_id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+}
+
+// ReactionCount sums up one reaction to a message.
+type ReactionCount struct {
+	Reaction string   `json:"reaction"`
+	Emoji    string   `json:"emoji"`
+	Count    int      `json:"count"`
+	Users    []string `json:"users"`
+}
+
+// Revision is an earlier version of an edited message.
+type Revision struct {
+	Revision int       `json:"revision"` // 1 is the original post
+	Author   string    `json:"author"`
+	Content  string    `json:"content"`
+	Tags     []string  `json:"tags,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// NewMessage is a message to post. Content is required, and Author unless
+// the API key or token names the poster.
+type NewMessage struct {
+	Author    string     `json:"author,omitempty"`
+	Email     string     `json:"email,omitempty"` // for the Gravatar, never shown
+	Content   string     `json:"content"`
+	ParentID  int        `json:"parent_id,omitempty"` // replies to this message
+	Tags      []string   `json:"tags,omitempty"`
+	Channel   string     `json:"channel,omitempty"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// IdempotencyKey makes the board create the message once however
+	// often it is posted with the key within a day, e.g. the ID of a CI
+	// job that may be rerun. CreateMessage makes one up if it is empty.
+	IdempotencyKey string `json:"-"`
+}
+
+// MessageUpdate is an edit; the fields left nil stay as they are.
+type MessageUpdate struct {
+	// Version is the version of the message the edit was made to, as
+	// GetMessage returned it. If someone else's edit has been saved since,
+	// UpdateMessage fails; see EditedMessage. 0 overwrites whatever
+	// version is current.
+	Version   int        `json:"version,omitempty"`
+	Author    *string    `json:"author,omitempty"`
+	Content   *string    `json:"content,omitempty"`
+	Tags      *[]string  `json:"tags,omitempty"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ListOptions filters and pages a listing. The zero value lists the first
+// page of 50, newest first.
+type ListOptions struct {
+	Page    int    // from 1
+	PerPage int    // up to 200
+	Sort    string // created (the default) or author
+	Author  string
+	Tag     string
+	Channel string
+	Pinned  *bool     // only pinned messages, or only the others
+	Since   time.Time // only messages posted after
+	// Scheduled lists the messages waiting to be published instead, for
+	// moderators.
+	Scheduled bool
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	if o.Page > 0 {
+		q.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PerPage > 0 {
+		q.Set("per_page", strconv.Itoa(o.PerPage))
+	}
+	for k, v := range map[string]string{"sort": o.Sort, "author": o.Author, "tag": o.Tag, "channel": o.Channel} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if o.Pinned != nil {
+		q.Set("pinned", strconv.FormatBool(*o.Pinned))
+	}
+	if !o.Since.IsZero() {
+		q.Set("since", o.Since.UTC().Format(time.RFC3339))
+	}
+	if o.Scheduled {
+		q.Set("scheduled", "true")
+	}
+	return q
+}
+
+// MessagePage is one page of a listing.
+type MessagePage struct {
+	Messages []Message
+	Total    int // across every page
+	path     string
+	next     url.Values // the query of the next page, nil on the last
+	etag     string
+}
+
+// HasNext reports whether another page follows p.
+func (p *MessagePage) HasNext() bool {
+	return p.next != nil
+}
+
+// ListMessages returns a page of GET /api/v1/messages.
+func (c *Client) ListMessages(ctx context.Context, opts ListOptions) (*MessagePage, error) {
+	return c.messagePage(ctx, "/api/v1/messages", opts.query())
+}
+
+// SearchMessages returns a page of the messages whose author or content
+// contains query, case-insensitively.
+func (c *Client) SearchMessages(ctx context.Context, query string, opts ListOptions) (*MessagePage, error) {
+	q := opts.query()
+	q.Set("q", query)
+	return c.messagePage(ctx, "/api/v1/messages/search", q)
+}
+
+// WaitMessages waits for the page of GET /api/v1/messages that opts asks
+// for to differ from p, a copy of it from ListMessages or WaitMessages,
+// for up to wait, at most a minute, and returns it as it then is, or p if
+// it has not changed. Dashboards call it in a loop rather than polling.
+func (c *Client) WaitMessages(ctx context.Context, opts ListOptions, p *MessagePage, wait time.Duration) (*MessagePage, error) {
+	q := opts.query()
+	q.Set("wait", strconv.Itoa(int(wait.Round(time.Second)/time.Second)))
+	np, err := c.messagePageIf(ctx, "/api/v1/messages", q, p.etag)
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusNotModified {
+		return p, nil
+	}
+	return np, err
+}
+
+// NextPage returns the page after p, which must have one.
+func (c *Client) NextPage(ctx context.Context, p *MessagePage) (*MessagePage, error) {
+	return c.messagePage(ctx, p.path, p.next)
+}
+
+// ListAllMessages iterates over every message of the listing, fetching
+// the pages as it goes. It stops after the first error.
+func (c *Client) ListAllMessages(ctx context.Context, opts ListOptions) iter.Seq2[Message, error] {
+	return c.allMessages(ctx, func() (*MessagePage, error) { return c.ListMessages(ctx, opts) })
+}
+
+// SearchAllMessages is ListAllMessages for SearchMessages.
+func (c *Client) SearchAllMessages(ctx context.Context, query string, opts ListOptions) iter.Seq2[Message, error] {
+	return c.allMessages(ctx, func() (*MessagePage, error) { return c.SearchMessages(ctx, query, opts) })
+}
+
+func (c *Client) allMessages(ctx context.Context, first func() (*MessagePage, error)) iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		p, err := first()
+		for {
+			if err != nil {
+				yield(Message{}, err)
+				return
+			}
+			for _, m := range p.Messages {
+				if !yield(m, nil) {
+					return
+				}
+			}
+			if !p.HasNext() {
+				return
+			}
+			p, err = c.NextPage(ctx, p)
+		}
+	}
+}
+
+func (c *Client) messagePage(ctx context.Context, path string, q url.Values) (*MessagePage, error) {
+	return c.messagePageIf(ctx, path, q, "")
+}
+
+// messagePageIf fetches a page unless its ETag is still etag, which is a
+// 304 *Error.
+func (c *Client) messagePageIf(ctx context.Context, path string, q url.Values, etag string) (*MessagePage, error) {
+	p := &MessagePage{path: path}
+	req := request{method: http.MethodGet, path: path, query: q}
+	if etag != "" {
+		req.header = http.Header{"If-None-Match": {etag}}
+	}
+	resp, err := c.do(ctx, req, &p.Messages)
+	if err != nil {
+		return nil, err
+	}
+	p.Total, _ = strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	p.next = nextLink(resp.Header.Get("Link"))
+	p.etag = resp.Header.Get("ETag")
+	return p, nil
+}
+
+// nextLink returns the query of the rel="next" link of an RFC 8288 Link
+// header. Only the query is kept, so that a board served under a path
+// prefix, which it does not know of, is still called at its own.
+func nextLink(header string) url.Values {
+	for _, link := range strings.Split(header, ",") {
+		target, params, _ := strings.Cut(strings.TrimSpace(link), ";")
+		if !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		u, err := url.Parse(strings.Trim(target, "<>"))
+		if err != nil {
+			return nil
+		}
+		return u.Query()
+	}
+	return nil
+}
+
+// GetMessage returns message id.
+func (c *Client) GetMessage(ctx context.Context, id int) (*Message, error) {
+	var m Message
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: messagePath(id, "")}, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// CreateMessage posts msg and returns it as saved. It is sent with an
+// Idempotency-Key, so failed calls are retried like GETs without the
+// message being posted twice.
+func (c *Client) CreateMessage(ctx context.Context, msg NewMessage) (*Message, error) {
+	key := msg.IdempotencyKey
+	if key == "" {
+		key = rand.Text()
+	}
+	req := request{method: http.MethodPost, path: "/api/v1/messages", in: msg, header: http.Header{"Idempotency-Key": {key}}}
+	var m Message
+	if _, err := c.do(ctx, req, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// UpdateMessage edits message id, keeping the earlier version in its
+// revisions.
+func (c *Client) UpdateMessage(ctx context.Context, id int, u MessageUpdate) (*Message, error) {
+	req := request{method: http.MethodPatch, path: messagePath(id, ""), in: u}
+	if u.Version == 0 {
+		req.header = http.Header{"If-Match": {"*"}}
+	}
+	var m Message
+	if _, err := c.do(ctx, req, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// EditedMessage returns the message as it now is if err is the 409 of an
+// UpdateMessage made to a version that someone else's edit has replaced,
+// or else nil. Merge the edit into it and try again with its Version.
+func EditedMessage(err error) *Message {
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusConflict {
+		return nil
+	}
+	var body struct{ Message *Message }
+	if json.Unmarshal(e.body, &body) != nil {
+		return nil
+	}
+	return body.Message
+}
+
+// DeleteMessage moves message id to the trash, for moderators.
+func (c *Client) DeleteMessage(ctx context.Context, id int) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: messagePath(id, "")}, nil)
+	return err
+}
+
+// Thread returns the conversation message id belongs to, from its root
+// down, oldest first.
+func (c *Client) Thread(ctx context.Context, id int) ([]Message, error) {
+	var ms []Message
+	_, err := c.do(ctx, request{method: http.MethodGet, path: messagePath(id, "/thread")}, &ms)
+	return ms, err
+}
+
+// Revisions returns the earlier versions of message id, oldest first.
+func (c *Client) Revisions(ctx context.Context, id int) ([]Revision, error) {
+	var rs []Revision
+	_, err := c.do(ctx, request{method: http.MethodGet, path: messagePath(id, "/revisions")}, &rs)
+	return rs, err
+}
+
+// Pin pins message id above the others, for moderators.
+func (c *Client) Pin(ctx context.Context, id int) (*Message, error) {
+	return c.pin(ctx, http.MethodPut, id)
+}
+
+// Unpin takes the pin off message id, for moderators.
+func (c *Client) Unpin(ctx context.Context, id int) (*Message, error) {
+	return c.pin(ctx, http.MethodDelete, id)
+}
+
+func (c *Client) pin(ctx context.Context, method string, id int) (*Message, error) {
+	var m Message
+	if _, err := c.do(ctx, request{method: method, path: messagePath(id, "/pin")}, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Reactions sums up the reactions to message id.
+func (c *Client) Reactions(ctx context.Context, id int) ([]ReactionCount, error) {
+	var rs []ReactionCount
+	_, err := c.do(ctx, request{method: http.MethodGet, path: messagePath(id, "/reactions")}, &rs)
+	return rs, err
+}
+
+// React adds the caller's reaction, such as "ack", to message id and
+// returns the new sums.
+func (c *Client) React(ctx context.Context, id int, reaction string) ([]ReactionCount, error) {
+	var rs []ReactionCount
+	in := map[string]string{"reaction": reaction}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: messagePath(id, "/reactions"), in: in}, &rs)
+	return rs, err
+}
+
+// Unreact takes the caller's reaction to message id back.
+func (c *Client) Unreact(ctx context.Context, id int, reaction string) ([]ReactionCount, error) {
+	var rs []ReactionCount
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: messagePath(id, "/reactions/"+reaction)}, &rs)
+	return rs, err
+}
+
+func messagePath(id int, sub string) string {
+	return "/api/v1/messages/" + strconv.Itoa(id) + sub
+}
+
+// BulkItem is the outcome for one message of CreateMessages or
+// DeleteMessages, with the status it would have had on its own: 201, 204,
+// 404, 422 with Errors, or 424 for a message not created because others
+// were invalid.
+type BulkItem struct {
+	Index   int               `json:"index"` // in the call
+	ID      int               `json:"id,omitempty"`
+	Status  int               `json:"status"`
+	Error   string            `json:"error,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+	Message *Message          `json:"message,omitempty"`
+}
+
+// BulkResult reports a bulk call message by message.
+type BulkResult struct {
+	Created int        `json:"created"`
+	Deleted int        `json:"deleted"`
+	Results []BulkItem `json:"results"`
+}
+
+// CreateMessages posts up to 500 messages at once, for moderators: all of
+// them or, if any is invalid, none. Then the error is a 422 *Error and
+// the result says what is wrong with each message.
+func (c *Client) CreateMessages(ctx context.Context, msgs []NewMessage) (*BulkResult, error) {
+	var res BulkResult
+	in := map[string][]NewMessage{"messages": msgs}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/messages/bulk", in: in}, &res)
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusUnprocessableEntity && json.Unmarshal(e.body, &res) == nil {
+		return &res, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DeleteMessages moves up to 500 messages to the trash, for moderators.
+// Each goes on its own, so the call succeeds even when some were not
+// found; the result tells.
+func (c *Client) DeleteMessages(ctx context.Context, ids []int) (*BulkResult, error) {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	var res BulkResult
+	q := url.Values{"ids": {strings.Join(s, ",")}}
+	if _, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/v1/messages", query: q}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxEventSize bounds one line of the event stream.
+const maxEventSize = 1 << 20
+
+// Event is what happened to a message, as GET /api/v1/messages/stream tells
+// it: Type is created, updated, deleted, restored, or mention when a new
+// message mentions the signed-in user. A deleted event's message carries
+// only its ID.
+type Event struct {
+	Type    string
+	Message Message
+}
+
+// Subscribe follows the board's event stream, of one channel's messages
+// or, with channel "", of all the caller may read. A dropped connection
+// is made again after the client's backoff, as when the board restarts;
+// what happened in between is not replayed, so a caller that must miss
+// nothing lists the messages since the last it saw. The iteration ends
+// when ctx is done, the loop breaks, or the board cannot be reached, with
+// the client's retries, or refuses the stream; the error is yielded last.
+func (c *Client) Subscribe(ctx context.Context, channel string) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		req := request{
+			method: http.MethodGet,
+			path:   "/api/v1/messages/stream",
+			query:  url.Values{},
+			header: http.Header{"Accept": {"text/event-stream"}},
+		}
+		if channel != "" {
+			req.query.Set("channel", channel)
+		}
+		delay := c.backoff
+		for {
+			resp, err := c.send(ctx, req)
+			if err != nil {
+				if ctx.Err() == nil {
+					yield(Event{}, err)
+				}
+				return
+			}
+			received, ok := readEvents(resp, yield)
+			resp.Body.Close()
+			if !ok || ctx.Err() != nil {
+				return
+			}
+			if received {
+				delay = c.backoff
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, time.Minute)
+		}
+	}
+}
+
+// readEvents yields the events of a stream until it ends. It reports
+// whether there were any, and false for ok once yield asked to stop.
+func readEvents(resp *http.Response, yield func(Event, error) bool) (received, ok bool) {
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, maxEventSize)
+	var ev Event
+	var data []string
+	for sc.Scan() {
+		field, value, _ := strings.Cut(sc.Text(), ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.Type = value
+		case "data":
+			data = append(data, value)
+		case "":
+			// A comment, such as the keep-alives, or the blank line that
+			// ends an event.
+			if sc.Text() != "" || data == nil {
+				continue
+			}
+			err := json.Unmarshal([]byte(strings.Join(data, "\n")), &ev.Message)
+			if err == nil {
+				received = true
+				if !yield(ev, nil) {
+					return received, false
+				}
+			} else if !yield(Event{}, errors.New("decoding event: "+err.Error())) {
+				return received, false
+			}
+			ev, data = Event{}, nil
+		}
+	}
+	return received, true
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/store"
+	"example.com/go-sample-site/internal/web"
+
+	"golang.org/x/term"
+)
+
+// command is one of the binary's subcommands. Each takes its arguments
+// first, then the server's flags, which with the environment and config
+// file say which database to work on.
+type command struct {
+	name  string
+	args  string // the arguments, for the usage
+	about string
+	run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "", "run the server (the default)", runServe},
+		{"migrate", "[status | up | down [n] | to <version>]", "show or change the schema version", runMigrate},
+		{"seed", "[file]", "create the default channel and admin account, and import the messages in file", runSeed},
+		{"export", "[csv | ndjson] [file]", "write every message to file, s3://bucket/key or stdout (ndjson unless csv)", runExport},
+		{"restore", "<file | backup>", "put the board back as a backup file, or one the server took, has it", runRestore},
+		{"user", "add <name> <role> | passwd <name>", "create an account or change its password, read from stdin", runUser},
+		{"help", "", "show this", func([]string) error { printUsage(); return nil }},
+	}
+}
+
+// findCommand picks the command args name and returns the arguments for
+// it. With none named, as when args start with a flag, it is serve, so
+// that the server runs as before there were commands. It returns nil for
+// a name it does not know.
+func findCommand(args []string) (*command, []string) {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i], args
+		}
+	}
+	return nil, nil
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: go-sample-site [command] [arguments] [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.args)
+		fmt.Fprintf(os.Stderr, "           %s\n", c.about)
+	}
+	fmt.Fprintln(os.Stderr, "\nThe flags are the server's; go-sample-site -h lists them.")
+}
+
+// usageError reports that command name was given the wrong arguments.
+func usageError(name string) error {
+	for _, c := range commands {
+		if c.name == name {
+			return fmt.Errorf("usage: go-sample-site %s %s [flags]", name, c.args)
+		}
+	}
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// positional splits args into the arguments before the first flag and
+// the rest.
+func positional(args []string) (pos, flags []string) {
+	i := 0
+	for i < len(args) && !strings.HasPrefix(args[i], "-") {
+		i++
+	}
+	return args[:i], args[i:]
+}
+
+// loadConfig is how every command reads its configuration: flags, then
+// the environment, then the config file. Logging is set up to match.
+func loadConfig(name string, args []string) (config.Config, error) {
+	cfg, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		if name != "serve" {
+			fmt.Fprintln(os.Stderr, usageError(name))
+		}
+		return cfg, err
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("config: %w", err)
+	}
+	return cfg, setupLogging(cfg.LogFormat)
+}
+
+// openStore opens the store cfg names, doing mode about its schema.
+func openStore(cfg config.Config, mode store.MigrationMode) (store.Store, error) {
+	s, err := store.Open(cfg.StoreBackend, cfg.DBPath, cfg.DatabaseURL, mode)
+	if err != nil {
+		return nil, fmt.Errorf("opening store: %w", err)
+	}
+	return s, nil
+}
+
+// openCommandStore is loadConfig then openStore for the commands that
+// change data, which migrate the schema as the server would on start.
+func openCommandStore(name string, args []string) (store.Store, error) {
+	cfg, err := loadConfig(name, args)
+	if err != nil {
+		return nil, err
+	}
+	return openStore(cfg, commandMigrationMode(cfg))
+}
+
+// commandMigrationMode is what the commands that change data do about an
+// old schema: migrate it as the server would on start.
+func commandMigrationMode(cfg config.Config) store.MigrationMode {
+	if !cfg.AutoMigrate {
+		return store.MigrateCheck
+	}
+	return store.MigrateUp
+}
+
+// runSeed is the seed command. It creates what the server would on its
+// first start, so that a database can be readied before it does, and
+// imports the messages of file, in any format /admin/import takes.
+func runSeed(args []string) error {
+	pos, args := positional(args)
+	if len(pos) > 1 {
+		return usageError("seed")
+	}
+	s, err := openCommandStore("seed", args)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	ctx := context.Background()
+	if err := web.Seed(ctx, s); err != nil {
+		return err
+	}
+	if len(pos) == 0 {
+		return nil
+	}
+	f, err := os.Open(pos[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := web.ImportMessages(ctx, s, f, f.Name())
+	if err != nil {
+		return fmt.Errorf("%s: %w", pos[0], err)
+	}
+	fmt.Printf("imported %d messages\n", n)
+	return nil
+}
+
+// runExport is the export command, the command-line twin of GET
+// /api/messages/export. It sees every channel, private ones included. The
+// file may be an s3://bucket/key URL, written with the server's S3
+// settings.
+func runExport(args []string) error {
+	pos, args := positional(args)
+	if len(pos) > 2 {
+		return usageError("export")
+	}
+	format := "ndjson"
+	if len(pos) > 0 {
+		format = pos[0]
+	}
+	if format != "csv" && format != "ndjson" {
+		return usageError("export")
+	}
+	cfg, err := loadConfig("export", args)
+	if err != nil {
+		return err
+	}
+	s, err := openStore(cfg, commandMigrationMode(cfg))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if len(pos) < 2 {
+		return web.ExportMessages(context.Background(), s, os.Stdout, format)
+	}
+	if strings.HasPrefix(pos[1], "s3://") {
+		return web.ExportMessagesS3(context.Background(), cfg, s, pos[1], format)
+	}
+	f, err := os.Create(pos[1])
+	if err != nil {
+		return err
+	}
+	if err := web.ExportMessages(context.Background(), s, f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runRestore is the restore command, the command-line twin of the
+// restore button on /admin/backups, for a server that is stopped: it does
+// not put running replicas in maintenance mode. The argument is a backup
+// file, or the name of one in the configured backup store. The board as
+// it was is backed up there first, so that the restore can be undone.
+func runRestore(args []string) error {
+	pos, args := positional(args)
+	if len(pos) != 1 {
+		return usageError("restore")
+	}
+	cfg, err := loadConfig("restore", args)
+	if err != nil {
+		return err
+	}
+	s, err := openStore(cfg, commandMigrationMode(cfg))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	res, err := web.Restore(context.Background(), cfg, s, pos[0])
+	if errors.Is(err, web.ErrBackupNotFound) {
+		return fmt.Errorf("%s: no such file or backup", pos[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d channels and %d messages from %s, taken %s\n", res.Channels, res.Messages, res.Restored, res.Taken.Format(time.RFC3339))
+	fmt.Printf("the board as it was is backed up as %s\n", res.Before.Name)
+	return nil
+}
+
+// runUser is the user command, which manages accounts:
+//
+//	go-sample-site user add <name> <role>  create an account
+//	go-sample-site user passwd <name>      change an account's password
+//
+// The password is asked for when stdin is a terminal, or else read from
+// its first line, so that it stays out of the arguments and shell history.
+func runUser(args []string) error {
+	pos, args := positional(args)
+	switch {
+	case len(pos) == 3 && pos[0] == "add":
+	case len(pos) == 2 && pos[0] == "passwd":
+	default:
+		return usageError("user")
+	}
+	s, err := openCommandStore("user", args)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if pos[0] == "add" {
+		err = web.AddUser(ctx, s, pos[1], pos[2], password)
+	} else {
+		err = web.SetPassword(ctx, s, pos[1], password)
+	}
+	if errors.Is(err, store.ErrUserNotFound) {
+		return fmt.Errorf("no user %q", pos[1])
+	}
+	return err
+}
+
+// readPassword reads a password from in: on a terminal, twice and without
+// echo; otherwise the first line.
+func readPassword(in *os.File) (string, error) {
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Again: ")
+	again, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(again) {
+		return "", errors.New("the passwords do not match")
+	}
+	return string(first), nil
+}
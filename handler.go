@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+	"example.com/go-sample-site/internal/web"
+)
+
+// NewServer returns the board for cfg, keeping its data in s, as the one
+// handler main serves: routes and all the middleware in front of them. It
+// is what tests hand to httptest.NewServer. The scheduler, stats and
+// monitors are left stopped and requests go to slog rather than an access
+// log, so a test sees only the requests it makes.
+func NewServer(cfg config.Config, s store.Store) (http.Handler, error) {
+	app, err := newApp(cfg, s, nil)
+	if err != nil {
+		return nil, err
+	}
+	return handler(cfg, app), nil
+}
+
+// newApp builds the board with the templates and static assets from the
+// directories cfg names, or else the copies embedded in the binary.
+func newApp(cfg config.Config, s store.Store, accessLog *middleware.AccessLog) (*web.App, error) {
+	tfs, err := assetFS(cfg.TemplateDir, "templates")
+	if err != nil {
+		return nil, fmt.Errorf("loading templates: %w", err)
+	}
+	sfs, err := assetFS(cfg.StaticDir, "static")
+	if err != nil {
+		return nil, fmt.Errorf("loading static assets: %w", err)
+	}
+	return web.New(cfg, s, web.Options{
+		Templates: tfs,
+		Static:    sfs,
+		OpenAPI:   openAPISpec,
+		APIDocs:   docsPage,
+		Build:     web.ReadBuildInfo(version, commit, buildTime),
+		AccessLog: accessLog,
+	})
+}
+
+// handler puts the generic middleware in front of app's routes.
+func handler(cfg config.Config, app *web.App) http.Handler {
+	proxies, _ := cfg.Proxies()
+	// Client addresses come first so that everything after logs the real
+	// one; Recover sits inside the request ID it quotes.
+	return middleware.NewChain(
+		middleware.RealIP(proxies),
+		middleware.Tracing,
+		middleware.RequestID,
+		middleware.Recover,
+		middleware.HSTS,
+		middleware.Compress,
+		middleware.CORS(middleware.CORSOptions{
+			Origins:     config.SplitList(cfg.CORSOrigins),
+			Methods:     config.SplitList(cfg.CORSMethods),
+			Headers:     config.SplitList(cfg.CORSHeaders),
+			Credentials: cfg.CORSCredentials,
+			MaxAge:      cfg.CORSMaxAge,
+		}),
+	).Then(app.Handler())
+}
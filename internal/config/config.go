@@ -0,0 +1,990 @@
+// Package config reads the server settings from defaults, a YAML file,
+// the environment and command-line flags, and checks them.
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the server settings. Values are layered: built-in defaults,
+// then the YAML file given by -config, then environment variables, then
+// command-line flags.
+type Config struct {
+	Addr            string        `yaml:"addr"`
+	Listen          []string      `yaml:"listen"`        // host:port or unix:/path each; replaces Addr when set
+	InternalAddr    string        `yaml:"internal_addr"` // metrics, probes and /debug; empty serves them on Addr
+	TemplateDir     string        `yaml:"template_dir"`
+	StaticDir       string        `yaml:"static_dir"`
+	Theme           string        `yaml:"theme"`         // from static/themes; visitors may pick another
+	StoreBackend    string        `yaml:"store_backend"` // memory, sqlite or postgres
+	DBPath          string        `yaml:"db_path"`
+	DatabaseURL     string        `yaml:"database_url"`
+	AutoMigrate     bool          `yaml:"auto_migrate"` // apply pending schema migrations on start
+	LogFormat       string        `yaml:"log_format"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	Dev             bool          `yaml:"dev"`         // re-parse templates on every request
+	Maintenance     bool          `yaml:"maintenance"` // start in maintenance mode
+
+	// AccessLog is a file for one line per request, in AccessLogFormat
+	// ("combined" or "json"), or "-" for standard output; empty logs
+	// requests through the regular logger. The file is rotated once it
+	// reaches AccessLogMaxMB or is AccessLogRotate old (0 disables either),
+	// keeping AccessLogKeep rotated files.
+	AccessLog       string        `yaml:"access_log"`
+	AccessLogFormat string        `yaml:"access_log_format"`
+	AccessLogMaxMB  int           `yaml:"access_log_max_mb"`
+	AccessLogRotate time.Duration `yaml:"access_log_rotate"`
+	AccessLogKeep   int           `yaml:"access_log_keep"`
+
+	// Posting rate limit per client IP; 0 disables it.
+	RateLimit  int  `yaml:"rate_limit"` // posts per minute
+	RateBurst  int  `yaml:"rate_burst"`
+	TrustProxy bool `yaml:"trust_proxy"` // take client IPs from X-Forwarded-For
+
+	// TrustedProxies lists the CIDRs, addresses and "unix" (for peers on a
+	// Unix socket) of the proxies whose forwarding headers are believed,
+	// comma-separated. TrustProxy trusts every peer instead.
+	TrustedProxies string `yaml:"trusted_proxies"`
+
+	// Cross-origin access to /api from browser pages: CORSOrigins lists
+	// origins such as https://dash.example.com, or "*"; empty disables CORS.
+	// The lists are comma-separated.
+	CORSOrigins     string        `yaml:"cors_origins"`
+	CORSMethods     string        `yaml:"cors_methods"`
+	CORSHeaders     string        `yaml:"cors_headers"`
+	CORSCredentials bool          `yaml:"cors_credentials"` // allow cookies and HTTP auth
+	CORSMaxAge      time.Duration `yaml:"cors_max_age"`     // how long browsers cache a preflight
+
+	// API requests each signed-in user and API key may make per
+	// APIQuotaWindow; 0 disables quotas.
+	APIQuota       int           `yaml:"api_quota"`
+	APIQuotaWindow time.Duration `yaml:"api_quota_window"`
+
+	// CacheTTL is how long the board and GET /api/messages are served from
+	// memory once rendered, unless a write comes first; 0 disables the
+	// cache.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+
+	// RedisURL, e.g. redis://redis:6379/0, moves sessions, the rate limit
+	// and quota counters and the response cache from process memory to
+	// Redis, where every replica sees them, so that a load balancer need
+	// not send a client back to the same one. The password, if any, may
+	// come separately as RedisPassword.
+	RedisURL      string `yaml:"redis_url"`
+	RedisPassword string `yaml:"redis_password"`
+
+	// PubSub relays message events between replicas, so that live pages
+	// and sockets on one see what is posted on another: "redis" through
+	// RedisURL, "nats" through the server at NATSURL, or "" for a single
+	// instance.
+	PubSub  string `yaml:"pubsub"`
+	NATSURL string `yaml:"nats_url"`
+
+	// HTTPS: either a certificate and key on disk, or ACMEDomain for
+	// certificates from Let's Encrypt cached in ACMECache. HTTPAddr is the
+	// plain-HTTP listener that redirects to HTTPS.
+	TLSCert    string `yaml:"tls_cert"`
+	TLSKey     string `yaml:"tls_key"`
+	ACMEDomain string `yaml:"acme_domain"` // comma-separated host names
+	ACMECache  string `yaml:"acme_cache"`
+	ACMEEmail  string `yaml:"acme_email"`
+	HTTPAddr   string `yaml:"http_addr"`
+	HTTP3Addr  string `yaml:"http3_addr"` // UDP address for HTTP/3, e.g. :443
+
+	// GRPCAddr serves the gRPC MessageService, over TLS when the main
+	// server uses it.
+	GRPCAddr string `yaml:"grpc_addr"`
+
+	// H2C serves HTTP/2 without TLS to clients that speak it from the
+	// start, e.g. gRPC-style clients behind a proxy that terminates TLS.
+	H2C bool `yaml:"h2c"`
+
+	// Single sign-on. OIDCIssuer enables an OpenID Connect provider such as
+	// Google (https://accounts.google.com); GitHubClientID enables GitHub.
+	// New users get SSODefaultRole unless SSORoleMap ("value=role,...")
+	// matches one of their OIDCRoleClaim values, which then sets the role on
+	// every login. PublicURL is the site's external base URL for callbacks.
+	OIDCIssuer         string `yaml:"oidc_issuer"`
+	OIDCClientID       string `yaml:"oidc_client_id"`
+	OIDCClientSecret   string `yaml:"oidc_client_secret"`
+	OIDCName           string `yaml:"oidc_name"`           // login button label
+	OIDCUsernameClaim  string `yaml:"oidc_username_claim"` // falls back to email, then sub
+	OIDCRoleClaim      string `yaml:"oidc_role_claim"`     // e.g. groups
+	GitHubClientID     string `yaml:"github_client_id"`
+	GitHubClientSecret string `yaml:"github_client_secret"`
+	SSORoleMap         string `yaml:"sso_role_map"`
+	SSODefaultRole     string `yaml:"sso_default_role"`
+	PublicURL          string `yaml:"public_url"`
+
+	// AuthBackend checks password logins: "local" accounts only, or
+	// "ldap", which binds against the directory for every name without a
+	// local password. LDAPUserFilter finds the user ("%s" is the name) and
+	// LDAPRoleMap maps group CNs from LDAPGroupAttr to roles.
+	AuthBackend      string `yaml:"auth_backend"`
+	LDAPURL          string `yaml:"ldap_url"` // ldap://host:389 or ldaps://host:636
+	LDAPStartTLS     bool   `yaml:"ldap_start_tls"`
+	LDAPBindDN       string `yaml:"ldap_bind_dn"` // service account for searches; empty searches anonymously
+	LDAPBindPassword string `yaml:"ldap_bind_password"`
+	LDAPBaseDN       string `yaml:"ldap_base_dn"`
+	LDAPUserFilter   string `yaml:"ldap_user_filter"`
+	LDAPGroupAttr    string `yaml:"ldap_group_attr"`
+	LDAPRoleMap      string `yaml:"ldap_role_map"`
+
+	// API tokens from /api/token: JWTKeys holds kid:secret pairs, the
+	// signing key first, and JWTTTL is how long a token lasts.
+	JWTKeys string        `yaml:"jwt_keys"`
+	JWTTTL  time.Duration `yaml:"jwt_ttl"`
+
+	// Message attachments, kept by BlobBackend: "disk" under AttachmentDir
+	// or "s3" in S3Bucket on any S3-compatible service, AWS unless
+	// S3Endpoint says otherwise. Without S3AccessKey, the credentials are
+	// found as the AWS SDKs find them: AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY, the shared credentials file, then the
+	// instance's or pod's role. S3SSE encrypts the objects written: "s3"
+	// with keys S3 manages, "kms" with S3SSEKMSKey or the account's default
+	// KMS key, "c" with S3SSECustomerKey, 32 bytes base64-encoded, which S3
+	// does not keep. AttachmentTypes
+	// lists the media types allowed, as sniffed from the file contents;
+	// AttachmentMaxFiles 0 disables uploads.
+	BlobBackend        string `yaml:"blob_backend"`
+	AttachmentDir      string `yaml:"attachment_dir"`
+	AttachmentMaxMB    int    `yaml:"attachment_max_mb"` // per file
+	AttachmentMaxFiles int    `yaml:"attachment_max_files"`
+	AttachmentTypes    string `yaml:"attachment_types"`
+	S3Endpoint         string `yaml:"s3_endpoint"` // host[:port], e.g. s3.amazonaws.com
+	S3Bucket           string `yaml:"s3_bucket"`
+	S3Region           string `yaml:"s3_region"`
+	S3AccessKey        string `yaml:"s3_access_key"`
+	S3SecretKey        string `yaml:"s3_secret_key"`
+	S3Prefix           string `yaml:"s3_prefix"`   // prepended to object names
+	S3Insecure         bool   `yaml:"s3_insecure"` // plain HTTP, for local MinIO
+	S3SSE              string `yaml:"s3_sse"`
+	S3SSEKMSKey        string `yaml:"s3_sse_kms_key"`
+	S3SSECustomerKey   string `yaml:"s3_sse_customer_key"`
+
+	// Backups, snapshots of the board kept by BackupBackend: "disk" under
+	// BackupDir or "s3" under backups/ in S3Bucket. One is taken every
+	// BackupInterval, 0 for only on demand, and the newest BackupKeep kept.
+	BackupBackend  string        `yaml:"backup_backend"`
+	BackupDir      string        `yaml:"backup_dir"`
+	BackupInterval time.Duration `yaml:"backup_interval"`
+	BackupKeep     int           `yaml:"backup_keep"`
+
+	GitHubWebhookSecret string `yaml:"github_webhook_secret"`
+	JenkinsToken        string `yaml:"jenkins_token"`
+	SlackWebhookURL     string `yaml:"slack_webhook_url"`
+	SentryDSN           string `yaml:"sentry_dsn"` // recovered panics are reported there
+
+	// Email subscriptions, sent through the SMTP server at SMTPAddr
+	// (host:port); empty disables them. Links in the mails point at
+	// PublicURL, which is required then.
+	SMTPAddr     string `yaml:"smtp_addr"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+	MailFrom     string `yaml:"mail_from"` // e.g. SLRS <noreply@example.com>
+
+	// JobWorkers is how many background jobs (notifications and the like)
+	// run at once.
+	JobWorkers int `yaml:"job_workers"`
+
+	// Uptime monitors: Monitors lists name=target pairs, comma-separated,
+	// where a target is an http(s) URL or tcp://host:port. They and the
+	// server's own health checks are probed every MonitorInterval.
+	Monitors        string        `yaml:"monitors"`
+	MonitorInterval time.Duration `yaml:"monitor_interval"`
+}
+
+// Default returns the built-in settings, which Load starts from. Tests
+// start from it too, changing what they need.
+func Default() Config {
+	return Config{
+		Addr:            ":8080",
+		Theme:           "default",
+		DBPath:          "messages.db",
+		AutoMigrate:     true,
+		LogFormat:       "text",
+		AccessLogFormat: "combined",
+		AccessLogMaxMB:  100,
+		AccessLogRotate: 24 * time.Hour,
+		AccessLogKeep:   7,
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    15 * time.Second,
+		IdleTimeout:     60 * time.Second,
+		ShutdownTimeout: 10 * time.Second,
+		JobWorkers:      4,
+		MonitorInterval: time.Minute,
+		RateLimit:       30,
+		RateBurst:       10,
+		APIQuota:        5000,
+		APIQuotaWindow:  time.Hour,
+		CORSMethods:     "GET, HEAD, POST, PUT, PATCH, DELETE",
+		CORSHeaders:     "Authorization, Content-Type, X-API-Key, If-Match, If-None-Match, Idempotency-Key, X-Request-ID",
+		CORSMaxAge:      10 * time.Minute,
+		ACMECache:       "acme-cache",
+
+		OIDCUsernameClaim: "preferred_username",
+		SSODefaultRole:    store.RolePoster,
+
+		AuthBackend:    "local",
+		LDAPUserFilter: "(uid=%s)",
+		LDAPGroupAttr:  "memberOf",
+
+		JWTTTL: 15 * time.Minute,
+
+		BlobBackend:        "disk",
+		AttachmentDir:      "attachments",
+		AttachmentMaxMB:    10,
+		AttachmentMaxFiles: 5,
+		AttachmentTypes:    "image/png, image/jpeg, image/gif, image/webp, text/plain, application/pdf, application/zip, application/x-gzip",
+		S3Endpoint:         "s3.amazonaws.com",
+
+		BackupBackend: "disk",
+		BackupDir:     "backups",
+		BackupKeep:    7,
+	}
+}
+
+func bindFlags(fs *flag.FlagSet, c *Config, file *string) {
+	fs.StringVar(file, "config", *file, "YAML config file")
+	fs.StringVar(&c.Addr, "addr", c.Addr, "listen address (env PORT sets :PORT)")
+	fs.Var(&listFlag{dst: &c.Listen}, "listen", "address to serve on instead of -addr, host:port or unix:/path/to.sock; repeat for several")
+	fs.StringVar(&c.InternalAddr, "internal-addr", c.InternalAddr, "listen address for /metrics, /healthz, /readyz and /debug/, which then leave -addr")
+	fs.StringVar(&c.TemplateDir, "templates", c.TemplateDir, "load templates from this directory instead of the embedded copy")
+	fs.StringVar(&c.StaticDir, "static", c.StaticDir, "serve static assets from this directory instead of the embedded copy")
+	fs.StringVar(&c.Theme, "theme", c.Theme, "site theme: default or the name of a stylesheet in static/themes")
+	fs.StringVar(&c.StoreBackend, "store", c.StoreBackend, "store backend: memory, sqlite or postgres (default sqlite, or memory when -db is empty)")
+	fs.StringVar(&c.DBPath, "db", c.DBPath, "SQLite database file (empty for an in-memory store)")
+	fs.StringVar(&c.DatabaseURL, "database-url", c.DatabaseURL, "Postgres connection string")
+	fs.BoolVar(&c.AutoMigrate, "auto-migrate", c.AutoMigrate, "apply pending schema migrations on start; with false, refuse to start until the migrate command has")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log output format: text or json")
+	fs.StringVar(&c.AccessLog, "access-log", c.AccessLog, "file to write an access log to, or - for standard output (default: requests go to the regular log)")
+	fs.StringVar(&c.AccessLogFormat, "access-log-format", c.AccessLogFormat, "access log format: combined (Apache) or json")
+	fs.IntVar(&c.AccessLogMaxMB, "access-log-max-mb", c.AccessLogMaxMB, "rotate the access log file at this size in megabytes (0 disables)")
+	fs.DurationVar(&c.AccessLogRotate, "access-log-rotate", c.AccessLogRotate, "rotate the access log file at this age (0 disables)")
+	fs.IntVar(&c.AccessLogKeep, "access-log-keep", c.AccessLogKeep, "rotated access log files to keep")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "maximum time to read a request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "maximum time to write a response (0 for none)")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "keep-alive idle timeout")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long to wait for requests to finish on shutdown")
+	fs.BoolVar(&c.Dev, "dev", c.Dev, "development mode: reload templates on every request")
+	fs.BoolVar(&c.Maintenance, "maintenance", c.Maintenance, "start in maintenance mode: only admins get past a 503 page")
+	fs.IntVar(&c.RateLimit, "rate-limit", c.RateLimit, "posts per minute allowed per client IP (0 disables)")
+	fs.IntVar(&c.RateBurst, "rate-burst", c.RateBurst, "posts a client may make in a burst")
+	fs.IntVar(&c.APIQuota, "api-quota", c.APIQuota, "API requests allowed per user or API key each -api-quota-window (0 disables)")
+	fs.DurationVar(&c.APIQuotaWindow, "api-quota-window", c.APIQuotaWindow, "period after which API quotas refill")
+	fs.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "serve the board and GET /api/messages from memory for this long after rendering, e.g. 2s (0 disables)")
+	fs.StringVar(&c.RedisURL, "redis-url", c.RedisURL, "Redis to keep sessions, rate limits, quotas and the response cache in, e.g. redis://redis:6379/0 (the password comes from REDIS_PASSWORD)")
+	fs.StringVar(&c.PubSub, "pubsub", c.PubSub, "relay live updates between replicas through redis or nats (default none)")
+	fs.StringVar(&c.NATSURL, "nats-url", c.NATSURL, "NATS server for -pubsub nats, e.g. nats://nats:4222")
+	fs.StringVar(&c.CORSOrigins, "cors-origins", c.CORSOrigins, "comma-separated origins allowed to call /api from a browser, or * for any")
+	fs.StringVar(&c.CORSMethods, "cors-methods", c.CORSMethods, "methods allowed in cross-origin API requests")
+	fs.StringVar(&c.CORSHeaders, "cors-headers", c.CORSHeaders, "request headers allowed in cross-origin API requests")
+	fs.BoolVar(&c.CORSCredentials, "cors-credentials", c.CORSCredentials, "let cross-origin API requests carry cookies (needs explicit -cors-origins)")
+	fs.DurationVar(&c.CORSMaxAge, "cors-max-age", c.CORSMaxAge, "how long browsers may cache a preflight response")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "TLS certificate file (with -tls-key) to serve HTTPS")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "TLS private key file")
+	fs.StringVar(&c.ACMEDomain, "acme-domain", c.ACMEDomain, "serve HTTPS with Let's Encrypt certificates for these comma-separated domains")
+	fs.StringVar(&c.ACMECache, "acme-cache", c.ACMECache, "directory Let's Encrypt certificates are cached in")
+	fs.StringVar(&c.ACMEEmail, "acme-email", c.ACMEEmail, "contact email for the Let's Encrypt account")
+	fs.StringVar(&c.HTTPAddr, "http-addr", c.HTTPAddr, "plain-HTTP listen address that redirects to HTTPS (default :80 with -acme-domain)")
+	fs.StringVar(&c.HTTP3Addr, "http3-addr", c.HTTP3Addr, "UDP address to serve HTTP/3 (QUIC) on, e.g. :443; needs TLS")
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", c.GRPCAddr, "listen address for the gRPC API, host:port or unix:/path/to.sock")
+	fs.BoolVar(&c.H2C, "h2c", c.H2C, "serve cleartext HTTP/2 (prior knowledge) alongside HTTP/1.1 when TLS is off")
+	fs.BoolVar(&c.TrustProxy, "trust-proxy", c.TrustProxy, "take client IPs from X-Forwarded-For whoever sends it (prefer -trusted-proxies)")
+	fs.StringVar(&c.TrustedProxies, "trusted-proxies", c.TrustedProxies, "comma-separated CIDRs of proxies whose X-Forwarded-For and X-Real-IP are believed, and unix for Unix socket peers")
+	fs.StringVar(&c.OIDCIssuer, "oidc-issuer", c.OIDCIssuer, "OpenID Connect issuer URL for single sign-on, e.g. https://accounts.google.com")
+	fs.StringVar(&c.OIDCClientID, "oidc-client-id", c.OIDCClientID, "OpenID Connect client ID (the secret comes from OIDC_CLIENT_SECRET)")
+	fs.StringVar(&c.OIDCName, "oidc-name", c.OIDCName, "label for the OpenID Connect login button")
+	fs.StringVar(&c.OIDCUsernameClaim, "oidc-username-claim", c.OIDCUsernameClaim, "ID token claim used as the user name")
+	fs.StringVar(&c.OIDCRoleClaim, "oidc-role-claim", c.OIDCRoleClaim, "ID token claim whose values -sso-role-map maps to roles")
+	fs.StringVar(&c.GitHubClientID, "github-client-id", c.GitHubClientID, "GitHub OAuth app client ID (the secret comes from GITHUB_CLIENT_SECRET)")
+	fs.StringVar(&c.SSORoleMap, "sso-role-map", c.SSORoleMap, "comma-separated claim=role pairs for single sign-on users")
+	fs.StringVar(&c.SSODefaultRole, "sso-default-role", c.SSODefaultRole, "role for new single sign-on users no mapping matches")
+	fs.StringVar(&c.PublicURL, "public-url", c.PublicURL, "external base URL of the site, for single sign-on callbacks (default from the request)")
+	fs.StringVar(&c.AuthBackend, "auth-backend", c.AuthBackend, "password login backend: local or ldap")
+	fs.StringVar(&c.LDAPURL, "ldap-url", c.LDAPURL, "LDAP server URL, e.g. ldaps://ldap.example.com")
+	fs.BoolVar(&c.LDAPStartTLS, "ldap-start-tls", c.LDAPStartTLS, "upgrade ldap:// connections with StartTLS")
+	fs.StringVar(&c.LDAPBindDN, "ldap-bind-dn", c.LDAPBindDN, "DN to bind as for user searches (the password comes from LDAP_BIND_PASSWORD)")
+	fs.StringVar(&c.LDAPBaseDN, "ldap-base-dn", c.LDAPBaseDN, "base DN user searches start from")
+	fs.StringVar(&c.LDAPUserFilter, "ldap-user-filter", c.LDAPUserFilter, "search filter for a user, %s is the login name (Active Directory: (sAMAccountName=%s))")
+	fs.StringVar(&c.LDAPGroupAttr, "ldap-group-attr", c.LDAPGroupAttr, "user attribute listing group DNs")
+	fs.StringVar(&c.LDAPRoleMap, "ldap-role-map", c.LDAPRoleMap, "comma-separated group-CN=role pairs")
+	fs.DurationVar(&c.JWTTTL, "jwt-ttl", c.JWTTTL, "lifetime of API tokens from /api/token (keys come from JWT_KEYS)")
+	fs.StringVar(&c.SMTPAddr, "smtp-addr", c.SMTPAddr, "SMTP server host:port for email subscriptions (the password comes from SMTP_PASSWORD)")
+	fs.StringVar(&c.SMTPUsername, "smtp-username", c.SMTPUsername, "SMTP user name, if the server wants one")
+	fs.StringVar(&c.MailFrom, "mail-from", c.MailFrom, "From address of subscription emails")
+	fs.IntVar(&c.JobWorkers, "job-workers", c.JobWorkers, "background jobs run at once")
+	fs.StringVar(&c.Monitors, "monitors", c.Monitors, "comma-separated name=target uptime monitors; targets are http(s) URLs or tcp://host:port")
+	fs.DurationVar(&c.MonitorInterval, "monitor-interval", c.MonitorInterval, "how often monitors are probed")
+	fs.StringVar(&c.BlobBackend, "blob-backend", c.BlobBackend, "attachment storage: disk or s3")
+	fs.StringVar(&c.AttachmentDir, "attachment-dir", c.AttachmentDir, "directory attachments are kept in with -blob-backend disk")
+	fs.IntVar(&c.AttachmentMaxMB, "attachment-max-mb", c.AttachmentMaxMB, "largest attachment allowed, in megabytes")
+	fs.IntVar(&c.AttachmentMaxFiles, "attachment-max-files", c.AttachmentMaxFiles, "attachments allowed per message (0 disables uploads)")
+	fs.StringVar(&c.AttachmentTypes, "attachment-types", c.AttachmentTypes, "comma-separated media types attachments may have")
+	fs.StringVar(&c.S3Endpoint, "s3-endpoint", c.S3Endpoint, "host[:port] of the S3-compatible service for the s3 backends")
+	fs.StringVar(&c.S3Bucket, "s3-bucket", c.S3Bucket, "bucket attachments and s3 backups are kept in")
+	fs.StringVar(&c.S3Region, "s3-region", c.S3Region, "bucket region")
+	fs.StringVar(&c.S3Prefix, "s3-prefix", c.S3Prefix, "prefix for the names of the objects written")
+	fs.BoolVar(&c.S3Insecure, "s3-insecure", c.S3Insecure, "talk to the S3 endpoint over plain HTTP (keys come from S3_ACCESS_KEY and S3_SECRET_KEY, or as for the AWS SDKs)")
+	fs.StringVar(&c.S3SSE, "s3-sse", c.S3SSE, "server-side encryption of the objects written: s3, kms or c (S3_SSE_CUSTOMER_KEY)")
+	fs.StringVar(&c.S3SSEKMSKey, "s3-sse-kms-key", c.S3SSEKMSKey, "KMS key ID for -s3-sse kms (default: the account's)")
+	fs.StringVar(&c.BackupBackend, "backup-backend", c.BackupBackend, "backup storage: disk or s3")
+	fs.StringVar(&c.BackupDir, "backup-dir", c.BackupDir, "directory backups are kept in with -backup-backend disk")
+	fs.DurationVar(&c.BackupInterval, "backup-interval", c.BackupInterval, "how often a backup is taken (0 for only on demand)")
+	fs.IntVar(&c.BackupKeep, "backup-keep", c.BackupKeep, "backups kept; older ones are deleted")
+}
+
+// Load builds the Config for the given command-line arguments.
+func Load(args []string) (Config, error) {
+	// First pass only finds -config; the other flags are applied last so
+	// they win over the file and the environment.
+	var file string
+	scratch := Default()
+	pre := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	pre.SetOutput(io.Discard)
+	bindFlags(pre, &scratch, &file)
+	if err := pre.Parse(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		// Let the real parse below report it with usage.
+		file = ""
+	}
+
+	cfg := Default()
+	if file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return cfg, err
+	}
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	bindFlags(fs, &cfg, &file)
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	if v := os.Getenv("PORT"); v != "" {
+		if _, err := strconv.Atoi(v); err != nil {
+			return fmt.Errorf("PORT: %q is not a number", v)
+		}
+		c.Addr = ":" + v
+	}
+	if v := os.Getenv("LISTEN"); v != "" {
+		c.Listen = SplitList(v)
+	}
+	// The AWS SDKs' variables, for deployments set up for those; the S3_
+	// ones below win.
+	for _, env := range []string{"AWS_DEFAULT_REGION", "AWS_REGION"} {
+		if v := os.Getenv(env); v != "" {
+			c.S3Region = v
+		}
+	}
+	for _, env := range []string{"AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_S3"} {
+		if v := os.Getenv(env); v != "" {
+			u, err := url.Parse(v)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("%s: %q is not an http(s) URL", env, v)
+			}
+			c.S3Endpoint, c.S3Insecure = u.Host, u.Scheme == "http"
+		}
+	}
+	for env, dst := range map[string]*string{
+		"INTERNAL_ADDR":     &c.InternalAddr,
+		"TEMPLATE_DIR":      &c.TemplateDir,
+		"STATIC_DIR":        &c.StaticDir,
+		"THEME":             &c.Theme,
+		"STORE_BACKEND":     &c.StoreBackend,
+		"DB_PATH":           &c.DBPath,
+		"DATABASE_URL":      &c.DatabaseURL,
+		"LOG_FORMAT":        &c.LogFormat,
+		"ACCESS_LOG":        &c.AccessLog,
+		"ACCESS_LOG_FORMAT": &c.AccessLogFormat,
+		"TLS_CERT":          &c.TLSCert,
+		"TLS_KEY":           &c.TLSKey,
+		"ACME_DOMAIN":       &c.ACMEDomain,
+		"ACME_CACHE":        &c.ACMECache,
+		"ACME_EMAIL":        &c.ACMEEmail,
+		"HTTP_ADDR":         &c.HTTPAddr,
+		"HTTP3_ADDR":        &c.HTTP3Addr,
+		"GRPC_ADDR":         &c.GRPCAddr,
+		"TRUSTED_PROXIES":   &c.TrustedProxies,
+		"CORS_ORIGINS":      &c.CORSOrigins,
+		"CORS_METHODS":      &c.CORSMethods,
+		"CORS_HEADERS":      &c.CORSHeaders,
+
+		"OIDC_ISSUER":          &c.OIDCIssuer,
+		"OIDC_CLIENT_ID":       &c.OIDCClientID,
+		"OIDC_CLIENT_SECRET":   &c.OIDCClientSecret,
+		"OIDC_NAME":            &c.OIDCName,
+		"OIDC_USERNAME_CLAIM":  &c.OIDCUsernameClaim,
+		"OIDC_ROLE_CLAIM":      &c.OIDCRoleClaim,
+		"GITHUB_CLIENT_ID":     &c.GitHubClientID,
+		"GITHUB_CLIENT_SECRET": &c.GitHubClientSecret,
+		"SSO_ROLE_MAP":         &c.SSORoleMap,
+		"SSO_DEFAULT_ROLE":     &c.SSODefaultRole,
+		"PUBLIC_URL":           &c.PublicURL,
+		"AUTH_BACKEND":         &c.AuthBackend,
+		"LDAP_URL":             &c.LDAPURL,
+		"LDAP_BIND_DN":         &c.LDAPBindDN,
+		"LDAP_BIND_PASSWORD":   &c.LDAPBindPassword,
+		"LDAP_BASE_DN":         &c.LDAPBaseDN,
+		"LDAP_USER_FILTER":     &c.LDAPUserFilter,
+		"LDAP_GROUP_ATTR":      &c.LDAPGroupAttr,
+		"LDAP_ROLE_MAP":        &c.LDAPRoleMap,
+		"JWT_KEYS":             &c.JWTKeys,
+		"BLOB_BACKEND":         &c.BlobBackend,
+		"ATTACHMENT_DIR":       &c.AttachmentDir,
+		"ATTACHMENT_TYPES":     &c.AttachmentTypes,
+		"S3_ENDPOINT":          &c.S3Endpoint,
+		"S3_BUCKET":            &c.S3Bucket,
+		"S3_REGION":            &c.S3Region,
+		"S3_ACCESS_KEY":        &c.S3AccessKey,
+		"S3_SECRET_KEY":        &c.S3SecretKey,
+		"S3_PREFIX":            &c.S3Prefix,
+		"S3_SSE":               &c.S3SSE,
+		"S3_SSE_KMS_KEY":       &c.S3SSEKMSKey,
+		"S3_SSE_CUSTOMER_KEY":  &c.S3SSECustomerKey,
+		"BACKUP_BACKEND":       &c.BackupBackend,
+		"BACKUP_DIR":           &c.BackupDir,
+
+		"GITHUB_WEBHOOK_SECRET": &c.GitHubWebhookSecret,
+		"JENKINS_TOKEN":         &c.JenkinsToken,
+		"SLACK_WEBHOOK_URL":     &c.SlackWebhookURL,
+		"SENTRY_DSN":            &c.SentryDSN,
+		"SMTP_ADDR":             &c.SMTPAddr,
+		"SMTP_USERNAME":         &c.SMTPUsername,
+		"SMTP_PASSWORD":         &c.SMTPPassword,
+		"MAIL_FROM":             &c.MailFrom,
+		"MONITORS":              &c.Monitors,
+		"REDIS_URL":             &c.RedisURL,
+		"REDIS_PASSWORD":        &c.RedisPassword,
+		"PUBSUB":                &c.PubSub,
+		"NATS_URL":              &c.NATSURL,
+	} {
+		if v, ok := os.LookupEnv(env); ok {
+			*dst = v
+		}
+	}
+	for env, dst := range map[string]*time.Duration{
+		"READ_TIMEOUT":      &c.ReadTimeout,
+		"WRITE_TIMEOUT":     &c.WriteTimeout,
+		"IDLE_TIMEOUT":      &c.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":  &c.ShutdownTimeout,
+		"API_QUOTA_WINDOW":  &c.APIQuotaWindow,
+		"CACHE_TTL":         &c.CacheTTL,
+		"CORS_MAX_AGE":      &c.CORSMaxAge,
+		"JWT_TTL":           &c.JWTTTL,
+		"ACCESS_LOG_ROTATE": &c.AccessLogRotate,
+		"MONITOR_INTERVAL":  &c.MonitorInterval,
+		"BACKUP_INTERVAL":   &c.BackupInterval,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+			*dst = d
+		}
+	}
+	for env, dst := range map[string]*bool{
+		"DEV":              &c.Dev,
+		"MAINTENANCE":      &c.Maintenance,
+		"AUTO_MIGRATE":     &c.AutoMigrate,
+		"TRUST_PROXY":      &c.TrustProxy,
+		"H2C":              &c.H2C,
+		"CORS_CREDENTIALS": &c.CORSCredentials,
+
+		"LDAP_START_TLS": &c.LDAPStartTLS,
+		"S3_INSECURE":    &c.S3Insecure,
+	} {
+		if v := os.Getenv(env); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+			*dst = b
+		}
+	}
+	for env, dst := range map[string]*int{
+		"RATE_LIMIT": &c.RateLimit,
+		"RATE_BURST": &c.RateBurst,
+		"API_QUOTA":  &c.APIQuota,
+
+		"ATTACHMENT_MAX_MB":    &c.AttachmentMaxMB,
+		"ATTACHMENT_MAX_FILES": &c.AttachmentMaxFiles,
+		"JOB_WORKERS":          &c.JobWorkers,
+		"ACCESS_LOG_MAX_MB":    &c.AccessLogMaxMB,
+		"ACCESS_LOG_KEEP":      &c.AccessLogKeep,
+		"BACKUP_KEEP":          &c.BackupKeep,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+			*dst = n
+		}
+	}
+	return nil
+}
+
+// listFlag is a flag that may be repeated, each time adding a value. The
+// first one on the command line replaces what the file or environment set.
+type listFlag struct {
+	dst *[]string
+	set bool // by the command line
+}
+
+func (f *listFlag) String() string {
+	if f == nil || f.dst == nil {
+		return ""
+	}
+	return strings.Join(*f.dst, ",")
+}
+
+func (f *listFlag) Set(v string) error {
+	if !f.set {
+		*f.dst = nil
+		f.set = true
+	}
+	*f.dst = append(*f.dst, v)
+	return nil
+}
+
+// ListenAddrs returns the addresses the main server listens on.
+func (c Config) ListenAddrs() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []string{c.Addr}
+}
+
+// checkListenAddr reports whether addr is host:port or unix: and a path.
+func checkListenAddr(addr string) error {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return fmt.Errorf("%q needs a socket path", addr)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("%q is neither host:port nor unix:/path", addr)
+	}
+	return nil
+}
+
+// Proxies returns the proxies c trusts: TrustedProxies, or with
+// TrustProxy any peer at all.
+func (c Config) Proxies() (*middleware.ProxyList, error) {
+	if c.TrustProxy {
+		return middleware.ParseProxyList("0.0.0.0/0, ::/0, unix")
+	}
+	return middleware.ParseProxyList(c.TrustedProxies)
+}
+
+// tagPattern is the rule for monitor names, the same as for tags.
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// SplitList splits a comma-separated setting, dropping blanks.
+func SplitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// JWTKey is one signing secret, named by the token's kid header.
+type JWTKey struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseJWTKeys reads JWT_KEYS, comma-separated kid:secret entries with the
+// current key first.
+func ParseJWTKeys(s string) ([]JWTKey, error) {
+	var keys []JWTKey
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("entry %q is not kid:secret", entry)
+		}
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("key %q: secret must be at least 32 characters", id)
+		}
+		for _, k := range keys {
+			if k.ID == id {
+				return nil, fmt.Errorf("key %q listed twice", id)
+			}
+		}
+		keys = append(keys, JWTKey{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// ParseRoleMap reads "value=role,value=role".
+func ParseRoleMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		value, role, ok := strings.Cut(pair, "=")
+		if !ok || value == "" || !store.ValidRole(role) {
+			return nil, fmt.Errorf("%q is not value=role with a known role", pair)
+		}
+		m[value] = role
+	}
+	return m, nil
+}
+
+// Monitor is a target probed for uptime.
+type Monitor struct {
+	Name   string
+	Target string // http(s) URL or tcp://host:port
+}
+
+// SelfMonitor is the built-in monitor that runs the server's own health
+// checks, the ones behind /readyz.
+const SelfMonitor = "self"
+
+// ParseMonitors reads "name=target,name=target". Names follow the rules for
+// tags; SelfMonitor is taken.
+func ParseMonitors(s string) ([]Monitor, error) {
+	var ms []Monitor
+	seen := map[string]bool{SelfMonitor: true}
+	for _, pair := range SplitList(s) {
+		name, target, ok := strings.Cut(pair, "=")
+		name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+		if !ok || !tagPattern.MatchString(name) {
+			return nil, fmt.Errorf("%q is not name=target with a name of up to 32 lowercase letters, digits, '.', '_' or '-'", pair)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("monitor name %q is used twice or reserved", name)
+		}
+		seen[name] = true
+		u, err := url.Parse(target)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("%s: %w", name, err)
+		case (u.Scheme == "http" || u.Scheme == "https") && u.Host != "":
+		case u.Scheme == "tcp" && u.Hostname() != "" && u.Port() != "":
+		default:
+			return nil, fmt.Errorf("%s: target %q is not an http(s) URL or tcp://host:port", name, target)
+		}
+		ms = append(ms, Monitor{Name: name, Target: target})
+	}
+	return ms, nil
+}
+
+// TLSEnabled reports whether the main listener serves HTTPS, either from a
+// certificate on disk or from Let's Encrypt.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCert != "" || c.ACMEDomain != ""
+}
+
+// ACMEDomains splits the comma-separated acme_domain setting.
+func (c Config) ACMEDomains() []string {
+	return SplitList(c.ACMEDomain)
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	if c.Addr == "" && len(c.Listen) == 0 {
+		errs = append(errs, errors.New("addr must not be empty"))
+	}
+	seen := map[string]bool{}
+	for _, addr := range c.ListenAddrs() {
+		if err := checkListenAddr(addr); err != nil {
+			errs = append(errs, fmt.Errorf("listen: %w", err))
+		}
+		if seen[addr] {
+			errs = append(errs, fmt.Errorf("listen: %q is given twice", addr))
+		}
+		seen[addr] = true
+	}
+	if c.AccessLogFormat != "combined" && c.AccessLogFormat != "json" {
+		errs = append(errs, fmt.Errorf("unknown access log format %q (want combined or json)", c.AccessLogFormat))
+	}
+	if c.AccessLogMaxMB < 0 || c.AccessLogRotate < 0 || c.AccessLogKeep < 0 {
+		errs = append(errs, errors.New("access_log_max_mb, access_log_rotate and access_log_keep must not be negative"))
+	}
+	if c.InternalAddr != "" && seen[c.InternalAddr] {
+		errs = append(errs, errors.New("internal_addr must differ from the main listen addresses"))
+	}
+	if c.GRPCAddr != "" {
+		if err := checkListenAddr(c.GRPCAddr); err != nil {
+			errs = append(errs, fmt.Errorf("grpc_addr: %w", err))
+		}
+		if seen[c.GRPCAddr] || c.GRPCAddr == c.InternalAddr {
+			errs = append(errs, errors.New("grpc_addr must differ from the other listen addresses"))
+		}
+	}
+	if c.Dev {
+		// Hot reload only makes sense for files on disk.
+		if c.TemplateDir == "" {
+			c.TemplateDir = "templates"
+		}
+		if c.StaticDir == "" {
+			c.StaticDir = "static"
+		}
+	}
+	for name, dir := range map[string]string{"template_dir": c.TemplateDir, "static_dir": c.StaticDir} {
+		if dir == "" {
+			continue
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("%s %q is not a directory", name, dir))
+		}
+	}
+	if c.StoreBackend == "" {
+		c.StoreBackend = "sqlite"
+		if c.DBPath == "" {
+			c.StoreBackend = "memory"
+		}
+	}
+	switch c.StoreBackend {
+	case "memory":
+	case "sqlite":
+		if c.DBPath == "" {
+			errs = append(errs, errors.New("the sqlite store needs db_path"))
+		}
+	case "postgres":
+		if c.DatabaseURL == "" {
+			errs = append(errs, errors.New("the postgres store needs database_url"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown store backend %q", c.StoreBackend))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("unknown log format %q (want text or json)", c.LogFormat))
+	}
+	if c.ReadTimeout <= 0 || c.IdleTimeout <= 0 || c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("read, idle and shutdown timeouts must be positive"))
+	}
+	if c.RateLimit < 0 || (c.RateLimit > 0 && c.RateBurst < 1) {
+		errs = append(errs, errors.New("rate_limit must not be negative and rate_burst must be at least 1"))
+	}
+	if c.APIQuota < 0 || c.APIQuotaWindow <= 0 {
+		errs = append(errs, errors.New("api_quota must not be negative and api_quota_window must be positive"))
+	}
+	if c.CacheTTL < 0 {
+		errs = append(errs, errors.New("cache_ttl must not be negative"))
+	}
+	if c.RedisURL != "" {
+		if u, err := url.Parse(c.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("redis_url %q must look like redis://host:port/db or rediss:// for TLS", c.RedisURL))
+		}
+	}
+	switch c.PubSub {
+	case "":
+	case "redis":
+		if c.RedisURL == "" {
+			errs = append(errs, errors.New("pubsub redis needs redis_url"))
+		}
+	case "nats":
+		if c.NATSURL == "" {
+			errs = append(errs, errors.New("pubsub nats needs nats_url"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown pubsub %q (want redis or nats)", c.PubSub))
+	}
+	for _, o := range SplitList(c.CORSOrigins) {
+		if o == "*" {
+			if c.CORSCredentials {
+				errs = append(errs, errors.New("cors_credentials needs explicit cors_origins, not *"))
+			}
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("cors origin %q must look like https://host[:port]", o))
+		}
+	}
+	if c.CORSMaxAge < 0 {
+		errs = append(errs, errors.New("cors_max_age must not be negative"))
+	}
+	if c.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+			errs = append(errs, fmt.Errorf("smtp_addr: %w", err))
+		}
+		if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+			errs = append(errs, fmt.Errorf("mail_from %q is not an email address", c.MailFrom))
+		}
+		if c.PublicURL == "" {
+			errs = append(errs, errors.New("email subscriptions need public_url for the links they send"))
+		}
+	}
+	if c.JobWorkers < 1 {
+		errs = append(errs, errors.New("job_workers must be at least 1"))
+	}
+	if c.AttachmentMaxFiles < 0 || c.AttachmentMaxMB < 1 {
+		errs = append(errs, errors.New("attachment_max_files must not be negative and attachment_max_mb must be at least 1"))
+	}
+	switch c.BlobBackend {
+	case "disk":
+		if c.AttachmentDir == "" {
+			errs = append(errs, errors.New("the disk blob backend needs attachment_dir"))
+		}
+	case "s3":
+		if c.S3Bucket == "" {
+			errs = append(errs, errors.New("the s3 blob backend needs s3_bucket"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown blob backend %q (want disk or s3)", c.BlobBackend))
+	}
+	switch c.BackupBackend {
+	case "disk":
+		if c.BackupDir == "" {
+			errs = append(errs, errors.New("the disk backup backend needs backup_dir"))
+		}
+	case "s3":
+		if c.S3Bucket == "" {
+			errs = append(errs, errors.New("the s3 backup backend needs s3_bucket"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown backup backend %q (want disk or s3)", c.BackupBackend))
+	}
+	switch c.S3SSE {
+	case "", "s3", "kms":
+	case "c":
+		if key, err := base64.StdEncoding.DecodeString(c.S3SSECustomerKey); err != nil || len(key) != 32 {
+			errs = append(errs, errors.New("s3_sse c needs s3_sse_customer_key, 32 bytes base64-encoded"))
+		}
+		if c.S3Insecure {
+			errs = append(errs, errors.New("s3_sse c needs HTTPS; S3 refuses customer keys over plain HTTP"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown s3_sse %q (want s3, kms or c)", c.S3SSE))
+	}
+	if c.S3SSEKMSKey != "" && c.S3SSE != "kms" {
+		errs = append(errs, errors.New("s3_sse_kms_key needs s3_sse kms"))
+	}
+	if c.BackupInterval < 0 || (c.BackupInterval > 0 && c.BackupInterval < time.Minute) {
+		errs = append(errs, errors.New("backup_interval must be 0 or at least a minute"))
+	}
+	if c.BackupKeep < 1 {
+		errs = append(errs, errors.New("backup_keep must be at least 1"))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	if c.ACMEDomain != "" {
+		if c.TLSCert != "" {
+			errs = append(errs, errors.New("acme_domain and tls_cert are mutually exclusive"))
+		}
+		if len(c.ACMEDomains()) == 0 || c.ACMECache == "" {
+			errs = append(errs, errors.New("acme_domain needs at least one domain and an acme_cache directory"))
+		}
+		if c.HTTPAddr == "" {
+			c.HTTPAddr = ":80"
+		}
+	}
+	if _, err := middleware.ParseProxyList(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+	if c.TrustProxy && c.TrustedProxies != "" {
+		errs = append(errs, errors.New("trust_proxy trusts every peer; set it or trusted_proxies, not both"))
+	}
+	if c.HTTP3Addr != "" {
+		if !c.TLSEnabled() {
+			errs = append(errs, errors.New("http3_addr needs tls_cert or acme_domain"))
+		}
+		if _, _, err := net.SplitHostPort(c.HTTP3Addr); err != nil {
+			errs = append(errs, fmt.Errorf("http3_addr: %w", err))
+		}
+	}
+	if c.H2C && c.TLSEnabled() {
+		errs = append(errs, errors.New("h2c is for plain HTTP; with TLS, HTTP/2 is negotiated anyway"))
+	}
+	if c.OIDCIssuer != "" && (c.OIDCClientID == "" || c.OIDCClientSecret == "") {
+		errs = append(errs, errors.New("oidc_issuer needs oidc_client_id and oidc_client_secret"))
+	}
+	if (c.GitHubClientID == "") != (c.GitHubClientSecret == "") {
+		errs = append(errs, errors.New("github_client_id and github_client_secret must be set together"))
+	}
+	if !store.ValidRole(c.SSODefaultRole) {
+		errs = append(errs, fmt.Errorf("unknown sso_default_role %q", c.SSODefaultRole))
+	}
+	if _, err := ParseRoleMap(c.SSORoleMap); err != nil {
+		errs = append(errs, fmt.Errorf("sso_role_map: %w", err))
+	}
+	switch c.AuthBackend {
+	case "local":
+	case "ldap":
+		if c.LDAPURL == "" || c.LDAPBaseDN == "" {
+			errs = append(errs, errors.New("the ldap auth backend needs ldap_url and ldap_base_dn"))
+		}
+		if strings.Count(c.LDAPUserFilter, "%s") != 1 {
+			errs = append(errs, errors.New("ldap_user_filter must contain %s once"))
+		}
+		if _, err := ParseRoleMap(c.LDAPRoleMap); err != nil {
+			errs = append(errs, fmt.Errorf("ldap_role_map: %w", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown auth backend %q (want local or ldap)", c.AuthBackend))
+	}
+	if _, err := ParseJWTKeys(c.JWTKeys); err != nil {
+		errs = append(errs, fmt.Errorf("jwt_keys: %w", err))
+	}
+	if c.JWTTTL <= 0 {
+		errs = append(errs, errors.New("jwt_ttl must be positive"))
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("public_url %q must be an absolute http(s) URL", c.PublicURL))
+		}
+	}
+	if _, err := ParseMonitors(c.Monitors); err != nil {
+		errs = append(errs, fmt.Errorf("monitors: %w", err))
+	}
+	if c.MonitorInterval < 5*time.Second {
+		errs = append(errs, errors.New("monitor_interval must be at least 5s"))
+	}
+	if c.WriteTimeout < 0 {
+		errs = append(errs, errors.New("write timeout must not be negative"))
+	}
+	return errors.Join(errs...)
+}
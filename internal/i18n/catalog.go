@@ -0,0 +1,156 @@
+package i18n
+
+import (
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message/catalog"
+)
+
+// translations maps each key, the English text, to its translation. Keys
+// that need plural forms, English included, are in plurals instead.
+var translations = map[language.Tag]map[string]string{
+	language.German: {
+		// index.html
+		"All":                                 "Alle",
+		"Channels…":                           "Kanäle…",
+		"Private: only admins":                "Privat: nur Admins",
+		"Archived":                            "Archiviert",
+		"No new messages can be posted here.": "Hier können keine neuen Nachrichten gepostet werden.",
+		"Welcome":                             "Willkommen",
+		"Channel":                             "Kanal",
+		"Your name":                           "Dein Name",
+		"Email for your Gravatar (optional, never shown)":                 "E-Mail für dein Gravatar (optional, wird nie angezeigt)",
+		"Message (Markdown: **bold**, `code`, [links](https://…), lists)": "Nachricht (Markdown: **fett**, `Code`, [Links](https://…), Listen)",
+		"Tags, e.g. deploy, release":                                      "Tags, z. B. deploy, release",
+		"Attach files":                                                    "Dateien anhängen",
+		"Schedule":                                                        "Planen",
+		"Publish at":                                                      "Veröffentlichen am",
+		"Expires at":                                                      "Läuft ab am",
+		"Times are UTC. Leave publish empty to post now, expiry empty to keep the message.": "Zeiten in UTC. Ohne Veröffentlichungszeit wird sofort gepostet, ohne Ablaufzeit bleibt die Nachricht stehen.",
+		"Your message was not posted. Fix the fields marked below.":                         "Deine Nachricht wurde nicht gepostet. Korrigiere die markierten Felder.",
+		"Post":            "Posten",
+		"Log in to post.": "Melde dich an, um zu posten.",
+		"Search #%s":      "#%s durchsuchen",
+		"Search messages": "Nachrichten durchsuchen",
+		"Search":          "Suchen",
+		"Clear":           "Zurücksetzen",
+		"Scheduled":       "Geplant",
+		"Anonymous":       "Anonym",
+		"Edit":            "Bearbeiten",
+		"Show all":        "Alle anzeigen",
+		// partials/message.html
+		"Pinned":                          "Angeheftet",
+		"Raw":                             "Quelltext",
+		"in #%s":                          "in #%s",
+		"React":                           "Reagieren",
+		"edited (%d)":                     "bearbeitet (%d)",
+		"(edited)":                        "(bearbeitet)",
+		"(reply)":                         "(Antwort)",
+		"expires":                         "läuft ab",
+		"Pin":                             "Anheften",
+		"Unpin":                           "Lösen",
+		"Move this message to the trash?": "Diese Nachricht in den Papierkorb verschieben?",
+		"Delete":                          "Löschen",
+		"Reply":                           "Antworten",
+		"Reply (Markdown)":                "Antwort (Markdown)",
+		// about.html
+		"About": "Über",
+		"This is a demo Go website using only net/http and html/template.": "Dies ist eine Demo-Website in Go, die nur net/http und html/template verwendet.",
+		// Locale.Ago
+		"just now": "gerade eben",
+		"%dm ago":  "vor %d Min.",
+		"%dh ago":  "vor %d Std.",
+		"in %dm":   "in %d Min.",
+		"in %dh":   "in %d Std.",
+	},
+	language.French: {
+		// index.html
+		"All":                                 "Tous",
+		"Channels…":                           "Canaux…",
+		"Private: only admins":                "Privé : admins seulement",
+		"Archived":                            "Archivé",
+		"No new messages can be posted here.": "Impossible d’y publier de nouveaux messages.",
+		"Welcome":                             "Bienvenue",
+		"Channel":                             "Canal",
+		"Your name":                           "Votre nom",
+		"Email for your Gravatar (optional, never shown)":                 "E-mail pour votre Gravatar (facultatif, jamais affiché)",
+		"Message (Markdown: **bold**, `code`, [links](https://…), lists)": "Message (Markdown : **gras**, `code`, [liens](https://…), listes)",
+		"Tags, e.g. deploy, release":                                      "Tags, p. ex. deploy, release",
+		"Attach files":                                                    "Joindre des fichiers",
+		"Schedule":                                                        "Programmer",
+		"Publish at":                                                      "Publier le",
+		"Expires at":                                                      "Expire le",
+		"Times are UTC. Leave publish empty to post now, expiry empty to keep the message.": "Heures en UTC. Laissez la publication vide pour publier tout de suite, l’expiration vide pour garder le message.",
+		"Your message was not posted. Fix the fields marked below.":                         "Votre message n’a pas été publié. Corrigez les champs indiqués.",
+		"Post":            "Publier",
+		"Log in to post.": "Connectez-vous pour publier.",
+		"Search #%s":      "Rechercher dans #%s",
+		"Search messages": "Rechercher des messages",
+		"Search":          "Rechercher",
+		"Clear":           "Effacer",
+		"Scheduled":       "Programmés",
+		"Anonymous":       "Anonyme",
+		"Edit":            "Modifier",
+		"Show all":        "Tout afficher",
+		// partials/message.html
+		"Pinned":                          "Épinglé",
+		"Raw":                             "Source",
+		"in #%s":                          "dans #%s",
+		"React":                           "Réagir",
+		"edited (%d)":                     "modifié (%d)",
+		"(edited)":                        "(modifié)",
+		"(reply)":                         "(réponse)",
+		"expires":                         "expire",
+		"Pin":                             "Épingler",
+		"Unpin":                           "Désépingler",
+		"Move this message to the trash?": "Mettre ce message à la corbeille ?",
+		"Delete":                          "Supprimer",
+		"Reply":                           "Répondre",
+		"Reply (Markdown)":                "Réponse (Markdown)",
+		// about.html
+		"About": "À propos",
+		"This is a demo Go website using only net/http and html/template.": "Ceci est un site de démonstration en Go qui n’utilise que net/http et html/template.",
+		// Locale.Ago
+		"just now": "à l’instant",
+		"%dm ago":  "il y a %d min",
+		"%dh ago":  "il y a %d h",
+		"%dd ago":  "il y a %d j",
+		"in %dm":   "dans %d min",
+		"in %dh":   "dans %d h",
+		"in %dd":   "dans %d j",
+	},
+}
+
+// plurals are the keys whose text depends on a count, the first argument:
+// the form for exactly one, then the one for any other count.
+var plurals = map[language.Tag]map[string][2]string{
+	language.English: {
+		"%d results for “%s”": {"1 result for “%[2]s”", "%[1]d results for “%[2]s”"},
+		"%d messages tagged":  {"1 message tagged", "%d messages tagged"},
+	},
+	language.German: {
+		"%d results for “%s”": {"1 Ergebnis für „%[2]s“", "%[1]d Ergebnisse für „%[2]s“"},
+		"%d messages tagged":  {"1 Nachricht mit dem Tag", "%d Nachrichten mit dem Tag"},
+		"%dd ago":             {"vor 1 Tag", "vor %d Tagen"},
+		"in %dd":              {"in 1 Tag", "in %d Tagen"},
+	},
+	language.French: {
+		"%d results for “%s”": {"1 résultat pour « %[2]s »", "%[1]d résultats pour « %[2]s »"},
+		"%d messages tagged":  {"1 message avec le tag", "%d messages avec le tag"},
+	},
+}
+
+var messages = func() *catalog.Builder {
+	b := catalog.NewBuilder(catalog.Fallback(Languages[0].Tag))
+	for tag, msgs := range translations {
+		for key, msg := range msgs {
+			b.SetString(tag, key, msg)
+		}
+	}
+	for tag, msgs := range plurals {
+		for key, forms := range msgs {
+			b.Set(tag, key, plural.Selectf(1, "%d", "=1", forms[0], "other", forms[1]))
+		}
+	}
+	return b
+}()
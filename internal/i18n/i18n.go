@@ -0,0 +1,163 @@
+// Package i18n translates the board's pages: a message catalog for the
+// languages below, language negotiation and times formatted for the
+// reader's locale.
+package i18n
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Language is one the pages are translated into.
+type Language struct {
+	Tag  language.Tag
+	Name string // in the language itself, for the language picker
+}
+
+// Code is the language's BCP 47 code, e.g. "de".
+func (l Language) Code() string { return l.Tag.String() }
+
+// Languages are the supported languages. The first is the fallback, and
+// the language the catalog's keys are written in.
+var Languages = []Language{
+	{language.English, "English"},
+	{language.German, "Deutsch"},
+	{language.French, "Français"},
+}
+
+var matcher = func() language.Matcher {
+	tags := make([]language.Tag, len(Languages))
+	for i, l := range Languages {
+		tags[i] = l.Tag
+	}
+	return language.NewMatcher(tags)
+}()
+
+// Locale translates text and formats times for one language, in one time
+// zone. It is meant for one request: unlike the catalog, it is not safe
+// for concurrent use.
+type Locale struct {
+	Language
+	p   *message.Printer
+	loc *time.Location
+}
+
+// Negotiate returns the Locale for the first of langs that matches a
+// supported language, each being a language code or an Accept-Language
+// header, and for the fallback if none does.
+func Negotiate(langs ...string) *Locale {
+	_, i := language.MatchStrings(matcher, langs...)
+	return newLocale(Languages[i])
+}
+
+// Lookup returns the Locale for code if it names a supported language.
+func Lookup(code string) (*Locale, bool) {
+	tag, err := language.Parse(code)
+	if err != nil {
+		return nil, false
+	}
+	for _, l := range Languages {
+		if l.Tag == tag {
+			return newLocale(l), true
+		}
+	}
+	return nil, false
+}
+
+func newLocale(l Language) *Locale {
+	return &Locale{l, message.NewPrinter(l.Tag, message.Catalog(messages)), time.UTC}
+}
+
+// In makes l show times in loc rather than UTC, and returns l.
+func (l *Locale) In(loc *time.Location) *Locale {
+	l.loc = loc
+	return l
+}
+
+// Zone is the name of the time zone l shows times in.
+func (l *Locale) Zone() string {
+	return l.loc.String()
+}
+
+// T translates key, an English format string, and formats args with it
+// the way the language writes numbers. Keys missing from the catalog are
+// used as they are.
+func (l *Locale) T(key string, args ...any) string {
+	return l.p.Sprintf(key, args...)
+}
+
+// Ago describes t relative to now: "3m ago", "in 2h", in the language's
+// words. Beyond a month it gives the date instead.
+func (l *Locale) Ago(t time.Time) string {
+	return l.ago(t, time.Now())
+}
+
+func (l *Locale) ago(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	var n int
+	var past, ahead string
+	switch {
+	case d < time.Minute:
+		return l.T("just now")
+	case d < time.Hour:
+		n, past, ahead = int(d/time.Minute), "%dm ago", "in %dm"
+	case d < 24*time.Hour:
+		n, past, ahead = int(d/time.Hour), "%dh ago", "in %dh"
+	case d < 30*24*time.Hour:
+		n, past, ahead = int(d/(24*time.Hour)), "%dd ago", "in %dd"
+	default:
+		return l.Date(t)
+	}
+	if future {
+		return l.T(ahead, n)
+	}
+	return l.T(past, n)
+}
+
+// Date formats the day of t, in l's time zone, the way the language writes
+// dates.
+func (l *Locale) Date(t time.Time) string {
+	t = t.In(l.loc)
+	f := dateFormats[l.Code()]
+	return fmt.Sprintf(f.date, t.Day(), f.months[t.Month()-1], t.Year())
+}
+
+// Time formats t in full, in l's time zone, which it names: for titles
+// and tooltips.
+func (l *Locale) Time(t time.Time) string {
+	t = t.In(l.loc)
+	return fmt.Sprintf(dateFormats[l.Code()].time, l.Date(t), t.Format("15:04 MST"))
+}
+
+// dateFormat is how a language writes dates: date takes the day, the
+// month's name and the year, time the date and the hour with its zone.
+type dateFormat struct {
+	months [12]string
+	date   string
+	time   string
+}
+
+var dateFormats = map[string]dateFormat{
+	"en": {
+		months: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		date:   "%[2]s %[1]d, %[3]d",
+		time:   "%s, %s",
+	},
+	"de": {
+		months: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		date:   "%d. %s %d",
+		time:   "%s, %s",
+	},
+	"fr": {
+		months: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		date:   "%d %s %d",
+		time:   "%s à %s",
+	},
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import "net/http"
+
+// Middleware wraps a handler in behaviour of its own.
+type Middleware func(http.Handler) http.Handler
+
+// Chain is middleware to apply in order: the first runs first on the way
+// in, so it sees the request before, and the response after, the rest.
+// The zero Chain applies nothing.
+type Chain struct {
+	mws []Middleware
+}
+
+// NewChain returns a chain of mws.
+func NewChain(mws ...Middleware) Chain {
+	return Chain{}.Use(mws...)
+}
+
+// Use returns c with mws appended, inside what c already has. c itself is
+// unchanged, so a chain can be shared by route groups that each add to it.
+func (c Chain) Use(mws ...Middleware) Chain {
+	all := make([]Middleware, 0, len(c.mws)+len(mws))
+	return Chain{mws: append(append(all, c.mws...), mws...)}
+}
+
+// Then returns h wrapped in the chain.
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c.mws) - 1; i >= 0; i-- {
+		h = c.mws[i](h)
+	}
+	return h
+}
+
+// ThenFunc is Then for a handler function.
+func (c Chain) ThenFunc(h http.HandlerFunc) http.Handler {
+	return c.Then(h)
+}
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ProxyList is a set of trusted proxies: the peers whose X-Forwarded-For,
+// X-Real-IP and X-Forwarded-Proto headers are believed. A nil list
+// believes nobody, so clients cannot pick their own address.
+type ProxyList struct {
+	prefixes []netip.Prefix
+	unix     bool // peers on a Unix socket, who have no address
+}
+
+// ParseProxyList parses a comma-separated list of CIDRs and addresses,
+// where "unix" stands for peers on a Unix socket. Empty is nil.
+func ParseProxyList(s string) (*ProxyList, error) {
+	var l ProxyList
+	for _, v := range splitList(s) {
+		if v == "unix" {
+			l.unix = true
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			a, aerr := netip.ParseAddr(v)
+			if aerr != nil {
+				return nil, fmt.Errorf("%q is neither a CIDR nor an IP address", v)
+			}
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		l.prefixes = append(l.prefixes, p.Masked())
+	}
+	if len(l.prefixes) == 0 && !l.unix {
+		return nil, nil
+	}
+	return &l, nil
+}
+
+// trusts reports whether the peer at addr is a trusted proxy. Peers on a
+// Unix socket have no IP address.
+func (l *ProxyList) trusts(addr string) bool {
+	if l == nil {
+		return false
+	}
+	a, err := netip.ParseAddr(addr)
+	if err != nil {
+		return l.unix
+	}
+	a = a.Unmap()
+	for _, p := range l.prefixes {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+type clientKey struct{}
+
+// client is what RealIP learned about a request's client.
+type client struct {
+	ip  string
+	tls bool // the client reached a trusted proxy over HTTPS
+}
+
+// RealIP works out the client's address once, believing the forwarding
+// headers of proxies, so the access log, rate limits, audit records and
+// traces all agree on it.
+func RealIP(proxies *ProxyList) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := client{
+				ip:  resolveClientIP(r, proxies),
+				tls: proxies.trusts(peerIP(r)) && r.Header.Get("X-Forwarded-Proto") == "https",
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, c)))
+		})
+	}
+}
+
+// ClientIP returns the address of the client that made r: the peer's,
+// unless RealIP learned better.
+func ClientIP(r *http.Request) string {
+	if c, ok := r.Context().Value(clientKey{}).(client); ok {
+		return c.ip
+	}
+	return peerIP(r)
+}
+
+// peerIP is the address r came from, without the port: the client, or the
+// last proxy on the way.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// resolveClientIP returns the client's address as proxies tell it. A
+// peer that is not trusted is the client. Otherwise X-Forwarded-For is
+// read from the right, each proxy having appended the address it was
+// reached from, and the first hop that is not a trusted proxy is the
+// client; the leftmost if they all are. Without X-Forwarded-For, a
+// trusted peer's X-Real-IP names the client.
+func resolveClientIP(r *http.Request, proxies *ProxyList) string {
+	peer := peerIP(r)
+	if !proxies.trusts(peer) {
+		return peer
+	}
+	var hops []string
+	for _, xff := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(xff, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// Garbage a proxy passed on; what it said before is no better.
+			break
+		}
+		client = hop
+		if !proxies.trusts(hop) {
+			break
+		}
+	}
+	if client != "" {
+		return client
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		if _, err := netip.ParseAddr(ip); err == nil {
+			return ip
+		}
+	}
+	return peer
+}
+
+// ForwardedTLS reports whether r reached a trusted proxy over HTTPS.
+func ForwardedTLS(r *http.Request) bool {
+	c, _ := r.Context().Value(clientKey{}).(client)
+	return c.tls
+}
+
+// splitList splits a comma-separated list, dropping blanks.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the smallest response, by Content-Length, worth
+// compressing. Responses without a length are always compressed.
+const compressMinSize = 1024
+
+// incompressibleTypes are content types that are already compressed, so
+// running them through gzip again only costs CPU.
+var incompressibleTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+	"font/woff", "font/woff2",
+	"application/gzip", "application/zip", "application/x-gzip",
+	"video/", "audio/",
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		zw, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return zw
+	}}
+	flateWriters = sync.Pool{New: func() any {
+		zw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return zw
+	}}
+)
+
+// Compress gzip- or deflate-encodes responses for clients that accept it.
+// WebSocket upgrades and HEAD requests pass straight through, and handlers
+// that set Content-Encoding themselves (such as /metrics) are left alone.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: enc}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" when neither is acceptable.
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressResponseWriter decides whether to compress when the header is
+// written, once the handler has set Content-Type and Content-Length.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	zw          io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressResponseWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	if cw.shouldCompress(code) {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// A strong ETag names the uncompressed bytes.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.zw = cw.newEncoder()
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressResponseWriter) shouldCompress(code int) bool {
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < compressMinSize {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(ct, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cw *compressResponseWriter) newEncoder() io.WriteCloser {
+	if cw.encoding == "gzip" {
+		zw := gzipWriters.Get().(*gzip.Writer)
+		zw.Reset(cw.ResponseWriter)
+		return zw
+	}
+	zw := flateWriters.Get().(*flate.Writer)
+	zw.Reset(cw.ResponseWriter)
+	return zw
+}
+
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.zw == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	return cw.zw.Write(p)
+}
+
+// Flush pushes buffered compressed bytes to the client, which streaming
+// handlers such as the SSE endpoint rely on.
+func (cw *compressResponseWriter) Flush() {
+	cw.FlushError()
+}
+
+// FlushError is used by http.ResponseController in preference to Flush.
+func (cw *compressResponseWriter) FlushError() error {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	var err error
+	switch zw := cw.zw.(type) {
+	case *gzip.Writer:
+		err = zw.Flush()
+	case *flate.Writer:
+		err = zw.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the compressed stream and returns the encoder to its pool.
+func (cw *compressResponseWriter) Close() error {
+	if cw.zw == nil {
+		return nil
+	}
+	err := cw.zw.Close()
+	switch zw := cw.zw.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(zw)
+	case *flate.Writer:
+		flateWriters.Put(zw)
+	}
+	cw.zw = nil
+	return err
+}
+
+func (cw *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer for
+// deadlines.
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsExposedHeaders are the response headers cross-origin scripts may
+// read besides the CORS-safelisted ones.
+const corsExposedHeaders = "ETag, Link, X-Total-Count, X-Request-ID, Idempotent-Replayed, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After"
+
+// CORSOptions lets browser pages on other origins call /api.
+type CORSOptions struct {
+	Origins     []string // exact origins, or "*" for any
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      time.Duration // how long preflight answers may be cached
+}
+
+type corsPolicy struct {
+	origins     []string
+	methods     string
+	headers     string
+	credentials bool
+	maxAge      string
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if it is not allowed. A wildcard policy answers "*" unless credentials
+// are allowed, which validate forbids.
+func (p *corsPolicy) allowOrigin(origin string) string {
+	if slices.Contains(p.origins, "*") {
+		return "*"
+	}
+	if slices.Contains(p.origins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// CORS adds CORS headers to /api responses for the origins opts allows and
+// answers their preflight requests itself, before authentication, as
+// browsers send preflights without credentials. With no origins the API
+// stays same-origin only.
+func CORS(opts CORSOptions) Middleware {
+	if len(opts.Origins) == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	p := &corsPolicy{
+		origins:     opts.Origins,
+		methods:     strings.Join(opts.Methods, ", "),
+		headers:     strings.Join(opts.Headers, ", "),
+		credentials: opts.Credentials,
+		maxAge:      strconv.Itoa(int(opts.MaxAge / time.Second)),
+	}
+	return p.handler
+}
+
+func (p *corsPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := p.allowOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if allowed == "" {
+			if preflight {
+				// Without the headers the browser blocks the real request.
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", allowed)
+		if p.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", p.methods)
+			h.Set("Access-Control-Allow-Headers", p.headers)
+			h.Set("Access-Control-Max-Age", p.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
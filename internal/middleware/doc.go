@@ -0,0 +1,6 @@
+// Package middleware holds the HTTP middleware that knows nothing of the
+// board itself: client addresses behind proxies, request IDs, access logs,
+// metrics, tracing, panic recovery, compression, CORS and HSTS. Each one
+// either is a Middleware or, when it takes options, returns one, and a
+// Chain layers them in a fixed order.
+package middleware
@@ -0,0 +1,17 @@
+package middleware
+
+import "net/http"
+
+// hstsMaxAge is how long browsers should insist on HTTPS once they have
+// seen the site over TLS.
+const hstsMaxAge = "max-age=31536000"
+
+// HSTS adds Strict-Transport-Security to responses sent over TLS.
+func HSTS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", hstsMaxAge)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
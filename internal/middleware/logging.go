@@ -0,0 +1,303 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLog writes one line per request.
+type AccessLog struct {
+	format string // "combined" or "json"
+	mu     sync.Mutex
+	w      io.Writer
+}
+
+// OpenAccessLog opens an access log in format, "combined" or "json", at
+// path: "-" for standard output, or a file rotated once it holds maxSize
+// bytes or is maxAge old, keeping keep rotated files. An empty path is no
+// access log, which is nil.
+func OpenAccessLog(path, format string, maxSize int64, maxAge time.Duration, keep int) (*AccessLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := openRotatingFile(path, maxSize, maxAge, keep)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &AccessLog{format: format, w: w}, nil
+}
+
+// accessEntry is one request as the access log records it.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	RemoteIP  string    `json:"remote_ip"`
+	User      string    `json:"user,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Type      string    `json:"content_type,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id"`
+	Duration  float64   `json:"duration_ms"`
+}
+
+func (l *AccessLog) log(e accessEntry) {
+	var b bytes.Buffer
+	if l.format == "json" {
+		json.NewEncoder(&b).Encode(e)
+	} else {
+		// Apache's combined format, with the request ID and content type
+		// added at the end.
+		fmt.Fprintf(&b, "%s - %s [%s] %s %d %d %s %s %s %s\n",
+			e.RemoteIP, orDash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			strconv.Quote(e.Method+" "+e.URI+" "+e.Proto), e.Status, e.Bytes,
+			strconv.Quote(orDash(e.Referer)), strconv.Quote(orDash(e.UserAgent)), strconv.Quote(e.RequestID), strconv.Quote(orDash(e.Type)))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(b.Bytes())
+}
+
+// orDash stands in "-" for an empty field, as Apache does.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+type accessUserKey struct{}
+
+// withAccessUser gives Logging's handlers somewhere to say who
+// the request turned out to be from, starting out as user.
+func withAccessUser(r *http.Request, user string) (*http.Request, *string) {
+	return r.WithContext(context.WithValue(r.Context(), accessUserKey{}, &user)), &user
+}
+
+// NoteAccessUser records user as the principal of r for the access log.
+// Handlers behind Logging call it when they learn of an API key or token
+// that Logging's own request did not carry.
+func NoteAccessUser(r *http.Request, user string) {
+	if p, ok := r.Context().Value(accessUserKey{}).(*string); ok {
+		*p = user
+	}
+}
+
+// rotatedSuffix is the layout of the time added to rotated file names.
+const rotatedSuffix = "20060102-150405.000"
+
+// rotatingFile is an append-only log file that is renamed aside, with a
+// timestamp suffix, once it holds maxSize bytes or is older than maxAge.
+// Zero disables either limit. Only the newest keep rotated files stay.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	full := rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize
+	old := rf.maxAge > 0 && time.Since(rf.opened) >= rf.maxAge
+	if full || old {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside, starts a new one and deletes the
+// oldest rotated files beyond keep.
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	renamed := os.Rename(rf.path, rf.path+"."+time.Now().UTC().Format(rotatedSuffix))
+	if err := rf.open(); err != nil {
+		return err
+	}
+	if renamed != nil {
+		return renamed
+	}
+	old, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return err
+	}
+	old = slices.DeleteFunc(old, func(name string) bool {
+		_, err := time.Parse(rotatedSuffix, strings.TrimPrefix(name, rf.path+"."))
+		return err != nil
+	})
+	slices.Sort(old) // the suffix sorts by time
+	for _, name := range old[:max(len(old)-rf.keep, 0)] {
+		os.Remove(name)
+	}
+	return nil
+}
+
+// Logging logs every request to l, or without an access log to slog, and
+// records its metrics and route for tracing. It goes inside the ServeMux,
+// around each route, so that r.Pattern is set. user names the principal of
+// a request as far as the middleware outside knows it.
+func Logging(l *AccessLog, user func(context.Context) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			lrw := &loggingResponseWriter{ResponseWriter: w, status: 200}
+			r, user := withAccessUser(r, user(r.Context()))
+			next.ServeHTTP(lrw, r)
+			elapsed := time.Since(start)
+			observeRequest(r, lrw, elapsed)
+			traceRequest(r, lrw.status)
+			if l == nil {
+				slog.InfoContext(r.Context(), "request", "method", r.Method, "path", r.URL.Path, "status", lrw.status,
+					"bytes", lrw.bytes, "content_type", lrw.contentType(), "duration", elapsed)
+				return
+			}
+			l.log(accessEntry{
+				Time:      start,
+				RemoteIP:  ClientIP(r),
+				User:      *user,
+				Method:    r.Method,
+				URI:       redactQuery(r.RequestURI),
+				Proto:     r.Proto,
+				Status:    lrw.status,
+				Bytes:     lrw.bytes,
+				Type:      lrw.contentType(),
+				Referer:   redactQuery(r.Referer()),
+				UserAgent: r.UserAgent(),
+				RequestID: RequestIDFrom(r.Context()),
+				Duration:  float64(elapsed.Microseconds()) / 1000,
+			})
+		})
+	}
+}
+
+// secretParams are query parameters kept out of the access log: the
+// Jenkins hook's shared secret, the subscription confirm and unsubscribe
+// tokens, and the like.
+var secretParams = []string{"token", "access_token", "api_key", "key", "secret", "password"}
+
+// redactQuery replaces the values of secretParams in the query of uri,
+// leaving the rest as it came.
+func redactQuery(uri string) string {
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok {
+		return uri
+	}
+	params := strings.Split(query, "&")
+	for i, p := range params {
+		name, _, _ := strings.Cut(p, "=")
+		if n, err := url.QueryUnescape(name); err == nil && slices.Contains(secretParams, strings.ToLower(n)) {
+			params[i] = name + "=REDACTED"
+		}
+	}
+	return path + "?" + strings.Join(params, "&")
+}
+
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64 // body bytes written, before any compression
+}
+
+// contentType is the media type of the response, without parameters.
+func (lrw *loggingResponseWriter) contentType() string {
+	t, _, _ := strings.Cut(lrw.Header().Get("Content-Type"), ";")
+	return strings.TrimSpace(t)
+}
+
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.bytes += int64(n)
+	return n, err
+}
+
+func (lrw *loggingResponseWriter) WriteHeader(code int) {
+	lrw.status = code
+	lrw.ResponseWriter.WriteHeader(code)
+}
+
+// Hijack passes through to the underlying writer so WebSocket upgrades
+// work behind the logging middleware.
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := lrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		lrw.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Flush passes through so streaming responses such as the SSE endpoint
+// reach the client as they are written, also for handlers that look for
+// http.Flusher rather than using http.ResponseController.
+func (lrw *loggingResponseWriter) Flush() {
+	lrw.FlushError()
+}
+
+// FlushError is used by http.ResponseController in preference to Flush.
+func (lrw *loggingResponseWriter) FlushError() error {
+	return http.NewResponseController(lrw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer's
+// SetWriteDeadline and the like.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
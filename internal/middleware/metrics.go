@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slrs_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "slrs_http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	panicsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "slrs_http_panics_total",
+		Help: "Handler panics recovered and answered with a 500.",
+	})
+
+	httpResponseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "slrs_http_response_size_bytes",
+		Help:    "HTTP response body size, before compression, by route and content type.",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 8), // 256B to 4MiB
+	}, []string{"route", "content_type"})
+)
+
+// observeRequest records one finished request. route is the ServeMux
+// pattern that matched, which keeps label cardinality bounded.
+func observeRequest(r *http.Request, lrw *loggingResponseWriter, elapsed time.Duration) {
+	route := r.Pattern
+	if route == "" {
+		route = "unmatched"
+	}
+	code := strconv.Itoa(lrw.status)
+	httpRequests.WithLabelValues(route, r.Method, code).Inc()
+	httpDuration.WithLabelValues(route, r.Method, code).Observe(elapsed.Seconds())
+	httpResponseSize.WithLabelValues(route, lrw.contentType()).Observe(float64(lrw.bytes))
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recover turns a panicking handler into a 500 response that quotes the
+// request ID, so a user's report can be matched with the stack trace in the
+// log and the event in Sentry.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// Deliberate: net/http drops the connection quietly.
+				panic(v)
+			}
+			reportPanic(r, v, debug.Stack())
+			id := RequestIDFrom(r.Context())
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal server error", "request_id": id})
+				return
+			}
+			http.Error(w, "Internal server error. Please quote request ID "+id+" when reporting this.", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reportPanic logs the panic v with its stack, counts it, marks the
+// request's span as failed and sends it to Sentry if that is set up.
+func reportPanic(r *http.Request, v any, stack []byte) {
+	ctx := r.Context()
+	slog.ErrorContext(ctx, "panic", "method", r.Method, "path", r.URL.Path, "err", v, "stack", string(stack))
+	panicsTotal.Inc()
+	trace.SpanFromContext(ctx).RecordError(fmt.Errorf("panic: %v", v))
+	traceRequest(r, http.StatusInternalServerError)
+	if sentry.CurrentHub().Client() == nil {
+		return
+	}
+	hub := sentry.CurrentHub().Clone()
+	hub.ConfigureScope(func(s *sentry.Scope) {
+		s.SetRequest(r)
+		s.SetTag("request_id", RequestIDFrom(ctx))
+	})
+	hub.RecoverWithContext(ctx, v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
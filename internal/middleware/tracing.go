@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans for requests. It does nothing until an exporting
+// provider is installed.
+var tracer = otel.Tracer("example.com/go-sample-site")
+
+// untracedPaths are hit every few seconds by probes and scrapers; like the
+// access log, traces leave them out.
+var untracedPaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// Tracing starts a server span for every request, continuing the trace of
+// an incoming traceparent header. The span is named after the method until
+// traceRequest learns the route.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if untracedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.ClientAddress(ClientIP(r)),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		))
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// traceRequest names the request's span after the ServeMux pattern that
+// matched and records the response status. Server errors mark the span
+// as failed.
+func traceRequest(r *http.Request, status int) {
+	span := trace.SpanFromContext(r.Context())
+	if r.Pattern != "" {
+		span.SetName(r.Method + " " + r.Pattern)
+		span.SetAttributes(semconv.HTTPRoute(r.Pattern))
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
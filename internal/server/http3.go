@@ -0,0 +1,63 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	"example.com/go-sample-site/internal/config"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// configureProtocols turns on cleartext HTTP/2 for srv if cfg asks for it.
+// Over TLS, HTTP/2 is negotiated without any of this.
+func configureProtocols(cfg config.Config, srv *http.Server) {
+	if !cfg.H2C {
+		return
+	}
+	var p http.Protocols
+	p.SetHTTP1(true)
+	p.SetUnencryptedHTTP2(true)
+	srv.Protocols = &p
+}
+
+// newHTTP3Server returns the HTTP/3 server for cfg.HTTP3Addr, or nil if it
+// is not set. It serves srv's handler with srv's certificates, so call it
+// after configureTLS. The main server then advertises it to browsers with
+// Alt-Svc, which they pick up on their next request.
+func newHTTP3Server(cfg config.Config, srv *http.Server) (*http3.Server, error) {
+	if cfg.HTTP3Addr == "" {
+		return nil, nil
+	}
+	tlsConfig, err := serverTLSConfig(cfg, srv)
+	if err != nil {
+		return nil, err
+	}
+	h3 := &http3.Server{
+		Addr:        cfg.HTTP3Addr,
+		Handler:     srv.Handler,
+		TLSConfig:   http3.ConfigureTLSConfig(tlsConfig),
+		IdleTimeout: cfg.IdleTimeout,
+	}
+	srv.Handler = altSvcMiddleware(h3, srv.Handler)
+	return h3, nil
+}
+
+// altSvcMiddleware announces the HTTP/3 server in responses sent over TLS.
+func altSvcMiddleware(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			h3.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveHTTP3 runs the HTTP/3 server on c until it is shut down.
+func serveHTTP3(srv *http3.Server, c net.PacketConn) {
+	slog.Info("HTTP/3 listener running", "addr", srv.Addr)
+	if err := srv.Serve(c); err != http.ErrServerClosed {
+		slog.Error("HTTP/3 listener", "err", err)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// listenFDsEnv tells a process started by a restart which listening
+// sockets it inherited: their addresses, comma-separated, in the order of
+// the file descriptors from 3 on.
+const listenFDsEnv = "SLRS_LISTEN_FDS"
+
+// restartParentEnv carries the PID of the process being replaced, which
+// the new one stops once it is serving.
+const restartParentEnv = "SLRS_RESTART_PARENT"
+
+var (
+	listenersMu sync.Mutex
+	// listeners are the open listening sockets by address, handed on to
+	// the next process at a restart: net.Listeners, and net.PacketConns
+	// under "udp:" and their address.
+	listeners = map[string]io.Closer{}
+	// inherited are the sockets handed over by the previous process that
+	// listen and listenPacket have not claimed yet.
+	inherited = map[string]io.Closer{}
+)
+
+// inheritListeners picks up the sockets of the process this one replaces,
+// if it was started by a restart.
+func inheritListeners() error {
+	v := os.Getenv(listenFDsEnv)
+	os.Unsetenv(listenFDsEnv)
+	if v == "" {
+		return nil
+	}
+	for i, addr := range strings.Split(v, ",") {
+		f := os.NewFile(uintptr(3+i), addr)
+		var s io.Closer
+		var err error
+		if strings.HasPrefix(addr, "udp:") {
+			s, err = net.FilePacketConn(f)
+		} else {
+			s, err = net.FileListener(f)
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("inherited listener %s: %w", addr, err)
+		}
+		inherited[addr] = s
+	}
+	return nil
+}
+
+// listen returns a listener on addr, a TCP host:port or unix: and the path
+// of a Unix domain socket: the one inherited from the previous process if
+// there is one, so connections waiting on it are not lost, or else a new
+// one.
+func listen(addr string) (net.Listener, error) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	l, ok := inherited[addr].(net.Listener)
+	if ok {
+		delete(inherited, addr)
+	} else {
+		var err error
+		if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+			l, err = listenUnix(path)
+		} else {
+			l, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	listeners[addr] = l
+	return l, nil
+}
+
+// listenPacket is listen for the UDP address addr.
+func listenPacket(addr string) (net.PacketConn, error) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	key := "udp:" + addr
+	c, ok := inherited[key].(net.PacketConn)
+	if ok {
+		delete(inherited, key)
+	} else {
+		var err error
+		if c, err = net.ListenPacket("udp", addr); err != nil {
+			return nil, err
+		}
+	}
+	listeners[key] = c
+	return c, nil
+}
+
+// listenUnix listens on the Unix domain socket at path. A socket left
+// behind by a process that did not exit cleanly is replaced; one another
+// process still accepts on is not. The socket file outlives the listener,
+// since the process a restart hands it to still needs it, and is replaced
+// at the next start.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s: address already in use", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	return l, nil
+}
+
+// closeUnclaimed closes inherited sockets nothing listens on any more,
+// e.g. after an address changed in the configuration, so clients are
+// refused instead of left waiting.
+func closeUnclaimed() {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	for addr, l := range inherited {
+		l.Close()
+		delete(inherited, addr)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package server
+
+// handleRestarts does nothing: restarts need SIGUSR2 and fd passing.
+func (s *Server) handleRestarts() {}
+
+// takeOver only closes what it did not claim; nothing starts this process
+// by a restart.
+func takeOver() { closeUnclaimed() }
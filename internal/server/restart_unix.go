@@ -0,0 +1,122 @@
+//go:build unix
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// handleRestarts starts a new copy of the binary on SIGUSR2, handing it
+// the listening sockets. The new process stops this one with SIGTERM once
+// it serves, and this one then drains like on any other shutdown, so a
+// deploy swaps the binary and sends SIGUSR2 without refusing a connection.
+// If the new process fails to start, this one keeps serving.
+func (s *Server) handleRestarts() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	go func() {
+		for range sig {
+			if s.draining.Load() {
+				continue
+			}
+			if err := s.restart(); err != nil {
+				slog.Error("restart", "err", err)
+			}
+		}
+	}()
+}
+
+// restart starts the new process with the listeners as fds 3 and up. It
+// forks with syscall rather than os/exec, which would switch the shared
+// sockets to blocking mode, leaving our Accept stuck in the kernel where
+// Shutdown cannot stop it.
+func (s *Server) restart() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	listenersMu.Lock()
+	var addrs []string
+	var fds []int
+	for addr, l := range listeners {
+		fd, err := dupSocket(l)
+		if err != nil {
+			listenersMu.Unlock()
+			closeFDs(fds)
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+		addrs = append(addrs, addr)
+		fds = append(fds, fd)
+	}
+	listenersMu.Unlock()
+	defer closeFDs(fds)
+	if len(fds) == 0 {
+		return errors.New("no listeners to hand over")
+	}
+	files := []uintptr{0, 1, 2}
+	for _, fd := range fds {
+		files = append(files, uintptr(fd))
+	}
+	env := append(os.Environ(), listenFDsEnv+"="+strings.Join(addrs, ","), restartParentEnv+"="+strconv.Itoa(os.Getpid()))
+	pid, err := syscall.ForkExec(exe, os.Args, &syscall.ProcAttr{Env: env, Files: files})
+	if err != nil {
+		return err
+	}
+	slog.Info("Restarting", "pid", pid)
+	go func() {
+		p, _ := os.FindProcess(pid)
+		state, err := p.Wait()
+		if !s.draining.Load() {
+			slog.Error("restart: new process exited before taking over; still serving", "pid", pid, "state", state, "err", err)
+		}
+	}()
+	return nil
+}
+
+// dupSocket returns a duplicate of the file descriptor of s, a listener
+// or packet connection.
+func dupSocket(s io.Closer) (int, error) {
+	sc, ok := s.(syscall.Conn)
+	if !ok {
+		return 0, errors.New("cannot be handed over")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	fd := -1
+	if err := rc.Control(func(s uintptr) { fd, err = syscall.Dup(int(s)) }); err != nil {
+		return 0, err
+	}
+	return fd, err
+}
+
+func closeFDs(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
+
+// takeOver stops the process this one replaces, if it was started by a
+// restart. Call it once every listener is open.
+func takeOver() {
+	closeUnclaimed()
+	v := os.Getenv(restartParentEnv)
+	os.Unsetenv(restartParentEnv)
+	pid, err := strconv.Atoi(v)
+	if err != nil || pid != os.Getppid() {
+		return
+	}
+	slog.Info("Taking over from the previous process", "pid", pid)
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		slog.Error("restart: stopping the previous process", "pid", pid, "err", err)
+	}
+}
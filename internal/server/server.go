@@ -0,0 +1,245 @@
+// Package server runs the board's listeners: the main server on every
+// listen address, the HTTP→HTTPS redirect, the internal listener, HTTP/3
+// and gRPC. It shuts them down gracefully on SIGINT or SIGTERM and hands
+// them to a new process on SIGUSR2.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"example.com/go-sample-site/internal/config"
+
+	"github.com/quic-go/quic-go/http3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
+)
+
+// Server serves a handler on the addresses in its configuration.
+type Server struct {
+	cfg      config.Config
+	srv      *http.Server
+	redirect *http.Server  // HTTP→HTTPS; nil when TLS is off or unset
+	internal *http.Server  // nil without an internal address
+	h3       *http3.Server // nil without an HTTP/3 address
+	grpc     *grpc.Server  // nil until ServeGRPC
+	// draining flips when shutdown begins.
+	draining atomic.Bool
+	// afterShutdown run once the listeners are closed, in order.
+	afterShutdown []func(ctx context.Context)
+}
+
+// New returns a Server for cfg that serves h on the main listeners and
+// internal, which may be nil when cfg.InternalAddr is empty, on the
+// internal one.
+func New(cfg config.Config, h, internal http.Handler) (*Server, error) {
+	s := &Server{cfg: cfg}
+	s.srv = &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      h,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+	if cfg.InternalAddr != "" && internal != nil {
+		// No write timeout: CPU profiles and traces stream for as long as
+		// they were asked to.
+		s.internal = &http.Server{
+			Addr:        cfg.InternalAddr,
+			Handler:     internal,
+			ReadTimeout: cfg.ReadTimeout,
+			IdleTimeout: cfg.IdleTimeout,
+		}
+	}
+	s.redirect = configureTLS(cfg, s.srv)
+	configureProtocols(cfg, s.srv)
+	var err error
+	if s.h3, err = newHTTP3Server(cfg, s.srv); err != nil {
+		return nil, fmt.Errorf("HTTP/3: %w", err)
+	}
+	return s, nil
+}
+
+// ServeGRPC has Run serve gRPC on cfg.GRPCAddr too, with the services
+// register adds; the main server's certificates secure it when it uses
+// TLS. Call it once, before Run.
+func (s *Server) ServeGRPC(register func(grpc.ServiceRegistrar)) error {
+	var opts []grpc.ServerOption
+	if s.cfg.TLSEnabled() {
+		tlsConfig, err := serverTLSConfig(s.cfg, s.srv)
+		if err != nil {
+			return fmt.Errorf("gRPC: %w", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s.grpc = grpc.NewServer(opts...)
+	register(s.grpc)
+	// So that grpcurl and the like can list and call the services without
+	// the .proto files.
+	reflection.Register(s.grpc)
+	return nil
+}
+
+// RegisterOnShutdown registers f to be called when shutdown begins, like
+// http.Server.RegisterOnShutdown: to close long-lived connections and
+// stop background loops.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.srv.RegisterOnShutdown(f)
+}
+
+// AfterShutdown registers f to be called once the listeners are closed
+// and requests have finished, with the shutdown deadline in ctx: to wait
+// for hijacked connections and flush what is buffered. They run in the
+// order they were registered.
+func (s *Server) AfterShutdown(f func(ctx context.Context)) {
+	s.afterShutdown = append(s.afterShutdown, f)
+}
+
+// Run opens the listeners, taking them over from the previous process
+// after a restart, and serves until SIGINT or SIGTERM. It returns once
+// the shutdown and everything registered with AfterShutdown is done.
+func (s *Server) Run() error {
+	cfg := s.cfg
+	if err := inheritListeners(); err != nil {
+		return fmt.Errorf("restart: %w", err)
+	}
+	var lns []net.Listener
+	for _, addr := range cfg.ListenAddrs() {
+		l, err := listen(addr)
+		if err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+		lns = append(lns, l)
+	}
+	if s.redirect != nil {
+		l, err := listen(s.redirect.Addr)
+		if err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+		go serveRedirect(s.redirect, l)
+	}
+	if s.internal != nil {
+		l, err := listen(s.internal.Addr)
+		if err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+		go serveInternal(s.internal, l)
+	}
+	if s.grpc != nil {
+		l, err := listen(cfg.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+		go serveGRPC(s.grpc, l)
+	}
+	var h3conn net.PacketConn
+	if s.h3 != nil {
+		var err error
+		if h3conn, err = listenPacket(s.h3.Addr); err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+		go serveHTTP3(s.h3, h3conn)
+	}
+	takeOver()
+	s.handleRestarts()
+	idle := make(chan struct{})
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		slog.Info("Shutting down...")
+		s.draining.Store(true)
+		if s.h3 != nil {
+			// A process taking over shares the UDP socket, so stop reading
+			// from it at once; HTTP/3 clients reconnect or fall back to TCP.
+			s.h3.Close()
+			h3conn.Close()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := s.srv.Shutdown(ctx); err != nil {
+			slog.Error("Shutdown", "err", err)
+		}
+		if s.redirect != nil {
+			s.redirect.Shutdown(ctx)
+		}
+		if s.internal != nil {
+			s.internal.Shutdown(ctx)
+		}
+		if s.grpc != nil {
+			// By now the shutdown hooks have ended the streams.
+			stopGRPC(ctx, s.grpc)
+		}
+		for _, f := range s.afterShutdown {
+			f(ctx)
+		}
+		close(idle)
+	}()
+	slog.Info("Server running", "addr", strings.Join(cfg.ListenAddrs(), ","), "tls", cfg.TLSEnabled())
+	// The same server serves every listener, so Shutdown closes them all.
+	errs := make(chan error, len(lns))
+	serve := func(l net.Listener) {
+		var err error
+		if cfg.TLSEnabled() {
+			// With ACME the certificate comes from srv.TLSConfig, so the
+			// file names are empty.
+			err = s.srv.ServeTLS(l, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			err = s.srv.Serve(l)
+		}
+		if err != http.ErrServerClosed {
+			errs <- fmt.Errorf("serve %s: %w", l.Addr(), err)
+			return
+		}
+		errs <- nil
+	}
+	for _, l := range lns {
+		go serve(l)
+	}
+	for range lns {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	<-idle
+	slog.Info("Server stopped.")
+	return nil
+}
+
+func serveGRPC(srv *grpc.Server, l net.Listener) {
+	slog.Info("gRPC listener running", "addr", l.Addr())
+	if err := srv.Serve(l); err != nil {
+		slog.Error("gRPC listener", "err", err)
+	}
+}
+
+// stopGRPC lets the calls in flight finish, cutting them off when ctx
+// expires.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+func serveInternal(srv *http.Server, l net.Listener) {
+	slog.Info("Internal listener running", "addr", srv.Addr)
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		slog.Error("internal listener", "err", err)
+	}
+}
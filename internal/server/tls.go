@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"example.com/go-sample-site/internal/config"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configureTLS sets up srv for HTTPS and returns the plain-HTTP server that
+// redirects to it, or nil when TLS is off or no redirect address is set.
+// In ACME mode the redirect server also answers Let's Encrypt's HTTP-01
+// challenges, so it should listen on port 80.
+func configureTLS(cfg config.Config, srv *http.Server) *http.Server {
+	if !cfg.TLSEnabled() {
+		return nil
+	}
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	// Redirect to the first TCP listener; browsers cannot reach a socket.
+	var httpsAddr string
+	for _, addr := range cfg.ListenAddrs() {
+		if !strings.HasPrefix(addr, "unix:") {
+			httpsAddr = addr
+			break
+		}
+	}
+	redirect := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirectToHTTPS(w, r, httpsAddr)
+	}))
+	if cfg.ACMEDomain != "" {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains()...),
+			Cache:      autocert.DirCache(cfg.ACMECache),
+			Email:      cfg.ACMEEmail,
+		}
+		srv.TLSConfig = m.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		redirect = m.HTTPHandler(redirect)
+	}
+	if cfg.HTTPAddr == "" {
+		return nil
+	}
+	return &http.Server{
+		Addr:              cfg.HTTPAddr,
+		Handler:           redirect,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// serverTLSConfig returns a copy of srv's TLS configuration, set up by
+// configureTLS, with the certificate in it, for the listeners that do not
+// go through ServeTLS.
+func serverTLSConfig(cfg config.Config, srv *http.Server) (*tls.Config, error) {
+	tlsConfig := srv.TLSConfig.Clone()
+	if cfg.TLSCert != "" {
+		// ServeTLS loads these for the TCP listeners itself.
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// redirectToHTTPS sends a permanent redirect to the same URL on the TLS
+// listener at addr.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request, addr string) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil && port != "443" && port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		// 308 keeps the method and body; 301 lets clients turn POST into GET.
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+}
+
+// serveRedirect runs the HTTP→HTTPS redirect listener until it is shut down.
+func serveRedirect(srv *http.Server, l net.Listener) {
+	slog.Info("Redirecting HTTP to HTTPS", "addr", srv.Addr)
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		slog.Error("redirect listener", "err", err)
+	}
+}
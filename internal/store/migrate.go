@@ -0,0 +1,277 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The SQL backends' schema is built by the numbered migrations in
+// migrations/<backend>/, each a <version>_<name>.up.sql applying it and a
+// .down.sql undoing it. The versions applied are recorded in the
+// schema_version table. A schema change is a new pair of files with the
+// next number, for both backends; released migrations are never edited.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// MigrationMode says what Open does about a schema older than the build.
+type MigrationMode int
+
+const (
+	// MigrateUp applies the pending migrations.
+	MigrateUp MigrationMode = iota
+	// MigrateCheck refuses to open a database that is missing some, for
+	// deployments that run the migrate command as a step of their own.
+	MigrateCheck
+	// MigrateNone leaves the schema be, for the migrate command.
+	MigrateNone
+)
+
+// Migration is one step of the schema, and when it was applied to the
+// database, if it was.
+type Migration struct {
+	Version int        `json:"version"`
+	Name    string     `json:"name"`
+	Applied *time.Time `json:"applied,omitempty"`
+
+	up, down string
+}
+
+// Migrator is implemented by the stores that have a schema to migrate.
+type Migrator interface {
+	// Migrations lists the migrations the build knows, oldest first.
+	Migrations(ctx context.Context) ([]Migration, error)
+	// SchemaVersion is that of the last migration applied, 0 for none.
+	SchemaVersion(ctx context.Context) (int, error)
+	// MigrateTo applies migrations, or rolls them back, until the schema is
+	// at version. Each migration is a transaction of its own.
+	MigrateTo(ctx context.Context, version int) error
+}
+
+// loadMigrations reads the migrations for dir, the backend.
+func loadMigrations(dir string) ([]Migration, error) {
+	dir = path.Join("migrations", dir)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), ".")
+		num, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version < 1 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: want <version>_<name>.up.sql or .down.sql", e.Name())
+		}
+		b, err := fs.ReadFile(migrationFiles, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(b)
+		} else {
+			m.down = string(b)
+		}
+	}
+	list := make([]Migration, 0, len(byVersion))
+	for v := 1; v <= len(byVersion); v++ {
+		m := byVersion[v]
+		if m == nil {
+			return nil, fmt.Errorf("migration %d is missing", v)
+		}
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down file", v)
+		}
+		list = append(list, *m)
+	}
+	return list, nil
+}
+
+func (s *sqlStore) migrations() ([]Migration, error) {
+	if s.postgres {
+		return loadMigrations("postgres")
+	}
+	return loadMigrations("sqlite")
+}
+
+// prepare brings the schema of a store just opened in line with mode.
+func (s *sqlStore) prepare(ctx context.Context, mode MigrationMode) error {
+	if mode == MigrateNone {
+		return nil
+	}
+	list, err := s.migrations()
+	if err != nil {
+		return err
+	}
+	latest := list[len(list)-1].Version
+	if mode == MigrateCheck {
+		current, err := s.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		if current < latest {
+			return fmt.Errorf("database schema is at version %d, this build needs %d: run the migrate command", current, latest)
+		}
+		return nil
+	}
+	// A database already past latest is left alone: it is probably being
+	// rolled forward by a newer build.
+	if current, err := s.SchemaVersion(ctx); err != nil || current >= latest {
+		return err
+	}
+	return s.MigrateTo(ctx, latest)
+}
+
+func (s *sqlStore) hasTable(ctx context.Context, name string) (bool, error) {
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	if s.postgres {
+		query = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`
+	}
+	var n int
+	err := s.db.QueryRowContext(ctx, s.rebind(query), name).Scan(&n)
+	return n > 0, err
+}
+
+func (s *sqlStore) SchemaVersion(ctx context.Context) (int, error) {
+	if ok, err := s.hasTable(ctx, "schema_version"); err != nil || !ok {
+		return 0, err
+	}
+	var v int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&v)
+	return v, err
+}
+
+func (s *sqlStore) Migrations(ctx context.Context) ([]Migration, error) {
+	list, err := s.migrations()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := s.hasTable(ctx, "schema_version"); err != nil || !ok {
+		return list, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT version, applied FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int
+		var applied time.Time
+		if err := rows.Scan(&v, &applied); err != nil {
+			return nil, err
+		}
+		if i := slices.IndexFunc(list, func(m Migration) bool { return m.Version == v }); i >= 0 {
+			list[i].Applied = &applied
+		}
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlStore) MigrateTo(ctx context.Context, version int) error {
+	list, err := s.migrations()
+	if err != nil {
+		return err
+	}
+	if version < 0 || version > len(list) {
+		return fmt.Errorf("no migration %d; the latest is %d", version, len(list))
+	}
+	if err := s.createSchemaVersion(ctx); err != nil {
+		return err
+	}
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > len(list) {
+		return fmt.Errorf("database schema is at version %d, newer than this build's %d", current, len(list))
+	}
+	for ; current < version; current++ {
+		if err := s.applyMigration(ctx, list[current], true); err != nil {
+			return err
+		}
+	}
+	for ; current > version; current-- {
+		if err := s.applyMigration(ctx, list[current-1], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createSchemaVersion creates the schema_version table. A database from
+// before migrations has its tables but not this one; its columns are
+// brought up to date then, so that the first migration can adopt it.
+func (s *sqlStore) createSchemaVersion(ctx context.Context) error {
+	ok, err := s.hasTable(ctx, "schema_version")
+	if err != nil || ok {
+		return err
+	}
+	if legacy, err := s.hasTable(ctx, "messages"); err != nil {
+		return err
+	} else if legacy {
+		if err := s.addColumns(ctx); err != nil {
+			return fmt.Errorf("upgrading a database from before migrations: %w", err)
+		}
+	}
+	timestamp := "DATETIME"
+	if s.postgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name    TEXT NOT NULL,
+	applied `+timestamp+` NOT NULL
+)`)
+	return err
+}
+
+// migrationLock is the Postgres advisory lock replicas starting together
+// take turns on, so that each migration is applied once.
+const migrationLock = 0x736c7273 // "slrs"
+
+// applyMigration applies m, or rolls it back, and records that it did.
+func (s *sqlStore) applyMigration(ctx context.Context, m Migration, up bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if s.postgres {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+			return err
+		}
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM schema_version WHERE version = ?`), m.Version).Scan(&n); err != nil {
+		return err
+	}
+	if applied := n > 0; applied == up {
+		// Another replica got there first.
+		return tx.Commit()
+	}
+	script, record := m.down, `DELETE FROM schema_version WHERE version = ?`
+	args := []any{m.Version}
+	if up {
+		script, record = m.up, `INSERT INTO schema_version (version, name, applied) VALUES (?, ?, ?)`
+		args = append(args, m.Name, time.Now().UTC())
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind(record), args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,18 @@
+DROP TABLE IF EXISTS reactions;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS banners;
+DROP TABLE IF EXISTS check_results;
+DROP TABLE IF EXISTS components;
+DROP TABLE IF EXISTS incident_updates;
+DROP TABLE IF EXISTS incidents;
+DROP TABLE IF EXISTS deployments;
+DROP TABLE IF EXISTS builds;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS message_revisions;
+DROP TABLE IF EXISTS channels;
+DROP TABLE IF EXISTS messages;
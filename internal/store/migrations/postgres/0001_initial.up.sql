@@ -0,0 +1,202 @@
+-- The schema as it stood when migrations were introduced. IF NOT EXISTS
+-- lets it adopt databases created before then.
+
+CREATE TABLE IF NOT EXISTS messages (
+	id         SERIAL PRIMARY KEY,
+	author     TEXT NOT NULL DEFAULT '',
+	content    TEXT NOT NULL,
+	created    TIMESTAMPTZ NOT NULL,
+	updated    TIMESTAMPTZ,
+	parent_id  INTEGER REFERENCES messages (id),
+	tags       TEXT NOT NULL DEFAULT '',
+	pinned     BOOLEAN NOT NULL DEFAULT FALSE,
+	deleted    TIMESTAMPTZ,
+	revisions  INTEGER NOT NULL DEFAULT 0,
+	email      TEXT NOT NULL DEFAULT '',
+	publish_at TIMESTAMPTZ,
+	expires_at TIMESTAMPTZ,
+	channel    TEXT NOT NULL DEFAULT 'general'
+);
+
+CREATE INDEX IF NOT EXISTS messages_created ON messages (created);
+
+CREATE TABLE IF NOT EXISTS channels (
+	name        TEXT PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT '',
+	created     TIMESTAMPTZ NOT NULL,
+	archived    TIMESTAMPTZ,
+	private     BOOLEAN NOT NULL DEFAULT FALSE,
+	users       TEXT NOT NULL DEFAULT '',
+	roles       TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS message_revisions (
+	message_id INTEGER NOT NULL REFERENCES messages (id),
+	revision   INTEGER NOT NULL,
+	author     TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	tags       TEXT NOT NULL DEFAULT '',
+	created    TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (message_id, revision)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	name          TEXT PRIMARY KEY,
+	password_hash BYTEA NOT NULL,
+	role          TEXT NOT NULL,
+	created       TIMESTAMPTZ NOT NULL,
+	provider      TEXT NOT NULL DEFAULT '',
+	email         TEXT NOT NULL DEFAULT '',
+	avatar        TEXT NOT NULL DEFAULT '',
+	color_scheme  TEXT NOT NULL DEFAULT '',
+	time_zone     TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+	email     TEXT PRIMARY KEY,
+	tags      TEXT NOT NULL DEFAULT '',
+	token     TEXT NOT NULL UNIQUE,
+	confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+	created   TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id      SERIAL PRIMARY KEY,
+	url     TEXT NOT NULL,
+	secret  TEXT NOT NULL,
+	events  TEXT NOT NULL DEFAULT '',
+	created TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id          SERIAL PRIMARY KEY,
+	webhook_id  INTEGER NOT NULL,
+	url         TEXT NOT NULL,
+	event       TEXT NOT NULL,
+	message_id  INTEGER NOT NULL,
+	attempt     INTEGER NOT NULL,
+	status      INTEGER NOT NULL,
+	error       TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL,
+	created     TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS builds (
+	id          SERIAL PRIMARY KEY,
+	job         TEXT NOT NULL,
+	number      INTEGER NOT NULL,
+	phase       TEXT NOT NULL,
+	status      TEXT NOT NULL DEFAULT '',
+	url         TEXT NOT NULL DEFAULT '',
+	branch      TEXT NOT NULL DEFAULT '',
+	commit_sha  TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	started     TIMESTAMPTZ NOT NULL,
+	updated     TIMESTAMPTZ NOT NULL,
+	UNIQUE (job, number)
+);
+
+CREATE TABLE IF NOT EXISTS deployments (
+	id          SERIAL PRIMARY KEY,
+	service     TEXT NOT NULL,
+	environment TEXT NOT NULL,
+	version     TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	actor       TEXT NOT NULL,
+	started     TIMESTAMPTZ NOT NULL,
+	finished    TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS incidents (
+	id         SERIAL PRIMARY KEY,
+	title      TEXT NOT NULL,
+	severity   TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	message_id INTEGER NOT NULL DEFAULT 0,
+	created    TIMESTAMPTZ NOT NULL,
+	updated    TIMESTAMPTZ NOT NULL,
+	resolved   TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS incident_updates (
+	id          SERIAL PRIMARY KEY,
+	incident_id INTEGER NOT NULL REFERENCES incidents (id),
+	status      TEXT NOT NULL,
+	severity    TEXT NOT NULL,
+	body        TEXT NOT NULL DEFAULT '',
+	author      TEXT NOT NULL,
+	created     TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS components (
+	id          SERIAL PRIMARY KEY,
+	name        TEXT NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT '',
+	state       TEXT NOT NULL,
+	check_name  TEXT NOT NULL DEFAULT '',
+	updated     TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS check_results (
+	id         SERIAL PRIMARY KEY,
+	monitor    TEXT NOT NULL,
+	up         BOOLEAN NOT NULL,
+	latency_ms INTEGER NOT NULL,
+	error      TEXT NOT NULL DEFAULT '',
+	checked    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS check_results_monitor ON check_results (monitor, checked);
+
+CREATE TABLE IF NOT EXISTS banners (
+	id       SERIAL PRIMARY KEY,
+	text     TEXT NOT NULL,
+	severity TEXT NOT NULL,
+	expires  TIMESTAMPTZ,
+	author   TEXT NOT NULL,
+	created  TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	name     TEXT PRIMARY KEY,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes   TEXT NOT NULL,
+	created  TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id          SERIAL PRIMARY KEY,
+	created     TIMESTAMPTZ NOT NULL,
+	actor       TEXT NOT NULL,
+	ip          TEXT NOT NULL DEFAULT '',
+	action      TEXT NOT NULL,
+	target      TEXT NOT NULL DEFAULT '',
+	before_json TEXT,
+	after_json  TEXT,
+	request_id  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created ON audit_log (created);
+
+CREATE TABLE IF NOT EXISTS attachments (
+	id           SERIAL PRIMARY KEY,
+	message_id   INTEGER NOT NULL REFERENCES messages (id),
+	name         TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size         BIGINT NOT NULL,
+	blob_key     TEXT NOT NULL,
+	created      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS attachments_message ON attachments (message_id);
+
+CREATE TABLE IF NOT EXISTS reactions (
+	message_id INTEGER NOT NULL REFERENCES messages (id),
+	reaction   TEXT NOT NULL,
+	user_name  TEXT NOT NULL,
+	created    TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (message_id, reaction, user_name)
+);
+
+CREATE INDEX IF NOT EXISTS messages_parent ON messages (parent_id);
+CREATE INDEX IF NOT EXISTS messages_channel ON messages (channel, created);
@@ -0,0 +1,304 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion is the format of the snapshots this build takes.
+const SnapshotVersion = 1
+
+// Snapshot is the content of the board at one moment, as backups keep
+// it: the channels, and every message with its earlier versions, those in
+// the trash and those not yet published included. Accounts, keys and the
+// rest of the store are not part of it.
+type Snapshot struct {
+	Version int       `json:"version"` // SnapshotVersion when taken
+	Taken   time.Time `json:"taken"`
+	// Schema is the schema version of the SQL store it was taken from, 0
+	// for the memory store.
+	Schema   int               `json:"schema,omitempty"`
+	Channels []Channel         `json:"channels"`
+	Messages []SnapshotMessage `json:"messages"` // oldest first
+}
+
+// SnapshotMessage is a message as a Snapshot keeps it, with what
+// Message's JSON leaves out.
+type SnapshotMessage struct {
+	Message
+	Email   string     `json:"email,omitempty"`
+	History []Revision `json:"history,omitempty"`
+}
+
+// Snapshotter is implemented by the stores that can be backed up.
+type Snapshotter interface {
+	// Snapshot reads the board as it stands, consistently even while it
+	// is being written to.
+	Snapshot(ctx context.Context) (*Snapshot, error)
+	// RestoreSnapshot puts the board back as snap has it, all at once.
+	// Messages keep their IDs. Channels and messages snap lacks are
+	// deleted, with the reactions and attachments of those messages; the
+	// others' stay.
+	RestoreSnapshot(ctx context.Context, snap *Snapshot) error
+}
+
+// ErrSnapshotIncompatible is returned for a snapshot this build or
+// database cannot restore.
+var ErrSnapshotIncompatible = errors.New("snapshot incompatible")
+
+// checkSnapshot makes sure snap can be restored into a database at
+// schema.
+func checkSnapshot(snap *Snapshot, schema int) error {
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return fmt.Errorf("%w: format %d, this build reads 1 to %d", ErrSnapshotIncompatible, snap.Version, SnapshotVersion)
+	}
+	if snap.Schema > schema {
+		return fmt.Errorf("%w: taken at schema version %d, the database is at %d; migrate it first", ErrSnapshotIncompatible, snap.Schema, schema)
+	}
+	return nil
+}
+
+func (s *memoryStore) Snapshot(ctx context.Context) (*Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap := &Snapshot{Version: SnapshotVersion, Taken: time.Now().UTC(), Channels: []Channel{}, Messages: []SnapshotMessage{}}
+	for _, c := range s.channels {
+		snap.Channels = append(snap.Channels, c)
+	}
+	slices.SortFunc(snap.Channels, func(a, b Channel) int { return strings.Compare(a.Name, b.Name) })
+	for _, m := range slices.Backward(s.messages) {
+		snap.Messages = append(snap.Messages, SnapshotMessage{Message: m, Email: m.Email, History: slices.Clone(s.revisions[m.ID])})
+	}
+	return snap, nil
+}
+
+func (s *memoryStore) RestoreSnapshot(ctx context.Context, snap *Snapshot) error {
+	// Whatever the schema it came from, the memory store holds the model
+	// as this build has it.
+	if err := checkSnapshot(snap, snap.Schema); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels = make(map[string]Channel, len(snap.Channels))
+	for _, c := range snap.Channels {
+		s.channels[c.Name] = c
+	}
+	s.messages = make([]Message, 0, len(snap.Messages))
+	s.revisions = make(map[int][]Revision)
+	ids := make(map[int]bool, len(snap.Messages))
+	for _, sm := range slices.Backward(snap.Messages) {
+		m := sm.Message
+		m.Email = sm.Email
+		m.Version = m.Revisions + 1
+		s.messages = append(s.messages, m)
+		if len(sm.History) > 0 {
+			s.revisions[m.ID] = slices.Clone(sm.History)
+		}
+		ids[m.ID] = true
+		s.nextID = max(s.nextID, m.ID+1)
+	}
+	s.reactions = slices.DeleteFunc(s.reactions, func(r Reaction) bool { return !ids[r.MessageID] })
+	s.attachments = slices.DeleteFunc(s.attachments, func(a Attachment) bool { return !ids[a.MessageID] })
+	return nil
+}
+
+func (s *sqlStore) Snapshot(ctx context.Context) (*Snapshot, error) {
+	// A Postgres transaction otherwise sees what others commit between
+	// its queries; SQLite's sees one state of the file anyway.
+	var opts *sql.TxOptions
+	if s.postgres {
+		opts = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	}
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	snap := &Snapshot{Version: SnapshotVersion, Taken: time.Now().UTC(), Channels: []Channel{}, Messages: []SnapshotMessage{}}
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&snap.Schema); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+channelColumns+` FROM channels ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		snap.Channels = append(snap.Channels, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT `+messageColumns+` FROM messages ORDER BY created, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byID := make(map[int]int) // index in snap.Messages
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[m.ID] = len(snap.Messages)
+		snap.Messages = append(snap.Messages, SnapshotMessage{Message: m, Email: m.Email})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT message_id, revision, author, content, tags, created FROM message_revisions ORDER BY message_id, revision`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var r Revision
+		var tags string
+		if err := rows.Scan(&id, &r.Revision, &r.Author, &r.Content, &tags, &r.Created); err != nil {
+			return nil, err
+		}
+		if tags != "" {
+			r.Tags = strings.Split(tags, ",")
+		}
+		if i, ok := byID[id]; ok {
+			snap.Messages[i].History = append(snap.Messages[i].History, r)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return snap, tx.Commit()
+}
+
+func (s *sqlStore) RestoreSnapshot(ctx context.Context, snap *Snapshot) error {
+	schema, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkSnapshot(snap, schema); err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	exec := func(query string, args ...any) error {
+		_, err := tx.ExecContext(ctx, s.rebind(query), args...)
+		return err
+	}
+
+	keep := make(map[string]bool, len(snap.Channels))
+	for _, c := range snap.Channels {
+		keep[c.Name] = true
+		if err := exec(`INSERT INTO channels (`+channelColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET description = excluded.description, created = excluded.created, archived = excluded.archived,
+			private = excluded.private, users = excluded.users, roles = excluded.roles`,
+			c.Name, c.Description, c.Created.UTC(), nullTime(c.Archived), c.Private, strings.Join(c.Users, ","), strings.Join(c.Roles, ",")); err != nil {
+			return fmt.Errorf("channel %s: %w", c.Name, err)
+		}
+	}
+	names, err := queryColumn[string](ctx, tx, `SELECT name FROM channels`)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !keep[name] {
+			if err := exec(`DELETE FROM channels WHERE name = ?`, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Replies are linked up once every message is in, and the revisions
+	// written afresh.
+	if err := exec(`UPDATE messages SET parent_id = NULL`); err != nil {
+		return err
+	}
+	if err := exec(`DELETE FROM message_revisions`); err != nil {
+		return err
+	}
+	ids := make(map[int]bool, len(snap.Messages))
+	for _, sm := range snap.Messages {
+		m := sm.Message
+		ids[m.ID] = true
+		if err := exec(`INSERT INTO messages (`+messageColumns+`) VALUES (?, ?, ?, ?, ?, NULL, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET author = excluded.author, content = excluded.content, created = excluded.created,
+			updated = excluded.updated, tags = excluded.tags, pinned = excluded.pinned, deleted = excluded.deleted,
+			revisions = excluded.revisions, email = excluded.email, publish_at = excluded.publish_at,
+			expires_at = excluded.expires_at, channel = excluded.channel`,
+			m.ID, m.Author, m.Content, m.Created.UTC(), nullTime(m.Updated), strings.Join(m.Tags, ","), m.Pinned, nullTime(m.Deleted),
+			m.Revisions, sm.Email, nullTime(m.PublishAt), nullTime(m.ExpiresAt), m.Channel); err != nil {
+			return fmt.Errorf("message %d: %w", m.ID, err)
+		}
+	}
+	existing, err := queryColumn[int](ctx, tx, `SELECT id FROM messages`)
+	if err != nil {
+		return err
+	}
+	for _, id := range existing {
+		if ids[id] {
+			continue
+		}
+		for _, table := range []string{"attachments", "reactions"} {
+			if err := exec(`DELETE FROM `+table+` WHERE message_id = ?`, id); err != nil {
+				return err
+			}
+		}
+		if err := exec(`DELETE FROM messages WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	for _, sm := range snap.Messages {
+		if sm.ParentID != 0 {
+			if err := exec(`UPDATE messages SET parent_id = ? WHERE id = ?`, sm.ParentID, sm.ID); err != nil {
+				return fmt.Errorf("message %d: %w", sm.ID, err)
+			}
+		}
+		for _, r := range sm.History {
+			if err := exec(`INSERT INTO message_revisions (message_id, revision, author, content, tags, created) VALUES (?, ?, ?, ?, ?, ?)`,
+				sm.ID, r.Revision, r.Author, r.Content, strings.Join(r.Tags, ","), r.Created.UTC()); err != nil {
+				return fmt.Errorf("message %d revision %d: %w", sm.ID, r.Revision, err)
+			}
+		}
+	}
+	if s.postgres {
+		// The IDs were given, so the sequence has not moved past them.
+		if err := exec(`SELECT setval(pg_get_serial_sequence('messages', 'id'), GREATEST((SELECT MAX(id) FROM messages), 1))`); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// queryColumn reads the one column query selects.
+func queryColumn[T any](ctx context.Context, tx *sql.Tx, query string) ([]T, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var vals []T
+	for rows.Next() {
+		var v T
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, rows.Err()
+}
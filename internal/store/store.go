@@ -0,0 +1,1766 @@
+// Package store holds the board's data model and the stores that keep it:
+// in memory, in a SQLite file or in PostgreSQL.
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	ID      int        `json:"id"`
+	Channel string     `json:"channel"` // replies are in their thread's channel
+	Author  string     `json:"author"`
+	Content string     `json:"content"`
+	Created time.Time  `json:"created"`
+	Updated *time.Time `json:"updated,omitempty"` // last edit, nil if never edited
+	// ParentID is the message this one replies to, or 0 for a new thread.
+	ParentID int      `json:"parent_id,omitempty"`
+	Tags     []string `json:"tags,omitempty"`   // normalized by the handlers
+	Pinned   bool     `json:"pinned,omitempty"` // listed above everything else
+	// Deleted is when the message went to the trash; only trash listings
+	// return such messages.
+	Deleted *time.Time `json:"deleted,omitempty"`
+	// Revisions counts the earlier versions kept by edits.
+	Revisions int `json:"revisions,omitempty"`
+	// Version is 1 as posted and goes up with every Update, so an edit can
+	// name the version it was made to; it is always Revisions+1.
+	Version int `json:"version"`
+	// Email is the author's optional address, used only to look up a
+	// Gravatar and never shown.
+	Email string `json:"-"`
+	// Attachments, Reactions and Avatar, the URL of the author's picture,
+	// are filled in by the handlers that show them.
+	Attachments []Attachment    `json:"attachments,omitempty"`
+	Reactions   []ReactionCount `json:"reactions,omitempty"`
+	Avatar      string          `json:"avatar,omitempty"`
+	// PublishAt keeps the message out of listings until then; ExpiresAt
+	// takes it out again. Either may be nil.
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Visible reports whether m is published and not yet expired at now.
+func (m Message) Visible(now time.Time) bool {
+	return (m.PublishAt == nil || !m.PublishAt.After(now)) && (m.ExpiresAt == nil || m.ExpiresAt.After(now))
+}
+
+// scheduled reports whether m is still waiting for its PublishAt.
+func (m Message) scheduled(now time.Time) bool {
+	return m.PublishAt != nil && m.PublishAt.After(now)
+}
+
+// webhookLogSize is how many webhook deliveries the store keeps.
+const webhookLogSize = 1000
+
+var (
+	// ErrNotFound is returned when a message ID does not exist.
+	ErrNotFound = errors.New("message not found")
+	// ErrUserNotFound is returned when a user name does not exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrKeyNotFound is returned when an API key does not exist.
+	ErrKeyNotFound = errors.New("api key not found")
+	// ErrDuplicate is returned when creating something whose name is taken.
+	ErrDuplicate = errors.New("already exists")
+	// ErrParentNotFound is returned when a reply names a missing message.
+	ErrParentNotFound = errors.New("parent message not found")
+	// ErrAttachmentNotFound is returned when an attachment ID does not exist.
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	// ErrSubscriptionNotFound is returned for an unknown email or token.
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	// ErrWebhookNotFound is returned when a webhook ID does not exist.
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrDeploymentNotFound is returned when a deployment ID does not exist.
+	ErrDeploymentNotFound = errors.New("deployment not found")
+	// ErrDeploymentFinished is returned when finishing a deployment twice.
+	ErrDeploymentFinished = errors.New("deployment already finished")
+	// ErrIncidentNotFound is returned when an incident ID does not exist.
+	ErrIncidentNotFound = errors.New("incident not found")
+	// ErrComponentNotFound is returned when a status page component ID
+	// does not exist.
+	ErrComponentNotFound = errors.New("component not found")
+	// ErrChannelNotFound is returned when a channel name does not exist.
+	ErrChannelNotFound = errors.New("channel not found")
+	// ErrBannerNotFound is returned when no announcement banner is set.
+	ErrBannerNotFound = errors.New("banner not found")
+	// ErrReactionNotFound is returned when removing a reaction that was
+	// never made.
+	ErrReactionNotFound = errors.New("reaction not found")
+	// ErrVersionConflict is returned by Update when the message is no
+	// longer at the version the edit was made to.
+	ErrVersionConflict = errors.New("message edited since")
+)
+
+// Store is everything a backend provides: messages, channels, attachments,
+// reactions, user accounts, API keys, the audit log, email subscriptions, webhooks, CI
+// builds, deployments, incidents, status page components, uptime check
+// results and the announcement banner.
+type Store interface {
+	MessageStore
+	ChannelStore
+	AttachmentStore
+	ReactionStore
+	UserStore
+	APIKeyStore
+	AuditStore
+	SubscriptionStore
+	WebhookStore
+	BuildStore
+	DeploymentStore
+	IncidentStore
+	ComponentStore
+	MonitorStore
+	BannerStore
+}
+
+// MessageStore is the persistence layer behind the board. Handlers only talk
+// to this interface so the backing database can be swapped out.
+type MessageStore interface {
+	// List returns the messages matching opts along with the total number
+	// of matches before Offset and Limit are applied. Deleted messages are
+	// left out unless opts.Trash asks for them instead, and so are those
+	// not yet published (see opts.Scheduled) or already expired.
+	List(ctx context.Context, opts ListOptions) ([]Message, int, error)
+	Get(ctx context.Context, id int) (Message, error)
+	// Thread returns the whole conversation id belongs to: its root
+	// message and every reply below it, oldest first.
+	Thread(ctx context.Context, id int) ([]Message, error)
+	// Due returns the messages outside the trash whose PublishAt or
+	// ExpiresAt falls in (after, until], oldest first.
+	Due(ctx context.Context, after, until time.Time) ([]Message, error)
+	// Each calls fn for every message, oldest first, without loading them
+	// all at once. It stops at the first error fn returns.
+	Each(ctx context.Context, fn func(Message) error) error
+	// Create assigns an ID and creation time to msg and saves it. A reply
+	// to a message that does not exist fails with ErrParentNotFound.
+	Create(ctx context.Context, msg *Message) error
+	// CreateBatch saves msgs atomically: either all are stored or none.
+	// Messages that already carry a Created time (imports) keep it.
+	CreateBatch(ctx context.Context, msgs []*Message) error
+	// Update saves the author, content, tags and schedule of an existing
+	// message, keeping the previous version as a Revision. It stamps Updated and
+	// refreshes msg with the stored copy. If msg.Version is set and another
+	// edit has been saved since, it fails with ErrVersionConflict.
+	Update(ctx context.Context, msg *Message) error
+	// Revisions returns the earlier versions of a message, oldest first.
+	Revisions(ctx context.Context, id int) ([]Revision, error)
+	// SetPinned pins or unpins a message without marking it edited.
+	SetPinned(ctx context.Context, id int, pinned bool) error
+	// Delete moves a message to the trash. Every other method except List
+	// with Trash set treats it as gone.
+	Delete(ctx context.Context, id int) error
+	// Restore takes a message back out of the trash.
+	Restore(ctx context.Context, id int) error
+	// Purge removes a trashed message for good. Its replies move up to its
+	// own parent so the rest of the conversation survives.
+	Purge(ctx context.Context, id int) error
+	// Ping checks that the backing database is reachable.
+	Ping(ctx context.Context) error
+	// Size returns roughly how many bytes the store takes up: the whole
+	// database for SQL stores, the message text for the memory store.
+	Size(ctx context.Context) (int64, error)
+	Close() error
+}
+
+// DefaultChannel is where messages go that name no channel, among them
+// every message from before channels existed.
+const DefaultChannel = "general"
+
+// Channel is a named room of the board. Archived channels stay readable
+// but take no new messages. A private channel is only seen by admins and
+// by the users, roles and API keys on its allowlist.
+type Channel struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Created     time.Time  `json:"created"`
+	Archived    *time.Time `json:"archived,omitempty"`
+	Private     bool       `json:"private"`
+	Users       []string   `json:"users,omitempty"` // user and API key names
+	Roles       []string   `json:"roles,omitempty"`
+}
+
+// ReadableBy reports whether u may read c. The handlers decide for the
+// user making a request, who may also use an API key; this is for others,
+// such as one mentioned in a message.
+func (c Channel) ReadableBy(u User) bool {
+	return !c.Private || u.Role == RoleAdmin || slices.Contains(c.Users, u.Name) || slices.Contains(c.Roles, u.Role)
+}
+
+// ChannelStore holds the channels messages are posted to.
+type ChannelStore interface {
+	// ListChannels returns every channel, archived ones included, by name.
+	ListChannels(ctx context.Context) ([]Channel, error)
+	GetChannel(ctx context.Context, name string) (Channel, error)
+	// CreateChannel saves c, stamping Created. A taken name fails with
+	// ErrDuplicate.
+	CreateChannel(ctx context.Context, c *Channel) error
+	// UpdateChannel saves the description, archived time, privacy and
+	// allowlists of c.
+	UpdateChannel(ctx context.Context, c *Channel) error
+}
+
+// Revision is an earlier version of a message, kept when it was edited.
+type Revision struct {
+	Revision int       `json:"revision"` // 1 is the original post
+	Author   string    `json:"author"`
+	Content  string    `json:"content"`
+	Tags     []string  `json:"tags,omitempty"`
+	Created  time.Time `json:"created"` // when this version was written
+}
+
+// RevisionOf captures m as revision n.
+func RevisionOf(m Message, n int) Revision {
+	r := Revision{Revision: n, Author: m.Author, Content: m.Content, Tags: m.Tags, Created: m.Created}
+	if m.Updated != nil {
+		r.Created = *m.Updated
+	}
+	return r
+}
+
+// User is an account that can sign in, with a password or through a
+// single sign-on provider.
+type User struct {
+	Name         string    `json:"name"`
+	PasswordHash []byte    `json:"-"` // bcrypt
+	Role         string    `json:"role"`
+	Created      time.Time `json:"created"`
+	// Provider is the single sign-on provider that created the account,
+	// or "" for accounts with a local password.
+	Provider string `json:"provider,omitempty"`
+	// Email and Avatar, the blob key of an uploaded picture, are set by the
+	// user on the account page.
+	Email  string `json:"email,omitempty"`
+	Avatar string `json:"-"`
+	// ColorScheme is "light" or "dark" once the user has picked one, or ""
+	// to follow their browser.
+	ColorScheme string `json:"color_scheme,omitempty"`
+	// TimeZone is the IANA name of the zone pages show the user times in,
+	// or "" to follow their browser.
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// Roles, from least to most trusted. The server decides what each may do.
+const (
+	RoleViewer    = "viewer"
+	RolePoster    = "poster"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// ValidRole reports whether role is one of the roles above.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleViewer, RolePoster, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// UserStore holds local accounts.
+type UserStore interface {
+	GetUser(ctx context.Context, name string) (User, error)
+	// SaveUser creates the user or replaces its password hash and role.
+	SaveUser(ctx context.Context, u User) error
+	// SetProfile replaces the user's email and avatar key.
+	SetProfile(ctx context.Context, name, email, avatar string) error
+	// SetColorScheme replaces the user's ColorScheme.
+	SetColorScheme(ctx context.Context, name, scheme string) error
+	// SetTimeZone replaces the user's TimeZone.
+	SetTimeZone(ctx context.Context, name, zone string) error
+	// ListUsers returns every account ordered by name.
+	ListUsers(ctx context.Context) ([]User, error)
+	DeleteUser(ctx context.Context, name string) error
+}
+
+// APIKey is a named credential for scripts. Only the SHA-256 of the secret
+// is kept.
+type APIKey struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"-"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+}
+
+// APIKeyStore holds API keys created at runtime; keys from the API_KEYS
+// environment variable never reach it.
+type APIKeyStore interface {
+	// GetAPIKey looks a key up by the hex SHA-256 of its secret.
+	GetAPIKey(ctx context.Context, hash string) (APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// CreateAPIKey fails with ErrDuplicate if the name is taken.
+	CreateAPIKey(ctx context.Context, k *APIKey) error
+	DeleteAPIKey(ctx context.Context, name string) error
+}
+
+// Attachment is a file uploaded with a message, served at
+// /attachments/{id}. The store keeps its metadata; the bytes live in the
+// blob store under Key.
+type Attachment struct {
+	ID          int       `json:"id"`
+	MessageID   int       `json:"message_id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Key         string    `json:"-"`
+	Created     time.Time `json:"created"`
+}
+
+// AttachmentStore holds attachment metadata. Purging a message drops its
+// attachments too; deleting their blobs is up to the caller.
+type AttachmentStore interface {
+	// CreateAttachment assigns a an ID and Created time and saves it.
+	CreateAttachment(ctx context.Context, a *Attachment) error
+	GetAttachment(ctx context.Context, id int) (Attachment, error)
+	// Attachments returns the attachments of the given messages, keyed by
+	// message ID and oldest first.
+	Attachments(ctx context.Context, messageIDs []int) (map[int][]Attachment, error)
+}
+
+// Reaction is one user's reaction to a message, such as an
+// acknowledgement of an incident notice.
+type Reaction struct {
+	MessageID int       `json:"message_id"`
+	Reaction  string    `json:"reaction"` // such as "ack"
+	User      string    `json:"user"`     // a user name, or "key:" and an API key's name
+	Created   time.Time `json:"created"`
+}
+
+// ReactionCount sums up one reaction to a message.
+type ReactionCount struct {
+	Reaction string   `json:"reaction"`
+	Emoji    string   `json:"emoji"`
+	Count    int      `json:"count"`
+	Users    []string `json:"users"` // who reacted, first first
+}
+
+// ReactionStore holds reactions to messages. Each user reacts to a message
+// at most once with each reaction.
+type ReactionStore interface {
+	// AddReaction saves r, stamping Created. It fails with ErrNotFound if
+	// the message does not exist and with ErrDuplicate if the user has
+	// already reacted this way.
+	AddReaction(ctx context.Context, r *Reaction) error
+	RemoveReaction(ctx context.Context, messageID int, reaction, user string) error
+	// Reactions returns the reactions to the given messages, keyed by
+	// message ID and oldest first.
+	Reactions(ctx context.Context, messageIDs []int) (map[int][]Reaction, error)
+}
+
+// Subscription asks for email about new messages: all of them, or only
+// those carrying one of Tags. It takes effect once confirmed through the
+// link mailed to Email. Token is in that link and in every unsubscribe
+// link.
+type Subscription struct {
+	Email     string    `json:"email"`
+	Tags      []string  `json:"tags,omitempty"`
+	Token     string    `json:"-"`
+	Confirmed bool      `json:"confirmed"`
+	Created   time.Time `json:"created"`
+}
+
+// Wants reports whether a message with tags should be mailed to s.
+func (s Subscription) Wants(tags []string) bool {
+	if len(s.Tags) == 0 {
+		return true
+	}
+	for _, t := range tags {
+		if slices.Contains(s.Tags, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscriptionStore holds email subscriptions, one per address.
+type SubscriptionStore interface {
+	// CreateSubscription saves s, replacing an unconfirmed subscription for
+	// the same email. It fails with ErrDuplicate if the email is already
+	// confirmed, so nobody can undo someone else's subscription.
+	CreateSubscription(ctx context.Context, s *Subscription) error
+	GetSubscription(ctx context.Context, email string) (Subscription, error)
+	SubscriptionByToken(ctx context.Context, token string) (Subscription, error)
+	ConfirmSubscription(ctx context.Context, token string) error
+	DeleteSubscription(ctx context.Context, token string) error
+	// ListSubscriptions returns every subscription ordered by email.
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+}
+
+// Webhook is an external URL that is sent message events, signed with
+// Secret.
+type Webhook struct {
+	ID      int       `json:"id"`
+	URL     string    `json:"url"`
+	Secret  string    `json:"-"`
+	Events  []string  `json:"events,omitempty"` // e.g. message.created; empty means all
+	Created time.Time `json:"created"`
+}
+
+// WebhookDelivery is one attempt to send an event to a webhook. URL is
+// copied so the log still makes sense after the webhook is deleted.
+type WebhookDelivery struct {
+	ID        int           `json:"id"`
+	WebhookID int           `json:"webhook_id"`
+	URL       string        `json:"url"`
+	Event     string        `json:"event"`
+	MessageID int           `json:"message_id"`
+	Attempt   int           `json:"attempt"`
+	Status    int           `json:"status,omitempty"` // HTTP status, 0 if there was no response
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Created   time.Time     `json:"created"`
+}
+
+// WebhookStore holds webhooks and a log of the latest deliveries.
+type WebhookStore interface {
+	// CreateWebhook assigns w an ID and Created time and saves it.
+	CreateWebhook(ctx context.Context, w *Webhook) error
+	// ListWebhooks returns every webhook, oldest first.
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	// AppendDelivery logs d, dropping the oldest entries beyond
+	// webhookLogSize.
+	AppendDelivery(ctx context.Context, d *WebhookDelivery) error
+	// ListDeliveries returns up to limit deliveries, newest first.
+	ListDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error)
+}
+
+// Build is a CI build as last reported, e.g. by Jenkins. A build is
+// reported several times as it runs and identified by Job and Number.
+type Build struct {
+	ID       int           `json:"id"`
+	Job      string        `json:"job"`
+	Number   int           `json:"number"`
+	Phase    string        `json:"phase"`            // QUEUED, STARTED, COMPLETED or FINALIZED
+	Status   string        `json:"status,omitempty"` // SUCCESS, FAILURE, UNSTABLE, ABORTED or NOT_BUILT once completed
+	URL      string        `json:"url,omitempty"`
+	Branch   string        `json:"branch,omitempty"`
+	Commit   string        `json:"commit,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Started  time.Time     `json:"started"`
+	Updated  time.Time     `json:"updated"`
+}
+
+// BuildStore holds CI build results.
+type BuildStore interface {
+	// SaveBuild stores b, replacing the job's earlier report of the same
+	// build number. The first report's ID and Started (the time of the
+	// report unless b has one) are kept, as are
+	// URL, Branch and Commit when b leaves them empty; b is updated to
+	// what was stored.
+	SaveBuild(ctx context.Context, b *Build) error
+	// RecentBuilds returns the latest perJob builds of every job, the
+	// most recently updated job first and builds newest first within it.
+	RecentBuilds(ctx context.Context, perJob int) ([]Build, error)
+}
+
+// Deployment is one rollout of a version of a service to an environment.
+type Deployment struct {
+	ID          int        `json:"id"`
+	Service     string     `json:"service"`
+	Environment string     `json:"environment"`
+	Version     string     `json:"version"`
+	Status      string     `json:"status"` // "running", "succeeded" or "failed"
+	Actor       string     `json:"actor"`  // who started it, as in the audit log
+	Started     time.Time  `json:"started"`
+	Finished    *time.Time `json:"finished,omitempty"`
+}
+
+// Duration is how long the deployment ran, or has been running.
+func (d Deployment) Duration() time.Duration {
+	if d.Finished == nil {
+		return time.Since(d.Started)
+	}
+	return d.Finished.Sub(d.Started)
+}
+
+// DeploymentFilter narrows ListDeployments; empty fields match anything.
+type DeploymentFilter struct {
+	Service     string
+	Environment string
+	Limit       int // 0 means no limit
+}
+
+// DeploymentStore records deployments.
+type DeploymentStore interface {
+	// CreateDeployment assigns d an ID and Started time and saves it.
+	CreateDeployment(ctx context.Context, d *Deployment) error
+	GetDeployment(ctx context.Context, id int) (Deployment, error)
+	// FinishDeployment sets the status of a running deployment and stamps
+	// Finished. It returns ErrDeploymentFinished if it was not running.
+	FinishDeployment(ctx context.Context, id int, status string) (Deployment, error)
+	// ListDeployments returns matching deployments, newest first.
+	ListDeployments(ctx context.Context, f DeploymentFilter) ([]Deployment, error)
+	// LatestDeployments returns the newest deployment of each service to
+	// each environment, ordered by service and environment.
+	LatestDeployments(ctx context.Context) ([]Deployment, error)
+}
+
+// Incident is an outage or other problem being worked on, with a timeline
+// of updates.
+type Incident struct {
+	ID        int              `json:"id"`
+	Title     string           `json:"title"`
+	Severity  string           `json:"severity"`             // "critical", "major" or "minor"
+	Status    string           `json:"status"`               // "investigating", "identified", "monitoring" or "resolved"
+	MessageID int              `json:"message_id,omitempty"` // the board message that follows it
+	Created   time.Time        `json:"created"`
+	Updated   time.Time        `json:"updated"`
+	Resolved  *time.Time       `json:"resolved,omitempty"`
+	Updates   []IncidentUpdate `json:"updates"` // oldest first
+}
+
+// IncidentUpdate is one entry on an incident's timeline.
+type IncidentUpdate struct {
+	ID       int       `json:"id"`
+	Status   string    `json:"status"`   // the incident's status after this update
+	Severity string    `json:"severity"` // and its severity
+	Body     string    `json:"body,omitempty"`
+	Author   string    `json:"author"`
+	Created  time.Time `json:"created"`
+}
+
+// IncidentFilter narrows ListIncidents.
+type IncidentFilter struct {
+	State string // "open", "resolved" or "" for both
+	Limit int    // 0 means no limit
+}
+
+// IncidentStore holds incidents and their timelines.
+type IncidentStore interface {
+	// CreateIncident assigns inc an ID and times and saves it along with
+	// the updates already on its timeline.
+	CreateIncident(ctx context.Context, inc *Incident) error
+	// GetIncident returns an incident with its timeline.
+	GetIncident(ctx context.Context, id int) (Incident, error)
+	// ListIncidents returns matching incidents with their timelines,
+	// newest first.
+	ListIncidents(ctx context.Context, f IncidentFilter) ([]Incident, error)
+	// UpdateIncident saves the title, severity, status, Resolved time and
+	// MessageID of inc, appends u to its timeline unless u is nil, and
+	// stamps Updated. inc is refreshed with the stored copy.
+	UpdateIncident(ctx context.Context, inc *Incident, u *IncidentUpdate) error
+}
+
+// Component is a part of the service shown on the status page. Its State
+// is set by admins, unless Check names a health check to follow.
+type Component struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	State       string    `json:"state"`           // "operational", "degraded" or "down"
+	Check       string    `json:"check,omitempty"` // e.g. "store"; empty for manual
+	Updated     time.Time `json:"updated"`
+}
+
+// ComponentStore holds the status page components.
+type ComponentStore interface {
+	// ListComponents returns every component, oldest first.
+	ListComponents(ctx context.Context) ([]Component, error)
+	// SaveComponent creates c if its ID is 0 and updates it otherwise,
+	// stamping Updated. Names are unique: a clash fails with ErrDuplicate.
+	SaveComponent(ctx context.Context, c *Component) error
+	DeleteComponent(ctx context.Context, id int) error
+}
+
+// Banner is the site-wide announcement shown atop every page until it
+// expires or is cleared. Every banner set gets a new ID, so dismissing one
+// does not hide the next.
+type Banner struct {
+	ID       int        `json:"id"`
+	Text     string     `json:"text"`
+	Severity string     `json:"severity"` // "info", "warning" or "critical"
+	Expires  *time.Time `json:"expires,omitempty"`
+	Author   string     `json:"author"`
+	Created  time.Time  `json:"created"`
+}
+
+// Active reports whether b is still to be shown.
+func (b Banner) Active() bool {
+	return b.Expires == nil || time.Now().Before(*b.Expires)
+}
+
+// BannerStore holds the announcement banner; there is at most one.
+type BannerStore interface {
+	// GetBanner returns the banner, expired or not, or ErrBannerNotFound.
+	GetBanner(ctx context.Context) (Banner, error)
+	// SetBanner replaces the banner with b, assigning it an ID and
+	// stamping Created.
+	SetBanner(ctx context.Context, b *Banner) error
+	// ClearBanner removes the banner, if there is one.
+	ClearBanner(ctx context.Context) error
+}
+
+// CheckResult is the outcome of one probe of a monitored target.
+type CheckResult struct {
+	ID      int           `json:"id"`
+	Monitor string        `json:"monitor"`
+	Up      bool          `json:"up"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+	Checked time.Time     `json:"checked"`
+}
+
+// MonitorStore keeps the results of uptime checks.
+type MonitorStore interface {
+	// AddCheckResult saves r, stamping Checked.
+	AddCheckResult(ctx context.Context, r *CheckResult) error
+	// RecentCheckResults returns up to limit of monitor's results, newest
+	// first.
+	RecentCheckResults(ctx context.Context, monitor string, limit int) ([]CheckResult, error)
+	// Uptime counts monitor's results checked after since, and how many of
+	// them were up.
+	Uptime(ctx context.Context, monitor string, since time.Time) (up, total int, err error)
+	// PruneCheckResults deletes results checked before the given time.
+	PruneCheckResults(ctx context.Context, before time.Time) error
+}
+
+// AuditEntry records one mutating action: who did what to which object,
+// with JSON snapshots of the object before and after where they apply.
+type AuditEntry struct {
+	ID        int             `json:"id"`
+	Time      time.Time       `json:"time"`
+	Actor     string          `json:"actor"` // user name, "key:<name>", "anonymous" or "system"
+	IP        string          `json:"ip,omitempty"`
+	Action    string          `json:"action"`           // e.g. "message.update", "login"
+	Target    string          `json:"target,omitempty"` // e.g. "message:12", "user:admin"
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+}
+
+// AuditFilter selects audit entries. Action matches exactly or as a
+// dotted prefix, so "message" finds "message.update".
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time // inclusive
+	Until  time.Time // exclusive
+	Offset int
+	Limit  int // 0 means no limit
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+	switch {
+	case f.Actor != "" && e.Actor != f.Actor,
+		f.Action != "" && e.Action != f.Action && !strings.HasPrefix(e.Action, f.Action+"."),
+		f.Target != "" && e.Target != f.Target,
+		!f.Since.IsZero() && e.Time.Before(f.Since),
+		!f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// AuditStore is an append-only log: entries can be added and read but
+// never changed or removed.
+type AuditStore interface {
+	// AppendAudit assigns e an ID and, if unset, a Time and saves it.
+	AppendAudit(ctx context.Context, e *AuditEntry) error
+	// ListAudit returns matching entries newest first, with the total
+	// before Offset and Limit.
+	ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, int, error)
+	// EachAudit calls fn for every matching entry, oldest first.
+	EachAudit(ctx context.Context, f AuditFilter, fn func(AuditEntry) error) error
+}
+
+// ListOptions filters, orders and pages a List call. The zero value lists
+// every message, pinned ones first and then newest first.
+type ListOptions struct {
+	Channel   string    // messages in this channel; empty for all
+	Hidden    []string  // leave out messages in these channels
+	Author    string    // exact author match
+	Tag       string    // messages carrying this tag
+	Pinned    *bool     // only pinned (true) or unpinned (false) messages
+	Trash     bool      // list deleted messages, most recently deleted first
+	Scheduled bool      // list messages waiting for their PublishAt instead, soonest first
+	Since     time.Time // only messages created after this instant
+	Query     string    // case-insensitive substring of author or content
+	Sort      string    // "created" (pinned, then newest first; default) or "author" (A-Z)
+	Offset    int
+	Limit     int // 0 means no limit
+}
+
+func (o ListOptions) match(m Message) bool {
+	if (m.Deleted != nil) != o.Trash {
+		return false
+	}
+	if now := time.Now(); !o.Trash && (o.Scheduled && !m.scheduled(now) || !o.Scheduled && !m.Visible(now)) {
+		return false
+	}
+	if o.Channel != "" && m.Channel != o.Channel || slices.Contains(o.Hidden, m.Channel) {
+		return false
+	}
+	if o.Author != "" && m.Author != o.Author {
+		return false
+	}
+	if o.Tag != "" && !slices.Contains(m.Tags, o.Tag) {
+		return false
+	}
+	if o.Pinned != nil && m.Pinned != *o.Pinned {
+		return false
+	}
+	if !o.Since.IsZero() && !m.Created.After(o.Since) {
+		return false
+	}
+	if o.Query != "" {
+		q := strings.ToLower(o.Query)
+		if !strings.Contains(strings.ToLower(m.Author), q) && !strings.Contains(strings.ToLower(m.Content), q) {
+			return false
+		}
+	}
+	return true
+}
+
+// page applies Offset and Limit to an already filtered and sorted slice.
+func (o ListOptions) page(msgs []Message) []Message {
+	if o.Offset >= len(msgs) {
+		return []Message{}
+	}
+	msgs = msgs[o.Offset:]
+	if o.Limit > 0 && o.Limit < len(msgs) {
+		msgs = msgs[:o.Limit]
+	}
+	return msgs
+}
+
+// Open opens a backend: memory, sqlite, which keeps its data in the
+// file at dbPath, or postgres, which connects to databaseURL. mode says
+// what to do about the SQL backends' schema; see migrate.go.
+func Open(backend, dbPath, databaseURL string, mode MigrationMode) (Store, error) {
+	switch backend {
+	case "memory":
+		return newMemoryStore(), nil
+	case "sqlite":
+		return openSQLiteStore(dbPath, mode)
+	case "postgres":
+		return openPostgresStore(databaseURL, mode)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// memoryStore keeps messages in a slice. Nothing survives a restart, which
+// makes it handy for tests and throwaway instances.
+type memoryStore struct {
+	mu        sync.RWMutex
+	messages  []Message
+	revisions map[int][]Revision // by message ID
+	nextID    int
+	users     map[string]User
+	keys      map[string]APIKey // by name
+	audit     []AuditEntry      // oldest first
+
+	attachments  []Attachment
+	reactions    []Reaction
+	attachmentID int // last assigned
+
+	subscriptions map[string]Subscription // by email
+
+	webhooks   []Webhook
+	webhookID  int               // last assigned
+	deliveries []WebhookDelivery // oldest first
+	deliveryID int               // last assigned
+
+	builds  []Build
+	buildID int // last assigned
+
+	deployments  []Deployment // oldest first
+	deploymentID int          // last assigned
+
+	incidents        []Incident // oldest first
+	incidentID       int        // last assigned
+	incidentUpdateID int        // last assigned
+
+	components  []Component
+	componentID int // last assigned
+
+	checkResults  []CheckResult // oldest first
+	checkResultID int           // last assigned
+
+	banner   *Banner
+	bannerID int // last assigned
+
+	channels map[string]Channel
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		messages:  []Message{{ID: 1, Channel: DefaultChannel, Author: "System", Content: "Welcome to the SLRS-Admin devops web testing! -V1", Created: time.Now()}},
+		revisions: make(map[int][]Revision),
+		nextID:    2,
+		users:     make(map[string]User),
+		keys:      make(map[string]APIKey),
+
+		subscriptions: make(map[string]Subscription),
+		channels:      make(map[string]Channel),
+	}
+}
+
+func (s *memoryStore) List(ctx context.Context, opts ListOptions) ([]Message, int, error) {
+	s.mu.RLock()
+	msgs := []Message{}
+	for _, m := range s.messages {
+		if opts.match(m) {
+			msgs = append(msgs, m)
+		}
+	}
+	s.mu.RUnlock()
+	// The slice is already newest first, so stable sorts keep that order
+	// within each author or pin state.
+	switch {
+	case opts.Trash:
+		sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Deleted.After(*msgs[j].Deleted) })
+	case opts.Scheduled:
+		sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].PublishAt.Before(*msgs[j].PublishAt) })
+	case opts.Sort == "author":
+		sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Author < msgs[j].Author })
+	default:
+		sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Pinned && !msgs[j].Pinned })
+	}
+	return opts.page(msgs), len(msgs), nil
+}
+
+func (s *memoryStore) Each(ctx context.Context, fn func(Message) error) error {
+	s.mu.RLock()
+	msgs := slices.Clone(s.messages)
+	s.mu.RUnlock()
+	for _, m := range slices.Backward(msgs) {
+		if m.Deleted != nil {
+			continue
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id int) (Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.live(id); i >= 0 {
+		return s.messages[i], nil
+	}
+	return Message{}, ErrNotFound
+}
+
+func (s *memoryStore) Thread(ctx context.Context, id int) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := s.live(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	for s.messages[i].ParentID != 0 {
+		if p := s.index(s.messages[i].ParentID); p >= 0 {
+			i = p
+		} else {
+			break
+		}
+	}
+	in := map[int]bool{s.messages[i].ID: true}
+	var thread []Message
+	if s.messages[i].Deleted == nil {
+		thread = append(thread, s.messages[i])
+	}
+	// Oldest first, a reply always comes after its parent. Trashed
+	// messages still link their replies into the thread.
+	for j := i - 1; j >= 0; j-- {
+		if m := s.messages[j]; in[m.ParentID] {
+			in[m.ID] = true
+			if m.Deleted == nil {
+				thread = append(thread, m)
+			}
+		}
+	}
+	return thread, nil
+}
+
+func (s *memoryStore) Due(ctx context.Context, after, until time.Time) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	in := func(t *time.Time) bool { return t != nil && t.After(after) && !t.After(until) }
+	var msgs []Message
+	for _, m := range slices.Backward(s.messages) {
+		if m.Deleted == nil && (in(m.PublishAt) || in(m.ExpiresAt)) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+func (s *memoryStore) Create(ctx context.Context, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg.ParentID != 0 {
+		i := s.live(msg.ParentID)
+		if i < 0 {
+			return ErrParentNotFound
+		}
+		msg.Channel = s.messages[i].Channel
+	}
+	msg.Channel = cmp.Or(msg.Channel, DefaultChannel)
+	msg.ID = s.nextID
+	s.nextID++
+	msg.Created = time.Now()
+	msg.Version = 1
+	s.messages = append([]Message{*msg}, s.messages...)
+	return nil
+}
+
+func (s *memoryStore) CreateBatch(ctx context.Context, msgs []*Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, msg := range msgs {
+		msg.ID = s.nextID
+		s.nextID++
+		msg.Channel = cmp.Or(msg.Channel, DefaultChannel)
+		if msg.Created.IsZero() {
+			msg.Created = now
+		}
+		msg.Version = 1
+		s.insert(*msg)
+	}
+	return nil
+}
+
+// insert places m so that s.messages stays newest first. Callers hold s.mu.
+func (s *memoryStore) insert(m Message) {
+	i := sort.Search(len(s.messages), func(i int) bool { return !s.messages[i].Created.After(m.Created) })
+	s.messages = slices.Insert(s.messages, i, m)
+}
+
+func (s *memoryStore) Update(ctx context.Context, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.live(msg.ID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	old := s.messages[i]
+	if msg.Version != 0 && msg.Version != old.Version {
+		return ErrVersionConflict
+	}
+	s.revisions[old.ID] = append(s.revisions[old.ID], RevisionOf(old, old.Revisions+1))
+	now := time.Now()
+	s.messages[i].Author = msg.Author
+	s.messages[i].Content = msg.Content
+	s.messages[i].Tags = slices.Clone(msg.Tags)
+	s.messages[i].PublishAt = msg.PublishAt
+	s.messages[i].ExpiresAt = msg.ExpiresAt
+	s.messages[i].Updated = &now
+	s.messages[i].Revisions++
+	s.messages[i].Version++
+	*msg = s.messages[i]
+	return nil
+}
+
+func (s *memoryStore) Revisions(ctx context.Context, id int) ([]Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.live(id) < 0 {
+		return nil, ErrNotFound
+	}
+	return append([]Revision{}, s.revisions[id]...), nil
+}
+
+func (s *memoryStore) SetPinned(ctx context.Context, id int, pinned bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.live(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.messages[i].Pinned = pinned
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.live(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	now := time.Now()
+	s.messages[i].Deleted = &now
+	return nil
+}
+
+func (s *memoryStore) Restore(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(id)
+	if i < 0 || s.messages[i].Deleted == nil {
+		return ErrNotFound
+	}
+	s.messages[i].Deleted = nil
+	return nil
+}
+
+func (s *memoryStore) Purge(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(id)
+	if i < 0 || s.messages[i].Deleted == nil {
+		return ErrNotFound
+	}
+	for j := range s.messages {
+		if s.messages[j].ParentID == id {
+			s.messages[j].ParentID = s.messages[i].ParentID
+		}
+	}
+	s.messages = append(s.messages[:i], s.messages[i+1:]...)
+	delete(s.revisions, id)
+	s.attachments = slices.DeleteFunc(s.attachments, func(a Attachment) bool { return a.MessageID == id })
+	s.reactions = slices.DeleteFunc(s.reactions, func(r Reaction) bool { return r.MessageID == id })
+	return nil
+}
+
+func (s *memoryStore) CreateAttachment(ctx context.Context, a *Attachment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index(a.MessageID) < 0 {
+		return ErrNotFound
+	}
+	s.attachmentID++
+	a.ID = s.attachmentID
+	a.Created = time.Now()
+	s.attachments = append(s.attachments, *a)
+	return nil
+}
+
+func (s *memoryStore) GetAttachment(ctx context.Context, id int) (Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, a := range s.attachments {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return Attachment{}, ErrAttachmentNotFound
+}
+
+func (s *memoryStore) Attachments(ctx context.Context, messageIDs []int) (map[int][]Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	byMessage := make(map[int][]Attachment)
+	for _, a := range s.attachments {
+		if slices.Contains(messageIDs, a.MessageID) {
+			byMessage[a.MessageID] = append(byMessage[a.MessageID], a)
+		}
+	}
+	return byMessage, nil
+}
+
+func (s *memoryStore) AddReaction(ctx context.Context, r *Reaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.live(r.MessageID) < 0 {
+		return ErrNotFound
+	}
+	for _, old := range s.reactions {
+		if old.MessageID == r.MessageID && old.Reaction == r.Reaction && old.User == r.User {
+			return ErrDuplicate
+		}
+	}
+	r.Created = time.Now()
+	s.reactions = append(s.reactions, *r)
+	return nil
+}
+
+func (s *memoryStore) RemoveReaction(ctx context.Context, messageID int, reaction, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.reactions)
+	s.reactions = slices.DeleteFunc(s.reactions, func(r Reaction) bool {
+		return r.MessageID == messageID && r.Reaction == reaction && r.User == user
+	})
+	if len(s.reactions) == n {
+		return ErrReactionNotFound
+	}
+	return nil
+}
+
+func (s *memoryStore) Reactions(ctx context.Context, messageIDs []int) (map[int][]Reaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	byMessage := make(map[int][]Reaction)
+	for _, r := range s.reactions {
+		if slices.Contains(messageIDs, r.MessageID) {
+			byMessage[r.MessageID] = append(byMessage[r.MessageID], r)
+		}
+	}
+	return byMessage, nil
+}
+
+func (s *memoryStore) GetUser(ctx context.Context, name string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *memoryStore) SaveUser(ctx context.Context, u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.users[u.Name]; ok {
+		u.Created = old.Created
+		u.Email, u.Avatar, u.ColorScheme, u.TimeZone = old.Email, old.Avatar, old.ColorScheme, old.TimeZone
+	} else {
+		u.Created = time.Now()
+	}
+	s.users[u.Name] = u
+	return nil
+}
+
+func (s *memoryStore) ListUsers(ctx context.Context) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	slices.SortFunc(users, func(a, b User) int { return strings.Compare(a.Name, b.Name) })
+	return users, nil
+}
+
+func (s *memoryStore) SetProfile(ctx context.Context, name, email, avatar string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Email, u.Avatar = email, avatar
+	s.users[name] = u
+	return nil
+}
+
+func (s *memoryStore) SetColorScheme(ctx context.Context, name, scheme string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.ColorScheme = scheme
+	s.users[name] = u
+	return nil
+}
+
+func (s *memoryStore) SetTimeZone(ctx context.Context, name, zone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.TimeZone = zone
+	s.users[name] = u
+	return nil
+}
+
+func (s *memoryStore) DeleteUser(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[name]; !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, name)
+	return nil
+}
+
+func (s *memoryStore) CreateSubscription(ctx context.Context, sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.subscriptions[sub.Email]; ok && old.Confirmed {
+		return ErrDuplicate
+	}
+	sub.Created = time.Now()
+	sub.Confirmed = false
+	s.subscriptions[sub.Email] = *sub
+	return nil
+}
+
+func (s *memoryStore) GetSubscription(ctx context.Context, email string) (Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub, ok := s.subscriptions[email]
+	if !ok {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+	return sub, nil
+}
+
+func (s *memoryStore) SubscriptionByToken(ctx context.Context, token string) (Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sub := range s.subscriptions {
+		if sub.Token == token {
+			return sub, nil
+		}
+	}
+	return Subscription{}, ErrSubscriptionNotFound
+}
+
+func (s *memoryStore) ConfirmSubscription(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for email, sub := range s.subscriptions {
+		if sub.Token == token {
+			sub.Confirmed = true
+			s.subscriptions[email] = sub
+			return nil
+		}
+	}
+	return ErrSubscriptionNotFound
+}
+
+func (s *memoryStore) DeleteSubscription(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for email, sub := range s.subscriptions {
+		if sub.Token == token {
+			delete(s.subscriptions, email)
+			return nil
+		}
+	}
+	return ErrSubscriptionNotFound
+}
+
+func (s *memoryStore) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Email < subs[j].Email })
+	return subs, nil
+}
+
+func (s *memoryStore) CreateWebhook(ctx context.Context, w *Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhookID++
+	w.ID = s.webhookID
+	w.Created = time.Now()
+	s.webhooks = append(s.webhooks, *w)
+	return nil
+}
+
+func (s *memoryStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.webhooks), nil
+}
+
+func (s *memoryStore) DeleteWebhook(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.webhooks, func(w Webhook) bool { return w.ID == id })
+	if i < 0 {
+		return ErrWebhookNotFound
+	}
+	s.webhooks = slices.Delete(s.webhooks, i, i+1)
+	return nil
+}
+
+func (s *memoryStore) AppendDelivery(ctx context.Context, d *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveryID++
+	d.ID = s.deliveryID
+	d.Created = time.Now()
+	s.deliveries = append(s.deliveries, *d)
+	if n := len(s.deliveries) - webhookLogSize; n > 0 {
+		s.deliveries = slices.Delete(s.deliveries, 0, n)
+	}
+	return nil
+}
+
+func (s *memoryStore) ListDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ds []WebhookDelivery
+	for _, d := range slices.Backward(s.deliveries) {
+		if len(ds) == limit {
+			break
+		}
+		ds = append(ds, d)
+	}
+	return ds, nil
+}
+
+func (s *memoryStore) SaveBuild(ctx context.Context, b *Build) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b.Updated = time.Now()
+	i := slices.IndexFunc(s.builds, func(o Build) bool { return o.Job == b.Job && o.Number == b.Number })
+	if i < 0 {
+		if b.Started.IsZero() {
+			b.Started = b.Updated
+		}
+		s.buildID++
+		b.ID = s.buildID
+		s.builds = append(s.builds, *b)
+		return nil
+	}
+	old := s.builds[i]
+	b.ID, b.Started = old.ID, old.Started
+	b.URL = cmp.Or(b.URL, old.URL)
+	b.Branch = cmp.Or(b.Branch, old.Branch)
+	b.Commit = cmp.Or(b.Commit, old.Commit)
+	s.builds[i] = *b
+	return nil
+}
+
+func (s *memoryStore) RecentBuilds(ctx context.Context, perJob int) ([]Build, error) {
+	s.mu.RLock()
+	byJob := make(map[string][]Build)
+	for _, b := range s.builds {
+		byJob[b.Job] = append(byJob[b.Job], b)
+	}
+	s.mu.RUnlock()
+	latest := func(bs []Build) time.Time {
+		return slices.MaxFunc(bs, func(a, b Build) int { return a.Updated.Compare(b.Updated) }).Updated
+	}
+	groups := slices.Collect(maps.Values(byJob))
+	slices.SortFunc(groups, func(a, b []Build) int {
+		return cmp.Or(latest(b).Compare(latest(a)), strings.Compare(a[0].Job, b[0].Job))
+	})
+	builds := []Build{}
+	for _, bs := range groups {
+		slices.SortFunc(bs, func(a, b Build) int { return b.Number - a.Number })
+		builds = append(builds, bs[:min(perJob, len(bs))]...)
+	}
+	return builds, nil
+}
+
+func (s *memoryStore) CreateDeployment(ctx context.Context, d *Deployment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deploymentID++
+	d.ID = s.deploymentID
+	d.Started = time.Now()
+	s.deployments = append(s.deployments, *d)
+	return nil
+}
+
+func (s *memoryStore) GetDeployment(ctx context.Context, id int) (Deployment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, d := range s.deployments {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	return Deployment{}, ErrDeploymentNotFound
+}
+
+func (s *memoryStore) FinishDeployment(ctx context.Context, id int, status string) (Deployment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.deployments, func(d Deployment) bool { return d.ID == id })
+	if i < 0 {
+		return Deployment{}, ErrDeploymentNotFound
+	}
+	d := &s.deployments[i]
+	if d.Status != "running" {
+		return *d, ErrDeploymentFinished
+	}
+	now := time.Now()
+	d.Status, d.Finished = status, &now
+	return *d, nil
+}
+
+func (s *memoryStore) ListDeployments(ctx context.Context, f DeploymentFilter) ([]Deployment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ds := []Deployment{}
+	for _, d := range slices.Backward(s.deployments) {
+		if f.Limit > 0 && len(ds) == f.Limit {
+			break
+		}
+		if (f.Service == "" || d.Service == f.Service) && (f.Environment == "" || d.Environment == f.Environment) {
+			ds = append(ds, d)
+		}
+	}
+	return ds, nil
+}
+
+func (s *memoryStore) LatestDeployments(ctx context.Context) ([]Deployment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	latest := make(map[[2]string]Deployment)
+	for _, d := range s.deployments {
+		latest[[2]string{d.Service, d.Environment}] = d
+	}
+	ds := slices.Collect(maps.Values(latest))
+	slices.SortFunc(ds, func(a, b Deployment) int {
+		return cmp.Or(strings.Compare(a.Service, b.Service), strings.Compare(a.Environment, b.Environment))
+	})
+	return ds, nil
+}
+
+func (s *memoryStore) CreateIncident(ctx context.Context, inc *Incident) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.incidentID++
+	inc.ID = s.incidentID
+	inc.Created = time.Now()
+	inc.Updated = inc.Created
+	for i := range inc.Updates {
+		s.incidentUpdateID++
+		inc.Updates[i].ID = s.incidentUpdateID
+		inc.Updates[i].Created = inc.Created
+	}
+	s.incidents = append(s.incidents, cloneIncident(*inc))
+	return nil
+}
+
+// cloneIncident copies inc so its timeline is not shared.
+func cloneIncident(inc Incident) Incident {
+	inc.Updates = slices.Clone(inc.Updates)
+	return inc
+}
+
+func (s *memoryStore) GetIncident(ctx context.Context, id int) (Incident, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, inc := range s.incidents {
+		if inc.ID == id {
+			return cloneIncident(inc), nil
+		}
+	}
+	return Incident{}, ErrIncidentNotFound
+}
+
+func (s *memoryStore) ListIncidents(ctx context.Context, f IncidentFilter) ([]Incident, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	incs := []Incident{}
+	for _, inc := range slices.Backward(s.incidents) {
+		if f.Limit > 0 && len(incs) == f.Limit {
+			break
+		}
+		resolved := inc.Status == "resolved"
+		if (f.State == "open" && resolved) || (f.State == "resolved" && !resolved) {
+			continue
+		}
+		incs = append(incs, cloneIncident(inc))
+	}
+	return incs, nil
+}
+
+func (s *memoryStore) UpdateIncident(ctx context.Context, inc *Incident, u *IncidentUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.incidents, func(o Incident) bool { return o.ID == inc.ID })
+	if i < 0 {
+		return ErrIncidentNotFound
+	}
+	stored := &s.incidents[i]
+	stored.Title, stored.Severity, stored.Status = inc.Title, inc.Severity, inc.Status
+	stored.Resolved, stored.MessageID = inc.Resolved, inc.MessageID
+	stored.Updated = time.Now()
+	if u != nil {
+		s.incidentUpdateID++
+		u.ID = s.incidentUpdateID
+		u.Created = stored.Updated
+		stored.Updates = append(stored.Updates, *u)
+	}
+	*inc = cloneIncident(*stored)
+	return nil
+}
+
+func (s *memoryStore) ListComponents(ctx context.Context) ([]Component, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.components), nil
+}
+
+func (s *memoryStore) SaveComponent(ctx context.Context, c *Component) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.ContainsFunc(s.components, func(o Component) bool { return o.Name == c.Name && o.ID != c.ID }) {
+		return ErrDuplicate
+	}
+	c.Updated = time.Now()
+	if c.ID == 0 {
+		s.componentID++
+		c.ID = s.componentID
+		s.components = append(s.components, *c)
+		return nil
+	}
+	i := slices.IndexFunc(s.components, func(o Component) bool { return o.ID == c.ID })
+	if i < 0 {
+		return ErrComponentNotFound
+	}
+	s.components[i] = *c
+	return nil
+}
+
+func (s *memoryStore) DeleteComponent(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.components, func(c Component) bool { return c.ID == id })
+	if i < 0 {
+		return ErrComponentNotFound
+	}
+	s.components = slices.Delete(s.components, i, i+1)
+	return nil
+}
+
+func (s *memoryStore) ListChannels(ctx context.Context) ([]Channel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.SortedFunc(maps.Values(s.channels), func(a, b Channel) int { return cmp.Compare(a.Name, b.Name) }), nil
+}
+
+func (s *memoryStore) GetChannel(ctx context.Context, name string) (Channel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.channels[name]
+	if !ok {
+		return Channel{}, ErrChannelNotFound
+	}
+	return c, nil
+}
+
+func (s *memoryStore) CreateChannel(ctx context.Context, c *Channel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.channels[c.Name]; ok {
+		return ErrDuplicate
+	}
+	c.Created = time.Now()
+	s.channels[c.Name] = *c
+	return nil
+}
+
+func (s *memoryStore) UpdateChannel(ctx context.Context, c *Channel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.channels[c.Name]
+	if !ok {
+		return ErrChannelNotFound
+	}
+	old.Description, old.Archived = c.Description, c.Archived
+	old.Private, old.Users, old.Roles = c.Private, slices.Clone(c.Users), slices.Clone(c.Roles)
+	s.channels[c.Name] = old
+	*c = old
+	return nil
+}
+
+func (s *memoryStore) GetBanner(ctx context.Context) (Banner, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.banner == nil {
+		return Banner{}, ErrBannerNotFound
+	}
+	return *s.banner, nil
+}
+
+func (s *memoryStore) SetBanner(ctx context.Context, b *Banner) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bannerID++
+	b.ID = s.bannerID
+	b.Created = time.Now()
+	saved := *b
+	s.banner = &saved
+	return nil
+}
+
+func (s *memoryStore) ClearBanner(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.banner = nil
+	return nil
+}
+
+func (s *memoryStore) AddCheckResult(ctx context.Context, r *CheckResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkResultID++
+	r.ID = s.checkResultID
+	r.Checked = time.Now()
+	s.checkResults = append(s.checkResults, *r)
+	return nil
+}
+
+func (s *memoryStore) RecentCheckResults(ctx context.Context, monitor string, limit int) ([]CheckResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rs := []CheckResult{}
+	for _, r := range slices.Backward(s.checkResults) {
+		if len(rs) == limit {
+			break
+		}
+		if r.Monitor == monitor {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+func (s *memoryStore) Uptime(ctx context.Context, monitor string, since time.Time) (up, total int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.checkResults {
+		if r.Monitor == monitor && r.Checked.After(since) {
+			total++
+			if r.Up {
+				up++
+			}
+		}
+	}
+	return up, total, nil
+}
+
+func (s *memoryStore) PruneCheckResults(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkResults = slices.DeleteFunc(s.checkResults, func(r CheckResult) bool { return r.Checked.Before(before) })
+	return nil
+}
+
+func (s *memoryStore) GetAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return APIKey{}, ErrKeyNotFound
+}
+
+func (s *memoryStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+func (s *memoryStore) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[k.Name]; ok {
+		return ErrDuplicate
+	}
+	k.Created = time.Now()
+	s.keys[k.Name] = *k
+	return nil
+}
+
+func (s *memoryStore) DeleteAPIKey(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[name]; !ok {
+		return ErrKeyNotFound
+	}
+	delete(s.keys, name)
+	return nil
+}
+
+func (s *memoryStore) AppendAudit(ctx context.Context, e *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = len(s.audit) + 1
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.audit = append(s.audit, *e)
+	return nil
+}
+
+func (s *memoryStore) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, int, error) {
+	entries := []AuditEntry{}
+	s.EachAudit(ctx, f, func(e AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	slices.Reverse(entries)
+	total := len(entries)
+	if f.Offset >= total {
+		return []AuditEntry{}, total, nil
+	}
+	entries = entries[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(entries) {
+		entries = entries[:f.Limit]
+	}
+	return entries, total, nil
+}
+
+func (s *memoryStore) EachAudit(ctx context.Context, f AuditFilter, fn func(AuditEntry) error) error {
+	s.mu.RLock()
+	entries := s.audit[:len(s.audit):len(s.audit)]
+	s.mu.RUnlock()
+	for _, e := range entries {
+		if !f.match(e) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error { return nil }
+
+func (s *memoryStore) Size(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var n int64
+	for _, m := range s.messages {
+		n += int64(len(m.Author) + len(m.Content))
+	}
+	for _, rs := range s.revisions {
+		for _, r := range rs {
+			n += int64(len(r.Author) + len(r.Content))
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) Close() error { return nil }
+
+// live is index for messages that are not in the trash.
+func (s *memoryStore) live(id int) int {
+	if i := s.index(id); i >= 0 && s.messages[i].Deleted == nil {
+		return i
+	}
+	return -1
+}
+
+// index returns the slice position of id, or -1. Callers hold s.mu.
+func (s *memoryStore) index(id int) int {
+	for i, m := range s.messages {
+		if m.ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// openPostgresStore connects to PostgreSQL. Several replicas can share one
+// database, which the in-memory and SQLite stores cannot offer.
+func openPostgresStore(dsn string, mode MigrationMode) (*sqlStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := &sqlStore{db: tracedDB{db, semconv.DBSystemPostgreSQL}, postgres: true}
+	if err := s.prepare(ctx, mode); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
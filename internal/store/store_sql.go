@@ -0,0 +1,1560 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sqlStore implements MessageStore on top of database/sql. Queries are
+// written with '?' placeholders and rewritten for drivers that want $n.
+type sqlStore struct {
+	db       tracedDB
+	postgres bool
+}
+
+// rebind rewrites '?' placeholders to $1, $2, ... when the driver needs it.
+func (s *sqlStore) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// column is a column added after the first release, before versioned
+// migrations. Databases created by older versions get it before they are
+// adopted at version 1; new ones already have it from that migration. The
+// list is closed: later columns come with migrations.
+type column struct {
+	table, name      string
+	sqliteDef, pgDef string
+}
+
+var addedColumns = []column{
+	{"messages", "updated", "DATETIME", "TIMESTAMPTZ"},
+	{"messages", "parent_id", "INTEGER REFERENCES messages (id)", "INTEGER REFERENCES messages (id)"},
+	{"messages", "tags", "TEXT NOT NULL DEFAULT ''", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "pinned", "BOOLEAN NOT NULL DEFAULT FALSE", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"messages", "deleted", "DATETIME", "TIMESTAMPTZ"},
+	{"messages", "revisions", "INTEGER NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "provider", "TEXT NOT NULL DEFAULT ''", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "email", "TEXT NOT NULL DEFAULT ''", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "email", "TEXT NOT NULL DEFAULT ''", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "avatar", "TEXT NOT NULL DEFAULT ''", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "publish_at", "DATETIME", "TIMESTAMPTZ"},
+	{"messages", "expires_at", "DATETIME", "TIMESTAMPTZ"},
+	{"messages", "channel", "TEXT NOT NULL DEFAULT 'general'", "TEXT NOT NULL DEFAULT 'general'"},
+	{"channels", "private", "BOOLEAN NOT NULL DEFAULT FALSE", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"channels", "users", "TEXT NOT NULL DEFAULT ''", "TEXT NOT NULL DEFAULT ''"},
+	{"channels", "roles", "TEXT NOT NULL DEFAULT ''", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "color_scheme", "TEXT NOT NULL DEFAULT ''", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "time_zone", "TEXT NOT NULL DEFAULT ''", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// addColumns adds the addedColumns a database from before migrations
+// lacks. Tables it lacks altogether are left to the first migration.
+func (s *sqlStore) addColumns(ctx context.Context) error {
+	for _, c := range addedColumns {
+		ok, err := s.hasTable(ctx, c.table)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if s.postgres {
+			if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s`, c.table, c.name, c.pgDef)); err != nil {
+				return err
+			}
+			continue
+		}
+		var n int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.name).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, c.table, c.name, c.sqliteDef)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+const messageColumns = `id, author, content, created, updated, parent_id, tags, pinned, deleted, revisions, email, publish_at, expires_at, channel`
+
+func scanMessage(row interface{ Scan(...any) error }) (Message, error) {
+	var m Message
+	var updated, deleted, publishAt, expiresAt sql.NullTime
+	var parent sql.NullInt64
+	var tags string
+	err := row.Scan(&m.ID, &m.Author, &m.Content, &m.Created, &updated, &parent, &tags, &m.Pinned, &deleted, &m.Revisions, &m.Email, &publishAt, &expiresAt, &m.Channel)
+	if updated.Valid {
+		m.Updated = &updated.Time
+	}
+	if deleted.Valid {
+		m.Deleted = &deleted.Time
+	}
+	if publishAt.Valid {
+		m.PublishAt = &publishAt.Time
+	}
+	if expiresAt.Valid {
+		m.ExpiresAt = &expiresAt.Time
+	}
+	m.ParentID = int(parent.Int64)
+	if tags != "" {
+		m.Tags = strings.Split(tags, ",")
+	}
+	m.Version = m.Revisions + 1
+	return m, err
+}
+
+// nullID stores a zero ParentID as NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// nullTime stores a nil time as NULL and others in UTC.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func (s *sqlStore) List(ctx context.Context, opts ListOptions) ([]Message, int, error) {
+	where := []string{"deleted IS NULL"}
+	if opts.Trash {
+		where[0] = "deleted IS NOT NULL"
+	}
+	var args []any
+	switch now := time.Now().UTC(); {
+	case opts.Trash:
+	case opts.Scheduled:
+		where = append(where, "publish_at > ?")
+		args = append(args, now)
+	default:
+		where = append(where, "(publish_at IS NULL OR publish_at <= ?)", "(expires_at IS NULL OR expires_at > ?)")
+		args = append(args, now, now)
+	}
+	if opts.Channel != "" {
+		where = append(where, "channel = ?")
+		args = append(args, opts.Channel)
+	}
+	if len(opts.Hidden) > 0 {
+		where = append(where, "channel NOT IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(opts.Hidden)), ", ")+")")
+		for _, c := range opts.Hidden {
+			args = append(args, c)
+		}
+	}
+	if opts.Author != "" {
+		where = append(where, "author = ?")
+		args = append(args, opts.Author)
+	}
+	if opts.Tag != "" {
+		// Tags are stored comma-joined; the added commas anchor the match.
+		where = append(where, `(',' || tags || ',') LIKE ? ESCAPE '\'`)
+		args = append(args, "%,"+likeEscaper.Replace(opts.Tag)+",%")
+	}
+	if opts.Pinned != nil {
+		where = append(where, "pinned = ?")
+		args = append(args, *opts.Pinned)
+	}
+	if !opts.Since.IsZero() {
+		where = append(where, "created > ?")
+		args = append(args, opts.Since.UTC())
+	}
+	if opts.Query != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(opts.Query)) + "%"
+		where = append(where, `(LOWER(author) LIKE ? ESCAPE '\' OR LOWER(content) LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	cond := " WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM messages`+cond), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	order := " ORDER BY pinned DESC, created DESC, id DESC"
+	switch {
+	case opts.Trash:
+		order = " ORDER BY deleted DESC, id DESC"
+	case opts.Scheduled:
+		order = " ORDER BY publish_at, id"
+	case opts.Sort == "author":
+		order = " ORDER BY author, created DESC, id DESC"
+	}
+	query := `SELECT ` + messageColumns + ` FROM messages` + cond + order
+	if opts.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, opts.Limit, opts.Offset)
+	} else if opts.Offset > 0 {
+		// SQLite requires a LIMIT before OFFSET; -1 means unbounded there
+		// and Postgres accepts LIMIT ALL.
+		query += " LIMIT " + s.unlimited() + " OFFSET ?"
+		args = append(args, opts.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	msgs := []Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, total, rows.Err()
+}
+
+// likeEscaper escapes LIKE wildcards so search terms match literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (s *sqlStore) unlimited() string {
+	if s.postgres {
+		return "ALL"
+	}
+	return "-1"
+}
+
+func (s *sqlStore) Each(ctx context.Context, fn func(Message) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+messageColumns+` FROM messages WHERE deleted IS NULL ORDER BY created, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) Get(ctx context.Context, id int) (Message, error) {
+	m, err := scanMessage(s.db.QueryRowContext(ctx, s.rebind(`SELECT `+messageColumns+` FROM messages WHERE id = ? AND deleted IS NULL`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Message{}, ErrNotFound
+	}
+	return m, err
+}
+
+func (s *sqlStore) Thread(ctx context.Context, id int) ([]Message, error) {
+	var root int
+	err := s.db.QueryRowContext(ctx, s.rebind(`WITH RECURSIVE up (id, parent_id) AS (
+			SELECT id, parent_id FROM messages WHERE id = ? AND deleted IS NULL
+			UNION ALL
+			SELECT m.id, m.parent_id FROM messages m JOIN up ON m.id = up.parent_id
+		) SELECT id FROM up WHERE parent_id IS NULL OR parent_id NOT IN (SELECT id FROM messages)`), id).Scan(&root)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(`WITH RECURSIVE thread (id) AS (
+			SELECT id FROM messages WHERE id = ?
+			UNION ALL
+			SELECT m.id FROM messages m JOIN thread t ON m.parent_id = t.id
+		) SELECT `+messageColumns+` FROM messages WHERE id IN (SELECT id FROM thread) AND deleted IS NULL ORDER BY created, id`), root)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []Message
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+func (s *sqlStore) Due(ctx context.Context, after, until time.Time) ([]Message, error) {
+	after, until = after.UTC(), until.UTC()
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+messageColumns+` FROM messages WHERE deleted IS NULL
+		AND ((publish_at > ? AND publish_at <= ?) OR (expires_at > ? AND expires_at <= ?)) ORDER BY created, id`), after, until, after, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []Message
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+func (s *sqlStore) Create(ctx context.Context, msg *Message) error {
+	if msg.ParentID != 0 {
+		parent, err := s.Get(ctx, msg.ParentID)
+		if errors.Is(err, ErrNotFound) {
+			return ErrParentNotFound
+		} else if err != nil {
+			return err
+		}
+		msg.Channel = parent.Channel
+	}
+	msg.Channel = cmp.Or(msg.Channel, DefaultChannel)
+	msg.Created = time.Now().UTC()
+	msg.Version = 1
+	return s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO messages (author, content, created, parent_id, tags, email, publish_at, expires_at, channel) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`),
+		msg.Author, msg.Content, msg.Created, nullID(msg.ParentID), strings.Join(msg.Tags, ","), msg.Email, nullTime(msg.PublishAt), nullTime(msg.ExpiresAt), msg.Channel).Scan(&msg.ID)
+}
+
+func (s *sqlStore) CreateBatch(ctx context.Context, msgs []*Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, s.rebind(`INSERT INTO messages (author, content, created, parent_id, tags, email, publish_at, expires_at, channel) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().UTC()
+	for _, msg := range msgs {
+		if msg.Created.IsZero() {
+			msg.Created = now
+		}
+		msg.Created = msg.Created.UTC()
+		msg.Channel = cmp.Or(msg.Channel, DefaultChannel)
+		msg.Version = 1
+		if err := stmt.QueryRowContext(ctx, msg.Author, msg.Content, msg.Created, nullID(msg.ParentID), strings.Join(msg.Tags, ","), msg.Email, nullTime(msg.PublishAt), nullTime(msg.ExpiresAt), msg.Channel).Scan(&msg.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Update(ctx context.Context, msg *Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE id = ? AND deleted IS NULL`
+	if s.postgres {
+		// Concurrent edits would otherwise both claim the next revision.
+		query += " FOR UPDATE"
+	}
+	old, err := scanMessage(tx.QueryRowContext(ctx, s.rebind(query), msg.ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if msg.Version != 0 && msg.Version != old.Version {
+		return ErrVersionConflict
+	}
+	rev := RevisionOf(old, old.Revisions+1)
+	// The revisions condition catches an edit saved since the SELECT where
+	// it does not lock the row.
+	res, err := tx.ExecContext(ctx, s.rebind(`UPDATE messages SET author = ?, content = ?, tags = ?, publish_at = ?, expires_at = ?, updated = ?, revisions = ? WHERE id = ? AND revisions = ?`),
+		msg.Author, msg.Content, strings.Join(msg.Tags, ","), nullTime(msg.PublishAt), nullTime(msg.ExpiresAt), time.Now().UTC(), rev.Revision, msg.ID, old.Revisions)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrVersionConflict
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO message_revisions (message_id, revision, author, content, tags, created) VALUES (?, ?, ?, ?, ?, ?)`),
+		old.ID, rev.Revision, rev.Author, rev.Content, strings.Join(rev.Tags, ","), rev.Created.UTC()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	updated, err := s.Get(ctx, msg.ID)
+	if err != nil {
+		return err
+	}
+	*msg = updated
+	return nil
+}
+
+func (s *sqlStore) Revisions(ctx context.Context, id int) ([]Revision, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT revision, author, content, tags, created FROM message_revisions WHERE message_id = ? ORDER BY revision`), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revs := []Revision{}
+	for rows.Next() {
+		var r Revision
+		var tags string
+		if err := rows.Scan(&r.Revision, &r.Author, &r.Content, &tags, &r.Created); err != nil {
+			return nil, err
+		}
+		if tags != "" {
+			r.Tags = strings.Split(tags, ",")
+		}
+		revs = append(revs, r)
+	}
+	return revs, rows.Err()
+}
+
+func (s *sqlStore) SetPinned(ctx context.Context, id int, pinned bool) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE messages SET pinned = ? WHERE id = ? AND deleted IS NULL`), pinned, id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *sqlStore) Delete(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE messages SET deleted = ? WHERE id = ? AND deleted IS NULL`), time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *sqlStore) Restore(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE messages SET deleted = NULL WHERE id = ? AND deleted IS NOT NULL`), id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *sqlStore) Purge(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"message_revisions", "attachments", "reactions"} {
+		if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM `+table+` WHERE message_id = ? AND EXISTS (SELECT 1 FROM messages WHERE id = ? AND deleted IS NOT NULL)`), id, id); err != nil {
+			return err
+		}
+	}
+	// Hand the replies to the deleted message's own parent.
+	if _, err := tx.ExecContext(ctx, s.rebind(`UPDATE messages SET parent_id = (SELECT parent_id FROM messages WHERE id = ?) WHERE parent_id = ?`), id, id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM messages WHERE id = ? AND deleted IS NOT NULL`), id)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) CreateAttachment(ctx context.Context, a *Attachment) error {
+	a.Created = time.Now().UTC()
+	err := s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO attachments (message_id, name, content_type, size, blob_key, created)
+		SELECT id, ?, ?, ?, ?, ? FROM messages WHERE id = ? RETURNING id`),
+		a.Name, a.ContentType, a.Size, a.Key, a.Created, a.MessageID).Scan(&a.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+const attachmentColumns = `id, message_id, name, content_type, size, blob_key, created`
+
+func scanAttachment(row interface{ Scan(...any) error }) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.MessageID, &a.Name, &a.ContentType, &a.Size, &a.Key, &a.Created)
+	return a, err
+}
+
+func (s *sqlStore) GetAttachment(ctx context.Context, id int) (Attachment, error) {
+	a, err := scanAttachment(s.db.QueryRowContext(ctx, s.rebind(`SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+	return a, err
+}
+
+func (s *sqlStore) Attachments(ctx context.Context, messageIDs []int) (map[int][]Attachment, error) {
+	byMessage := make(map[int][]Attachment)
+	if len(messageIDs) == 0 {
+		return byMessage, nil
+	}
+	args := make([]any, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+attachmentColumns+` FROM attachments WHERE message_id IN (`+marks+`) ORDER BY id`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		byMessage[a.MessageID] = append(byMessage[a.MessageID], a)
+	}
+	return byMessage, rows.Err()
+}
+
+func (s *sqlStore) AddReaction(ctx context.Context, r *Reaction) error {
+	r.Created = time.Now().UTC()
+	// The message must exist and not be in the trash.
+	res, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO reactions (message_id, reaction, user_name, created)
+		SELECT id, ?, ?, ? FROM messages WHERE id = ? AND deleted IS NULL
+		ON CONFLICT (message_id, reaction, user_name) DO NOTHING`), r.Reaction, r.User, r.Created, r.MessageID)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) == nil {
+		return nil
+	}
+	if _, err := s.Get(ctx, r.MessageID); err != nil {
+		return err
+	}
+	return ErrDuplicate
+}
+
+func (s *sqlStore) RemoveReaction(ctx context.Context, messageID int, reaction, user string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM reactions WHERE message_id = ? AND reaction = ? AND user_name = ?`), messageID, reaction, user)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrReactionNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) Reactions(ctx context.Context, messageIDs []int) (map[int][]Reaction, error) {
+	byMessage := make(map[int][]Reaction)
+	if len(messageIDs) == 0 {
+		return byMessage, nil
+	}
+	args := make([]any, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT message_id, reaction, user_name, created FROM reactions WHERE message_id IN (`+marks+`) ORDER BY created`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r Reaction
+		if err := rows.Scan(&r.MessageID, &r.Reaction, &r.User, &r.Created); err != nil {
+			return nil, err
+		}
+		byMessage[r.MessageID] = append(byMessage[r.MessageID], r)
+	}
+	return byMessage, rows.Err()
+}
+
+func (s *sqlStore) GetUser(ctx context.Context, name string) (User, error) {
+	var u User
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT name, password_hash, role, created, provider, email, avatar, color_scheme, time_zone FROM users WHERE name = ?`), name).
+		Scan(&u.Name, &u.PasswordHash, &u.Role, &u.Created, &u.Provider, &u.Email, &u.Avatar, &u.ColorScheme, &u.TimeZone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (s *sqlStore) SaveUser(ctx context.Context, u User) error {
+	if u.PasswordHash == nil {
+		u.PasswordHash = []byte{} // single sign-on accounts have no password
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO users (name, password_hash, role, created, provider) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET password_hash = excluded.password_hash, role = excluded.role, provider = excluded.provider`),
+		u.Name, u.PasswordHash, u.Role, time.Now().UTC(), u.Provider)
+	return err
+}
+
+func (s *sqlStore) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, password_hash, role, created, provider, email, avatar, color_scheme, time_zone FROM users ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Name, &u.PasswordHash, &u.Role, &u.Created, &u.Provider, &u.Email, &u.Avatar, &u.ColorScheme, &u.TimeZone); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlStore) SetProfile(ctx context.Context, name, email, avatar string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE users SET email = ?, avatar = ? WHERE name = ?`), email, avatar, name)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) SetColorScheme(ctx context.Context, name, scheme string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE users SET color_scheme = ? WHERE name = ?`), scheme, name)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) SetTimeZone(ctx context.Context, name, zone string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE users SET time_zone = ? WHERE name = ?`), zone, name)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteUser(ctx context.Context, name string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM users WHERE name = ?`), name)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) CreateSubscription(ctx context.Context, sub *Subscription) error {
+	sub.Created = time.Now().UTC()
+	sub.Confirmed = false
+	res, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO subscriptions (email, tags, token, confirmed, created) VALUES (?, ?, ?, FALSE, ?)
+		ON CONFLICT (email) DO UPDATE SET tags = excluded.tags, token = excluded.token, created = excluded.created
+		WHERE subscriptions.confirmed = FALSE`),
+		sub.Email, strings.Join(sub.Tags, ","), sub.Token, sub.Created)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+const subscriptionColumns = `email, tags, token, confirmed, created`
+
+func scanSubscription(row interface{ Scan(...any) error }) (Subscription, error) {
+	var sub Subscription
+	var tags string
+	err := row.Scan(&sub.Email, &tags, &sub.Token, &sub.Confirmed, &sub.Created)
+	if tags != "" {
+		sub.Tags = strings.Split(tags, ",")
+	}
+	return sub, err
+}
+
+func (s *sqlStore) GetSubscription(ctx context.Context, email string) (Subscription, error) {
+	sub, err := scanSubscription(s.db.QueryRowContext(ctx, s.rebind(`SELECT `+subscriptionColumns+` FROM subscriptions WHERE email = ?`), email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+	return sub, err
+}
+
+func (s *sqlStore) SubscriptionByToken(ctx context.Context, token string) (Subscription, error) {
+	sub, err := scanSubscription(s.db.QueryRowContext(ctx, s.rebind(`SELECT `+subscriptionColumns+` FROM subscriptions WHERE token = ?`), token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+	return sub, err
+}
+
+func (s *sqlStore) ConfirmSubscription(ctx context.Context, token string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE subscriptions SET confirmed = TRUE WHERE token = ?`), token)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteSubscription(ctx context.Context, token string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM subscriptions WHERE token = ?`), token)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+subscriptionColumns+` FROM subscriptions ORDER BY email`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	subs := []Subscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (s *sqlStore) CreateWebhook(ctx context.Context, w *Webhook) error {
+	w.Created = time.Now().UTC()
+	return s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO webhooks (url, secret, events, created) VALUES (?, ?, ?, ?) RETURNING id`),
+		w.URL, w.Secret, strings.Join(w.Events, ","), w.Created).Scan(&w.ID)
+}
+
+func (s *sqlStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, url, secret, events, created FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		var events string
+		if err := rows.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Created); err != nil {
+			return nil, err
+		}
+		if events != "" {
+			w.Events = strings.Split(events, ",")
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *sqlStore) DeleteWebhook(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM webhooks WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) AppendDelivery(ctx context.Context, d *WebhookDelivery) error {
+	d.Created = time.Now().UTC()
+	err := s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO webhook_deliveries (webhook_id, url, event, message_id, attempt, status, error, duration_ms, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`),
+		d.WebhookID, d.URL, d.Event, d.MessageID, d.Attempt, d.Status, d.Error, d.Duration.Milliseconds(), d.Created).Scan(&d.ID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.rebind(`DELETE FROM webhook_deliveries WHERE id <= ?`), d.ID-webhookLogSize)
+	return err
+}
+
+func (s *sqlStore) ListDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, webhook_id, url, event, message_id, attempt, status, error, duration_ms, created
+		FROM webhook_deliveries ORDER BY id DESC LIMIT ?`), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ds := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var ms int64
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Event, &d.MessageID, &d.Attempt, &d.Status, &d.Error, &ms, &d.Created); err != nil {
+			return nil, err
+		}
+		d.Duration = time.Duration(ms) * time.Millisecond
+		ds = append(ds, d)
+	}
+	return ds, rows.Err()
+}
+
+const buildColumns = `id, job, number, phase, status, url, branch, commit_sha, duration_ms, started, updated`
+
+func scanBuild(row interface{ Scan(...any) error }) (Build, error) {
+	var b Build
+	var ms int64
+	err := row.Scan(&b.ID, &b.Job, &b.Number, &b.Phase, &b.Status, &b.URL, &b.Branch, &b.Commit, &ms, &b.Started, &b.Updated)
+	b.Duration = time.Duration(ms) * time.Millisecond
+	return b, err
+}
+
+func (s *sqlStore) SaveBuild(ctx context.Context, b *Build) error {
+	b.Updated = time.Now().UTC()
+	if b.Started.IsZero() {
+		b.Started = b.Updated
+	}
+	saved, err := scanBuild(s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO builds (job, number, phase, status, url, branch, commit_sha, duration_ms, started, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (job, number) DO UPDATE SET
+			phase = excluded.phase,
+			status = excluded.status,
+			url = COALESCE(NULLIF(excluded.url, ''), builds.url),
+			branch = COALESCE(NULLIF(excluded.branch, ''), builds.branch),
+			commit_sha = COALESCE(NULLIF(excluded.commit_sha, ''), builds.commit_sha),
+			duration_ms = excluded.duration_ms,
+			updated = excluded.updated
+		RETURNING `+buildColumns),
+		b.Job, b.Number, b.Phase, b.Status, b.URL, b.Branch, b.Commit, b.Duration.Milliseconds(), b.Started, b.Updated))
+	if err != nil {
+		return err
+	}
+	*b = saved
+	return nil
+}
+
+func (s *sqlStore) RecentBuilds(ctx context.Context, perJob int) ([]Build, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+buildColumns+` FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY job ORDER BY number DESC) AS n,
+				MAX(updated) OVER (PARTITION BY job) AS job_updated
+			FROM builds) b
+		WHERE n <= ? ORDER BY job_updated DESC, job, number DESC`), perJob)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	builds := []Build{}
+	for rows.Next() {
+		b, err := scanBuild(rows)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, b)
+	}
+	return builds, rows.Err()
+}
+
+const deploymentColumns = `id, service, environment, version, status, actor, started, finished`
+
+func scanDeployment(row interface{ Scan(...any) error }) (Deployment, error) {
+	var d Deployment
+	var finished sql.NullTime
+	err := row.Scan(&d.ID, &d.Service, &d.Environment, &d.Version, &d.Status, &d.Actor, &d.Started, &finished)
+	if finished.Valid {
+		d.Finished = &finished.Time
+	}
+	return d, err
+}
+
+func (s *sqlStore) CreateDeployment(ctx context.Context, d *Deployment) error {
+	d.Started = time.Now().UTC()
+	return s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO deployments (service, environment, version, status, actor, started) VALUES (?, ?, ?, ?, ?, ?) RETURNING id`),
+		d.Service, d.Environment, d.Version, d.Status, d.Actor, d.Started).Scan(&d.ID)
+}
+
+func (s *sqlStore) GetDeployment(ctx context.Context, id int) (Deployment, error) {
+	d, err := scanDeployment(s.db.QueryRowContext(ctx, s.rebind(`SELECT `+deploymentColumns+` FROM deployments WHERE id = ?`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Deployment{}, ErrDeploymentNotFound
+	}
+	return d, err
+}
+
+func (s *sqlStore) FinishDeployment(ctx context.Context, id int, status string) (Deployment, error) {
+	d, err := scanDeployment(s.db.QueryRowContext(ctx, s.rebind(`UPDATE deployments SET status = ?, finished = ?
+		WHERE id = ? AND status = 'running' RETURNING `+deploymentColumns), status, time.Now().UTC(), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		if d, err = s.GetDeployment(ctx, id); err == nil {
+			err = ErrDeploymentFinished
+		}
+	}
+	return d, err
+}
+
+func (s *sqlStore) ListDeployments(ctx context.Context, f DeploymentFilter) ([]Deployment, error) {
+	query := `SELECT ` + deploymentColumns + ` FROM deployments`
+	var where []string
+	var args []any
+	if f.Service != "" {
+		where = append(where, "service = ?")
+		args = append(args, f.Service)
+	}
+	if f.Environment != "" {
+		where = append(where, "environment = ?")
+		args = append(args, f.Environment)
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY id DESC`
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+	return s.queryDeployments(ctx, query, args...)
+}
+
+func (s *sqlStore) LatestDeployments(ctx context.Context) ([]Deployment, error) {
+	return s.queryDeployments(ctx, `SELECT `+deploymentColumns+` FROM deployments
+		WHERE id IN (SELECT MAX(id) FROM deployments GROUP BY service, environment)
+		ORDER BY service, environment`)
+}
+
+func (s *sqlStore) queryDeployments(ctx context.Context, query string, args ...any) ([]Deployment, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ds := []Deployment{}
+	for rows.Next() {
+		d, err := scanDeployment(rows)
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, d)
+	}
+	return ds, rows.Err()
+}
+
+const incidentColumns = `id, title, severity, status, message_id, created, updated, resolved`
+
+func scanIncident(row interface{ Scan(...any) error }) (Incident, error) {
+	var inc Incident
+	var resolved sql.NullTime
+	err := row.Scan(&inc.ID, &inc.Title, &inc.Severity, &inc.Status, &inc.MessageID, &inc.Created, &inc.Updated, &resolved)
+	if resolved.Valid {
+		inc.Resolved = &resolved.Time
+	}
+	return inc, err
+}
+
+func (s *sqlStore) CreateIncident(ctx context.Context, inc *Incident) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	inc.Created = time.Now().UTC()
+	inc.Updated = inc.Created
+	err = tx.QueryRowContext(ctx, s.rebind(`INSERT INTO incidents (title, severity, status, message_id, created, updated, resolved) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`),
+		inc.Title, inc.Severity, inc.Status, inc.MessageID, inc.Created, inc.Updated, nullTime(inc.Resolved)).Scan(&inc.ID)
+	if err != nil {
+		return err
+	}
+	for i := range inc.Updates {
+		inc.Updates[i].Created = inc.Created
+		if err := s.insertIncidentUpdate(ctx, tx, inc.ID, &inc.Updates[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) insertIncidentUpdate(ctx context.Context, tx *sql.Tx, incident int, u *IncidentUpdate) error {
+	return tx.QueryRowContext(ctx, s.rebind(`INSERT INTO incident_updates (incident_id, status, severity, body, author, created) VALUES (?, ?, ?, ?, ?, ?) RETURNING id`),
+		incident, u.Status, u.Severity, u.Body, u.Author, u.Created).Scan(&u.ID)
+}
+
+func (s *sqlStore) GetIncident(ctx context.Context, id int) (Incident, error) {
+	inc, err := scanIncident(s.db.QueryRowContext(ctx, s.rebind(`SELECT `+incidentColumns+` FROM incidents WHERE id = ?`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Incident{}, ErrIncidentNotFound
+	}
+	if err != nil {
+		return Incident{}, err
+	}
+	incs := []Incident{inc}
+	err = s.loadIncidentUpdates(ctx, incs)
+	return incs[0], err
+}
+
+func (s *sqlStore) ListIncidents(ctx context.Context, f IncidentFilter) ([]Incident, error) {
+	query := `SELECT ` + incidentColumns + ` FROM incidents`
+	switch f.State {
+	case "open":
+		query += ` WHERE status <> 'resolved'`
+	case "resolved":
+		query += ` WHERE status = 'resolved'`
+	}
+	query += ` ORDER BY id DESC`
+	var args []any
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	incs := []Incident{}
+	for rows.Next() {
+		inc, err := scanIncident(rows)
+		if err != nil {
+			return nil, err
+		}
+		incs = append(incs, inc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return incs, s.loadIncidentUpdates(ctx, incs)
+}
+
+// loadIncidentUpdates fills in the timelines of incs with one query.
+func (s *sqlStore) loadIncidentUpdates(ctx context.Context, incs []Incident) error {
+	if len(incs) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Incident, len(incs))
+	args := make([]any, len(incs))
+	for i := range incs {
+		incs[i].Updates = []IncidentUpdate{}
+		byID[incs[i].ID] = &incs[i]
+		args[i] = incs[i].ID
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT incident_id, id, status, severity, body, author, created FROM incident_updates
+		WHERE incident_id IN (?`+strings.Repeat(", ?", len(incs)-1)+`) ORDER BY id`), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var incident int
+		var u IncidentUpdate
+		if err := rows.Scan(&incident, &u.ID, &u.Status, &u.Severity, &u.Body, &u.Author, &u.Created); err != nil {
+			return err
+		}
+		byID[incident].Updates = append(byID[incident].Updates, u)
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) UpdateIncident(ctx context.Context, inc *Incident, u *IncidentUpdate) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, s.rebind(`UPDATE incidents SET title = ?, severity = ?, status = ?, message_id = ?, updated = ?, resolved = ? WHERE id = ?`),
+		inc.Title, inc.Severity, inc.Status, inc.MessageID, now, nullTime(inc.Resolved), inc.ID)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrIncidentNotFound
+	}
+	if u != nil {
+		u.Created = now
+		if err := s.insertIncidentUpdate(ctx, tx, inc.ID, u); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	stored, err := s.GetIncident(ctx, inc.ID)
+	if err != nil {
+		return err
+	}
+	*inc = stored
+	return nil
+}
+
+func (s *sqlStore) ListComponents(ctx context.Context) ([]Component, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, description, state, check_name, updated FROM components ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cs := []Component{}
+	for rows.Next() {
+		var c Component
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.State, &c.Check, &c.Updated); err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
+
+func (s *sqlStore) SaveComponent(ctx context.Context, c *Component) error {
+	c.Updated = time.Now().UTC()
+	if c.ID == 0 {
+		err := s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO components (name, description, state, check_name, updated) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (name) DO NOTHING RETURNING id`),
+			c.Name, c.Description, c.State, c.Check, c.Updated).Scan(&c.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicate
+		}
+		return err
+	}
+	var taken int
+	if err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM components WHERE name = ? AND id <> ?`), c.Name, c.ID).Scan(&taken); err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrDuplicate
+	}
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE components SET name = ?, description = ?, state = ?, check_name = ?, updated = ? WHERE id = ?`),
+		c.Name, c.Description, c.State, c.Check, c.Updated, c.ID)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrComponentNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteComponent(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM components WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrComponentNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) ListChannels(ctx context.Context) ([]Channel, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+channelColumns+` FROM channels ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cs := []Channel{}
+	for rows.Next() {
+		c, err := scanChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
+
+const channelColumns = `name, description, created, archived, private, users, roles`
+
+func scanChannel(row interface{ Scan(...any) error }) (Channel, error) {
+	var c Channel
+	var archived sql.NullTime
+	var users, roles string
+	err := row.Scan(&c.Name, &c.Description, &c.Created, &archived, &c.Private, &users, &roles)
+	if archived.Valid {
+		c.Archived = &archived.Time
+	}
+	if users != "" {
+		c.Users = strings.Split(users, ",")
+	}
+	if roles != "" {
+		c.Roles = strings.Split(roles, ",")
+	}
+	return c, err
+}
+
+func (s *sqlStore) GetChannel(ctx context.Context, name string) (Channel, error) {
+	c, err := scanChannel(s.db.QueryRowContext(ctx, s.rebind(`SELECT `+channelColumns+` FROM channels WHERE name = ?`), name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Channel{}, ErrChannelNotFound
+	}
+	return c, err
+}
+
+func (s *sqlStore) CreateChannel(ctx context.Context, c *Channel) error {
+	c.Created = time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO channels (name, description, created, private, users, roles) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (name) DO NOTHING`),
+		c.Name, c.Description, c.Created, c.Private, strings.Join(c.Users, ","), strings.Join(c.Roles, ","))
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (s *sqlStore) UpdateChannel(ctx context.Context, c *Channel) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE channels SET description = ?, archived = ?, private = ?, users = ?, roles = ? WHERE name = ?`),
+		c.Description, nullTime(c.Archived), c.Private, strings.Join(c.Users, ","), strings.Join(c.Roles, ","), c.Name)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrChannelNotFound
+	}
+	updated, err := s.GetChannel(ctx, c.Name)
+	if err != nil {
+		return err
+	}
+	*c = updated
+	return nil
+}
+
+func (s *sqlStore) GetBanner(ctx context.Context) (Banner, error) {
+	var b Banner
+	var expires sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT id, text, severity, expires, author, created FROM banners ORDER BY id DESC LIMIT 1`).
+		Scan(&b.ID, &b.Text, &b.Severity, &expires, &b.Author, &b.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return b, ErrBannerNotFound
+	}
+	if expires.Valid {
+		b.Expires = &expires.Time
+	}
+	return b, err
+}
+
+func (s *sqlStore) SetBanner(ctx context.Context, b *Banner) error {
+	b.Created = time.Now().UTC()
+	err := s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO banners (text, severity, expires, author, created) VALUES (?, ?, ?, ?, ?) RETURNING id`),
+		b.Text, b.Severity, nullTime(b.Expires), b.Author, b.Created).Scan(&b.ID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.rebind(`DELETE FROM banners WHERE id < ?`), b.ID)
+	return err
+}
+
+func (s *sqlStore) ClearBanner(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM banners`)
+	return err
+}
+
+func (s *sqlStore) AddCheckResult(ctx context.Context, r *CheckResult) error {
+	r.Checked = time.Now().UTC()
+	return s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO check_results (monitor, up, latency_ms, error, checked)
+		VALUES (?, ?, ?, ?, ?) RETURNING id`),
+		r.Monitor, r.Up, r.Latency.Milliseconds(), r.Error, r.Checked).Scan(&r.ID)
+}
+
+func (s *sqlStore) RecentCheckResults(ctx context.Context, monitor string, limit int) ([]CheckResult, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, monitor, up, latency_ms, error, checked
+		FROM check_results WHERE monitor = ? ORDER BY checked DESC, id DESC LIMIT ?`), monitor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rs := []CheckResult{}
+	for rows.Next() {
+		var r CheckResult
+		var ms int64
+		if err := rows.Scan(&r.ID, &r.Monitor, &r.Up, &ms, &r.Error, &r.Checked); err != nil {
+			return nil, err
+		}
+		r.Latency = time.Duration(ms) * time.Millisecond
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
+
+func (s *sqlStore) Uptime(ctx context.Context, monitor string, since time.Time) (up, total int, err error) {
+	err = s.db.QueryRowContext(ctx, s.rebind(`SELECT COALESCE(SUM(CASE WHEN up THEN 1 ELSE 0 END), 0), COUNT(*)
+		FROM check_results WHERE monitor = ? AND checked > ?`), monitor, since.UTC()).Scan(&up, &total)
+	return up, total, err
+}
+
+func (s *sqlStore) PruneCheckResults(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM check_results WHERE checked < ?`), before.UTC())
+	return err
+}
+
+func (s *sqlStore) GetAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	var k APIKey
+	var scopes string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT name, key_hash, scopes, created FROM api_keys WHERE key_hash = ?`), hash).
+		Scan(&k.Name, &k.Hash, &scopes, &k.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrKeyNotFound
+	}
+	k.Scopes = SplitScopes(scopes)
+	return k, err
+}
+
+func (s *sqlStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, key_hash, scopes, created FROM api_keys ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var scopes string
+		if err := rows.Scan(&k.Name, &k.Hash, &scopes, &k.Created); err != nil {
+			return nil, err
+		}
+		k.Scopes = SplitScopes(scopes)
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqlStore) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	k.Created = time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO api_keys (name, key_hash, scopes, created) VALUES (?, ?, ?, ?) ON CONFLICT (name) DO NOTHING`),
+		k.Name, k.Hash, strings.Join(k.Scopes, ","), k.Created)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteAPIKey(ctx context.Context, name string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM api_keys WHERE name = ?`), name)
+	if err != nil {
+		return err
+	}
+	if checkAffected(res) != nil {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// SplitScopes parses a comma-separated list of scopes, the form a key's
+// scopes are stored in.
+func SplitScopes(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func (s *sqlStore) AppendAudit(ctx context.Context, e *AuditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	return s.db.QueryRowContext(ctx, s.rebind(`INSERT INTO audit_log (created, actor, ip, action, target, before_json, after_json, request_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`),
+		e.Time, e.Actor, e.IP, e.Action, e.Target, nullJSON(e.Before), nullJSON(e.After), e.RequestID).Scan(&e.ID)
+}
+
+// nullJSON stores a missing snapshot as NULL.
+func nullJSON(b json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(b), Valid: len(b) > 0}
+}
+
+func (s *sqlStore) auditWhere(f AuditFilter) (string, []any) {
+	var where []string
+	var args []any
+	if f.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		where = append(where, `(action = ? OR action LIKE ? ESCAPE '\')`)
+		args = append(args, f.Action, likeEscaper.Replace(f.Action)+".%")
+	}
+	if f.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, f.Target)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, f.Until.UTC())
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+const auditColumns = `id, created, actor, ip, action, target, before_json, after_json, request_id`
+
+func scanAudit(row interface{ Scan(...any) error }) (AuditEntry, error) {
+	var e AuditEntry
+	var before, after sql.NullString
+	err := row.Scan(&e.ID, &e.Time, &e.Actor, &e.IP, &e.Action, &e.Target, &before, &after, &e.RequestID)
+	if before.Valid {
+		e.Before = json.RawMessage(before.String)
+	}
+	if after.Valid {
+		e.After = json.RawMessage(after.String)
+	}
+	return e, err
+}
+
+func (s *sqlStore) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, int, error) {
+	cond, args := s.auditWhere(f)
+	var total int
+	if err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM audit_log`+cond), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query := `SELECT ` + auditColumns + ` FROM audit_log` + cond + ` ORDER BY id DESC`
+	if f.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, f.Limit, f.Offset)
+	} else if f.Offset > 0 {
+		query += " LIMIT " + s.unlimited() + " OFFSET ?"
+		args = append(args, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+func (s *sqlStore) EachAudit(ctx context.Context, f AuditFilter, fn func(AuditEntry) error) error {
+	cond, args := s.auditWhere(f)
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+auditColumns+` FROM audit_log`+cond+` ORDER BY id`), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error { return s.db.PingContext(ctx) }
+
+func (s *sqlStore) Size(ctx context.Context) (int64, error) {
+	query := `SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`
+	if s.postgres {
+		query = `SELECT pg_database_size(current_database())`
+	}
+	var n int64
+	err := s.db.QueryRowContext(ctx, query).Scan(&n)
+	return n, err
+}
+
+func (s *sqlStore) Close() error { return s.db.Close() }
+
+// checkAffected maps a write that matched no rows to ErrNotFound.
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// tracer starts the spans for store calls. It does nothing until the
+// server installs an exporting provider.
+var tracer = otel.Tracer("example.com/go-sample-site")
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedDB wraps the database handle of a sqlStore so that every
+// statement run outside a transaction gets a client span. Queries end
+// their span once the rows are ready, not when they have been read.
+type tracedDB struct {
+	*sql.DB
+	system attribute.KeyValue // semconv.DBSystemSqlite or DBSystemPostgreSQL
+}
+
+func (db tracedDB) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	op, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	op = strings.ToUpper(op)
+	return tracer.Start(ctx, op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		db.system,
+		semconv.DBOperationName(op),
+		semconv.DBQueryText(query),
+	))
+}
+
+func (db tracedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := db.start(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (db tracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := db.start(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+func (db tracedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := db.start(ctx, query)
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
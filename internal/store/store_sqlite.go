@@ -0,0 +1,26 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	_ "modernc.org/sqlite"
+)
+
+// openSQLiteStore opens (creating if needed) a SQLite database file.
+func openSQLiteStore(path string, mode MigrationMode) (*sqlStore, error) {
+	// WAL lets streaming reads (exports) run alongside writes; writers
+	// still take turns, waiting up to busy_timeout for the lock.
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(4)
+	s := &sqlStore{db: tracedDB{db, semconv.DBSystemSqlite}}
+	if err := s.prepare(context.Background(), mode); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
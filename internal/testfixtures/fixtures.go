@@ -0,0 +1,63 @@
+// Package testfixtures seeds a store with a known cast of users and
+// messages, for tests that drive the whole board over HTTP.
+package testfixtures
+
+import (
+	"context"
+	"testing"
+
+	"example.com/go-sample-site/internal/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password is the password of every user Seed adds.
+const Password = "fixture-password"
+
+// Users are the accounts Seed adds, one for each role.
+var Users = []store.User{
+	{Name: "ada", Role: store.RoleAdmin},
+	{Name: "mo", Role: store.RoleModerator},
+	{Name: "pat", Role: store.RolePoster},
+	{Name: "vic", Role: store.RoleViewer},
+}
+
+// Messages returns the messages Seed adds, oldest first. A ParentID is
+// the index of the parent in the slice plus one, and becomes its ID once
+// stored.
+func Messages() []store.Message {
+	return []store.Message{
+		{Channel: store.DefaultChannel, Author: "pat", Content: "Deploying api 1.4.0 to staging", Tags: []string{"deploy", "staging"}},
+		{Channel: store.DefaultChannel, Author: "mo", Content: "Staging looks good", ParentID: 1},
+		{Channel: store.DefaultChannel, Author: "Deploys", Content: "deploy of web 2.1.0 to production started by ada", Tags: []string{"deploy", "production"}},
+		{Channel: store.DefaultChannel, Author: "ada", Content: "Maintenance window on Saturday, 02:00 UTC", Tags: []string{"ops"}},
+	}
+}
+
+// Seed adds Users and Messages to s, failing tb if the store does, and
+// returns the messages as stored, with their IDs.
+func Seed(tb testing.TB, s store.Store) []store.Message {
+	tb.Helper()
+	ctx := context.Background()
+	// The lowest cost keeps logins in tests fast.
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.MinCost)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, u := range Users {
+		u.PasswordHash = hash
+		if err := s.SaveUser(ctx, u); err != nil {
+			tb.Fatalf("seeding user %s: %v", u.Name, err)
+		}
+	}
+	msgs := Messages()
+	for i := range msgs {
+		if p := msgs[i].ParentID; p > 0 {
+			msgs[i].ParentID = msgs[p-1].ID
+		}
+		if err := s.Create(ctx, &msgs[i]); err != nil {
+			tb.Fatalf("seeding message %d: %v", i+1, err)
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,239 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+)
+
+// API scopes. Read covers GET and HEAD, write everything else; moderate
+// allows editing and deleting other people's messages and admin adds the
+// admin endpoints. See scopePermissions.
+const (
+	ScopeRead     = "read"
+	ScopeWrite    = "write"
+	ScopeModerate = "moderate"
+	ScopeAdmin    = "admin"
+)
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadAPIKeyConfig reads API_KEYS, a comma-separated list of
+// name:scope+scope:secret entries, and API_ANONYMOUS_SCOPES, the
+// comma-separated scopes for requests without a key (default "read"; set
+// it to "read,write" to let visitors post, or "" to hide the board too).
+func (app *App) loadAPIKeyConfig() error {
+	app.configKeys = make(map[string]store.APIKey)
+	app.anonScopes = []string{ScopeRead}
+	if v := os.Getenv("API_KEYS"); v != "" {
+		for _, entry := range strings.Split(v, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+			if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+				return fmt.Errorf("API_KEYS: entry %q is not name:scopes:secret", entry)
+			}
+			scopes := strings.Split(parts[1], "+")
+			if err := validateScopes(scopes); err != nil {
+				return fmt.Errorf("API_KEYS: key %q: %w", parts[0], err)
+			}
+			app.configKeys[hashAPIKey(parts[2])] = store.APIKey{Name: parts[0], Scopes: scopes}
+		}
+	}
+	if v, ok := os.LookupEnv("API_ANONYMOUS_SCOPES"); ok {
+		app.anonScopes = store.SplitScopes(v)
+		for _, s := range app.anonScopes {
+			if s != ScopeRead && s != ScopeWrite {
+				return fmt.Errorf("API_ANONYMOUS_SCOPES: only read and write can be granted anonymously, not %q", s)
+			}
+		}
+	}
+	return nil
+}
+
+func validateScopes(scopes []string) error {
+	for _, s := range scopes {
+		switch s {
+		case ScopeRead, ScopeWrite, ScopeModerate, ScopeAdmin:
+		default:
+			return fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	return nil
+}
+
+type apiKeyKey struct{}
+
+// currentAPIKey returns the key that authenticated r, or nil.
+func currentAPIKey(r *http.Request) *store.APIKey {
+	k, _ := r.Context().Value(apiKeyKey{}).(*store.APIKey)
+	return k
+}
+
+// anonymousScopesKey holds, in a request's context, the anonScopes of
+// the App serving it.
+type anonymousScopesKey struct{}
+
+// withAnonymousScopes records app's anonScopes in r, for can.
+func (app *App) withAnonymousScopes(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), anonymousScopesKey{}, app.anonScopes))
+}
+
+// anonymousScopes returns the scopes r would have without credentials:
+// none unless withAnonymousScopes recorded the App's.
+func anonymousScopes(r *http.Request) []string {
+	scopes, _ := r.Context().Value(anonymousScopesKey{}).([]string)
+	return scopes
+}
+
+// requestAPIKey extracts a key from "Authorization: Bearer" or X-API-Key.
+func requestAPIKey(r *http.Request) string {
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(v)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// apiKeyMiddleware authenticates /api requests and checks the permission
+// the method needs: read for GET and HEAD, post for everything else. A
+// presented key must be valid (401 otherwise) and is judged by its scopes
+// alone; without a key the user's role and anonymousScopes apply (see can).
+// A bearer JWT from /api/token stands in for the user or key it was issued
+// to.
+func (app *App) apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := PermPost
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			need = PermRead
+		}
+		r, err := app.withAPIPrincipal(r)
+		if err != nil {
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		middleware.NoteAccessUser(r, accessUser(r.Context()))
+		if !requirePermission(w, r, need) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withAPIPrincipal adds the API key or token that r carries, if any, to
+// its context. The error is fit for the client.
+func (app *App) withAPIPrincipal(r *http.Request) (*http.Request, error) {
+	secret := requestAPIKey(r)
+	switch {
+	case looksLikeJWT(secret):
+		c, err := app.verifyToken(secret)
+		if err != nil {
+			return r, err
+		}
+		return tokenPrincipal(r, c)
+	case secret != "":
+		k, err := app.lookupAPIKey(r.Context(), secret)
+		if err != nil {
+			if !errors.Is(err, store.ErrKeyNotFound) {
+				slog.ErrorContext(r.Context(), "looking up API key", "err", err)
+			}
+			return r, errors.New("invalid API key")
+		}
+		return r.WithContext(context.WithValue(r.Context(), apiKeyKey{}, &k)), nil
+	}
+	return r, nil
+}
+
+func (app *App) lookupAPIKey(ctx context.Context, secret string) (store.APIKey, error) {
+	hash := hashAPIKey(secret)
+	if k, ok := app.configKeys[hash]; ok {
+		return k, nil
+	}
+	return app.apiKeys.GetAPIKey(ctx, hash)
+}
+
+// listKeysAPIHandler serves GET /api/keys for admins.
+func (app *App) listKeysAPIHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.apiKeys.ListAPIKeys(r.Context())
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// createKeyAPIHandler serves POST /api/keys for admins, which takes
+// {"name": ..., "scopes": [...]}. The secret is only ever shown in the
+// creation response.
+func (app *App) createKeyAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Name   string
+		Scopes []string
+	}
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if strings.TrimSpace(in.Name) == "" || len(in.Scopes) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "name and scopes required")
+		return
+	}
+	if err := validateScopes(in.Scopes); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	b := make([]byte, 24)
+	rand.Read(b)
+	secret := "slrs_" + hex.EncodeToString(b)
+	k := store.APIKey{Name: in.Name, Hash: hashAPIKey(secret), Scopes: in.Scopes}
+	if err := app.apiKeys.CreateAPIKey(r.Context(), &k); err != nil {
+		if errors.Is(err, store.ErrDuplicate) {
+			writeJSONError(w, http.StatusConflict, "an API key with that name exists")
+			return
+		}
+		storeError(w, r, err)
+		return
+	}
+	app.recordAudit(r.Context(), "api_key.create", "api_key:"+k.Name, nil, k)
+	writeJSON(w, http.StatusCreated, struct {
+		store.APIKey
+		Key string `json:"key"`
+	}{k, secret})
+}
+
+// deleteKeyAPIHandler serves DELETE /api/keys/{name} for admins.
+func (app *App) deleteKeyAPIHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	err := app.apiKeys.DeleteAPIKey(r.Context(), name)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		writeJSONError(w, http.StatusNotFound, "api key not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	app.recordAudit(r.Context(), "api_key.delete", "api_key:"+name, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// accessUser is the signed-in user's name, "key:<name>" for an API key,
+// or "" for anonymous requests.
+func accessUser(ctx context.Context) string {
+	if u, ok := ctx.Value(userKey{}).(*store.User); ok {
+		return u.Name
+	}
+	if k, ok := ctx.Value(apiKeyKey{}).(*store.APIKey); ok {
+		return "key:" + k.Name
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/middleware"
+)
+
+// The unversioned /api/... paths, v1 from before there were versions, are
+// deprecated since legacyAPIDeprecated and go at legacyAPISunset.
+var (
+	legacyAPIDeprecated = time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)
+	legacyAPISunset     = time.Date(2027, time.April, 15, 0, 0, 0, 0, time.UTC)
+)
+
+// apiVersion is a version of the API, served under /api/<name>: its routes
+// and how it encodes JSON. A change that would break scripts, to a route
+// or a representation, goes into a new version with routes and marshal of
+// its own; the versions before it keep theirs.
+type apiVersion struct {
+	name    string
+	routes  func(app *App, g apiGroups)
+	marshal func(v any) ([]byte, error)
+}
+
+// apiVersions are the versions served, oldest first.
+var apiVersions = []*apiVersion{
+	{name: "v1", routes: (*App).apiV1Routes, marshal: marshalJSON},
+}
+
+// apiGroups are the groups a version registers its routes on, mounted at
+// its prefix: pages and limited as Handler has them, api and adminAPI with
+// API keys, quotas and time zones.
+type apiGroups struct {
+	pages, limited, api, adminAPI routeGroup
+}
+
+// handleAPI registers every version's routes under its prefix, and v1's
+// again under the unversioned /api, marked deprecated.
+func (app *App) handleAPI(pages, limited routeGroup) {
+	for _, v := range apiVersions {
+		v.routes(app, app.apiGroups(pages, limited, "/api/"+v.name, withAPIVersion(v, v.name)))
+	}
+	v1 := apiVersions[0]
+	v1.routes(app, app.apiGroups(pages, limited, "/api", withAPIVersion(v1, "unversioned"), deprecatedAPI))
+}
+
+func (app *App) apiGroups(pages, limited routeGroup, prefix string, mws ...middleware.Middleware) apiGroups {
+	pages, limited = pages.Mount(prefix).Use(mws...), limited.Mount(prefix).Use(mws...)
+	api := pages.Use(app.apiKeyMiddleware, app.quotaMiddleware, apiTimeZone)
+	return apiGroups{pages: pages, limited: limited, api: api, adminAPI: api.Use(requires(PermAdmin))}
+}
+
+// apiWriter is the ResponseWriter of an API request, which carries the
+// version for writeJSON and encodeMessages.
+type apiWriter struct {
+	http.ResponseWriter
+	version *apiVersion
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *apiWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withAPIVersion hands the requests to a mount of v, counted as label,
+// an apiWriter for v.
+func withAPIVersion(v *apiVersion, label string) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiRequests.WithLabelValues(label).Inc()
+			next.ServeHTTP(&apiWriter{ResponseWriter: w, version: v}, r)
+		})
+	}
+}
+
+// marshalOf returns how the API version w was handed out for encodes JSON,
+// v1's outside the API.
+func marshalOf(w http.ResponseWriter) func(v any) ([]byte, error) {
+	for {
+		switch ww := w.(type) {
+		case *apiWriter:
+			return ww.version.marshal
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return marshalJSON
+		}
+	}
+}
+
+// marshalJSON is v1's JSON: encoding/json's, a value to a line.
+func marshalJSON(v any) ([]byte, error) {
+	body, err := json.Marshal(v)
+	return append(body, '\n'), err
+}
+
+// deprecatedAPI announces, with the Deprecation (RFC 9745) and Sunset
+// (RFC 8594) headers, that the unversioned paths are going, and links the
+// /api/v1 path that replaces r's.
+func deprecatedAPI(next http.Handler) http.Handler {
+	deprecation := "@" + strconv.FormatInt(legacyAPIDeprecated.Unix(), 10)
+	sunset := legacyAPISunset.Format(http.TimeFormat)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Deprecation", deprecation)
+		h.Set("Sunset", sunset)
+		h.Add("Link", `<`+versionedPath(r.URL.EscapedPath())+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// versionedPath is the /api/v1 path for an unversioned /api path.
+func versionedPath(p string) string {
+	return "/api/v1" + strings.TrimPrefix(p, "/api")
+}
+
+// unversionedPath is the unversioned /api path for a path under any
+// version, for the tables keyed by path: bodyLimits and
+// maintenanceOpenPaths.
+func unversionedPath(p string) string {
+	for _, v := range apiVersions {
+		if rest, ok := strings.CutPrefix(p, "/api/"+v.name+"/"); ok {
+			return "/api/" + rest
+		}
+	}
+	return p
+}
@@ -0,0 +1,297 @@
+// Package web is the board itself: the pages and API handlers, and the
+// services behind them. New wires it up; Handler serves it.
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// App is the board: its stores, the services around them and the state
+// the handlers share. Handlers and the background loops are its methods,
+// so each App is self-contained and several can run in one process.
+type App struct {
+	messages       store.MessageStore // with auditing and live updates
+	users          store.UserStore
+	sessions       sessionStore
+	apiKeys        store.APIKeyStore
+	configKeys     map[string]store.APIKey // from API_KEYS, indexed by hash
+	anonScopes     []string                // from API_ANONYMOUS_SCOPES; see anonymousScopes
+	auditLog       store.AuditStore        // an entry for every mutating action; see recordAudit
+	attachments    store.AttachmentStore
+	blobs          BlobStore // attachment contents
+	reactions      store.ReactionStore
+	subscriptions  store.SubscriptionStore
+	webhooks       store.WebhookStore
+	builds         store.BuildStore
+	deployments    store.DeploymentStore
+	incidents      store.IncidentStore
+	components     store.ComponentStore
+	monitorResults store.MonitorStore
+	banners        store.BannerStore
+	channels       store.ChannelStore
+
+	// attachmentLimits apply to every upload; maxFiles 0 turns attachments
+	// off.
+	attachmentLimits struct {
+		maxFiles int
+		maxSize  int64    // bytes per file
+		types    []string // allowed media types, as sniffed from the contents
+	}
+
+	// templates holds one template set per page, each parsed together with
+	// layout.html and partials/ so that every page can define its own
+	// "content" block.
+	// In development mode (-dev) pages are re-parsed from templateFS on
+	// every render so HTML edits show up without a restart.
+	templates  map[string]*template.Template
+	devMode    bool
+	templateFS fs.FS
+	// themes are the site's themes, theme the one pages get unless the
+	// visitor picked another.
+	themes []string
+	theme  string
+
+	hub *Hub
+	// live is the hub live pages and sockets follow: hub, plus what other
+	// replicas relay through broker, when there is one.
+	live   *Hub
+	broker broker
+	origin string    // tells this replica's relayed events from the others'
+	jobs   *jobQueue // background work that should not hold up a request
+	// mails sends subscription email; nil when no SMTP server is configured.
+	mails *mailer
+	stats *statsAggregator
+	// monitors are config.SelfMonitor first, then the configured ones.
+	monitors []config.Monitor
+	// healthChecks are the readiness checks by name. Status page components
+	// can follow one of them.
+	healthChecks map[string]func(ctx context.Context) error
+	// maintenance is nil while the site is open. It lives in this process
+	// only: with several replicas, switch each of them, or start them with
+	// -maintenance.
+	maintenance atomic.Pointer[maintenanceState]
+	// wsConns tracks open sockets. http.Server.Shutdown does not wait for
+	// hijacked connections, so shutdown waits on this after closing the hub.
+	wsConns sync.WaitGroup
+
+	// redis, when redis_url is set, holds the sessions, rate limits,
+	// quotas, cached responses and idempotency keys, for all replicas.
+	redis *redis.Client
+	// postLimiter throttles posting per client IP; nil disables limiting.
+	postLimiter rateLimiter
+	// quotas counts API requests per signed-in user and API key; nil
+	// disables them. Anonymous requests are only limited per IP by
+	// postLimiter.
+	quotas quotaTracker
+	// cache keeps the board and message list for cache_ttl; nil when
+	// caching is off.
+	cache *responseCache
+	// idempotency remembers the Idempotency-Keys of new messages.
+	idempotency idempotencyStore
+
+	// backups keeps snapshots of the board, taken every backupInterval
+	// and on demand; nil when the store cannot take them.
+	backups        backupStore
+	snapshots      store.Snapshotter
+	backupInterval time.Duration
+	backupKeep     int
+	backupMu       sync.Mutex // held while a backup is taken
+
+	// jwtKeys verify tokens; the first also signs new ones. Older keys stay
+	// in the list during a rotation until tokens they signed have expired.
+	jwtKeys []config.JWTKey
+	jwtTTL  time.Duration
+	// ldapAuth checks passwords against a directory when auth_backend is
+	// ldap.
+	ldapAuth     *ldapAuthenticator
+	ssoProviders []*ssoProvider
+	ssoPublicURL string
+	// ssoRoles maps role claim values to roles; ssoDefaultRole is for new
+	// users none of whose values are mapped.
+	ssoRoles       map[string]string
+	ssoDefaultRole string
+	ssoPending     *pendingLogins
+	// githubSecret is the shared secret configured on the GitHub webhook;
+	// jenkinsToken the one Jenkins sends in the notification URL.
+	githubSecret string
+	jenkinsToken string
+
+	static          fs.FS // served under /static/
+	openAPI         []byte
+	apiDocs         []byte
+	build           BuildInfo
+	accessLog       *middleware.AccessLog // nil when access logging is off
+	internalAddr    string                // the ops endpoints are served there when set
+	monitorInterval time.Duration
+	// draining flips when the server starts shutting down so that /readyz
+	// takes the instance out of rotation before connections are closed.
+	draining atomic.Bool
+}
+
+// exported is the App the process-wide expvar and Prometheus gauges report
+// on: the last one created.
+var exported atomic.Pointer[App]
+
+// Options are what New needs besides the configuration and the store.
+type Options struct {
+	Templates fs.FS  // pages, layout.html, partials/*.html and mail/*.txt
+	Static    fs.FS  // served under /static/
+	OpenAPI   []byte // the OpenAPI document, served at /api/openapi.json
+	APIDocs   []byte // the page at /api/docs that renders it
+	Build     BuildInfo
+	// AccessLog is where requests are logged; nil turns the access log
+	// off.
+	AccessLog *middleware.AccessLog
+}
+
+// New returns the board for cfg, keeping its data in s. It seeds the
+// admin user and default channels, and starts the notifiers that follow
+// new messages; the loops that run on a timer wait for Start.
+func New(cfg config.Config, s store.Store, opts Options) (*App, error) {
+	app := &App{
+		sessions:        newMemorySessions(),
+		hub:             newHub(),
+		jwtTTL:          15 * time.Minute,
+		ssoDefaultRole:  store.RolePoster,
+		ssoPending:      &pendingLogins{m: make(map[string]pendingLogin)},
+		devMode:         cfg.Dev,
+		static:          opts.Static,
+		openAPI:         opts.OpenAPI,
+		apiDocs:         opts.APIDocs,
+		build:           opts.Build,
+		accessLog:       opts.AccessLog,
+		internalAddr:    cfg.InternalAddr,
+		monitorInterval: cfg.MonitorInterval,
+	}
+	app.stats = &statsAggregator{app: app}
+	app.healthChecks = map[string]func(ctx context.Context) error{
+		"templates": func(context.Context) error {
+			if len(app.templates) == 0 {
+				return errors.New("not loaded")
+			}
+			return nil
+		},
+		"store": func(ctx context.Context) error { return app.messages.Ping(ctx) },
+	}
+	if cfg.Maintenance {
+		app.maintenance.Store(&maintenanceState{RetryAfter: defaultRetryAfter, Since: time.Now(), By: "system"})
+	}
+	if err := app.loadTemplates(opts.Templates); err != nil {
+		return nil, fmt.Errorf("loading templates: %w", err)
+	}
+	if err := app.loadThemes(cfg.Theme); err != nil {
+		return nil, err
+	}
+	app.messages = &broadcastStore{MessageStore: auditingStore{instrumentedStore{s}, app}, hub: app.hub}
+	app.users = s
+	app.apiKeys = s
+	app.auditLog = s
+	app.attachments = s
+	app.reactions = s
+	app.subscriptions = s
+	app.webhooks = s
+	app.builds = s
+	app.deployments = s
+	app.incidents = s
+	app.components = s
+	app.monitorResults = s
+	app.banners = s
+	app.channels = s
+	if err := app.loadAPIKeyConfig(); err != nil {
+		return nil, err
+	}
+	if err := app.setupJWT(cfg); err != nil {
+		return nil, fmt.Errorf("jwt_keys: %w", err)
+	}
+	app.githubSecret = cfg.GitHubWebhookSecret
+	app.jenkinsToken = cfg.JenkinsToken
+	if err := app.setupRedis(cfg); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if err := app.setupPubSub(cfg); err != nil {
+		return nil, fmt.Errorf("pubsub: %w", err)
+	}
+	if app.cache != nil {
+		go app.cache.follow(app.live.Subscribe())
+	}
+	if err := app.setupAttachments(cfg); err != nil {
+		return nil, fmt.Errorf("attachments: %w", err)
+	}
+	if err := app.setupBackups(cfg, s); err != nil {
+		return nil, fmt.Errorf("backups: %w", err)
+	}
+	app.jobs = newJobQueue(cfg.JobWorkers)
+	if cfg.SlackWebhookURL != "" {
+		app.startSlackNotifier(cfg.SlackWebhookURL)
+	}
+	app.startWebhooks()
+	if err := app.setupMonitors(cfg); err != nil {
+		return nil, fmt.Errorf("monitors: %w", err)
+	}
+	var err error
+	if cfg.SMTPAddr != "" {
+		if app.mails, err = app.startMailer(cfg, opts.Templates); err != nil {
+			return nil, fmt.Errorf("mail: %w", err)
+		}
+	}
+	if err := app.seedAdmin(context.Background()); err != nil {
+		return nil, fmt.Errorf("seeding admin user: %w", err)
+	}
+	if err := app.seedChannels(context.Background()); err != nil {
+		return nil, fmt.Errorf("seeding channels: %w", err)
+	}
+	if err := app.setupSSO(context.Background(), cfg); err != nil {
+		return nil, fmt.Errorf("single sign-on: %w", err)
+	}
+	if cfg.AuthBackend == "ldap" {
+		if app.ldapAuth, err = newLDAPAuthenticator(cfg); err != nil {
+			return nil, fmt.Errorf("ldap: %w", err)
+		}
+	}
+	exported.Store(app)
+	return app, nil
+}
+
+// Start starts the scheduler, the stats, the monitors, the backups and the
+// sweeps of in-memory limits. The returned function begins the shutdown:
+// it fails the readiness probe, closes the live connections and stops the
+// loops.
+func (app *App) Start() (stop func()) {
+	stops := []func(){app.startScheduler(), app.startStats(), app.startMonitors(app.monitorInterval), app.startBackups(), app.startSweeps(time.Minute)}
+	return func() {
+		app.draining.Store(true)
+		app.hub.Close()
+		if app.broker != nil {
+			app.broker.close()
+			app.live.Close()
+		}
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// Shutdown finishes what is in flight once the server stopped taking
+// requests, waiting at most until ctx is done: WebSockets, which
+// http.Server.Shutdown does not wait for, and queued jobs. The store stays
+// open; it belongs to the caller.
+func (app *App) Shutdown(ctx context.Context) {
+	app.waitForWebSockets(ctx)
+	app.jobs.drain(ctx)
+	if app.redis != nil {
+		app.redis.Close()
+	}
+}
@@ -0,0 +1,264 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/store"
+)
+
+// maxUploadMemory is how much of a multipart form is held in memory; the
+// rest of the files spill to temporary files.
+const maxUploadMemory = 32 << 20
+
+// setupAttachments applies the attachment settings and opens the blob
+// store, which stays open with uploads disabled so earlier files are still
+// served. Posting routes get a body limit big enough for a full set of
+// files.
+func (app *App) setupAttachments(cfg config.Config) error {
+	b, err := openBlobStore(cfg)
+	if err != nil {
+		return err
+	}
+	app.blobs = b
+	app.attachmentLimits.maxFiles = cfg.AttachmentMaxFiles
+	app.attachmentLimits.maxSize = int64(cfg.AttachmentMaxMB) << 20
+	app.attachmentLimits.types = config.SplitList(cfg.AttachmentTypes)
+	limit := int64(cfg.AttachmentMaxFiles)*app.attachmentLimits.maxSize + maxRequestBody
+	bodyLimits["/submit"] = limit
+	bodyLimits["/api/messages"] = limit
+	return nil
+}
+
+// upload is a file from a multipart form that is within the limits.
+type upload struct {
+	header      *multipart.FileHeader
+	name        string
+	contentType string
+}
+
+// checkUploads applies attachmentLimits to the "files" of form, which may
+// be nil. The content type comes from the file's first bytes, not from
+// what the client claims.
+func (app *App) checkUploads(form *multipart.Form) ([]upload, error) {
+	if form == nil || len(form.File["files"]) == 0 {
+		return nil, nil
+	}
+	files := form.File["files"]
+	errs := fieldErrors{}
+	switch {
+	case app.attachmentLimits.maxFiles == 0:
+		errs.add("files", "attachments are disabled")
+	case len(files) > app.attachmentLimits.maxFiles:
+		errs.add("files", fmt.Sprintf("at most %d files per message", app.attachmentLimits.maxFiles))
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	var ups []upload
+	for _, fh := range files {
+		name := attachmentName(fh.Filename)
+		if fh.Size > app.attachmentLimits.maxSize {
+			errs.add("files", fmt.Sprintf("%s is larger than %d MB", name, app.attachmentLimits.maxSize>>20))
+			continue
+		}
+		ct, err := sniffUpload(fh)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(app.attachmentLimits.types, ct) {
+			errs.add("files", fmt.Sprintf("%s is %s, which is not allowed", name, ct))
+			continue
+		}
+		ups = append(ups, upload{header: fh, name: name, contentType: ct})
+	}
+	return ups, errs.err()
+}
+
+func sniffUpload(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	ct, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	return ct, nil
+}
+
+// attachmentName makes a client's file name safe to store and echo back in
+// Content-Disposition: no directories, no control characters, at most 255
+// characters.
+func attachmentName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if r := []rune(name); len(r) > 255 {
+		name = string(r[:255])
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "attachment"
+	}
+	return name
+}
+
+// saveAttachments stores ups as attachments of msg, which must already
+// exist, and adds them to msg.Attachments. Live pages hear about the files
+// through an "updated" event, since the "created" one went out without
+// them.
+func (app *App) saveAttachments(ctx context.Context, msg *store.Message, ups []upload) error {
+	if len(ups) == 0 {
+		return nil
+	}
+	for _, u := range ups {
+		a := store.Attachment{MessageID: msg.ID, Name: u.name, ContentType: u.contentType, Size: u.header.Size, Key: newBlobKey()}
+		f, err := u.header.Open()
+		if err != nil {
+			return err
+		}
+		err = app.blobs.Put(ctx, a.Key, f, a.Size, a.ContentType)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("storing %s: %w", a.Name, err)
+		}
+		if err := app.attachments.CreateAttachment(ctx, &a); err != nil {
+			app.blobs.Delete(ctx, a.Key)
+			return err
+		}
+		app.recordAudit(ctx, "attachment.create", messageTarget(msg.ID), nil, a)
+		msg.Attachments = append(msg.Attachments, a)
+	}
+	app.hub.Publish(Event{Type: "updated", Message: *msg})
+	return nil
+}
+
+func newBlobKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withAttachments fills in Attachments for msgs.
+func (app *App) withAttachments(ctx context.Context, msgs []store.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]int, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	byMessage, err := app.attachments.Attachments(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range msgs {
+		msgs[i].Attachments = byMessage[msgs[i].ID]
+	}
+	return nil
+}
+
+// attachmentsOf returns the attachments of message id.
+func (app *App) attachmentsOf(ctx context.Context, id int) ([]store.Attachment, error) {
+	byMessage, err := app.attachments.Attachments(ctx, []int{id})
+	return byMessage[id], err
+}
+
+// deleteBlobs removes the contents of atts after their message was purged.
+// Failures only leave orphaned blobs behind, so they are logged, not
+// returned.
+func (app *App) deleteBlobs(ctx context.Context, atts []store.Attachment) {
+	for _, a := range atts {
+		if err := app.blobs.Delete(ctx, a.Key); err != nil {
+			slog.ErrorContext(ctx, "deleting attachment blob", "attachment", a.ID, "err", err)
+		}
+	}
+}
+
+// isImage reports whether an attachment can be shown as a thumbnail. SVG
+// is left out because it can carry script.
+func isImage(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml"
+}
+
+// inlineAttachment reports whether browsers may show a file in place;
+// anything else is offered as a download.
+func inlineAttachment(contentType string) bool {
+	return contentType == "text/plain" || isImage(contentType)
+}
+
+// byteSize formats n for people, e.g. 1.5 MB.
+func byteSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// attachmentHandler serves GET /attachments/{id} to anyone who can read the
+// board. Files of trashed messages are hidden with them. Responses are
+// sandboxed and never sniffed, so an upload cannot run script on the site.
+func (app *App) attachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	a, err := app.attachments.GetAttachment(r.Context(), id)
+	if err == nil {
+		err = app.checkMessageAccess(r, a.MessageID)
+	}
+	if err == nil {
+		_, err = app.messages.Get(r.Context(), a.MessageID)
+	}
+	var f io.ReadSeekCloser
+	if err == nil {
+		f, err = app.blobs.Open(r.Context(), a.Key)
+	}
+	if errors.Is(err, store.ErrAttachmentNotFound) || errors.Is(err, store.ErrNotFound) || errors.Is(err, ErrBlobNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "attachment", "id", id, "err", err)
+		return
+	}
+	defer f.Close()
+	disposition := "attachment"
+	if inlineAttachment(a.ContentType) {
+		disposition = "inline"
+	}
+	h := w.Header()
+	h.Set("Content-Type", a.ContentType)
+	h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Name}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox; default-src 'none'; img-src 'self'; style-src 'unsafe-inline'")
+	h.Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", a.Created, f)
+}
@@ -0,0 +1,315 @@
+package web
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+)
+
+type (
+	auditIPKey    struct{}
+	auditActorKey struct{}
+)
+
+// auditMiddleware stores the client address in the request context so
+// store wrappers deep in a request can still say where a change came from.
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), auditIPKey{}, middleware.ClientIP(r))))
+	})
+}
+
+// withAuditActor names the actor for changes made under ctx, for callers
+// such as webhooks that act on nobody's session.
+func withAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// auditActor works out who is acting under ctx: an explicit actor, the
+// signed-in user, the API key, an anonymous client, or the server itself.
+func auditActor(ctx context.Context) string {
+	if a, ok := ctx.Value(auditActorKey{}).(string); ok {
+		return a
+	}
+	if u, ok := ctx.Value(userKey{}).(*store.User); ok {
+		return u.Name
+	}
+	if k, ok := ctx.Value(apiKeyKey{}).(*store.APIKey); ok {
+		return "key:" + k.Name
+	}
+	if ctx.Value(auditIPKey{}) != nil {
+		return "anonymous"
+	}
+	return "system"
+}
+
+// recordAudit appends an entry for action on target. before and after are
+// snapshots of the object (nil where there is none). A failure is logged
+// rather than returned: the action itself has already happened.
+func (app *App) recordAudit(ctx context.Context, action, target string, before, after any) {
+	ip, _ := ctx.Value(auditIPKey{}).(string)
+	e := store.AuditEntry{
+		Actor:     auditActor(ctx),
+		IP:        ip,
+		Action:    action,
+		Target:    target,
+		Before:    auditSnapshot(before),
+		After:     auditSnapshot(after),
+		RequestID: middleware.RequestIDFrom(ctx),
+	}
+	if err := app.auditLog.AppendAudit(ctx, &e); err != nil {
+		slog.ErrorContext(ctx, "audit", "action", action, "target", target, "err", err)
+	}
+}
+
+func auditSnapshot(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func messageTarget(id int) string { return "message:" + strconv.Itoa(id) }
+
+// auditingStore records every successful message write in auditLog.
+type auditingStore struct {
+	store.MessageStore
+	app *App
+}
+
+func (s auditingStore) Create(ctx context.Context, msg *store.Message) error {
+	if err := s.MessageStore.Create(ctx, msg); err != nil {
+		return err
+	}
+	s.app.recordAudit(ctx, "message.create", messageTarget(msg.ID), nil, msg)
+	return nil
+}
+
+// CreateBatch records one entry for the whole batch with the new IDs, not
+// one per message, so a large import does not drown the log.
+func (s auditingStore) CreateBatch(ctx context.Context, msgs []*store.Message) error {
+	if err := s.MessageStore.CreateBatch(ctx, msgs); err != nil {
+		return err
+	}
+	ids := make([]int, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	s.app.recordAudit(ctx, "message.import", "", nil, map[string]any{"count": len(msgs), "ids": ids})
+	return nil
+}
+
+func (s auditingStore) Update(ctx context.Context, msg *store.Message) error {
+	before, err := s.MessageStore.Get(ctx, msg.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.MessageStore.Update(ctx, msg); err != nil {
+		return err
+	}
+	s.app.recordAudit(ctx, "message.update", messageTarget(msg.ID), before, msg)
+	return nil
+}
+
+func (s auditingStore) SetPinned(ctx context.Context, id int, pinned bool) error {
+	if err := s.MessageStore.SetPinned(ctx, id, pinned); err != nil {
+		return err
+	}
+	action := "message.unpin"
+	if pinned {
+		action = "message.pin"
+	}
+	s.app.recordAudit(ctx, action, messageTarget(id), nil, nil)
+	return nil
+}
+
+func (s auditingStore) Delete(ctx context.Context, id int) error {
+	before, err := s.MessageStore.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.MessageStore.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.app.recordAudit(ctx, "message.delete", messageTarget(id), before, nil)
+	return nil
+}
+
+func (s auditingStore) Restore(ctx context.Context, id int) error {
+	if err := s.MessageStore.Restore(ctx, id); err != nil {
+		return err
+	}
+	var after any
+	if msg, err := s.MessageStore.Get(ctx, id); err == nil {
+		after = msg
+	}
+	s.app.recordAudit(ctx, "message.restore", messageTarget(id), nil, after)
+	return nil
+}
+
+func (s auditingStore) Purge(ctx context.Context, id int) error {
+	if err := s.MessageStore.Purge(ctx, id); err != nil {
+		return err
+	}
+	s.app.recordAudit(ctx, "message.purge", messageTarget(id), nil, nil)
+	return nil
+}
+
+const auditPerPage = 50
+
+// parseAuditQuery reads actor, action, target, since and until from a
+// query string. since and until take RFC 3339 timestamps or dates; a date
+// for until includes that whole day.
+func parseAuditQuery(q url.Values) (store.AuditFilter, error) {
+	f := store.AuditFilter{Actor: q.Get("actor"), Action: q.Get("action"), Target: q.Get("target")}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			*p.t = t
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return f, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", p.name)
+		}
+		if p.name == "until" {
+			t = t.AddDate(0, 0, 1)
+		}
+		*p.t = t
+	}
+	return f, nil
+}
+
+// auditPage is the filter form and one page of results on /admin/audit.
+type auditPage struct {
+	Entries          []store.AuditEntry
+	Filter           url.Values
+	Page, Total      int
+	PrevURL, NextURL string
+}
+
+// adminAuditHandler serves GET /admin/audit, the audit log newest first
+// with the same filters as /api/audit.
+func (app *App) adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data := TemplateData{Title: "Audit log", Audit: &auditPage{Filter: q, Page: 1}, Now: time.Now()}
+	f, err := parseAuditQuery(q)
+	if err != nil {
+		data.Flash = errorFlash(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		app.renderTemplate(w, r, "audit.html", data)
+		return
+	}
+	if p, err := strconv.Atoi(q.Get("page")); err == nil && p > 1 {
+		data.Audit.Page = p
+	}
+	f.Offset, f.Limit = (data.Audit.Page-1)*auditPerPage, auditPerPage
+	if data.Audit.Entries, data.Audit.Total, err = app.auditLog.ListAudit(r.Context(), f); err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	pageURL := func(p int) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(p))
+		return "/admin/audit?" + q.Encode()
+	}
+	if data.Audit.Page > 1 {
+		data.Audit.PrevURL = pageURL(data.Audit.Page - 1)
+	}
+	if data.Audit.Page*auditPerPage < data.Audit.Total {
+		data.Audit.NextURL = pageURL(data.Audit.Page + 1)
+	}
+	app.renderTemplate(w, r, "audit.html", data)
+}
+
+// auditAPIHandler serves GET /api/audit (admin): the audit log newest
+// first, filtered and paged like /api/messages.
+func (app *App) auditAPIHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Reuse the message list's page and per_page rules.
+	_, page, perPage, err := parseListQuery(url.Values{"page": {r.URL.Query().Get("page")}, "per_page": {r.URL.Query().Get("per_page")}})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.Offset, f.Limit = (page-1)*perPage, perPage
+	entries, total, err := app.auditLog.ListAudit(r.Context(), f)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	setPaginationHeaders(w, r, page, perPage, total)
+	writeJSON(w, http.StatusOK, entries)
+}
+
+var auditCSVHeader = []string{"id", "time", "actor", "ip", "action", "target", "before", "after", "request_id"}
+
+// auditExportHandler serves GET /api/audit/export?format=csv|ndjson
+// (admin), every matching entry oldest first.
+func (app *App) auditExportHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "ndjson":
+		contentType = "application/x-ndjson"
+	default:
+		writeJSONError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.%s"`, time.Now().UTC().Format("20060102-150405"), format))
+
+	var write func(store.AuditEntry) error
+	var flush func() error
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write(auditCSVHeader)
+		write = func(e store.AuditEntry) error {
+			return cw.Write([]string{strconv.Itoa(e.ID), e.Time.UTC().Format(time.RFC3339Nano), e.Actor, e.IP, e.Action, e.Target, string(e.Before), string(e.After), e.RequestID})
+		}
+		flush = func() error { cw.Flush(); return cw.Error() }
+	} else {
+		enc := json.NewEncoder(w)
+		write = func(e store.AuditEntry) error { return enc.Encode(e) }
+		flush = func() error { return nil }
+	}
+	err = app.auditLog.EachAudit(r.Context(), f, write)
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "audit export", "format", format, "err", err)
+	}
+}
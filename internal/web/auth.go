@@ -0,0 +1,273 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"example.com/go-sample-site/internal/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie = "slrs_session"
+	sessionTTL    = 12 * time.Hour
+)
+
+// errNoSession is returned by sessionStore.lookup for tokens that are
+// unknown or have expired.
+var errNoSession = errors.New("no such session")
+
+// sessionStore maps random cookie tokens to signed-in sessions.
+type sessionStore interface {
+	// create starts sess, which lasts sessionTTL.
+	create(ctx context.Context, sess session) (token string, expires time.Time, err error)
+	lookup(ctx context.Context, token string) (session, error)
+	delete(ctx context.Context, token string) error
+}
+
+// memorySessions keeps sessions in process memory, so a restart signs
+// everyone out, and each replica knows only its own.
+type memorySessions struct {
+	mu sync.Mutex
+	m  map[string]session
+}
+
+type session struct {
+	user    string
+	expires time.Time
+	// provider and idToken are set for single sign-on logins so logout
+	// can end the session at the provider too.
+	provider string
+	idToken  string
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{m: make(map[string]session)}
+}
+
+func (s *memorySessions) create(ctx context.Context, sess session) (token string, expires time.Time, err error) {
+	token = randomToken()
+	sess.expires = time.Now().Add(sessionTTL)
+	s.mu.Lock()
+	s.m[token] = sess
+	s.mu.Unlock()
+	return token, sess.expires, nil
+}
+
+func (s *memorySessions) lookup(ctx context.Context, token string) (session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.m[token]
+	if !ok {
+		return session{}, errNoSession
+	}
+	if time.Now().After(sess.expires) {
+		delete(s.m, token)
+		return session{}, errNoSession
+	}
+	return sess, nil
+}
+
+// randomToken returns 32 random bytes, base64url-encoded.
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *memorySessions) delete(ctx context.Context, token string) error {
+	s.mu.Lock()
+	delete(s.m, token)
+	s.mu.Unlock()
+	return nil
+}
+
+type userKey struct{}
+
+// sessionMiddleware resolves the session cookie, if any, to a User that
+// handlers can read with currentUser, and records the App's anonScopes
+// for can.
+func (app *App) sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = app.withAnonymousScopes(r)
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			sess, err := app.sessions.lookup(r.Context(), c.Value)
+			if err == nil {
+				if u, err := app.users.GetUser(r.Context(), sess.user); err == nil {
+					r = r.WithContext(context.WithValue(r.Context(), userKey{}, &u))
+				}
+			} else if !errors.Is(err, errNoSession) {
+				slog.ErrorContext(r.Context(), "session lookup", "err", err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// currentUser returns the signed-in user, or nil for anonymous requests.
+func currentUser(r *http.Request) *store.User {
+	u, _ := r.Context().Value(userKey{}).(*store.User)
+	return u
+}
+
+// dummyHash is compared against when a user does not exist so that failed
+// logins take the same time either way.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// errInvalidCredentials is a wrong password for an existing user.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// authenticate checks a password login. With an LDAP backend the directory
+// decides for every name except accounts with a local password, which stay
+// usable as a way in when the directory is down.
+func (app *App) authenticate(ctx context.Context, name, password string) (store.User, error) {
+	u, err := app.users.GetUser(ctx, name)
+	if app.ldapAuth != nil && (errors.Is(err, store.ErrUserNotFound) || (err == nil && u.Provider == "ldap")) {
+		user, role, err := app.ldapAuth.authenticate(ctx, name, password)
+		if err != nil {
+			return store.User{}, err
+		}
+		return app.externalUser(ctx, "ldap", user, role)
+	}
+	if errors.Is(err, store.ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return store.User{}, err
+	}
+	if err != nil {
+		return store.User{}, err
+	}
+	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)); err != nil {
+		// Includes single sign-on accounts, which have no hash at all.
+		return store.User{}, errInvalidCredentials
+	}
+	return u, nil
+}
+
+func (app *App) loginHandler(w http.ResponseWriter, r *http.Request) {
+	next := safeRedirect(r.FormValue("next"))
+	data := TemplateData{Title: "Log in", Next: next, SSO: app.ssoProviders, Now: time.Now()}
+	if r.Method != http.MethodPost {
+		app.renderTemplate(w, r, "login.html", data)
+		return
+	}
+	name := r.PostForm.Get("username")
+	u, err := app.authenticate(r.Context(), name, r.PostForm.Get("password"))
+	if err != nil {
+		if !errors.Is(err, store.ErrUserNotFound) && !errors.Is(err, errInvalidCredentials) {
+			slog.ErrorContext(r.Context(), "login", "err", err)
+		}
+		app.recordAudit(r.Context(), "login.failed", "user:"+name, nil, nil)
+		data.Flash = errorFlash("Invalid username or password.")
+		w.WriteHeader(http.StatusUnauthorized)
+		app.renderTemplate(w, r, "login.html", data)
+		return
+	}
+	if !app.startSession(w, r, session{user: u.Name}) {
+		return
+	}
+	redirectWithFlash(w, r, next, flashSuccess, "Signed in as "+u.Name+".")
+}
+
+// safeRedirect returns next if it is a path on this site, and "/" if not:
+// nothing with a scheme or a host, nor what browsers read as a host, such
+// as "//host" and "/\host", escaped or not.
+func safeRedirect(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" {
+		return "/"
+	}
+	for _, s := range []string{next, u.Path} {
+		if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.ContainsFunc(s, func(c rune) bool { return c == '\\' || unicode.IsControl(c) }) {
+			return "/"
+		}
+	}
+	return next
+}
+
+// startSession signs r's client in as sess.user and records the login. It
+// reports false when the session could not be kept, and it has answered.
+func (app *App) startSession(w http.ResponseWriter, r *http.Request, sess session) bool {
+	token, expires, err := app.sessions.create(r.Context(), sess)
+	if err != nil {
+		http.Error(w, "Session store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "session create", "err", err)
+		return false
+	}
+	app.recordAudit(withAuditActor(r.Context(), sess.user), "login", "user:"+sess.user, nil, nil)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return true
+}
+
+func (app *App) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	var sess session
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		sess, _ = app.sessions.lookup(r.Context(), c.Value)
+		if err := app.sessions.delete(r.Context(), c.Value); err != nil {
+			slog.ErrorContext(r.Context(), "session delete", "err", err)
+		}
+	}
+	if u := currentUser(r); u != nil {
+		app.recordAudit(r.Context(), "logout", "user:"+u.Name, nil, nil)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	setFlash(w, r, flashSuccess, "Signed out.")
+	// Single sign-on sessions also end at the provider when it supports
+	// RP-initiated logout; it sends the browser back to the board.
+	if p := app.ssoProviderNamed(sess.provider); p != nil && p.endSession != "" {
+		http.Redirect(w, r, p.logoutURL(app.baseURL(r), sess.idToken), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// seedAdmin creates or resets the admin account from ADMIN_USER (default
+// "admin") and ADMIN_PASSWORD. Without a password nothing is changed.
+func (app *App) seedAdmin(ctx context.Context) error {
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		return nil
+	}
+	name := os.Getenv("ADMIN_USER")
+	if name == "" {
+		name = "admin"
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := app.users.SaveUser(ctx, store.User{Name: name, PasswordHash: hash, Role: store.RoleAdmin}); err != nil {
+		return err
+	}
+	app.recordAudit(ctx, "user.save", "user:"+name, nil, map[string]string{"role": store.RoleAdmin})
+	return nil
+}
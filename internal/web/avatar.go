@@ -0,0 +1,247 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	avatarSize      = 128     // uploaded pictures are stored as avatarSize squares
+	maxAvatarUpload = 2 << 20 // bytes
+	maxAvatarPixels = 4000 * 4000
+)
+
+// gravatarURL returns the Gravatar for email, falling back to a generated
+// pattern for addresses Gravatar does not know.
+func gravatarURL(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) + "?s=128&d=identicon"
+}
+
+// avatarURL picks the picture for a message author: u's uploaded avatar if
+// the author is a registered user who has one, else the Gravatar for the
+// email given with the message or, failing that, u's own. It returns ""
+// when there is nothing to show.
+func avatarURL(u *store.User, email string) string {
+	if u != nil && u.Avatar != "" {
+		// The key in the query string lets browsers cache the picture
+		// until it changes.
+		return "/avatars/" + url.PathEscape(u.Name) + "?v=" + u.Avatar[:8]
+	}
+	if email == "" && u != nil {
+		email = u.Email
+	}
+	if email == "" {
+		return ""
+	}
+	return gravatarURL(email)
+}
+
+// withAvatars fills in Avatar for msgs.
+func (app *App) withAvatars(ctx context.Context, msgs []store.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	list, err := app.users.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*store.User, len(list))
+	for i := range list {
+		byName[list[i].Name] = &list[i]
+	}
+	for i := range msgs {
+		msgs[i].Avatar = avatarURL(byName[msgs[i].Author], msgs[i].Email)
+	}
+	return nil
+}
+
+// messageAvatar returns the avatar URL for a single message.
+func (app *App) messageAvatar(ctx context.Context, msg store.Message) string {
+	if u, err := app.users.GetUser(ctx, msg.Author); err == nil {
+		return avatarURL(&u, msg.Email)
+	}
+	return avatarURL(nil, msg.Email)
+}
+
+// resizeAvatar decodes a PNG, JPEG, GIF or WebP picture, crops the middle
+// square and scales it to avatarSize, returning it as PNG.
+func resizeAvatar(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// Check the dimensions first so a small file cannot claim a huge
+	// canvas and exhaust memory while decoding.
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("is not a PNG, JPEG, GIF or WebP image")
+	}
+	if cfg.Width*cfg.Height > maxAvatarPixels {
+		return nil, errors.New("must be at most 4000×4000 pixels")
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("is not a PNG, JPEG, GIF or WebP image")
+	}
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(b.Min).Add(image.Pt((b.Dx()-side)/2, (b.Dy()-side)/2))
+	dst := image.NewRGBA(image.Rect(0, 0, avatarSize, avatarSize))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// avatarHandler serves GET /avatars/{name}: the user's uploaded picture,
+// or a redirect to their Gravatar. Requests with the current ?v= may be
+// cached for good; others revalidate against the ETag.
+func (app *App) avatarHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := app.users.GetUser(r.Context(), r.PathValue("name"))
+	if errors.Is(err, store.ErrUserNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	if u.Avatar == "" {
+		if u.Email == "" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, gravatarURL(u.Email), http.StatusFound)
+		return
+	}
+	f, err := app.blobs.Open(r.Context(), u.Avatar)
+	if errors.Is(err, ErrBlobNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "avatar", "user", u.Name, "err", err)
+		return
+	}
+	defer f.Close()
+	h := w.Header()
+	h.Set("Content-Type", "image/png")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("ETag", `"`+u.Avatar+`"`)
+	if v := r.URL.Query().Get("v"); v != "" && strings.HasPrefix(u.Avatar, v) {
+		h.Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "private, no-cache")
+	}
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// profile is what the account page changes, as recorded in the audit log.
+type profile struct {
+	Email  string `json:"email"`
+	Avatar bool   `json:"avatar"`
+}
+
+// accountHandler serves /account, where signed-in users set the email
+// behind their Gravatar or upload a picture of their own.
+func (app *App) accountHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil {
+		http.Redirect(w, r, "/login?next=/account", http.StatusSeeOther)
+		return
+	}
+	data := TemplateData{Title: "Account", Now: time.Now()}
+	if r.Method == http.MethodPost {
+		err := app.saveProfile(r, u)
+		if err == nil {
+			redirectWithFlash(w, r, "/account", flashSuccess, "Account saved.")
+			return
+		}
+		var fe fieldErrors
+		if !errors.As(err, &fe) {
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "profile", "user", u.Name, "err", err)
+			return
+		}
+		data.Flash = errorFlash("Not saved: " + fe.Error())
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	app.renderTemplate(w, r, "account.html", data)
+}
+
+// saveProfile applies the account form to u: email, time zone, and either
+// a new picture in "avatar" or "remove_avatar". Problems with the input
+// come back as fieldErrors.
+func (app *App) saveProfile(r *http.Request, u *store.User) error {
+	ctx := r.Context()
+	errs := fieldErrors{}
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	errs.checkEmail("email", email)
+	zone := strings.TrimSpace(r.PostFormValue("time_zone"))
+	if _, ok := loadZone(zone); zone != "" && !ok {
+		errs.add("time_zone", "is not a time zone such as Europe/Berlin")
+	}
+	var img []byte
+	if f, fh, err := r.FormFile("avatar"); err == nil {
+		defer f.Close()
+		if fh.Size > maxAvatarUpload {
+			errs.add("avatar", "must be at most 2 MB")
+		} else if img, err = resizeAvatar(f); err != nil {
+			errs.add("avatar", err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	avatar := u.Avatar
+	switch {
+	case img != nil:
+		avatar = newBlobKey()
+		if err := app.blobs.Put(ctx, avatar, bytes.NewReader(img), int64(len(img)), "image/png"); err != nil {
+			return err
+		}
+	case r.PostFormValue("remove_avatar") != "":
+		avatar = ""
+	}
+	if err := app.users.SetProfile(ctx, u.Name, email, avatar); err != nil {
+		if img != nil {
+			app.blobs.Delete(ctx, avatar)
+		}
+		return err
+	}
+	if zone != u.TimeZone {
+		if err := app.users.SetTimeZone(ctx, u.Name, zone); err != nil {
+			return err
+		}
+	}
+	if u.Avatar != "" && avatar != u.Avatar {
+		if err := app.blobs.Delete(ctx, u.Avatar); err != nil {
+			slog.ErrorContext(ctx, "deleting old avatar", "user", u.Name, "err", err)
+		}
+	}
+	app.recordAudit(ctx, "user.profile", "user:"+u.Name, profile{u.Email, u.Avatar != ""}, profile{email, avatar != ""})
+	return nil
+}
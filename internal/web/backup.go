@@ -0,0 +1,485 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// A backup is a store.Snapshot as gzipped JSON, named after when it was
+// taken so that names sort oldest first.
+const backupTimeFormat = "20060102-150405.000"
+
+var backupName = regexp.MustCompile(`^backup-\d{8}-\d{6}\.\d{3}\.json\.gz$`)
+
+// ErrBackupNotFound is returned for a backup name the backup store does
+// not have.
+var ErrBackupNotFound = errors.New("backup not found")
+
+var (
+	backupsTaken = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slrs_backups_total",
+		Help: "Backups attempted by result.",
+	}, []string{"result"})
+	lastBackup = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "slrs_backup_last_success_timestamp_seconds",
+		Help: "When the last backup that succeeded was taken, as a Unix time.",
+	})
+)
+
+// Backup describes a backup kept by the backup store.
+type Backup struct {
+	Name  string    `json:"name"`
+	Size  int64     `json:"size"`
+	Taken time.Time `json:"taken"`
+}
+
+// backupStore keeps backups by name.
+type backupStore interface {
+	put(ctx context.Context, name string, r io.Reader, size int64) error
+	// list returns the backups, newest first.
+	list(ctx context.Context) ([]Backup, error)
+	open(ctx context.Context, name string) (io.ReadCloser, error)
+	delete(ctx context.Context, name string) error
+}
+
+// newBackup describes the backup called name, or reports that name is
+// not one.
+func newBackup(name string, size int64) (Backup, bool) {
+	if !backupName.MatchString(name) {
+		return Backup{}, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, "backup-"), ".json.gz")
+	taken, err := time.Parse(backupTimeFormat, stamp)
+	return Backup{Name: name, Size: size, Taken: taken}, err == nil
+}
+
+func sortBackups(list []Backup) {
+	slices.SortFunc(list, func(a, b Backup) int { return strings.Compare(b.Name, a.Name) })
+}
+
+// setupBackups opens the backup store cfg names. Backups need a store
+// that can take snapshots; without one, app.backups stays nil.
+func (app *App) setupBackups(cfg config.Config, s store.Store) error {
+	snaps, ok := s.(store.Snapshotter)
+	if !ok {
+		return nil
+	}
+	switch cfg.BackupBackend {
+	case "disk":
+		// put creates the directory with the first backup.
+		app.backups = diskBackups{dir: cfg.BackupDir}
+	case "s3":
+		bucket, err := openS3(cfg, cfg.S3Bucket, cfg.S3Prefix+"backups/")
+		if err != nil {
+			return err
+		}
+		app.backups = s3Backups{bucket: bucket}
+	default:
+		return fmt.Errorf("unknown backup backend %q", cfg.BackupBackend)
+	}
+	app.snapshots = snaps
+	app.backupInterval = cfg.BackupInterval
+	app.backupKeep = cfg.BackupKeep
+	return nil
+}
+
+// backup takes a backup, then deletes the oldest beyond backupKeep.
+// Backups are taken one at a time.
+func (app *App) backup(ctx context.Context) (Backup, error) {
+	app.backupMu.Lock()
+	defer app.backupMu.Unlock()
+	b, err := app.takeBackup(ctx)
+	if err != nil {
+		backupsTaken.WithLabelValues("error").Inc()
+		return Backup{}, err
+	}
+	backupsTaken.WithLabelValues("ok").Inc()
+	lastBackup.Set(float64(b.Taken.Unix()))
+	slog.InfoContext(ctx, "backup taken", "name", b.Name, "size", b.Size)
+
+	list, err := app.backups.list(ctx)
+	if err != nil {
+		return b, fmt.Errorf("listing backups to prune: %w", err)
+	}
+	for _, old := range list[min(app.backupKeep, len(list)):] {
+		if err := app.backups.delete(ctx, old.Name); err != nil {
+			return b, fmt.Errorf("deleting %s: %w", old.Name, err)
+		}
+	}
+	return b, nil
+}
+
+func (app *App) takeBackup(ctx context.Context) (Backup, error) {
+	ctx, span := tracer.Start(ctx, "backup")
+	defer span.End()
+	snap, err := app.snapshots.Snapshot(ctx)
+	if err != nil {
+		return Backup{}, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(snap); err != nil {
+		return Backup{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return Backup{}, err
+	}
+	b, _ := newBackup("backup-"+snap.Taken.Format(backupTimeFormat)+".json.gz", int64(buf.Len()))
+	return b, app.backups.put(ctx, b.Name, &buf, b.Size)
+}
+
+// RestoreResult is what a restore put back.
+type RestoreResult struct {
+	Restored string    `json:"restored"` // the backup or file
+	Taken    time.Time `json:"taken"`    // when its snapshot was
+	Channels int       `json:"channels"`
+	Messages int       `json:"messages"`
+	// Before is the backup of the board as it was, to undo the restore
+	// with.
+	Before Backup `json:"before"`
+}
+
+// readSnapshot decodes a backup, gzipped as the backup store keeps it or
+// not.
+func readSnapshot(r io.Reader) (*store.Snapshot, error) {
+	br := bufio.NewReader(r)
+	r = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	}
+	var snap store.Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("not a backup: %w", err)
+	}
+	return &snap, nil
+}
+
+// openSnapshot reads the backup called name from the backup store.
+func (app *App) openSnapshot(ctx context.Context, name string) (*store.Snapshot, error) {
+	if _, ok := newBackup(name, 0); !ok || app.backups == nil {
+		return nil, ErrBackupNotFound
+	}
+	rc, err := app.backups.open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readSnapshot(rc)
+}
+
+// restore puts the board back as snap, from the backup or file called
+// from, has it. A backup of the board as it was is taken first. This
+// replica is in maintenance mode meanwhile, unless it already was, so
+// that nobody writes to what is being replaced; other replicas are not,
+// so switch them first.
+func (app *App) restore(ctx context.Context, snap *store.Snapshot, from string) (RestoreResult, error) {
+	app.backupMu.Lock()
+	defer app.backupMu.Unlock()
+	before, err := app.takeBackup(ctx)
+	if err != nil {
+		return RestoreResult{}, fmt.Errorf("backing up the board first: %w", err)
+	}
+	if app.maintenance.Load() == nil {
+		app.setMaintenance(ctx, &maintenanceState{Message: "Restoring from a backup.", RetryAfter: 60})
+		defer app.setMaintenance(ctx, nil)
+	}
+	if err := app.snapshots.RestoreSnapshot(ctx, snap); err != nil {
+		return RestoreResult{}, err
+	}
+	app.cache.invalidate(ctx)
+	res := RestoreResult{Restored: from, Taken: snap.Taken, Channels: len(snap.Channels), Messages: len(snap.Messages), Before: before}
+	slog.WarnContext(ctx, "board restored", "from", from, "taken", snap.Taken, "before", before.Name)
+	app.recordAudit(ctx, "backup.restore", "backup:"+from, nil, res)
+	return res, nil
+}
+
+// restoreBackup restores the backup called name.
+func (app *App) restoreBackup(ctx context.Context, name string) (RestoreResult, error) {
+	snap, err := app.openSnapshot(ctx, name)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	return app.restore(ctx, snap, name)
+}
+
+// startBackups takes a backup every backupInterval until the returned
+// function is called. With several replicas, each takes its own; set the
+// interval on one of them.
+func (app *App) startBackups() (stop func()) {
+	if app.backups == nil || app.backupInterval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(app.backupInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if _, err := app.backup(context.Background()); err != nil {
+					slog.Error("backup", "err", err)
+				}
+			}
+		}
+	}()
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// backupsAPIHandler serves GET /api/backups, the backups kept, newest
+// first, for admins.
+func (app *App) backupsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if app.backups == nil {
+		writeJSONError(w, http.StatusNotImplemented, "this store cannot be backed up")
+		return
+	}
+	list, err := app.backups.list(r.Context())
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// createBackupAPIHandler serves POST /api/backups for admins, which takes
+// a backup now and answers with it.
+func (app *App) createBackupAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if app.backups == nil {
+		writeJSONError(w, http.StatusNotImplemented, "this store cannot be backed up")
+		return
+	}
+	b, err := app.backup(r.Context())
+	if b.Name == "" {
+		storeError(w, r, err)
+		return
+	}
+	if err != nil {
+		// Taken, but the old ones could not be pruned; try again next time.
+		slog.ErrorContext(r.Context(), "backup", "err", err)
+	}
+	app.recordAudit(r.Context(), "backup.create", "backup:"+b.Name, nil, b)
+	writeJSON(w, http.StatusCreated, b)
+}
+
+// backupAPIHandler serves GET /api/backups/{name}, the backup itself, for
+// admins.
+func (app *App) backupAPIHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := newBackup(name, 0); !ok || app.backups == nil {
+		writeJSONError(w, http.StatusNotFound, "backup not found")
+		return
+	}
+	rc, err := app.backups.open(r.Context(), name)
+	if errors.Is(err, ErrBackupNotFound) {
+		writeJSONError(w, http.StatusNotFound, "backup not found")
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	defer rc.Close()
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	if _, err := io.Copy(w, rc); err != nil {
+		slog.ErrorContext(r.Context(), "backup download", "name", name, "err", err)
+	}
+}
+
+// restoreBackupAPIHandler serves POST /api/backups/{name}/restore for
+// admins, which puts the board back as the backup has it.
+func (app *App) restoreBackupAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if app.backups == nil {
+		writeJSONError(w, http.StatusNotImplemented, "this store cannot be backed up")
+		return
+	}
+	res, err := app.restoreBackup(r.Context(), r.PathValue("name"))
+	switch {
+	case errors.Is(err, ErrBackupNotFound):
+		writeJSONError(w, http.StatusNotFound, "backup not found")
+	case errors.Is(err, store.ErrSnapshotIncompatible):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		storeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+type backupsPage struct {
+	List     []Backup
+	Interval time.Duration // 0 when only taken on demand
+	Keep     int
+}
+
+// adminBackupsHandler serves /admin/backups. GET lists the backups, each
+// with a button to restore it; POST takes one now.
+func (app *App) adminBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.backups == nil {
+		http.Error(w, "This store cannot be backed up.", http.StatusNotImplemented)
+		return
+	}
+	if r.Method == http.MethodPost {
+		b, err := app.backup(r.Context())
+		if b.Name == "" {
+			slog.ErrorContext(r.Context(), "backup", "err", err)
+			redirectWithFlash(w, r, "/admin/backups", flashError, "The backup failed: "+err.Error())
+			return
+		}
+		app.recordAudit(r.Context(), "backup.create", "backup:"+b.Name, nil, b)
+		redirectWithFlash(w, r, "/admin/backups", flashSuccess, "Backed up as "+b.Name+".")
+		return
+	}
+	list, err := app.backups.list(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "backups", "err", err)
+		http.Error(w, "Could not list the backups.", http.StatusInternalServerError)
+		return
+	}
+	page := &backupsPage{List: list, Interval: app.backupInterval, Keep: app.backupKeep}
+	app.renderTemplate(w, r, "backups.html", TemplateData{Title: "Backups", Backups: page, Now: time.Now()})
+}
+
+// adminRestoreHandler serves POST /admin/backups/{name}/restore.
+func (app *App) adminRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := app.restoreBackup(r.Context(), r.PathValue("name"))
+	if errors.Is(err, ErrBackupNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "restore", "err", err)
+		redirectWithFlash(w, r, "/admin/backups", flashError, "Not restored: "+err.Error())
+		return
+	}
+	redirectWithFlash(w, r, "/admin/backups", flashSuccess, fmt.Sprintf("Restored %d messages from %s. The board as it was is in %s.", res.Messages, res.Restored, res.Before.Name))
+}
+
+// diskBackups keeps backups as files in dir.
+type diskBackups struct {
+	dir string
+}
+
+func (d diskBackups) put(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(d.dir, 0o750); err != nil {
+		return err
+	}
+	// Written aside and renamed, so that a backup cut short is never listed.
+	f, err := os.CreateTemp(d.dir, ".partial-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(d.dir, name))
+}
+
+func (d diskBackups) list(ctx context.Context) ([]Backup, error) {
+	entries, err := os.ReadDir(d.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []Backup{}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if b, ok := newBackup(e.Name(), info.Size()); ok {
+			list = append(list, b)
+		}
+	}
+	sortBackups(list)
+	return list, nil
+}
+
+func (d diskBackups) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(d.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBackupNotFound
+	}
+	return f, err
+}
+
+func (d diskBackups) delete(ctx context.Context, name string) error {
+	err := os.Remove(filepath.Join(d.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// s3Backups keeps backups as objects in an S3 bucket, under backups/.
+type s3Backups struct {
+	bucket *s3Bucket
+}
+
+func (s s3Backups) put(ctx context.Context, name string, r io.Reader, size int64) error {
+	return s.bucket.put(ctx, name, r, size, "application/gzip")
+}
+
+func (s s3Backups) list(ctx context.Context) ([]Backup, error) {
+	list := []Backup{}
+	err := s.bucket.list(ctx, func(key string, size int64) {
+		if b, ok := newBackup(key, size); ok {
+			list = append(list, b)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortBackups(list)
+	return list, nil
+}
+
+func (s s3Backups) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	obj, err := s.bucket.open(ctx, name)
+	if errors.Is(err, errNoSuchObject) {
+		return nil, ErrBackupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s s3Backups) delete(ctx context.Context, name string) error {
+	return s.bucket.remove(ctx, name)
+}
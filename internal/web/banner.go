@@ -0,0 +1,198 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// bannerSeverities are the severities a banner can have, mildest first.
+var bannerSeverities = []string{"info", "warning", "critical"}
+
+// bannerDismissCookie holds the ID of the banner a visitor dismissed;
+// app.js sets it.
+const bannerDismissCookie = "slrs_banner_dismissed"
+
+// pageBanner returns the banner to show atop r's page: the active one,
+// unless the visitor dismissed it.
+func (app *App) pageBanner(r *http.Request) *store.Banner {
+	b, err := app.activeBanner(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return nil
+	}
+	if b == nil {
+		return nil
+	}
+	if c, err := r.Cookie(bannerDismissCookie); err == nil && c.Value == strconv.Itoa(b.ID) {
+		return nil
+	}
+	return b
+}
+
+// activeBanner returns the banner if one is set and has not expired.
+func (app *App) activeBanner(ctx context.Context) (*store.Banner, error) {
+	b, err := app.banners.GetBanner(ctx)
+	if errors.Is(err, store.ErrBannerNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !b.Active() {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+// bannerRequest is the body of PUT /api/banner.
+type bannerRequest struct {
+	Text     string     `json:"text"`
+	Severity string     `json:"severity"` // default info
+	Expires  *time.Time `json:"expires"`  // optional
+}
+
+func (req bannerRequest) validate() error {
+	fe := fieldErrors{}
+	switch n := len([]rune(req.Text)); {
+	case strings.TrimSpace(req.Text) == "":
+		fe["text"] = "is required"
+	case n > 300:
+		fe["text"] = "must be at most 300 characters"
+	}
+	if !slices.Contains(bannerSeverities, req.Severity) {
+		fe["severity"] = "must be one of " + strings.Join(bannerSeverities, ", ")
+	}
+	if req.Expires != nil && !req.Expires.After(time.Now()) {
+		fe["expires"] = "must be in the future"
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
+
+// setBanner saves req as the new banner, by whoever is acting under ctx.
+func (app *App) setBanner(ctx context.Context, req bannerRequest) (store.Banner, error) {
+	before, err := app.activeBanner(ctx)
+	if err != nil {
+		return store.Banner{}, err
+	}
+	b := store.Banner{Text: strings.TrimSpace(req.Text), Severity: req.Severity, Expires: req.Expires, Author: auditActor(ctx)}
+	if err := app.banners.SetBanner(ctx, &b); err != nil {
+		return store.Banner{}, err
+	}
+	app.recordAudit(ctx, "banner.set", "banner", before, b)
+	return b, nil
+}
+
+func (app *App) clearBanner(ctx context.Context) error {
+	before, err := app.activeBanner(ctx)
+	if err != nil {
+		return err
+	}
+	if err := app.banners.ClearBanner(ctx); err != nil {
+		return err
+	}
+	if before != nil {
+		app.recordAudit(ctx, "banner.clear", "banner", before, nil)
+	}
+	return nil
+}
+
+// getBannerAPIHandler serves GET /api/banner, the active banner or 404.
+func (app *App) getBannerAPIHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := app.activeBanner(r.Context())
+	switch {
+	case err != nil:
+		storeError(w, r, err)
+	case b == nil:
+		writeJSONError(w, http.StatusNotFound, "no banner")
+	default:
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+// setBannerAPIHandler serves PUT /api/banner for admins, which takes
+// {"text": ..., "severity": ..., "expires": ...}.
+func (app *App) setBannerAPIHandler(w http.ResponseWriter, r *http.Request) {
+	req := bannerRequest{Severity: "info"}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	b, err := app.setBanner(r.Context(), req)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// clearBannerAPIHandler serves DELETE /api/banner for admins.
+func (app *App) clearBannerAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.clearBanner(r.Context()); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bannerDuration is an expiry offered by the form on /status.
+type bannerDuration struct {
+	Label string
+	Value string // for time.ParseDuration; empty for none
+}
+
+var bannerDurations = []bannerDuration{
+	{"never expires", ""},
+	{"for 1 hour", "1h"},
+	{"for 1 day", "24h"},
+	{"for 1 week", "168h"},
+}
+
+// adminBannerHandler serves POST /admin/banner, the form on /status: it
+// sets the banner from text, severity and a duration out of
+// bannerDurations, or removes it if clear is set.
+func (app *App) adminBannerHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.FormValue("clear") != "" {
+		if err := app.clearBanner(ctx); err != nil {
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "store", "err", err)
+			return
+		}
+		redirectWithFlash(w, r, "/status", flashSuccess, "Banner removed.")
+		return
+	}
+	req := bannerRequest{Text: r.FormValue("text"), Severity: r.FormValue("severity")}
+	if v := r.FormValue("expires"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			redirectWithFlash(w, r, "/status", flashError, "Not saved: expires is not a duration")
+			return
+		}
+		t := time.Now().Add(d)
+		req.Expires = &t
+	}
+	if err := req.validate(); err != nil {
+		redirectWithFlash(w, r, "/status", flashError, "Not saved: "+err.Error())
+		return
+	}
+	if _, err := app.setBanner(ctx, req); err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	redirectWithFlash(w, r, "/status", flashSuccess, "Banner set.")
+}
@@ -0,0 +1,118 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"example.com/go-sample-site/internal/config"
+)
+
+// ErrBlobNotFound is returned when a blob key does not exist.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps file contents by key. Keys are random hex strings chosen
+// by the caller, so they are safe as file names and object names alike.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the blob for reading; seeking lets range requests work.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// openBlobStore opens the backend selected by cfg.BlobBackend.
+func openBlobStore(cfg config.Config) (BlobStore, error) {
+	switch cfg.BlobBackend {
+	case "disk":
+		// Put creates the directory with the first upload.
+		return diskBlobStore{dir: cfg.AttachmentDir}, nil
+	case "s3":
+		return openS3BlobStore(cfg)
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.BlobBackend)
+	}
+}
+
+// diskBlobStore keeps each blob in a file under dir, fanned out into
+// subdirectories by the first two characters of the key.
+type diskBlobStore struct {
+	dir string
+}
+
+func (d diskBlobStore) path(key string) string {
+	return filepath.Join(d.dir, key[:2], key)
+}
+
+func (d diskBlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	// Write to a temporary name first so a failed upload never leaves a
+	// truncated blob behind.
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (d diskBlobStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (d diskBlobStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// s3BlobStore keeps blobs as objects named key in an S3 bucket.
+type s3BlobStore struct {
+	bucket *s3Bucket
+}
+
+func openS3BlobStore(cfg config.Config) (*s3BlobStore, error) {
+	bucket, err := openS3(cfg, cfg.S3Bucket, cfg.S3Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &s3BlobStore{bucket: bucket}, nil
+}
+
+func (s *s3BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	return s.bucket.put(ctx, key, r, size, contentType)
+}
+
+func (s *s3BlobStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	obj, err := s.bucket.open(ctx, key)
+	if errors.Is(err, errNoSuchObject) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *s3BlobStore) Delete(ctx context.Context, key string) error {
+	return s.bucket.remove(ctx, key)
+}
@@ -0,0 +1,166 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// maxBulk is the most messages one bulk request creates or deletes.
+const maxBulk = 500
+
+// bulkItem is the outcome for one message of a bulk request, with the
+// status it would have had as a request of its own.
+type bulkItem struct {
+	Index   int            `json:"index"` // in the request
+	ID      int            `json:"id,omitempty"`
+	Status  int            `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Errors  fieldErrors    `json:"errors,omitempty"`
+	Message *store.Message `json:"message,omitempty"`
+}
+
+type bulkCreateResult struct {
+	Error   string     `json:"error,omitempty"` // when none were created
+	Created int        `json:"created"`
+	Results []bulkItem `json:"results"`
+}
+
+type bulkDeleteResult struct {
+	Deleted int        `json:"deleted"`
+	Results []bulkItem `json:"results"`
+}
+
+// bulkCreateAPIHandler serves POST /api/messages/bulk for moderators:
+// {"messages": [...]}, each as POST /api/messages takes it as JSON, all
+// created in one transaction. If any is invalid none is, and the 422 says
+// what is wrong with each; otherwise the 201 carries them all as saved.
+// Replies must be to messages that already exist. A bulk request takes one
+// post from the rate limit whatever its size, hence the moderate scope.
+func (app *App) bulkCreateAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePermission(w, r, PermModerate) {
+		return
+	}
+	var in struct {
+		Messages []struct {
+			Author, Email, Content string
+			ParentID               int `json:"parent_id"`
+			Tags                   []string
+			Channel                string
+			PublishAt              *time.Time `json:"publish_at"`
+			ExpiresAt              *time.Time `json:"expires_at"`
+		}
+	}
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	switch {
+	case len(in.Messages) == 0:
+		writeJSONError(w, http.StatusBadRequest, "messages required")
+		return
+	case len(in.Messages) > maxBulk:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("at most %d messages at once", maxBulk))
+		return
+	}
+	ctx := r.Context()
+	res := bulkCreateResult{Results: make([]bulkItem, len(in.Messages))}
+	msgs := make([]*store.Message, len(in.Messages))
+	invalid := false
+	for i, m := range in.Messages {
+		msg := &store.Message{Author: m.Author, Email: m.Email, Content: m.Content, ParentID: m.ParentID, Tags: m.Tags, Channel: m.Channel, PublishAt: m.PublishAt, ExpiresAt: m.ExpiresAt}
+		_, err := app.checkNewMessage(r, msg, nil)
+		if err == nil && msg.ParentID != 0 {
+			// CreateBatch, unlike Create, takes the parent on trust.
+			_, err = app.messages.Get(ctx, msg.ParentID)
+		}
+		if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrParentNotFound) {
+			err = fieldErrors{"parent_id": "does not exist"}
+		}
+		var fe fieldErrors
+		if errors.As(err, &fe) {
+			res.Results[i] = bulkItem{Index: i, Status: http.StatusUnprocessableEntity, Error: "invalid input", Errors: fe}
+			invalid = true
+		} else if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		msgs[i] = msg
+	}
+	if invalid {
+		for i := range res.Results {
+			if res.Results[i].Status == 0 {
+				res.Results[i] = bulkItem{Index: i, Status: http.StatusFailedDependency, Error: "not created, as others are invalid"}
+			}
+		}
+		res.Error = "invalid input"
+		writeJSON(w, http.StatusUnprocessableEntity, res)
+		return
+	}
+	if err := app.messages.CreateBatch(ctx, msgs); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	zone := requestZone(r)
+	for i, msg := range msgs {
+		inZone(zone, msg)
+		res.Results[i] = bulkItem{Index: i, ID: msg.ID, Status: http.StatusCreated, Message: msg}
+	}
+	res.Created = len(msgs)
+	slog.InfoContext(ctx, "bulk created messages", "count", len(msgs))
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// bulkDeleteAPIHandler serves DELETE /api/messages?ids=1,2,3 for
+// moderators. Each message goes to the trash on its own, so one missing
+// fails alone; the response gives each ID's outcome.
+func (app *App) bulkDeleteAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePermission(w, r, PermModerate) {
+		return
+	}
+	var ids []int
+	for _, v := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "ids must be comma-separated message IDs")
+			return
+		}
+		ids = append(ids, id)
+	}
+	switch {
+	case len(ids) == 0:
+		writeJSONError(w, http.StatusBadRequest, "ids required")
+		return
+	case len(ids) > maxBulk:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("at most %d messages at once", maxBulk))
+		return
+	}
+	ctx := r.Context()
+	res := bulkDeleteResult{Results: make([]bulkItem, len(ids))}
+	for i, id := range ids {
+		item := bulkItem{Index: i, ID: id, Status: http.StatusNoContent}
+		err := app.checkMessageAccess(r, id)
+		if err == nil {
+			err = app.messages.Delete(ctx, id)
+		}
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			item.Status, item.Error = http.StatusNotFound, "message not found"
+		case err != nil:
+			slog.ErrorContext(ctx, "store", "err", err)
+			item.Status, item.Error = http.StatusInternalServerError, "Store error"
+		default:
+			res.Deleted++
+		}
+		res.Results[i] = item
+	}
+	writeJSON(w, http.StatusOK, res)
+}
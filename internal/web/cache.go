@@ -0,0 +1,259 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedResponses bounds the in-memory response cache; once it is
+// full, the cache starts over.
+const maxCachedResponses = 1000
+
+// cachedHeaders are the response headers a cached response is served
+// with. The rest, cookies and quotas among them, belong to the request.
+var cachedHeaders = []string{"Content-Type", "Content-Language", "Cache-Control", "ETag", "Last-Modified", "Link", "Vary", "X-Total-Count"}
+
+// csrfPlaceholder stands in for the CSRF token in cached pages, which is
+// different for every browser.
+const csrfPlaceholder = "\x00csrf-token\x00"
+
+// responseCache keeps the busiest responses, the board and the message
+// list, for a few seconds, so a crowd loading them at once costs a render
+// now and then rather than one each. A response is kept per URL and per
+// viewer: user or API key, cookies and the language asked for. Any write,
+// a form or API request that changes something or an Event on the hub,
+// empties it.
+type responseCache struct {
+	ttl   time.Duration
+	store cacheStore
+}
+
+// cacheStore holds the responses of a responseCache. Emptying it starts a
+// new generation; a response rendered in an earlier one is not kept.
+type cacheStore interface {
+	// get returns the response kept for key, if any, and the generation.
+	get(ctx context.Context, key string) (*cachedResponse, uint64, error)
+	// put keeps e for key until it expires. Once the generation is past
+	// gen, e is not served.
+	put(ctx context.Context, key string, gen uint64, e *cachedResponse) error
+	clear(ctx context.Context) error
+}
+
+type cachedResponse struct {
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Expires time.Time   `json:"expires"`
+}
+
+// newResponseCache returns a cache keeping responses in store for ttl, or
+// nil, which caches nothing, for a ttl of 0.
+func newResponseCache(ttl time.Duration, store cacheStore) *responseCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &responseCache{ttl: ttl, store: store}
+}
+
+// memoryCache is a cacheStore in process memory, one for each replica.
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+	gen     uint64
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: make(map[string]*cachedResponse)}
+}
+
+func (c *memoryCache) get(ctx context.Context, key string) (*cachedResponse, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key], c.gen, nil
+}
+
+func (c *memoryCache) put(ctx context.Context, key string, gen uint64, e *cachedResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return nil
+	}
+	if len(c.entries) >= maxCachedResponses {
+		clear(c.entries)
+	}
+	c.entries[key] = e
+	return nil
+}
+
+func (c *memoryCache) clear(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	clear(c.entries)
+	return nil
+}
+
+// cached is route middleware serving GET requests from the cache, and
+// filling it with the successful responses that set no cookies. Should the
+// store fail, requests are served as if nothing was cached.
+func (c *responseCache) cached(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := cacheKey(r)
+		if c == nil || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		e, gen, err := c.store.get(r.Context(), key)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "response cache", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if e != nil && time.Now().Before(e.Expires) {
+			cacheRequests.WithLabelValues("hit").Inc()
+			e.serve(w, r)
+			return
+		}
+		cacheRequests.WithLabelValues("miss").Inc()
+		w.Header().Set("X-Cache", "MISS")
+		rec := &cacheRecorder{ResponseWriter: w, status: http.StatusOK, cookies: len(w.Header().Values("Set-Cookie"))}
+		next.ServeHTTP(rec, r)
+		if rec.header == nil || rec.status != http.StatusOK || rec.setCookie {
+			return
+		}
+		body := rec.body.Bytes()
+		if token := csrfToken(r); token != "" {
+			body = bytes.ReplaceAll(body, []byte(token), []byte(csrfPlaceholder))
+		}
+		e = &cachedResponse{Header: rec.header, Body: body, Expires: time.Now().Add(c.ttl)}
+		if err := c.store.put(r.Context(), key, gen, e); err != nil {
+			slog.ErrorContext(r.Context(), "response cache", "err", err)
+		}
+	})
+}
+
+// invalidate empties the cache.
+func (c *responseCache) invalidate(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	if err := c.store.clear(ctx); err != nil {
+		slog.ErrorContext(ctx, "response cache", "err", err)
+	}
+}
+
+// invalidating is middleware emptying the cache after every request that
+// may have changed something.
+func (c *responseCache) invalidating(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			// Even if the client is gone, what it changed has changed.
+			c.invalidate(context.WithoutCancel(r.Context()))
+		}
+	})
+}
+
+// follow empties the cache on every change to a message, including those
+// made outside of requests, until events is closed.
+func (c *responseCache) follow(events chan Event) {
+	for range events {
+		c.invalidate(context.Background())
+	}
+}
+
+// cacheKey is what tells r's response apart from others to the same URL:
+// who is asking, in what language and format, and the cookies the pages are
+// rendered from. Requests that are not GETs, long polls, which must wait
+// for what the cache already has to change, and those a flash is waiting
+// for, are not cached.
+func cacheKey(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet || r.URL.Query().Has("wait") {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString(r.URL.RequestURI())
+	b.WriteString("\n" + accessUser(r.Context()))
+	b.WriteString("\n" + r.Header.Get("Accept-Language"))
+	b.WriteString("\n" + r.Header.Get("Accept"))
+	cookies := r.Cookies()
+	slices.SortFunc(cookies, func(a, b *http.Cookie) int { return strings.Compare(a.Name, b.Name) })
+	for _, ck := range cookies {
+		switch ck.Name {
+		case flashCookie:
+			return "", false
+		case sessionCookie, csrfCookie:
+			// Stand for the user, and the token swapped in on the way out.
+			continue
+		}
+		b.WriteString("\n" + ck.Name + "=" + ck.Value)
+	}
+	return b.String(), true
+}
+
+// serve answers r with e, or 304 Not Modified when r's copy is current.
+func (e *cachedResponse) serve(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for k, v := range e.Header {
+		h[k] = v
+	}
+	h.Set("X-Cache", "HIT")
+	if etag := e.Header.Get("ETag"); etag != "" {
+		modified, _ := http.ParseTime(e.Header.Get("Last-Modified"))
+		if notModified(r, etag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	body := e.Body
+	if token := csrfToken(r); token != "" {
+		body = bytes.ReplaceAll(body, []byte(csrfPlaceholder), []byte(token))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// cacheRecorder passes a response through while keeping a copy of it.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status    int
+	header    http.Header // the cachedHeaders, once the header is written
+	cookies   int         // Set-Cookie headers before the handler ran
+	setCookie bool        // the handler set cookies of its own
+	body      bytes.Buffer
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *cacheRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	if rec.header == nil {
+		rec.status = status
+		h := rec.ResponseWriter.Header()
+		rec.header = make(http.Header)
+		for _, k := range cachedHeaders {
+			if v := h.Values(k); len(v) > 0 {
+				rec.header[k] = slices.Clone(v)
+			}
+		}
+		rec.setCookie = len(h.Values("Set-Cookie")) > rec.cookies
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *cacheRecorder) Write(p []byte) (int, error) {
+	if rec.header == nil {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
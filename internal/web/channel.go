@@ -0,0 +1,449 @@
+package web
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+const maxChannelDescription = 200
+
+func channelTarget(name string) string { return "channel:" + name }
+
+// seedChannels makes sure defaultChannel exists.
+func (app *App) seedChannels(ctx context.Context) error {
+	err := app.channels.CreateChannel(ctx, &store.Channel{Name: store.DefaultChannel, Description: "Everything without a channel of its own"})
+	if errors.Is(err, store.ErrDuplicate) {
+		return nil
+	}
+	return err
+}
+
+// canReadChannel reports whether r may see c and its messages: anyone
+// may see a public channel, admins and c's allowlist a private one. API
+// keys are listed by name among the users.
+func canReadChannel(r *http.Request, c store.Channel) bool {
+	if !c.Private || can(r, PermAdmin) {
+		return true
+	}
+	if k := currentAPIKey(r); k != nil {
+		return slices.Contains(c.Users, k.Name)
+	}
+	u := currentUser(r)
+	return u != nil && c.ReadableBy(*u)
+}
+
+// readableChannels returns the channels r may see.
+func (app *App) readableChannels(r *http.Request) ([]store.Channel, error) {
+	cs, err := app.channels.ListChannels(r.Context())
+	return slices.DeleteFunc(cs, func(c store.Channel) bool { return !canReadChannel(r, c) }), err
+}
+
+// hiddenChannels returns the names of the private channels r may not see,
+// for ListOptions.Hidden.
+func (app *App) hiddenChannels(r *http.Request) ([]string, error) {
+	cs, err := app.channels.ListChannels(r.Context())
+	var hidden []string
+	for _, c := range cs {
+		if !canReadChannel(r, c) {
+			hidden = append(hidden, c.Name)
+		}
+	}
+	return hidden, err
+}
+
+// inPrivateChannel reports whether m is in a private channel, for the
+// notifiers, which have no reader to ask canReadChannel about: Slack,
+// webhooks and mail carry public messages only. Events without a message
+// body, such as deletes, name no channel; they are not private.
+func (app *App) inPrivateChannel(ctx context.Context, m store.Message) (bool, error) {
+	c, err := app.channels.GetChannel(ctx, m.Channel)
+	if errors.Is(err, store.ErrChannelNotFound) {
+		return false, nil
+	}
+	return c.Private, err
+}
+
+// getChannel returns the named channel if r may see it; a private channel
+// it may not see is ErrChannelNotFound, so its name does not leak.
+func (app *App) getChannel(r *http.Request, name string) (store.Channel, error) {
+	c, err := app.channels.GetChannel(r.Context(), name)
+	if err == nil && !canReadChannel(r, c) {
+		return store.Channel{}, store.ErrChannelNotFound
+	}
+	return c, err
+}
+
+// canReadMessage reports whether r may see the channel m is in. Channels
+// are never deleted, so only events without a message body, such as
+// deletes, name none; they pass.
+func (app *App) canReadMessage(r *http.Request, m store.Message) (bool, error) {
+	c, err := app.channels.GetChannel(r.Context(), m.Channel)
+	if errors.Is(err, store.ErrChannelNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return canReadChannel(r, c), nil
+}
+
+// checkMessageAccess fails with ErrNotFound if message id is in a channel
+// r may not see. A missing message passes, for the caller to report.
+func (app *App) checkMessageAccess(r *http.Request, id int) error {
+	m, err := app.messages.Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ok, err := app.canReadMessage(r, m)
+	if err == nil && !ok {
+		err = store.ErrNotFound
+	}
+	return err
+}
+
+// checkChannel settles the channel msg is posted to by r: its parent's for
+// a reply, otherwise the one named or defaultChannel. The channel must
+// exist, be visible to r and not be archived.
+func (app *App) checkChannel(r *http.Request, msg *store.Message) error {
+	ctx := r.Context()
+	if msg.ParentID != 0 {
+		parent, err := app.messages.Get(ctx, msg.ParentID)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil // Create reports ErrParentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		msg.Channel = parent.Channel
+	}
+	msg.Channel = cmp.Or(strings.ToLower(strings.TrimSpace(msg.Channel)), store.DefaultChannel)
+	c, err := app.getChannel(r, msg.Channel)
+	switch {
+	case errors.Is(err, store.ErrChannelNotFound) && msg.ParentID != 0:
+		return store.ErrParentNotFound
+	case errors.Is(err, store.ErrChannelNotFound):
+		return fieldErrors{"channel": "does not exist"}
+	case err != nil:
+		return err
+	case c.Archived != nil:
+		return fieldErrors{"channel": "is archived"}
+	}
+	return nil
+}
+
+// channelInput creates or changes a channel. Fields left nil keep their
+// value.
+type channelInput struct {
+	Name        string    `json:"name"`
+	Description *string   `json:"description"`
+	Archived    *bool     `json:"archived"`
+	Private     *bool     `json:"private"`
+	Users       *[]string `json:"users"`
+	Roles       *[]string `json:"roles"`
+}
+
+func (in *channelInput) validate(create bool) error {
+	errs := fieldErrors{}
+	in.Name = strings.ToLower(strings.TrimSpace(in.Name))
+	if create && !tagPattern.MatchString(in.Name) {
+		errs.add("name", "must be up to 32 lowercase letters, digits, '.', '_' or '-'")
+	}
+	if in.Description != nil {
+		*in.Description = strings.TrimSpace(*in.Description)
+		errs.checkText("description", *in.Description, maxChannelDescription, false)
+	}
+	if in.Archived != nil && *in.Archived && in.Name == store.DefaultChannel {
+		errs.add("archived", "the default channel cannot be archived")
+	}
+	if in.Private != nil && *in.Private && in.Name == store.DefaultChannel {
+		errs.add("private", "the default channel cannot be private")
+	}
+	if in.Users != nil {
+		*in.Users = cleanList(*in.Users)
+		for _, u := range *in.Users {
+			errs.checkText("users", u, maxAuthorLength, false)
+			if strings.Contains(u, ",") {
+				errs.add("users", "names cannot contain commas")
+			}
+		}
+	}
+	if in.Roles != nil {
+		*in.Roles = cleanList(*in.Roles)
+		for _, role := range *in.Roles {
+			if !store.ValidRole(role) {
+				errs.add("roles", "must be viewer, poster, moderator or admin")
+			}
+		}
+	}
+	return errs.err()
+}
+
+// cleanList trims the entries of an allowlist and drops empty and repeated
+// ones.
+func cleanList(list []string) []string {
+	var out []string
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s != "" && !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// createChannel saves a new channel from in, which has been validated.
+func (app *App) createChannel(ctx context.Context, in channelInput) (store.Channel, error) {
+	c := store.Channel{Name: in.Name}
+	in.apply(&c)
+	if err := app.channels.CreateChannel(ctx, &c); err != nil {
+		if errors.Is(err, store.ErrDuplicate) {
+			return c, fieldErrors{"name": "is taken by another channel"}
+		}
+		return c, err
+	}
+	app.recordAudit(ctx, "channel.create", channelTarget(c.Name), nil, c)
+	return c, nil
+}
+
+// apply copies the description, privacy and allowlists of in to c.
+func (in channelInput) apply(c *store.Channel) {
+	if in.Description != nil {
+		c.Description = *in.Description
+	}
+	if in.Private != nil {
+		c.Private = *in.Private
+	}
+	if in.Users != nil {
+		c.Users = *in.Users
+	}
+	if in.Roles != nil {
+		c.Roles = *in.Roles
+	}
+}
+
+// updateChannel applies in, which has been validated, to its channel.
+func (app *App) updateChannel(ctx context.Context, in channelInput) (store.Channel, error) {
+	before, err := app.channels.GetChannel(ctx, in.Name)
+	if err != nil {
+		return before, err
+	}
+	c := before
+	in.apply(&c)
+	if in.Archived != nil && *in.Archived != (c.Archived != nil) {
+		c.Archived = nil
+		if *in.Archived {
+			now := time.Now().UTC()
+			c.Archived = &now
+		}
+	}
+	if err := app.channels.UpdateChannel(ctx, &c); err != nil {
+		return c, err
+	}
+	action := "channel.update"
+	switch {
+	case before.Archived == nil && c.Archived != nil:
+		action = "channel.archive"
+	case before.Archived != nil && c.Archived == nil:
+		action = "channel.unarchive"
+	}
+	app.recordAudit(ctx, action, channelTarget(c.Name), before, c)
+	return c, nil
+}
+
+// listChannelsAPIHandler serves GET /api/channels, the channels the
+// caller may see.
+func (app *App) listChannelsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	cs, err := app.readableChannels(r)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cs)
+}
+
+// createChannelAPIHandler serves POST /api/channels for admins, which
+// takes {"name": ..., "description": ..., "private": true, "users": [...],
+// "roles": [...]}.
+func (app *App) createChannelAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var in channelInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	in.Archived = nil
+	if err := in.validate(true); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	c, err := app.createChannel(r.Context(), in)
+	var fe fieldErrors
+	if errors.As(err, &fe) {
+		writeInputError(w, err)
+		return
+	} else if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+c.Name)
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// getChannelAPIHandler serves GET /api/channels/{name}.
+func (app *App) getChannelAPIHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := app.getChannel(r, r.PathValue("name"))
+	if err != nil {
+		channelError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// updateChannelAPIHandler serves PATCH /api/channels/{name} for admins,
+// who change the channel's description, archive it or set who may see it
+// with {"description": ..., "archived": true, "private": true, "users":
+// [...], "roles": [...]}.
+func (app *App) updateChannelAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var in channelInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	in.Name = r.PathValue("name")
+	if err := in.validate(false); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	c, err := app.updateChannel(r.Context(), in)
+	if err != nil {
+		channelError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func channelError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrChannelNotFound) {
+		writeJSONError(w, http.StatusNotFound, "channel not found")
+		return
+	}
+	storeError(w, r, err)
+}
+
+// accessView is the data of the "access" template in channels.html.
+type accessView struct {
+	Channel *store.Channel // nil on the form for a new channel
+	Roles   []string
+}
+
+// channelsPage is the data behind channels.html.
+type channelsPage struct {
+	Channels []store.Channel
+	Counts   map[string]int // messages in each channel
+	Roles    []string       // offered for private channels' allowlists
+}
+
+// channelsHandler serves /channels, the list of channels. Admins create
+// one with POST /channels, archive or bring one back with POST
+// /channels/{name}/archive and /unarchive, and set who may see it with
+// POST /channels/{name}/access.
+func (app *App) channelsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	data := TemplateData{Title: "Channels", Now: time.Now()}
+	if r.Method == http.MethodPost {
+		err := app.saveChannelForm(r)
+		var fe fieldErrors
+		switch {
+		case errors.As(err, &fe):
+			data.Flash = errorFlash("Not saved: " + fe.Error())
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		case errors.Is(err, store.ErrChannelNotFound):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "store", "err", err)
+			return
+		default:
+			redirectWithFlash(w, r, "/channels", flashSuccess, "Channel saved.")
+			return
+		}
+	}
+	cs, err := app.readableChannels(r)
+	page := &channelsPage{Channels: cs, Counts: map[string]int{}, Roles: []string{store.RoleViewer, store.RolePoster, store.RoleModerator}}
+	for _, c := range cs {
+		if err != nil {
+			break
+		}
+		_, page.Counts[c.Name], err = app.messages.List(ctx, store.ListOptions{Channel: c.Name, Limit: 1})
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	data.ChannelList = page
+	app.renderTemplate(w, r, "channels.html", data)
+}
+
+// saveChannelForm handles the admin forms on /channels: a new channel, or
+// an action on the channel in the path.
+func (app *App) saveChannelForm(r *http.Request) error {
+	name := r.PathValue("name")
+	if name == "" {
+		desc := r.FormValue("description")
+		in := channelInput{Name: r.FormValue("name"), Description: &desc}
+		accessForm(r, &in)
+		if err := in.validate(true); err != nil {
+			return err
+		}
+		_, err := app.createChannel(r.Context(), in)
+		return err
+	}
+	in := channelInput{Name: name}
+	switch action := r.PathValue("action"); action {
+	case "archive", "unarchive":
+		archived := action == "archive"
+		in.Archived = &archived
+	case "access":
+		accessForm(r, &in)
+	default:
+		return store.ErrChannelNotFound
+	}
+	if err := in.validate(false); err != nil {
+		return err
+	}
+	_, err := app.updateChannel(r.Context(), in)
+	return err
+}
+
+// accessForm reads the privacy fields of the forms on /channels: private
+// (a checkbox), users (comma-separated) and roles (checkboxes).
+func accessForm(r *http.Request, in *channelInput) {
+	private := r.FormValue("private") == "on"
+	users := strings.Split(r.FormValue("users"), ",")
+	roles := r.Form["roles"]
+	in.Private, in.Users, in.Roles = &private, &users, &roles
+}
+
+// channelHandler serves /c/{name}, the board narrowed to one channel.
+func (app *App) channelHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := app.getChannel(r, r.PathValue("name"))
+	if errors.Is(err, store.ErrChannelNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	app.renderBoard(w, r, &c, nil)
+}
@@ -0,0 +1,155 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/store"
+)
+
+// The commands besides serve work on the store with no server running.
+// What they share with it is here, so that a user added or messages
+// imported from the command line are checked and audited as through the
+// API. The audit log names "cli" as their actor.
+
+// newCommandApp returns an App with just the stores the commands need.
+func newCommandApp(ctx context.Context, s store.Store) (*App, context.Context) {
+	app := &App{users: s, channels: s, auditLog: s}
+	app.messages = auditingStore{s, app}
+	return app, withAuditActor(ctx, "cli")
+}
+
+// Seed creates what the server does on start in an empty database: the
+// default channel, and the admin account when ADMIN_PASSWORD is set.
+func Seed(ctx context.Context, s store.Store) error {
+	app, ctx := newCommandApp(ctx, s)
+	if err := app.seedAdmin(ctx); err != nil {
+		return fmt.Errorf("seeding admin user: %w", err)
+	}
+	if err := app.seedChannels(ctx); err != nil {
+		return fmt.Errorf("seeding channels: %w", err)
+	}
+	return nil
+}
+
+// ImportMessages stores the messages in file, in any format
+// /admin/import takes, and returns how many there were. Nothing is stored
+// unless every row is valid; the error then lists those that are not.
+func ImportMessages(ctx context.Context, s store.Store, file io.Reader, filename string) (int, error) {
+	app, ctx := newCommandApp(ctx, s)
+	res, _ := app.importMessages(ctx, file, filename)
+	if len(res.Errors) == 0 {
+		return res.Imported, nil
+	}
+	errs := make([]error, len(res.Errors))
+	for i, e := range res.Errors {
+		if e.Row == 0 {
+			errs[i] = errors.New(e.Error)
+		} else {
+			errs[i] = fmt.Errorf("row %d: %s", e.Row, e.Error)
+		}
+	}
+	return 0, errors.Join(errs...)
+}
+
+// ExportMessages writes every message in s to w, oldest first, in format,
+// csv or ndjson, as GET /api/messages/export does for an admin.
+func ExportMessages(ctx context.Context, s store.MessageStore, w io.Writer, format string) error {
+	if _, ok := exportContentTypes[format]; !ok {
+		return errors.New("format must be csv or ndjson")
+	}
+	write, flush := exportWriter(w, format)
+	if err := s.Each(ctx, write); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// ExportMessagesS3 is ExportMessages to the object at url, s3://bucket/key,
+// on the S3 service cfg configures and encrypted as it says. The object
+// only appears once the export is complete.
+func ExportMessagesS3(ctx context.Context, cfg config.Config, s store.MessageStore, url, format string) error {
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		return errors.New("format must be csv or ndjson")
+	}
+	name, key, ok := parseS3URL(url)
+	if !ok {
+		return fmt.Errorf("%s: want s3://bucket/key", url)
+	}
+	bucket, err := openS3(cfg, name, "")
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(ExportMessages(ctx, s, pw, format)) }()
+	err = bucket.put(ctx, key, pr, -1, contentType)
+	// Stops the export if the upload gave up first.
+	pr.CloseWithError(err)
+	return err
+}
+
+// AddUser creates the account name with role and password, which must
+// pass the checks POST /api/users makes.
+func AddUser(ctx context.Context, s store.Store, name, role, password string) error {
+	in := userInput{Name: name, Role: &role, Password: &password}
+	if err := in.validate(true); err != nil {
+		return err
+	}
+	if _, err := s.GetUser(ctx, in.Name); err == nil {
+		return errors.New("a user with that name exists")
+	} else if !errors.Is(err, store.ErrUserNotFound) {
+		return err
+	}
+	app, ctx := newCommandApp(ctx, s)
+	return app.storeUser(ctx, &store.User{Name: in.Name, Role: role}, &password, nil)
+}
+
+// SetPassword changes the password of the account name.
+func SetPassword(ctx context.Context, s store.Store, name, password string) error {
+	in := userInput{Password: &password}
+	if err := in.validate(false); err != nil {
+		return err
+	}
+	u, err := s.GetUser(ctx, name)
+	if err != nil {
+		return err
+	}
+	before := u
+	app, ctx := newCommandApp(ctx, s)
+	return app.storeUser(ctx, &u, &password, &before)
+}
+
+// Restore puts the board back as the backup src has it: a file, gzipped
+// or not, or else the name of a backup in the store cfg configures. The
+// board as it was is backed up there first. A running server's replicas
+// are not put in maintenance mode by it, so stop them first, or restore
+// through /admin/backups instead.
+func Restore(ctx context.Context, cfg config.Config, s store.Store, src string) (RestoreResult, error) {
+	app, ctx := newCommandApp(ctx, s)
+	if err := app.setupBackups(cfg, s); err != nil {
+		return RestoreResult{}, err
+	}
+	if app.backups == nil {
+		return RestoreResult{}, fmt.Errorf("the %s store cannot be backed up or restored", cfg.StoreBackend)
+	}
+	f, err := os.Open(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return app.restoreBackup(ctx, src)
+	}
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	defer f.Close()
+	snap, err := readSnapshot(f)
+	if err != nil {
+		return RestoreResult{}, fmt.Errorf("%s: %w", src, err)
+	}
+	return app.restore(ctx, snap, filepath.Base(src))
+}
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// writeMessagePage sends a page of messages, as mediaType, with an ETag
+// and Last-Modified so polling clients can revalidate, answering 304 Not
+// Modified when their copy is current. The ETag hashes the page itself
+// together with the total, so edits and deletes change it as well as new
+// posts; Last-Modified is the newest Created or Updated time on the page.
+func writeMessagePage(w http.ResponseWriter, r *http.Request, mediaType string, msgs []store.Message, total int) {
+	p, err := newMessagePage(w, r, mediaType, msgs, total)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "encoding failed")
+		return
+	}
+	p.write(w, r)
+}
+
+// messagePage is a page of messages as writeMessagePage sends it.
+type messagePage struct {
+	mediaType string
+	body      []byte
+	etag      string
+	modified  time.Time
+}
+
+func newMessagePage(w http.ResponseWriter, r *http.Request, mediaType string, msgs []store.Message, total int) (*messagePage, error) {
+	messagesInZone(requestZone(r), msgs)
+	body, err := encodeMessages(marshalOf(w), mediaType, msgs, total)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(append(strconv.AppendInt(nil, int64(total), 10), body...))
+	return &messagePage{mediaType, body, `"` + hex.EncodeToString(sum[:8]) + `"`, lastModified(msgs)}, nil
+}
+
+// notModified reports whether r's copy of the page is current.
+func (p *messagePage) notModified(r *http.Request) bool {
+	return notModified(r, p.etag, p.modified)
+}
+
+func (p *messagePage) write(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("ETag", p.etag)
+	if !p.modified.IsZero() {
+		h.Set("Last-Modified", p.modified.UTC().Format(http.TimeFormat))
+	}
+	h.Set("Cache-Control", "no-cache")
+	if p.notModified(r) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", contentType(p.mediaType))
+	h.Set("Content-Length", strconv.Itoa(len(p.body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(p.body)
+}
+
+func lastModified(msgs []store.Message) time.Time {
+	var t time.Time
+	for _, m := range msgs {
+		if m.Created.After(t) {
+			t = m.Created
+		}
+		if m.Updated != nil && m.Updated.After(t) {
+			t = *m.Updated
+		}
+	}
+	return t
+}
+
+// notModified evaluates If-None-Match and, only when that is absent,
+// If-Modified-Since, as RFC 9110 orders them. ETags compare weakly because
+// middleware.Compress weakens them on encoded responses.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// etagSuffixes tell the representations of a message apart in its ETag;
+// JSON, which edits are made to, has none.
+var etagSuffixes = map[string]string{"application/xml": "-xml", "text/plain": "-text"}
+
+// messageETag is the ETag of message m as mediaType: its version, with a
+// suffix for representations other than JSON, e.g. "3" or "3-xml". Only
+// edits move it on, not pins or reactions. It is strong, as If-Match on an
+// edit needs; middleware.Compress weakens it on compressed responses.
+func messageETag(m store.Message, mediaType string) string {
+	return `"` + strconv.Itoa(m.Version) + etagSuffixes[mediaType] + `"`
+}
+
+// errNoVersion is returned by editVersion for an edit that names no
+// version.
+var errNoVersion = errors.New("send the version you edited, as If-Match or version")
+
+// errETagMismatch is returned by editVersion for an If-Match that names no
+// version of the message's JSON.
+var errETagMismatch = errors.New(`If-Match must be the strong ETag of the message as JSON, such as "3"; send the version in the body otherwise`)
+
+// editVersion returns the version of the message an edit was made to,
+// from If-Match or, failing that, body, the version in the request body;
+// 0, for If-Match: *, means whatever version is current. If-Match compares
+// strongly, as RFC 9110 has it: a weak ETag, or one of another
+// representation, is errETagMismatch, for 412.
+func editVersion(r *http.Request, body *int) (int, error) {
+	im := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case im == "*":
+		return 0, nil
+	case im != "":
+		v, err := strconv.Atoi(strings.Trim(im, `"`))
+		if err != nil || v < 1 || im != `"`+strconv.Itoa(v)+`"` {
+			return 0, errETagMismatch
+		}
+		if body != nil && *body != v {
+			return 0, errors.New("If-Match and version disagree")
+		}
+		return v, nil
+	case body != nil:
+		if *body < 1 {
+			return 0, errors.New("version must be a positive integer")
+		}
+		return *body, nil
+	}
+	return 0, errNoVersion
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("jobs", expvar.Func(func() any {
+		app := exported.Load()
+		if app == nil || app.jobs == nil {
+			return nil
+		}
+		active, failed := app.jobs.snapshot()
+		return map[string]int{"active": len(active), "failed": len(failed)}
+	}))
+}
+
+// debugMux serves the runtime's introspection endpoints: the profiles of
+// net/http/pprof under /debug/pprof/ and expvar's /debug/vars, which adds
+// goroutine and job counts to the memory statistics.
+var debugMux = func() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}()
+
+// debugHandler serves /debug/ to admins, signed in or with an admin API
+// key.
+func debugHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePermission(w, r, PermAdmin) {
+		return
+	}
+	debugMux.ServeHTTP(w, r)
+}
@@ -0,0 +1,207 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// maxVersionLength caps Deployment.Version, in characters.
+const maxVersionLength = 100
+
+// recentDeployments is how many deployments /environments lists.
+const recentDeployments = 20
+
+func deploymentTarget(id int) string {
+	return "deployment:" + strconv.Itoa(id)
+}
+
+// deploymentInput is the body of POST /api/deployments.
+type deploymentInput struct {
+	Service     string `json:"service"`
+	Environment string `json:"environment"`
+	Version     string `json:"version"`
+}
+
+func (in *deploymentInput) validate() error {
+	errs := fieldErrors{}
+	in.Service = strings.ToLower(strings.TrimSpace(in.Service))
+	in.Environment = strings.ToLower(strings.TrimSpace(in.Environment))
+	in.Version = strings.TrimSpace(in.Version)
+	for field, v := range map[string]string{"service": in.Service, "environment": in.Environment} {
+		if !tagPattern.MatchString(v) {
+			errs.add(field, "must be up to 32 letters, digits, '.', '_' or '-'")
+		}
+	}
+	if in.Version == "" {
+		errs.add("version", "is required")
+	}
+	errs.checkText("version", in.Version, maxVersionLength, false)
+	return errs.err()
+}
+
+// listDeploymentsAPIHandler serves GET /api/deployments: deployments,
+// newest first, optionally by ?service= and ?environment=.
+func (app *App) listDeploymentsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := store.DeploymentFilter{Service: q.Get("service"), Environment: q.Get("environment"), Limit: 50}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			writeJSONError(w, http.StatusBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		f.Limit = n
+	}
+	list, err := app.deployments.ListDeployments(r.Context(), f)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// createDeploymentAPIHandler serves POST /api/deployments, which starts a
+// deployment.
+func (app *App) createDeploymentAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var in deploymentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.validate(); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	ctx := r.Context()
+	d := store.Deployment{Service: in.Service, Environment: in.Environment, Version: in.Version, Status: "running", Actor: auditActor(ctx)}
+	if err := app.deployments.CreateDeployment(ctx, &d); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	app.recordAudit(ctx, "deployment.start", deploymentTarget(d.ID), nil, d)
+	app.announceDeployment(r, d, fmt.Sprintf("deploy of %s %s to %s started by %s", d.Service, d.Version, d.Environment, d.Actor))
+	writeJSON(w, http.StatusCreated, d)
+}
+
+// deploymentID parses the {id} of a deployment route, answering 404 when
+// it is not one.
+func deploymentID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusNotFound, "deployment not found")
+		return 0, false
+	}
+	return id, true
+}
+
+// getDeploymentAPIHandler serves GET /api/deployments/{id}.
+func (app *App) getDeploymentAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := deploymentID(w, r)
+	if !ok {
+		return
+	}
+	d, err := app.deployments.GetDeployment(r.Context(), id)
+	if err != nil {
+		deploymentError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// finishDeploymentAPIHandler serves POST /api/deployments/{id}/finish,
+// which takes {"status": "succeeded"} or {"status": "failed"}.
+func (app *App) finishDeploymentAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := deploymentID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	var in struct {
+		Status string `json:"status"`
+	}
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.Status != "succeeded" && in.Status != "failed" {
+		writeInputError(w, fieldErrors{"status": `must be "succeeded" or "failed"`})
+		return
+	}
+	before, err := app.deployments.GetDeployment(ctx, id)
+	if err == nil {
+		var d store.Deployment
+		if d, err = app.deployments.FinishDeployment(ctx, id, in.Status); err == nil {
+			app.recordAudit(ctx, "deployment.finish", deploymentTarget(id), before, d)
+			if d.Status == "failed" {
+				app.announceDeployment(r, d, fmt.Sprintf("deploy of %s %s to %s failed after %s", d.Service, d.Version, d.Environment, d.Duration().Round(time.Second)))
+			}
+			writeJSON(w, http.StatusOK, d)
+			return
+		}
+	}
+	deploymentError(w, r, err)
+}
+
+func deploymentError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrDeploymentNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, store.ErrDeploymentFinished):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		storeError(w, r, err)
+	}
+}
+
+// announceDeployment posts content to the board, tagged deploy and with the
+// environment. The deployment stands even if the message cannot be saved.
+func (app *App) announceDeployment(r *http.Request, d store.Deployment, content string) {
+	tags, _ := normalizeTags([]string{"deploy", d.Environment})
+	msg := store.Message{Author: "Deploys", Content: content, Tags: tags}
+	if err := app.messages.Create(r.Context(), &msg); err != nil {
+		slog.ErrorContext(r.Context(), "deployment message", "deployment", d.ID, "err", err)
+	}
+}
+
+// environmentsPage is the data behind environments.html: the latest
+// deployment of every service to every environment, and the most recent
+// deployments.
+type environmentsPage struct {
+	Services     []string
+	Environments []string
+	Latest       map[string]map[string]store.Deployment // by service, then environment
+	Recent       []store.Deployment
+}
+
+// environmentsHandler serves GET /environments.
+func (app *App) environmentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	latest, err := app.deployments.LatestDeployments(ctx)
+	page := &environmentsPage{Latest: make(map[string]map[string]store.Deployment)}
+	if err == nil {
+		page.Recent, err = app.deployments.ListDeployments(ctx, store.DeploymentFilter{Limit: recentDeployments})
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	for _, d := range latest {
+		if page.Latest[d.Service] == nil {
+			page.Services = append(page.Services, d.Service)
+			page.Latest[d.Service] = make(map[string]store.Deployment)
+		}
+		page.Latest[d.Service][d.Environment] = d
+		if !slices.Contains(page.Environments, d.Environment) {
+			page.Environments = append(page.Environments, d.Environment)
+		}
+	}
+	slices.Sort(page.Environments)
+	app.renderTemplate(w, r, "environments.html", TemplateData{Title: "Environments", Environments: page, Now: time.Now()})
+}
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// exportWriteTimeout replaces the server's WriteTimeout for exports, which
+// can take a while on a large board.
+const exportWriteTimeout = 10 * time.Minute
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+var csvHeader = []string{"id", "author", "content", "created", "updated", "parent_id", "tags", "pinned"}
+
+// exportHandler serves GET /api/messages/export?format=csv|ndjson. The whole
+// history is streamed oldest first straight from the store.
+func (app *App) exportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+	hidden, err := app.hiddenChannels(r)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="messages-%s.%s"`, time.Now().UTC().Format("20060102-150405"), format))
+
+	write, flush := exportWriter(w, format)
+	err = app.messages.Each(r.Context(), func(m store.Message) error {
+		if slices.Contains(hidden, m.Channel) {
+			return nil
+		}
+		return write(m)
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		// Headers are gone by now; all we can do is log and cut the
+		// response short.
+		slog.ErrorContext(r.Context(), "export", "format", format, "err", err)
+	}
+}
+
+// exportWriter returns functions writing messages to w in format, csv or
+// ndjson, and flushing what is buffered once they are written.
+func exportWriter(w io.Writer, format string) (write func(store.Message) error, flush func() error) {
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		write = func(m store.Message) error { return cw.Write(messageCSVRecord(m)) }
+		flush = func() error { cw.Flush(); return cw.Error() }
+		return write, flush
+	}
+	enc := json.NewEncoder(w)
+	return func(m store.Message) error { return enc.Encode(m) }, func() error { return nil }
+}
+
+func messageCSVRecord(m store.Message) []string {
+	updated := ""
+	if m.Updated != nil {
+		updated = m.Updated.UTC().Format(time.RFC3339Nano)
+	}
+	parent := ""
+	if m.ParentID != 0 {
+		parent = strconv.Itoa(m.ParentID)
+	}
+	return []string{strconv.Itoa(m.ID), m.Author, m.Content, m.Created.UTC().Format(time.RFC3339Nano), updated, parent, strings.Join(m.Tags, ","), strconv.FormatBool(m.Pinned)}
+}
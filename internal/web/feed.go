@@ -0,0 +1,240 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// feedSize is how many of the latest messages the feeds carry.
+const feedSize = 50
+
+const feedTitle = "SLRS-Admin Devops Site"
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	Creator     string   `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Link       atomLink       `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// feedHandler serves /feed.xml (RSS 2.0) and /atom.xml with the latest
+// feedSize messages, newest first; ?tag= narrows them to one tag, e.g. to
+// follow only deploy announcements, and ?channel= to one channel. Readers are expected to poll, so
+// answers carry an ETag and Last-Modified and 304 when nothing changed.
+func (app *App) feedHandler(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(r.URL.Query().Get("tag"))
+	channel := strings.ToLower(r.URL.Query().Get("channel"))
+	hidden, err := app.hiddenChannels(r)
+	var msgs []store.Message
+	if err == nil {
+		msgs, _, err = app.messages.List(r.Context(), store.ListOptions{Tag: tag, Channel: channel, Hidden: hidden, Limit: feedSize})
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	// The store lists pinned messages first; feeds are by date alone.
+	slices.SortStableFunc(msgs, func(a, b store.Message) int { return b.Created.Compare(a.Created) })
+
+	base := app.baseURL(r)
+	self := base + r.URL.RequestURI()
+	title := feedTitle
+	if channel != "" {
+		title += " in #" + channel
+	}
+	if tag != "" {
+		title += " #" + tag
+	}
+	modified := lastModified(msgs)
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	var doc any
+	contentType := "application/rss+xml; charset=utf-8"
+	if r.URL.Path == "/atom.xml" {
+		contentType = "application/atom+xml; charset=utf-8"
+		doc = atomDoc(msgs, base, self, title, modified)
+	} else {
+		doc = rssDoc(msgs, base, self, title, modified)
+	}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		http.Error(w, "Encoding error", http.StatusInternalServerError)
+		return
+	}
+	body = append([]byte(xml.Header), append(body, '\n')...)
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	h.Set("Cache-Control", "private, max-age=300")
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+func rssDoc(msgs []store.Message, base, self, title string, modified time.Time) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          base + "/",
+			Description:   "Deploy and incident announcements from " + feedTitle,
+			Self:          atomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: modified.UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, m := range msgs {
+		link := messageURL(base, m.ID)
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       feedItemTitle(m),
+			Link:        link,
+			GUID:        link,
+			Creator:     authorName(m),
+			Categories:  m.Tags,
+			PubDate:     m.Created.UTC().Format(time.RFC1123Z),
+			Description: string(renderMarkdown(m.Content)),
+		})
+	}
+	return feed
+}
+
+func atomDoc(msgs []store.Message, base, self, title string, modified time.Time) atomFeed {
+	feed := atomFeed{
+		Title:   title,
+		ID:      self,
+		Updated: modified.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+			{Href: base + "/", Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, m := range msgs {
+		updated := m.Created
+		if m.Updated != nil {
+			updated = *m.Updated
+		}
+		e := atomEntry{
+			Title:     feedItemTitle(m),
+			ID:        messageURL(base, m.ID),
+			Published: m.Created.UTC().Format(time.RFC3339),
+			Updated:   updated.UTC().Format(time.RFC3339),
+			Author:    atomPerson{Name: authorName(m)},
+			Link:      atomLink{Href: messageURL(base, m.ID), Rel: "alternate", Type: "text/html"},
+			Content:   atomText{Type: "html", Body: string(renderMarkdown(m.Content))},
+		}
+		if m.Author != "" {
+			e.Author.URI = base + profileURL(m.Author)
+		}
+		for _, t := range m.Tags {
+			e.Categories = append(e.Categories, atomCategory{Term: t})
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	return feed
+}
+
+// messageURL links to a message on the index page.
+func messageURL(base string, id int) string {
+	return base + "/#message-" + strconv.Itoa(id)
+}
+
+func authorName(m store.Message) string {
+	if m.Author == "" {
+		return "Anonymous"
+	}
+	return m.Author
+}
+
+// feedItemTitle is the first line of a message, shortened to 80
+// characters.
+func feedItemTitle(m store.Message) string {
+	line, _, _ := strings.Cut(m.Content, "\n")
+	line = strings.TrimSpace(strings.TrimLeft(line, "#> "))
+	if utf8.RuneCountInString(line) > 80 {
+		line = string([]rune(line)[:79]) + "…"
+	}
+	if line == "" {
+		line = "Message #" + strconv.Itoa(m.ID)
+	}
+	return line
+}
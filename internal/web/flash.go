@@ -0,0 +1,74 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// flashCookie carries a Flash across the redirect that follows a form to
+// the page rendered next, which clears it. It is not signed: what it
+// holds is only ever shown, escaped, to the client that sent it.
+const flashCookie = "slrs_flash"
+
+// The levels of a Flash, which style it.
+const (
+	flashSuccess = "success"
+	flashError   = "error"
+)
+
+// Flash is a one-off notice atop a page, the outcome of the form just
+// submitted.
+type Flash struct {
+	Level string // flashSuccess or flashError
+	Text  string
+}
+
+// errorFlash is a Flash for a form shown again with what was wrong with it.
+func errorFlash(text string) *Flash {
+	return &Flash{Level: flashError, Text: text}
+}
+
+// setFlash leaves text for the next page r's client is shown, normally
+// the one it is about to be redirected to.
+func setFlash(w http.ResponseWriter, r *http.Request, level, text string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    url.Values{"level": {level}, "text": {text}}.Encode(),
+		Path:     "/",
+		MaxAge:   5 * 60,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// redirectWithFlash redirects to target with text left for it.
+func redirectWithFlash(w http.ResponseWriter, r *http.Request, target, level, text string) {
+	setFlash(w, r, level, text)
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// takeFlash returns the Flash setFlash left for r, if any, and clears it.
+func takeFlash(w http.ResponseWriter, r *http.Request) *Flash {
+	c, err := r.Cookie(flashCookie)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	v, err := url.ParseQuery(c.Value)
+	if err != nil || v.Get("text") == "" {
+		return nil
+	}
+	level := v.Get("level")
+	if level != flashSuccess {
+		level = flashError
+	}
+	return &Flash{Level: level, Text: v.Get("text")}
+}
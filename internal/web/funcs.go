@@ -0,0 +1,138 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"example.com/go-sample-site/internal/i18n"
+	"example.com/go-sample-site/internal/store"
+)
+
+// templateFuncs returns the functions available to every page template.
+func (app *App) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"build":         func() BuildInfo { return app.build },
+		"byteSize":      byteSize,
+		"channelAccess": channelAccess,
+		"contains":      slices.Contains[[]string],
+		"datetime":      datetime,
+		"formTime":      formTime,
+		"highlight":     highlight,
+		"incidentItem":  incidentItem,
+		"isImage":       isImage,
+		"join":          strings.Join,
+		"languages":     func() []i18n.Language { return i18n.Languages },
+		"markdown":      renderMarkdown,
+		"mentionsUser":  mentionsUser,
+		"percent":       percent,
+		"plural":        plural,
+		"profileURL":    profileURL,
+		"reactionKinds": func() []reactionKind { return reactionKinds },
+		"safeURL":       safeURL,
+		"threadItem":    threadItem,
+		"truncate":      truncate,
+		"userAvatar":    func(u *store.User) string { return avatarURL(u, "") },
+	}
+}
+
+// threadItem pairs a message node with the page so the recursive "message"
+// template in index.html can still reach the query, admin flag and CSRF
+// token.
+func threadItem(n *ThreadNode, page TemplateData) threadView {
+	return threadView{n, page}
+}
+
+// incidentItem does the same for the "incident" template in
+// incidents.html.
+func incidentItem(inc store.Incident, page TemplateData) incidentView {
+	return incidentView{inc, page}
+}
+
+// channelAccess pairs the roles offered on /channels with the channel whose
+// access form is shown, if any, for the "access" template.
+func channelAccess(roles []string, c ...store.Channel) accessView {
+	v := accessView{Roles: roles}
+	if len(c) > 0 {
+		v.Channel = &c[0]
+	}
+	return v
+}
+
+// mentionsUser reports whether m mentions the signed-in user u.
+func mentionsUser(m store.Message, u *store.User) bool {
+	return u != nil && m.Author != u.Name && mentioned(m, u.Name)
+}
+
+// percent is n as a whole percentage of total, for bar widths.
+func percent(n, total int) int {
+	if total <= 0 {
+		return 0
+	}
+	return n * 100 / total
+}
+
+// highlight HTML-escapes text and wraps case-insensitive matches of q in
+// <mark> elements.
+func highlight(text, q string) template.HTML {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+	re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(q))
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// datetime formats t for the datetime attribute of a <time> element.
+func datetime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// truncate shortens s to at most n characters, ending it with an
+// ellipsis when anything was cut.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return strings.TrimSpace(string(r[:max(n-1, 0)])) + "…"
+}
+
+// plural formats n with the singular or plural form of a noun, as in
+// "1 reply" and "3 replies".
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// safeURL passes on relative URLs and those with an http, https or mailto
+// scheme as trusted, so that html/template leaves them alone; anything
+// else becomes "#".
+func safeURL(s string) template.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "#"
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return template.URL(s)
+	}
+	return "#"
+}
@@ -0,0 +1,164 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+const maxWebhookBody = 5 << 20
+
+// githubEventTags tags the messages each event produces so the board can be
+// filtered to them.
+var githubEventTags = map[string][]string{
+	"push":              {"github", "push"},
+	"release":           {"github", "release"},
+	"deployment":        {"github", "deploy"},
+	"deployment_status": {"github", "deploy"},
+}
+
+// githubHookHandler serves POST /hooks/github. It checks the
+// X-Hub-Signature-256 HMAC and turns push, release and deployment events
+// into board messages so the board doubles as a deployment feed.
+func (app *App) githubHookHandler(w http.ResponseWriter, r *http.Request) {
+	if app.githubSecret == "" {
+		writeJSONError(w, http.StatusServiceUnavailable, "GitHub webhook secret not configured")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "payload too large")
+		return
+	}
+	if !app.validGitHubSignature(r.Header.Get("X-Hub-Signature-256"), body) {
+		writeJSONError(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+	// Hooks can be configured to send the JSON form-encoded as "payload".
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad form body")
+			return
+		}
+		body = []byte(form.Get("payload"))
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "ping" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "pong"})
+		return
+	}
+	content, err := formatGitHubEvent(event, body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if content == "" {
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "ignored", "event": event})
+		return
+	}
+	msg := store.Message{Author: "GitHub", Content: content, Tags: githubEventTags[event]}
+	if err := app.messages.Create(withAuditActor(r.Context(), "github"), &msg); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "github event", "event", event, "delivery", r.Header.Get("X-GitHub-Delivery"), "message", msg.ID)
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+func (app *App) validGitHubSignature(header string, body []byte) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(app.githubSecret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// githubPayload holds the fields used from the event types we handle.
+type githubPayload struct {
+	Action     string `json:"action"`
+	Ref        string `json:"ref"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	Pusher struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+	Commits    []json.RawMessage `json:"commits"`
+	HeadCommit *struct {
+		Message string `json:"message"`
+	} `json:"head_commit"`
+	Release struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"release"`
+	Deployment struct {
+		Ref         string `json:"ref"`
+		Environment string `json:"environment"`
+		Creator     struct {
+			Login string `json:"login"`
+		} `json:"creator"`
+	} `json:"deployment"`
+	DeploymentStatus struct {
+		State string `json:"state"`
+	} `json:"deployment_status"`
+}
+
+// formatGitHubEvent renders a supported event as message text. Unsupported
+// events and actions yield "".
+func formatGitHubEvent(event string, body []byte) (string, error) {
+	var p githubPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return "", fmt.Errorf("Bad JSON: %v", err)
+	}
+	repo := p.Repository.FullName
+	switch event {
+	case "push":
+		branch := strings.TrimPrefix(strings.TrimPrefix(p.Ref, "refs/heads/"), "refs/tags/")
+		s := fmt.Sprintf("push to %s (%s) by %s: %d commit(s)", repo, branch, p.Pusher.Name, len(p.Commits))
+		if p.HeadCommit != nil {
+			s += " — " + firstLine(p.HeadCommit.Message)
+		}
+		return s, nil
+	case "release":
+		if p.Action != "published" {
+			return "", nil
+		}
+		name := p.Release.TagName
+		if p.Release.Name != "" && p.Release.Name != name {
+			name += " (" + p.Release.Name + ")"
+		}
+		return fmt.Sprintf("release %s of %s published by %s %s", name, repo, p.Sender.Login, p.Release.HTMLURL), nil
+	case "deployment":
+		return fmt.Sprintf("deploy of %s (%s) to %s by %s", repo, p.Deployment.Ref, p.Deployment.Environment, p.Deployment.Creator.Login), nil
+	case "deployment_status":
+		return fmt.Sprintf("deploy of %s (%s) to %s: %s", repo, p.Deployment.Ref, p.Deployment.Environment, p.DeploymentStatus.State), nil
+	}
+	return "", nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
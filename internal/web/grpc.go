@@ -0,0 +1,278 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	slrsv1 "example.com/go-sample-site/api/proto/slrs/v1"
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RegisterGRPC adds the board's gRPC services to s; see
+// api/proto/slrs/v1/messages.proto.
+func (app *App) RegisterGRPC(s grpc.ServiceRegistrar) {
+	slrsv1.RegisterMessageServiceServer(s, messageService{app: app})
+}
+
+// messageService is the gRPC MessageService. Its calls go through the
+// same stores and checks as the JSON API's, so they are audited, counted
+// and broadcast alike.
+type messageService struct {
+	slrsv1.UnimplementedMessageServiceServer
+	app *App
+}
+
+// grpcRequest stands in for the HTTP request a call would have been, so
+// that the API's checks apply to it as they are: it carries the caller's
+// key or token from the metadata and address, and has passed maintenance
+// mode, the caller's quota and need. The error is a status for the
+// client.
+func (app *App) grpcRequest(ctx context.Context, need Permission) (*http.Request, error) {
+	method, _ := grpc.Method(ctx)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, method, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, h := range []string{"Authorization", "X-API-Key"} {
+		if v := md.Get(h); len(v) > 0 {
+			r.Header.Set(h, v[0])
+		}
+	}
+	r = app.withAnonymousScopes(r.WithContext(context.WithValue(r.Context(), auditIPKey{}, middleware.ClientIP(r))))
+	if r, err = app.withAPIPrincipal(r); err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if m := app.maintenance.Load(); m != nil && !can(r, PermAdmin) {
+		return nil, status.Error(codes.Unavailable, strings.TrimSuffix("down for maintenance: "+m.Message, ": "))
+	}
+	if subject := quotaSubject(r); app.quotas != nil && subject != "" {
+		if _, ok, err := app.quotas.take(ctx, subject); err != nil {
+			slog.ErrorContext(ctx, "quota", "err", err)
+		} else if !ok {
+			return nil, status.Error(codes.ResourceExhausted, "API quota exceeded")
+		}
+	}
+	if !can(r, need) {
+		switch {
+		case currentAPIKey(r) != nil:
+			return nil, status.Error(codes.PermissionDenied, "API key lacks the "+permissionScope[need]+" scope")
+		case currentUser(r) == nil:
+			return nil, status.Error(codes.Unauthenticated, "authentication required")
+		default:
+			return nil, status.Error(codes.PermissionDenied, string(need)+" permission required")
+		}
+	}
+	return r, nil
+}
+
+// grpcError is storeError and writeInputError for gRPC.
+func grpcError(ctx context.Context, err error) error {
+	var fe fieldErrors
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return status.Error(codes.NotFound, "message not found")
+	case errors.Is(err, store.ErrParentNotFound):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &fe):
+		st := status.New(codes.InvalidArgument, "invalid input: "+fe.Error())
+		details := &errdetails.BadRequest{}
+		for field, problem := range fe {
+			details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: field, Description: problem})
+		}
+		slices.SortFunc(details.FieldViolations, func(a, b *errdetails.BadRequest_FieldViolation) int { return strings.Compare(a.Field, b.Field) })
+		if withDetails, err := st.WithDetails(details); err == nil {
+			st = withDetails
+		}
+		return st.Err()
+	}
+	slog.ErrorContext(ctx, "store", "err", err)
+	return status.Error(codes.Internal, "store error")
+}
+
+func (s messageService) ListMessages(ctx context.Context, req *slrsv1.ListMessagesRequest) (*slrsv1.ListMessagesResponse, error) {
+	r, err := s.app.grpcRequest(ctx, PermRead)
+	if err != nil {
+		return nil, err
+	}
+	opts := store.ListOptions{
+		Limit:   int(req.GetPageSize()),
+		Author:  req.GetAuthor(),
+		Tag:     strings.ToLower(req.GetTag()),
+		Channel: strings.ToLower(req.GetChannel()),
+	}
+	if opts.Limit == 0 {
+		opts.Limit = defaultPerPage
+	}
+	if opts.Limit < 0 || opts.Limit > maxPerPage {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be between 1 and %d", maxPerPage)
+	}
+	// The token is the offset of the page, which is all the store needs.
+	if t := req.GetPageToken(); t != "" {
+		if opts.Offset, err = strconv.Atoi(t); err != nil || opts.Offset < 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+	}
+	if req.GetSince() != nil {
+		opts.Since = req.GetSince().AsTime()
+	}
+	if opts.Hidden, err = s.app.hiddenChannels(r); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	msgs, total, err := s.app.messages.List(r.Context(), opts)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	resp := &slrsv1.ListMessagesResponse{TotalSize: int32(total)}
+	for _, m := range msgs {
+		resp.Messages = append(resp.Messages, messageProto(m))
+	}
+	if next := opts.Offset + len(msgs); len(msgs) > 0 && next < total {
+		resp.NextPageToken = strconv.Itoa(next)
+	}
+	return resp, nil
+}
+
+func (s messageService) GetMessage(ctx context.Context, req *slrsv1.GetMessageRequest) (*slrsv1.Message, error) {
+	r, err := s.app.grpcRequest(ctx, PermRead)
+	if err != nil {
+		return nil, err
+	}
+	id := int(req.GetId())
+	if err := s.app.checkMessageAccess(r, id); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	msg, err := s.app.messages.Get(r.Context(), id)
+	if err == nil && !msg.Visible(time.Now()) && !can(r, PermModerate) {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return messageProto(msg), nil
+}
+
+func (s messageService) CreateMessage(ctx context.Context, req *slrsv1.CreateMessageRequest) (*slrsv1.Message, error) {
+	r, err := s.app.grpcRequest(ctx, PermPost)
+	if err != nil {
+		return nil, err
+	}
+	if s.app.postLimiter != nil {
+		ok, _, err := s.app.postLimiter.allow(ctx, middleware.ClientIP(r))
+		if err != nil {
+			slog.ErrorContext(ctx, "rate limit", "err", err)
+		} else if !ok {
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+	}
+	msg := store.Message{
+		Author:    req.GetAuthor(),
+		Email:     req.GetEmail(),
+		Content:   req.GetContent(),
+		ParentID:  int(req.GetParentId()),
+		Tags:      req.GetTags(),
+		Channel:   req.GetChannel(),
+		PublishAt: timeOf(req.GetPublishAt()),
+		ExpiresAt: timeOf(req.GetExpiresAt()),
+	}
+	if _, err := s.app.checkNewMessage(r, &msg, nil); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	if err := s.app.messages.Create(r.Context(), &msg); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return messageProto(msg), nil
+}
+
+var eventTypes = map[string]slrsv1.MessageEvent_Type{
+	"created":  slrsv1.MessageEvent_TYPE_CREATED,
+	"updated":  slrsv1.MessageEvent_TYPE_UPDATED,
+	"deleted":  slrsv1.MessageEvent_TYPE_DELETED,
+	"restored": slrsv1.MessageEvent_TYPE_RESTORED,
+}
+
+// WatchMessages is GET /api/messages/stream for gRPC, without the
+// mentions.
+func (s messageService) WatchMessages(req *slrsv1.WatchMessagesRequest, stream grpc.ServerStreamingServer[slrsv1.MessageEvent]) error {
+	r, err := s.app.grpcRequest(stream.Context(), PermRead)
+	if err != nil {
+		return err
+	}
+	channel := strings.ToLower(req.GetChannel())
+	events := s.app.live.Subscribe()
+	defer s.app.live.Unsubscribe(events)
+	// Headers go out now, so that the client knows it is watching.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "the server is shutting down")
+			}
+			if ok, _ := s.app.canReadMessage(r, ev.Message); !ok {
+				continue
+			}
+			if channel != "" && ev.Message.Channel != "" && ev.Message.Channel != channel {
+				continue
+			}
+			if err := stream.Send(&slrsv1.MessageEvent{Type: eventTypes[ev.Type], Message: messageProto(ev.Message)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func messageProto(m store.Message) *slrsv1.Message {
+	return &slrsv1.Message{
+		Id:        int64(m.ID),
+		Channel:   m.Channel,
+		Author:    m.Author,
+		Content:   m.Content,
+		Created:   timestampOf(&m.Created),
+		Updated:   timestampOf(m.Updated),
+		ParentId:  int64(m.ParentID),
+		Tags:      m.Tags,
+		Pinned:    m.Pinned,
+		Revisions: int32(m.Revisions),
+		PublishAt: timestampOf(m.PublishAt),
+		ExpiresAt: timestampOf(m.ExpiresAt),
+	}
+}
+
+// timestampOf and timeOf convert the optional times; nil stays nil.
+func timestampOf(t *time.Time) *timestamppb.Timestamp {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func timeOf(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
@@ -0,0 +1,54 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// healthzHandler is the liveness probe: the process is up and serving.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// healthCheckNames returns the names of healthChecks, sorted.
+func (app *App) healthCheckNames() []string {
+	return slices.Sorted(maps.Keys(app.healthChecks))
+}
+
+// runHealthCheck runs the named check with the probes' 2s timeout.
+func (app *App) runHealthCheck(ctx context.Context, name string) error {
+	check, ok := app.healthChecks[name]
+	if !ok {
+		return errors.New("unknown check")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return check(ctx)
+}
+
+// readyzHandler is the readiness probe. It reports each check and answers
+// 503 if any of them fail.
+func (app *App) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ok := true
+	for name := range app.healthChecks {
+		checks[name] = "ok"
+		if err := app.runHealthCheck(r.Context(), name); err != nil {
+			checks[name] = err.Error()
+			ok = false
+		}
+	}
+	if app.draining.Load() {
+		checks["server"] = "shutting down"
+		ok = false
+	}
+	status, code := "ok", http.StatusOK
+	if !ok {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// revisionsAPIHandler serves GET /api/messages/{id}/revisions: the earlier
+// versions of a message, oldest first. The current version is the message
+// itself.
+func (app *App) revisionsAPIHandler(w http.ResponseWriter, r *http.Request, id int) {
+	revs, err := app.messages.Revisions(r.Context(), id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, revs)
+}
+
+// historyEntry is one version on the history page with its changes from
+// the version before.
+type historyEntry struct {
+	store.Revision
+	Current bool
+	Diff    []diffOp // nil for the original post
+}
+
+// historyHandler serves GET /messages/{id}/history, every version of a
+// message newest first, each diffed against the one it replaced.
+func (app *App) historyHandler(w http.ResponseWriter, r *http.Request, id int) {
+	msg, err := app.messages.Get(r.Context(), id)
+	if err == nil {
+		var revs []store.Revision
+		if revs, err = app.messages.Revisions(r.Context(), id); err == nil {
+			data := TemplateData{Title: "Message history", Message: &msg, History: messageHistory(msg, revs), Now: time.Now()}
+			app.renderTemplate(w, r, "history.html", data)
+			return
+		}
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	http.Error(w, "Store error", http.StatusInternalServerError)
+	slog.ErrorContext(r.Context(), "store", "err", err)
+}
+
+func messageHistory(msg store.Message, revs []store.Revision) []historyEntry {
+	versions := append(revs, store.RevisionOf(msg, msg.Revisions+1))
+	entries := make([]historyEntry, len(versions))
+	for i, v := range versions {
+		e := historyEntry{Revision: v, Current: i == len(versions)-1}
+		if i > 0 {
+			e.Diff = diffWords(versions[i-1].Content, v.Content)
+		}
+		entries[len(versions)-1-i] = e
+	}
+	return entries
+}
+
+// diffOp is a run of text that both versions share ("same"), or that only
+// the old ("del") or new ("ins") one has.
+type diffOp struct {
+	Kind string
+	Text string
+}
+
+var diffTokens = regexp.MustCompile(`\s+|[^\s]+`)
+
+// maxDiffCells bounds the LCS table; past it the diff is a plain
+// replacement.
+const maxDiffCells = 1 << 20
+
+// diffWords compares a and b word by word, keeping whitespace so the
+// result reads like the original text.
+func diffWords(a, b string) []diffOp {
+	x, y := diffTokens.FindAllString(a, -1), diffTokens.FindAllString(b, -1)
+	if (len(x)+1)*(len(y)+1) > maxDiffCells {
+		return []diffOp{{"del", a}, {"ins", b}}
+	}
+	// lcs[i][j] is the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []diffOp
+	add := func(kind, text string) {
+		if n := len(ops); n > 0 && ops[n-1].Kind == kind {
+			ops[n-1].Text += text
+			return
+		}
+		ops = append(ops, diffOp{kind, text})
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			add("same", x[i])
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			add("del", x[i])
+			i++
+		default:
+			add("ins", y[j])
+			j++
+		}
+	}
+	return ops
+}
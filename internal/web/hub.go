@@ -0,0 +1,243 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// Event describes a change to a message. Type is "created", "updated",
+// "deleted" or "restored"; for deletions only Message.ID is set.
+type Event struct {
+	Type    string        `json:"type"`
+	Message store.Message `json:"message"`
+}
+
+// Hub fans events out to any number of subscribers. A subscriber that falls
+// behind misses events rather than stalling the publisher, unless it came
+// through SubscribeAll.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	queues []*eventQueue
+	closed bool
+}
+
+func newHub() *Hub {
+	return &Hub{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel of future events. It is closed by Unsubscribe
+// or when the hub shuts down.
+func (h *Hub) Subscribe() chan Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan Event, 16)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+// SubscribeAll is Subscribe for the server's own consumers, the notifiers
+// and the relay, which must see every event: what they have not taken yet
+// waits in memory, however much piles up. The channel is closed once the
+// hub has shut down and the subscriber has taken everything before that.
+func (h *Hub) SubscribeAll() <-chan Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	q := &eventQueue{wake: make(chan struct{}, 1), out: make(chan Event)}
+	if h.closed {
+		close(q.out)
+		return q.out
+	}
+	h.queues = append(h.queues, q)
+	go q.run()
+	return q.out
+}
+
+func (h *Hub) Unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *Hub) Publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	for _, q := range h.queues {
+		q.push(ev)
+	}
+}
+
+// Close disconnects every subscriber; used during server shutdown so
+// long-lived streams do not hold it up.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+	for _, q := range h.queues {
+		q.close()
+	}
+	h.queues = nil
+}
+
+// eventQueue holds a SubscribeAll subscriber's events until it takes them
+// from out.
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []Event
+	closed  bool
+	wake    chan struct{} // signalled by push and close
+	out     chan Event
+}
+
+func (q *eventQueue) push(ev Event) {
+	q.mu.Lock()
+	q.pending = append(q.pending, ev)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run hands the pending events to out, in order, until the queue is
+// closed and empty.
+func (q *eventQueue) run() {
+	for {
+		q.mu.Lock()
+		batch, closed := q.pending, q.closed
+		q.pending = nil
+		q.mu.Unlock()
+		for _, ev := range batch {
+			q.out <- ev
+		}
+		if len(batch) == 0 {
+			if closed {
+				close(q.out)
+				return
+			}
+			<-q.wake
+		}
+	}
+}
+
+// broadcastStore publishes an Event to hub after every successful write.
+type broadcastStore struct {
+	store.MessageStore
+	hub *Hub
+}
+
+// Create announces msg unless it is scheduled for later; the scheduler
+// announces it then.
+func (s *broadcastStore) Create(ctx context.Context, msg *store.Message) error {
+	if err := s.MessageStore.Create(ctx, msg); err != nil {
+		return err
+	}
+	if msg.Visible(time.Now()) {
+		s.hub.Publish(Event{Type: "created", Message: *msg})
+	}
+	return nil
+}
+
+// CreateBatch announces only messages created now; imported history that
+// arrives with its own Created time is not news, and scheduled messages
+// wait for the scheduler.
+func (s *broadcastStore) CreateBatch(ctx context.Context, msgs []*store.Message) error {
+	fresh := make([]bool, len(msgs))
+	for i, msg := range msgs {
+		fresh[i] = msg.Created.IsZero()
+	}
+	if err := s.MessageStore.CreateBatch(ctx, msgs); err != nil {
+		return err
+	}
+	now := time.Now()
+	for i, msg := range msgs {
+		if fresh[i] && msg.Visible(now) {
+			s.hub.Publish(Event{Type: "created", Message: *msg})
+		}
+	}
+	return nil
+}
+
+// Update announces an edit that moves the message's schedule across the
+// present as a creation or deletion, and keeps quiet about edits to
+// messages nobody can see yet.
+func (s *broadcastStore) Update(ctx context.Context, msg *store.Message) error {
+	before, err := s.MessageStore.Get(ctx, msg.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.MessageStore.Update(ctx, msg); err != nil {
+		return err
+	}
+	now := time.Now()
+	switch was, is := before.Visible(now), msg.Visible(now); {
+	case is && !was:
+		s.hub.Publish(Event{Type: "created", Message: *msg})
+	case was && !is:
+		s.hub.Publish(Event{Type: "deleted", Message: store.Message{ID: msg.ID}})
+	case is:
+		s.hub.Publish(Event{Type: "updated", Message: *msg})
+	}
+	return nil
+}
+
+func (s *broadcastStore) SetPinned(ctx context.Context, id int, pinned bool) error {
+	if err := s.MessageStore.SetPinned(ctx, id, pinned); err != nil {
+		return err
+	}
+	if msg, err := s.MessageStore.Get(ctx, id); err == nil {
+		s.hub.Publish(Event{Type: "updated", Message: msg})
+	}
+	return nil
+}
+
+func (s *broadcastStore) Delete(ctx context.Context, id int) error {
+	if err := s.MessageStore.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.hub.Publish(Event{Type: "deleted", Message: store.Message{ID: id}})
+	return nil
+}
+
+// Restore announces the message as "restored" rather than "created", so
+// it reappears on open pages without being sent to Slack as new.
+func (s *broadcastStore) Restore(ctx context.Context, id int) error {
+	if err := s.MessageStore.Restore(ctx, id); err != nil {
+		return err
+	}
+	if msg, err := s.MessageStore.Get(ctx, id); err == nil && msg.Visible(time.Now()) {
+		s.hub.Publish(Event{Type: "restored", Message: msg})
+	}
+	return nil
+}
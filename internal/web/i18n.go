@@ -0,0 +1,37 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"example.com/go-sample-site/internal/i18n"
+)
+
+// langCookie keeps the language picked with ?lang= for the pages that
+// follow.
+const langCookie = "slrs_lang"
+
+// pageLocale returns the language r's page is shown in: the one asked for
+// with ?lang=, which is remembered, or else the one remembered before, or
+// else the best match for Accept-Language.
+func pageLocale(w http.ResponseWriter, r *http.Request) *i18n.Locale {
+	w.Header().Add("Vary", "Accept-Language")
+	if l, ok := i18n.Lookup(r.URL.Query().Get("lang")); ok {
+		setPreferenceCookie(w, r, langCookie, l.Code())
+		return l
+	}
+	var lang string
+	if c, err := r.Cookie(langCookie); err == nil {
+		lang = c.Value
+	}
+	return i18n.Negotiate(lang, r.Header.Get("Accept-Language"))
+}
+
+// T translates key for the page, formatting args with it; see
+// i18n.Locale.T.
+func (d TemplateData) T(key string, args ...any) string {
+	if d.Locale == nil {
+		return fmt.Sprintf(key, args...)
+	}
+	return d.Locale.T(key, args...)
+}
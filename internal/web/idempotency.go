@@ -0,0 +1,180 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/go-sample-site/internal/middleware"
+)
+
+// idempotencyTTL is how long an Idempotency-Key is remembered: long enough
+// for a CI job to be retried or rerun, short enough that the keys of every
+// post ever made are not kept.
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyClaimTTL is how long a key stays claimed by a request that
+// neither completes nor releases it, say on a replica that died.
+const idempotencyClaimTTL = 5 * time.Minute
+
+// maxIdempotencyKey is the longest Idempotency-Key accepted.
+const maxIdempotencyKey = 255
+
+// idempotencyRecord is what a claimed key stands for: the request that
+// claimed it, by a hash of its body, and the message it created, 0 while
+// that request is still running.
+type idempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	MessageID   int    `json:"message_id"`
+}
+
+// idempotencyStore remembers the Idempotency-Keys of POST /api/messages
+// for idempotencyTTL.
+type idempotencyStore interface {
+	// claim takes key for a request with fingerprint, for
+	// idempotencyClaimTTL. If the key is already taken it returns what it
+	// was taken with instead.
+	claim(ctx context.Context, key, fingerprint string) (*idempotencyRecord, error)
+	// complete records the message the request that claimed key created.
+	complete(ctx context.Context, key string, rec idempotencyRecord) error
+	// release frees key after its request failed, for a retry to claim.
+	release(ctx context.Context, key string) error
+}
+
+// memoryIdempotency keeps the keys in process memory, so each replica
+// knows only the keys it was sent.
+type memoryIdempotency struct {
+	mu   sync.Mutex
+	keys map[string]memoryIdempotencyKey
+}
+
+type memoryIdempotencyKey struct {
+	idempotencyRecord
+	expires time.Time
+}
+
+func newMemoryIdempotency() *memoryIdempotency {
+	return &memoryIdempotency{keys: make(map[string]memoryIdempotencyKey)}
+}
+
+func (s *memoryIdempotency) claim(ctx context.Context, key, fingerprint string) (*idempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.keys[key]; ok && time.Now().Before(k.expires) {
+		rec := k.idempotencyRecord
+		return &rec, nil
+	}
+	s.keys[key] = memoryIdempotencyKey{idempotencyRecord{Fingerprint: fingerprint}, time.Now().Add(idempotencyClaimTTL)}
+	return nil, nil
+}
+
+func (s *memoryIdempotency) complete(ctx context.Context, key string, rec idempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = memoryIdempotencyKey{rec, time.Now().Add(idempotencyTTL)}
+	return nil
+}
+
+func (s *memoryIdempotency) release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+// sweep forgets keys that have expired.
+func (s *memoryIdempotency) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, k := range s.keys {
+		if !now.Before(k.expires) {
+			delete(s.keys, key)
+		}
+	}
+}
+
+// idempotencyClaim is a request's hold on its Idempotency-Key. The zero
+// value, for a request without one, does nothing.
+type idempotencyClaim struct {
+	store       idempotencyStore
+	key         string
+	fingerprint string
+}
+
+// finish completes the claim with the message id, or releases it if the
+// request failed, which id 0 says. It goes ahead if the client has hung up: the
+// message is there for its retry all the same.
+func (c idempotencyClaim) finish(ctx context.Context, id int) {
+	if c.store == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	var err error
+	if id != 0 {
+		err = c.store.complete(ctx, c.key, idempotencyRecord{Fingerprint: c.fingerprint, MessageID: id})
+	} else {
+		err = c.store.release(ctx, c.key)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "idempotency key", "err", err)
+	}
+}
+
+// claimIdempotencyKey claims r's Idempotency-Key, if it has one, for the
+// caller. in, the request as decoded, is its fingerprint: the fields
+// rather than the body, which as multipart differs with every boundary.
+// A key used before answers for r: with the message it created, 409 while
+// its request is running, or 422 if it came with different fields. Then,
+// and on errors, it reports false.
+func (app *App) claimIdempotencyKey(w http.ResponseWriter, r *http.Request, in any) (idempotencyClaim, bool) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" || app.idempotency == nil {
+		return idempotencyClaim{}, true
+	}
+	if len(key) > maxIdempotencyKey {
+		writeJSONError(w, http.StatusBadRequest, "Idempotency-Key too long")
+		return idempotencyClaim{}, false
+	}
+	fields, err := json.Marshal(in)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "encoding failed")
+		return idempotencyClaim{}, false
+	}
+	sum := sha256.Sum256(fields)
+	// Keys are the caller's own: the same key from someone else is another.
+	subject := quotaSubject(r)
+	if subject == "" {
+		subject = "ip:" + middleware.ClientIP(r)
+	}
+	c := idempotencyClaim{app.idempotency, subject + "\x00" + key, hex.EncodeToString(sum[:])}
+	ctx := r.Context()
+	prev, err := c.store.claim(ctx, c.key, c.fingerprint)
+	switch {
+	case err != nil:
+		// Without the store posts go through as they did before keys.
+		slog.ErrorContext(ctx, "idempotency key", "err", err)
+		return idempotencyClaim{}, true
+	case prev == nil:
+		return c, true
+	case prev.Fingerprint != c.fingerprint:
+		writeJSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was used for a different request")
+		return idempotencyClaim{}, false
+	case prev.MessageID == 0:
+		writeJSONError(w, http.StatusConflict, "a request with this Idempotency-Key is in progress")
+		return idempotencyClaim{}, false
+	}
+	msg, err := app.messages.Get(ctx, prev.MessageID)
+	if err != nil {
+		storeError(w, r, err)
+		return idempotencyClaim{}, false
+	}
+	inZone(requestZone(r), &msg)
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeJSON(w, http.StatusCreated, msg)
+	return idempotencyClaim{}, false
+}
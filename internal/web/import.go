@@ -0,0 +1,217 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+const maxImportSize = 10 << 20
+
+// importRow is one record of an import file before validation.
+type importRow struct {
+	Author  string   `json:"author"`
+	Content string   `json:"content"`
+	Created string   `json:"created"`
+	Tags    []string `json:"tags"`
+}
+
+// ImportError reports why one row of an import file was rejected. Row
+// numbers start at 1 and, for CSV, do not count the header.
+type ImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportResult summarises an import. Nothing is stored unless every row is
+// valid.
+type ImportResult struct {
+	Imported int           `json:"imported"`
+	Errors   []ImportError `json:"errors,omitempty"`
+}
+
+// adminImportHandler serves /admin/import. GET shows the upload form; POST
+// takes a multipart "file" in JSON (an array), NDJSON or CSV (with an
+// author,content[,created] header, as produced by the export) and inserts
+// its messages in one transaction. Clients asking for JSON get the
+// ImportResult back as JSON instead of a page.
+func (app *App) adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	data := TemplateData{Title: "Import messages", Now: time.Now()}
+	if r.Method != http.MethodPost {
+		app.renderTemplate(w, r, "import.html", data)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		data.Flash = errorFlash("Choose a file of at most 10 MB to import.")
+		w.WriteHeader(http.StatusBadRequest)
+		app.renderTemplate(w, r, "import.html", data)
+		return
+	}
+	defer file.Close()
+
+	res, status := app.importMessages(r.Context(), file, header.Filename)
+	if wantsJSON(r) {
+		writeJSON(w, status, res)
+		return
+	}
+	data.Import = &res
+	w.WriteHeader(status)
+	app.renderTemplate(w, r, "import.html", data)
+}
+
+func (app *App) importMessages(ctx context.Context, file io.Reader, filename string) (ImportResult, int) {
+	rows, err := readImportFile(file, filename)
+	if err != nil {
+		return ImportResult{Errors: []ImportError{{Row: 0, Error: err.Error()}}}, http.StatusBadRequest
+	}
+	var res ImportResult
+	msgs := make([]*store.Message, 0, len(rows))
+	for i, row := range rows {
+		msg, err := row.message()
+		if err != nil {
+			res.Errors = append(res.Errors, ImportError{Row: i + 1, Error: err.Error()})
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(res.Errors) > 0 {
+		return res, http.StatusUnprocessableEntity
+	}
+	if len(msgs) == 0 {
+		return ImportResult{Errors: []ImportError{{Row: 0, Error: "file contains no messages"}}}, http.StatusBadRequest
+	}
+	if err := app.messages.CreateBatch(ctx, msgs); err != nil {
+		slog.ErrorContext(ctx, "import", "err", err)
+		return ImportResult{Errors: []ImportError{{Row: 0, Error: "Store error"}}}, http.StatusInternalServerError
+	}
+	slog.InfoContext(ctx, "imported messages", "count", len(msgs), "file", filename)
+	return ImportResult{Imported: len(msgs)}, http.StatusOK
+}
+
+func (row importRow) message() (*store.Message, error) {
+	msg := &store.Message{Author: row.Author, Content: row.Content, Tags: row.Tags}
+	if err := validateMessage(msg); err != nil {
+		return nil, err
+	}
+	if row.Created != "" {
+		t, err := time.Parse(time.RFC3339, row.Created)
+		if err != nil {
+			return nil, fmt.Errorf("created %q is not an RFC 3339 timestamp", row.Created)
+		}
+		if t.After(time.Now()) {
+			return nil, errors.New("created is in the future")
+		}
+		msg.Created = t
+	}
+	return msg, nil
+}
+
+// readImportFile decodes rows, picking the format from the file extension
+// and falling back to sniffing the first non-blank byte.
+func readImportFile(file io.Reader, filename string) ([]importRow, error) {
+	br := bufio.NewReader(file)
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if format != "csv" && format != "json" && format != "ndjson" {
+		format = "csv"
+		if b, err := peekNonSpace(br); err == nil && (b == '[' || b == '{') {
+			format = "json"
+		}
+	}
+	switch format {
+	case "csv":
+		return readImportCSV(br)
+	default:
+		return readImportJSON(br)
+	}
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for n := 1; ; n++ {
+		b, err := br.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		if c := b[n-1]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, nil
+		}
+	}
+}
+
+// readImportJSON accepts either one JSON array or a stream of objects
+// (NDJSON).
+func readImportJSON(br *bufio.Reader) ([]importRow, error) {
+	if b, err := peekNonSpace(br); err == nil && b == '[' {
+		var rows []importRow
+		if err := json.NewDecoder(br).Decode(&rows); err != nil {
+			return nil, fmt.Errorf("bad JSON: %v", err)
+		}
+		return rows, nil
+	}
+	var rows []importRow
+	dec := json.NewDecoder(br)
+	for {
+		var row importRow
+		if err := dec.Decode(&row); err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("bad JSON after %d row(s): %v", len(rows), err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readImportCSV(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("bad CSV header: %v", err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := col["content"]; !ok {
+		return nil, errors.New("CSV header must include a content column")
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+	var rows []importRow
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad CSV: %v", err)
+		}
+		rows = append(rows, importRow{
+			Author:  field(rec, "author"),
+			Content: field(rec, "content"),
+			Created: field(rec, "created"),
+			Tags:    strings.Split(field(rec, "tags"), ","),
+		})
+	}
+}
+
+// wantsJSON reports whether the client prefers a JSON response.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
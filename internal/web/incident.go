@@ -0,0 +1,387 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+var (
+	incidentSeverities = []string{"critical", "major", "minor"}
+	incidentStatuses   = []string{"investigating", "identified", "monitoring", "resolved"}
+)
+
+const (
+	maxIncidentTitle  = 200
+	maxIncidentUpdate = 5000 // leaves room in the board message
+	resolvedIncidents = 20   // shown on /incidents
+)
+
+func incidentTarget(id int) string {
+	return "incident:" + strconv.Itoa(id)
+}
+
+// incidentInput opens an incident or changes one. Fields left nil keep
+// their value; Message goes on the timeline.
+type incidentInput struct {
+	Title    *string `json:"title"`
+	Severity *string `json:"severity"`
+	Status   *string `json:"status"`
+	Message  string  `json:"message"`
+}
+
+func (in *incidentInput) validate(create bool) error {
+	errs := fieldErrors{}
+	if in.Title != nil {
+		*in.Title = strings.TrimSpace(*in.Title)
+		if *in.Title == "" {
+			errs.add("title", "is required")
+		}
+		errs.checkText("title", *in.Title, maxIncidentTitle, false)
+	} else if create {
+		errs.add("title", "is required")
+	}
+	if in.Severity != nil && !slices.Contains(incidentSeverities, *in.Severity) {
+		errs.add("severity", "must be one of "+strings.Join(incidentSeverities, ", "))
+	} else if in.Severity == nil && create {
+		errs.add("severity", "is required")
+	}
+	if in.Status != nil && !slices.Contains(incidentStatuses, *in.Status) {
+		errs.add("status", "must be one of "+strings.Join(incidentStatuses, ", "))
+	}
+	in.Message = strings.TrimSpace(in.Message)
+	errs.checkText("message", in.Message, maxIncidentUpdate, true)
+	return errs.err()
+}
+
+// openIncident saves a new incident from in, with in.Message as the first
+// entry on its timeline, and pins a board message about it.
+func (app *App) openIncident(ctx context.Context, in incidentInput) (store.Incident, error) {
+	if err := in.validate(true); err != nil {
+		return store.Incident{}, err
+	}
+	inc := store.Incident{Title: *in.Title, Severity: *in.Severity, Status: "investigating"}
+	if in.Status != nil {
+		inc.Status = *in.Status
+	}
+	if inc.Status == "resolved" {
+		now := time.Now()
+		inc.Resolved = &now
+	}
+	inc.Updates = []store.IncidentUpdate{{Status: inc.Status, Severity: inc.Severity, Body: in.Message, Author: auditActor(ctx)}}
+	if err := app.incidents.CreateIncident(ctx, &inc); err != nil {
+		return store.Incident{}, err
+	}
+	app.recordAudit(ctx, "incident.create", incidentTarget(inc.ID), nil, incidentSnapshot(inc))
+	app.syncIncidentMessage(ctx, &inc)
+	return inc, nil
+}
+
+// changeIncident applies in to incident id, adding a timeline entry, and
+// brings its board message up to date. Resolving unpins the message and
+// reopening pins it again.
+func (app *App) changeIncident(ctx context.Context, id int, in incidentInput) (store.Incident, error) {
+	if err := in.validate(false); err != nil {
+		return store.Incident{}, err
+	}
+	before, err := app.incidents.GetIncident(ctx, id)
+	if err != nil {
+		return store.Incident{}, err
+	}
+	inc := before
+	if in.Title != nil {
+		inc.Title = *in.Title
+	}
+	if in.Severity != nil {
+		inc.Severity = *in.Severity
+	}
+	if in.Status != nil {
+		inc.Status = *in.Status
+	}
+	if inc.Title == before.Title && inc.Severity == before.Severity && inc.Status == before.Status && in.Message == "" {
+		return store.Incident{}, fieldErrors{"message": "is required when nothing else changes"}
+	}
+	action := "incident.update"
+	switch {
+	case inc.Status == "resolved" && before.Status != "resolved":
+		now := time.Now()
+		inc.Resolved = &now
+		action = "incident.resolve"
+	case inc.Status != "resolved":
+		inc.Resolved = nil
+	}
+	u := &store.IncidentUpdate{Status: inc.Status, Severity: inc.Severity, Body: in.Message, Author: auditActor(ctx)}
+	if err := app.incidents.UpdateIncident(ctx, &inc, u); err != nil {
+		return store.Incident{}, err
+	}
+	app.recordAudit(ctx, action, incidentTarget(id), incidentSnapshot(before), incidentSnapshot(inc))
+	app.syncIncidentMessage(ctx, &inc)
+	return inc, nil
+}
+
+// incidentSnapshot is inc without its timeline, for the audit log.
+func incidentSnapshot(inc store.Incident) store.Incident {
+	inc.Updates = nil
+	return inc
+}
+
+// syncIncidentMessage posts or rewrites the board message that follows
+// inc, pinned while the incident is open. Failures are logged: the
+// incident itself has been saved.
+func (app *App) syncIncidentMessage(ctx context.Context, inc *store.Incident) {
+	open := inc.Status != "resolved"
+	content := incidentContent(*inc)
+	if inc.MessageID == 0 {
+		if !open {
+			return
+		}
+		msg := store.Message{Author: "Incidents", Content: content, Tags: []string{"incident"}}
+		err := app.messages.Create(ctx, &msg)
+		if err == nil {
+			err = app.messages.SetPinned(ctx, msg.ID, true)
+		}
+		if err == nil {
+			inc.MessageID = msg.ID
+			err = app.incidents.UpdateIncident(ctx, inc, nil)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "incident message", "incident", inc.ID, "err", err)
+		}
+		return
+	}
+	msg, err := app.messages.Get(ctx, inc.MessageID)
+	if errors.Is(err, store.ErrNotFound) {
+		return // a moderator deleted it
+	}
+	if err == nil {
+		msg.Content = content
+		err = app.messages.Update(ctx, &msg)
+	}
+	if err == nil && msg.Pinned != open {
+		err = app.messages.SetPinned(ctx, msg.ID, open)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "incident message", "incident", inc.ID, "message", inc.MessageID, "err", err)
+	}
+}
+
+// incidentContent is the board message for inc: its state and the latest
+// word on it.
+func incidentContent(inc store.Incident) string {
+	var b strings.Builder
+	if inc.Status == "resolved" && inc.Resolved != nil {
+		fmt.Fprintf(&b, "**Resolved: %s** · %s · after %s", inc.Title, inc.Severity, inc.Resolved.Sub(inc.Created).Round(time.Second))
+	} else {
+		fmt.Fprintf(&b, "**Incident: %s** · %s · %s", inc.Title, inc.Severity, inc.Status)
+	}
+	for _, u := range slices.Backward(inc.Updates) {
+		if u.Body != "" {
+			b.WriteString("\n\n" + u.Body)
+			break
+		}
+	}
+	fmt.Fprintf(&b, "\n\n[Timeline](/incidents#incident-%d)", inc.ID)
+	return b.String()
+}
+
+func incidentError(w http.ResponseWriter, r *http.Request, err error) {
+	var fe fieldErrors
+	switch {
+	case errors.As(err, &fe):
+		writeInputError(w, err)
+	case errors.Is(err, store.ErrIncidentNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	default:
+		storeError(w, r, err)
+	}
+}
+
+// listIncidentsAPIHandler serves GET /api/incidents: incidents, newest
+// first, optionally only ?state=open or ?state=resolved.
+func (app *App) listIncidentsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := store.IncidentFilter{State: q.Get("state"), Limit: 50}
+	if f.State != "" && f.State != "open" && f.State != "resolved" {
+		writeJSONError(w, http.StatusBadRequest, `state must be "open" or "resolved"`)
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			writeJSONError(w, http.StatusBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		f.Limit = n
+	}
+	list, err := app.incidents.ListIncidents(r.Context(), f)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// openIncidentAPIHandler serves POST /api/incidents, which opens an
+// incident.
+func (app *App) openIncidentAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var in incidentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	inc, err := app.openIncident(r.Context(), in)
+	if err != nil {
+		incidentError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, inc)
+}
+
+// incidentID parses the {id} of an incident route, answering 404 when it
+// is not one.
+func incidentID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusNotFound, "incident not found")
+		return 0, false
+	}
+	return id, true
+}
+
+// getIncidentAPIHandler serves GET /api/incidents/{id}: the incident with
+// its timeline.
+func (app *App) getIncidentAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := incidentID(w, r)
+	if !ok {
+		return
+	}
+	inc, err := app.incidents.GetIncident(r.Context(), id)
+	if err != nil {
+		incidentError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, inc)
+}
+
+// updateIncidentAPIHandler serves PATCH /api/incidents/{id}.
+func (app *App) updateIncidentAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := incidentID(w, r)
+	if !ok {
+		return
+	}
+	var in incidentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	app.writeIncidentChange(w, r, id, in)
+}
+
+// resolveIncidentAPIHandler serves POST /api/incidents/{id}/resolve, a
+// PATCH to status "resolved".
+func (app *App) resolveIncidentAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := incidentID(w, r)
+	if !ok {
+		return
+	}
+	var in incidentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	resolved := "resolved"
+	in = incidentInput{Status: &resolved, Message: in.Message}
+	inc, err := app.incidents.GetIncident(r.Context(), id)
+	if err == nil && inc.Status == "resolved" {
+		writeJSONError(w, http.StatusConflict, "incident already resolved")
+		return
+	}
+	app.writeIncidentChange(w, r, id, in)
+}
+
+func (app *App) writeIncidentChange(w http.ResponseWriter, r *http.Request, id int, in incidentInput) {
+	inc, err := app.changeIncident(r.Context(), id, in)
+	if err != nil {
+		incidentError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, inc)
+}
+
+// incidentView pairs an incident with the page for the "incident"
+// template in incidents.html.
+type incidentView struct {
+	Incident store.Incident
+	Page     TemplateData
+}
+
+// incidentsPage is the data behind incidents.html.
+type incidentsPage struct {
+	Open, Resolved []store.Incident
+	Severities     []string
+	Statuses       []string
+}
+
+// incidentsHandler serves GET /incidents: open incidents and the latest
+// resolved ones with their timelines. Moderators open incidents by POSTing
+// the form to /incidents and update one by POSTing to /incidents/{id}.
+func (app *App) incidentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	data := TemplateData{Title: "Incidents", Now: time.Now()}
+	if r.Method == http.MethodPost {
+		in := incidentInput{Message: r.PostFormValue("message")}
+		for _, f := range []struct {
+			name string
+			dst  **string
+		}{{"title", &in.Title}, {"severity", &in.Severity}, {"status", &in.Status}} {
+			if _, ok := r.PostForm[f.name]; ok {
+				v := r.PostFormValue(f.name)
+				*f.dst = &v
+			}
+		}
+		var inc store.Incident
+		var err error
+		if idStr := r.PathValue("id"); idStr == "" {
+			inc, err = app.openIncident(ctx, in)
+		} else if id, convErr := strconv.Atoi(idStr); convErr == nil {
+			inc, err = app.changeIncident(ctx, id, in)
+		} else {
+			http.NotFound(w, r)
+			return
+		}
+		var fe fieldErrors
+		switch {
+		case errors.As(err, &fe):
+			data.Flash = errorFlash("Not saved: " + fe.Error())
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		case errors.Is(err, store.ErrIncidentNotFound):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "store", "err", err)
+			return
+		default:
+			redirectWithFlash(w, r, "/incidents#incident-"+strconv.Itoa(inc.ID), flashSuccess, "Incident saved.")
+			return
+		}
+	}
+	page := &incidentsPage{Severities: incidentSeverities, Statuses: incidentStatuses}
+	var err error
+	page.Open, err = app.incidents.ListIncidents(ctx, store.IncidentFilter{State: "open"})
+	if err == nil {
+		page.Resolved, err = app.incidents.ListIncidents(ctx, store.IncidentFilter{State: "resolved", Limit: resolvedIncidents})
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	data.Incidents = page
+	app.renderTemplate(w, r, "incidents.html", data)
+}
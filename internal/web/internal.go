@@ -0,0 +1,29 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// handleOps adds /metrics, /healthz and /readyz to mux. Probes are hit
+// every few seconds; keep them out of the access log.
+func (app *App) handleOps(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", app.readyzHandler)
+}
+
+// InternalHandler serves the operational endpoints, /debug/ included,
+// for the internal listener, or returns nil when there is none. It does
+// no authentication, so the address must only be reachable from inside
+// the network; Handler then leaves them out.
+func (app *App) InternalHandler() http.Handler {
+	if app.internalAddr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	app.handleOps(mux)
+	mux.Handle("/debug/", debugMux)
+	return mux
+}
@@ -0,0 +1,121 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// buildsPerJob is how many builds /builds shows for each job.
+const buildsPerJob = 10
+
+// jenkinsPayload holds the fields used from the Notification plugin's JSON
+// format.
+type jenkinsPayload struct {
+	Name  string `json:"name"`
+	Build struct {
+		FullURL   string `json:"full_url"`
+		Number    int    `json:"number"`
+		Phase     string `json:"phase"`
+		Status    string `json:"status"`
+		Duration  int64  `json:"duration"`  // milliseconds
+		Timestamp int64  `json:"timestamp"` // start, milliseconds since the epoch
+		SCM       struct {
+			Branch string `json:"branch"`
+			Commit string `json:"commit"`
+		} `json:"scm"`
+	} `json:"build"`
+}
+
+// jenkinsHookHandler serves POST /hooks/jenkins?token=, the endpoint for
+// the Jenkins Notification plugin. The plugin cannot sign its requests, so
+// the endpoint URL carries the token instead. Each notification updates
+// the build it is about.
+func (app *App) jenkinsHookHandler(w http.ResponseWriter, r *http.Request) {
+	if app.jenkinsToken == "" {
+		writeJSONError(w, http.StatusServiceUnavailable, "Jenkins token not configured")
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(app.jenkinsToken)) != 1 {
+		writeJSONError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "payload too large")
+		return
+	}
+	b, err := parseJenkinsBuild(body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := app.builds.SaveBuild(r.Context(), &b); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "jenkins build", "job", b.Job, "number", b.Number, "phase", b.Phase, "status", b.Status)
+	writeJSON(w, http.StatusOK, b)
+}
+
+// parseJenkinsBuild turns a notification into the Build it reports.
+func parseJenkinsBuild(body []byte) (store.Build, error) {
+	var p jenkinsPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return store.Build{}, fmt.Errorf("Bad JSON: %v", err)
+	}
+	if p.Name == "" || p.Build.Number <= 0 || p.Build.Phase == "" {
+		return store.Build{}, fmt.Errorf("name, build.number and build.phase are required")
+	}
+	b := store.Build{
+		Job:      p.Name,
+		Number:   p.Build.Number,
+		Phase:    strings.ToUpper(p.Build.Phase),
+		Status:   strings.ToUpper(p.Build.Status),
+		URL:      p.Build.FullURL,
+		Branch:   p.Build.SCM.Branch,
+		Commit:   p.Build.SCM.Commit,
+		Duration: time.Duration(p.Build.Duration) * time.Millisecond,
+	}
+	if p.Build.Timestamp > 0 {
+		b.Started = time.UnixMilli(p.Build.Timestamp).UTC()
+	}
+	return b, nil
+}
+
+// buildJob is one job's row group on /builds.
+type buildJob struct {
+	Name   string
+	Builds []store.Build // newest first
+}
+
+// buildsHandler serves GET /builds, the latest builds of every job that
+// has reported to /hooks/jenkins.
+func (app *App) buildsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.jenkinsToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	recent, err := app.builds.RecentBuilds(r.Context(), buildsPerJob)
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	var groups []buildJob
+	for _, b := range recent {
+		if len(groups) == 0 || groups[len(groups)-1].Name != b.Job {
+			groups = append(groups, buildJob{Name: b.Job})
+		}
+		groups[len(groups)-1].Builds = append(groups[len(groups)-1].Builds, b)
+	}
+	app.renderTemplate(w, r, "builds.html", TemplateData{Title: "Builds", Builds: groups, Now: time.Now()})
+}
@@ -0,0 +1,259 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	jobMaxAttempts = 5
+	jobMaxBackoff  = 5 * time.Minute
+	jobHistory     = 100 // failed jobs kept for /admin/jobs
+)
+
+var jobsDone = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "slrs_jobs_total",
+	Help: "Finished background jobs by kind and result.",
+}, []string{"kind", "result"})
+
+// Job is one unit of background work. Run is retried with exponential
+// backoff until it succeeds, returns a permanent error or has had
+// jobMaxAttempts tries.
+type Job struct {
+	ID        int
+	Kind      string // e.g. "slack" or "mail"
+	Summary   string // what the job is about, for people
+	State     string // "pending", "running", "retrying" or "failed"
+	Attempts  int
+	LastError string
+	Created   time.Time
+	NextRun   time.Time // when a retrying job runs again
+	Run       func(ctx context.Context) error
+}
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// permanent wraps err so the job fails without further attempts.
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+// retryAfterError asks for the next attempt after a given delay instead of
+// the usual backoff, e.g. when a service answered 429 with Retry-After.
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e retryAfterError) Error() string { return e.err.Error() }
+func (e retryAfterError) Unwrap() error { return e.err }
+
+func retryAfter(d time.Duration, err error) error {
+	return retryAfterError{err, d}
+}
+
+// jobQueue is an in-process queue served by a fixed pool of workers. Jobs
+// live in memory only: whatever has not run when the process exits is
+// lost, so drain gives them a chance first.
+type jobQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	ready   []*Job       // oldest first
+	active  map[int]*Job // ready, running and retrying jobs
+	failed  []*Job       // oldest first, at most jobHistory
+	nextID  int
+	closing bool
+
+	ctx     context.Context // cancelled when drain gives up
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// newJobQueue starts n workers.
+func newJobQueue(n int) *jobQueue {
+	q := &jobQueue{active: make(map[int]*Job)}
+	q.cond = sync.NewCond(&q.mu)
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	for range n {
+		q.workers.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue adds a job of kind that calls run.
+func (q *jobQueue) Enqueue(kind, summary string, run func(ctx context.Context) error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	j := &Job{ID: q.nextID, Kind: kind, Summary: summary, State: "pending", Created: time.Now(), Run: run}
+	q.active[j.ID] = j
+	q.ready = append(q.ready, j)
+	q.cond.Signal()
+}
+
+func (q *jobQueue) work() {
+	defer q.workers.Done()
+	for {
+		q.mu.Lock()
+		for len(q.ready) == 0 && !(q.closing && len(q.active) == 0) {
+			q.cond.Wait()
+		}
+		if len(q.ready) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		j := q.ready[0]
+		q.ready = q.ready[1:]
+		j.State = "running"
+		j.Attempts++
+		q.mu.Unlock()
+
+		err := j.Run(q.ctx)
+		q.finish(j, err)
+	}
+}
+
+// finish records the outcome of one attempt and schedules the next one if
+// there should be one.
+func (q *jobQueue) finish(j *Job, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err == nil {
+		delete(q.active, j.ID)
+		jobsDone.WithLabelValues(j.Kind, "ok").Inc()
+		q.cond.Broadcast()
+		return
+	}
+	j.LastError = err.Error()
+	var perm permanentError
+	if errors.As(err, &perm) || j.Attempts >= jobMaxAttempts || q.ctx.Err() != nil {
+		j.State = "failed"
+		delete(q.active, j.ID)
+		q.failed = append(q.failed, j)
+		if len(q.failed) > jobHistory {
+			q.failed = q.failed[1:]
+		}
+		jobsDone.WithLabelValues(j.Kind, "failed").Inc()
+		slog.Error("job failed", "job", j.ID, "kind", j.Kind, "summary", j.Summary, "attempts", j.Attempts, "err", err)
+		q.cond.Broadcast()
+		return
+	}
+	delay := time.Second << (j.Attempts - 1)
+	var ra retryAfterError
+	if errors.As(err, &ra) && ra.after > 0 {
+		delay = ra.after
+	}
+	delay = min(delay, jobMaxBackoff)
+	j.State = "retrying"
+	j.NextRun = time.Now().Add(delay)
+	slog.Warn("job attempt failed", "job", j.ID, "kind", j.Kind, "attempt", j.Attempts, "retry_in", delay, "err", err)
+	time.AfterFunc(delay, func() { q.requeue(j) })
+}
+
+func (q *jobQueue) requeue(j *Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.State = "pending"
+	q.ready = append(q.ready, j)
+	q.cond.Signal()
+}
+
+// retry queues a failed job again with a fresh set of attempts. It reports
+// false if id is not among the failed jobs.
+func (q *jobQueue) retry(id int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, j := range q.failed {
+		if j.ID == id {
+			q.failed = append(q.failed[:i], q.failed[i+1:]...)
+			j.State, j.Attempts, j.LastError = "pending", 0, ""
+			q.active[j.ID] = j
+			q.ready = append(q.ready, j)
+			q.cond.Signal()
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot returns copies of the unfinished jobs, oldest first, and of the
+// failed ones, newest first.
+func (q *jobQueue) snapshot() (active, failed []Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.active {
+		active = append(active, *j)
+	}
+	slices.SortFunc(active, func(a, b Job) int { return a.ID - b.ID })
+	for _, j := range slices.Backward(q.failed) {
+		failed = append(failed, *j)
+	}
+	return active, failed
+}
+
+// drain lets the workers finish every queued job, retries included, and
+// returns once they have or ctx expires. Jobs still unfinished then are
+// cancelled and lost.
+func (q *jobQueue) drain(ctx context.Context) {
+	q.mu.Lock()
+	q.closing = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.mu.Lock()
+		n := len(q.active)
+		q.mu.Unlock()
+		slog.Warn("jobs: shutting down with unfinished jobs", "count", n)
+		q.cancel()
+	}
+}
+
+// jobsPage is the data behind jobs.html.
+type jobsPage struct {
+	Active, Failed []Job
+}
+
+// adminJobsHandler serves GET /admin/jobs, the queued, running and
+// retrying jobs and the latest failures.
+func (app *App) adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	page := &jobsPage{}
+	page.Active, page.Failed = app.jobs.snapshot()
+	app.renderTemplate(w, r, "jobs.html", TemplateData{Title: "Jobs", Jobs: page, Now: time.Now()})
+}
+
+// retryJobHandler serves POST /admin/jobs/{id}/retry.
+func (app *App) retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !app.jobs.retry(id) {
+		http.NotFound(w, r)
+		return
+	}
+	app.recordAudit(r.Context(), "job.retry", "job:"+idStr, nil, nil)
+	redirectWithFlash(w, r, "/admin/jobs", flashSuccess, "Job "+idStr+" queued again.")
+}
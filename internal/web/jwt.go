@@ -0,0 +1,212 @@
+package web
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/store"
+)
+
+// Bearer tokens for the API. POST /api/token trades a password or an API key
+// for a short-lived JWT signed with HMAC-SHA256, which apiKeyMiddleware then
+// accepts in place of either. A token carries the user's role or the key's
+// scopes, so checking it needs no session or store lookup; the price is that
+// a role change or deleted key only takes effect when the token expires.
+
+const jwtIssuer = "slrs"
+
+var (
+	errTokenInvalid = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+)
+
+// tokenClaims is the JWT payload. Kind says whether Subject is a user name,
+// with Role set, or an API key name, with Scope set (space-separated, as in
+// OAuth).
+type tokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Kind      string `json:"kind"` // user or api_key
+	Role      string `json:"role,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+}
+
+// setupJWT loads the signing keys. Without JWT_KEYS a random key is made up,
+// so tokens stop working on restart and are not shared between replicas.
+func (app *App) setupJWT(cfg config.Config) error {
+	keys, err := config.ParseJWTKeys(cfg.JWTKeys)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		keys = []config.JWTKey{{ID: "ephemeral", Secret: secret}}
+		slog.Info("JWT_KEYS is not set; API tokens are signed with a per-process key")
+	}
+	app.jwtKeys = keys
+	app.jwtTTL = cfg.JWTTTL
+	return nil
+}
+
+var jwtEncoding = base64.RawURLEncoding
+
+func (app *App) signToken(c tokenClaims) (string, error) {
+	k := app.jwtKeys[0]
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT", "kid": k.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := jwtEncoding.EncodeToString(header) + "." + jwtEncoding.EncodeToString(payload)
+	return signed + "." + jwtEncoding.EncodeToString(jwtMAC(k.Secret, signed)), nil
+}
+
+func jwtMAC(secret []byte, signed string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(signed))
+	return m.Sum(nil)
+}
+
+// looksLikeJWT tells tokens apart from API key secrets: a JWT is three
+// base64url parts and its header always starts with {", "eyJ" encoded.
+func looksLikeJWT(s string) bool {
+	return strings.HasPrefix(s, "eyJ") && strings.Count(s, ".") == 2
+}
+
+// verifyToken checks the signature, algorithm, issuer and expiry of token
+// and returns its claims.
+func (app *App) verifyToken(token string) (tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return tokenClaims{}, errTokenInvalid
+	}
+	var header struct{ Alg, Kid string }
+	if b, err := jwtEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(b, &header) != nil {
+		return tokenClaims{}, errTokenInvalid
+	}
+	// Only HS256 is ever issued; anything else, "none" included, is forged.
+	if header.Alg != "HS256" {
+		return tokenClaims{}, errTokenInvalid
+	}
+	sig, err := jwtEncoding.DecodeString(parts[2])
+	if err != nil {
+		return tokenClaims{}, errTokenInvalid
+	}
+	valid := false
+	for _, k := range app.jwtKeys {
+		if k.ID == header.Kid {
+			valid = hmac.Equal(sig, jwtMAC(k.Secret, parts[0]+"."+parts[1]))
+			break
+		}
+	}
+	if !valid {
+		return tokenClaims{}, errTokenInvalid
+	}
+	var c tokenClaims
+	if b, err := jwtEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(b, &c) != nil {
+		return tokenClaims{}, errTokenInvalid
+	}
+	if c.Issuer != jwtIssuer || c.Subject == "" {
+		return tokenClaims{}, errTokenInvalid
+	}
+	if time.Now().Unix() >= c.ExpiresAt {
+		return tokenClaims{}, errTokenExpired
+	}
+	return c, nil
+}
+
+// tokenPrincipal returns r with the user or API key c stands for in its
+// context, so that can and the audit log treat it like a session or key.
+func tokenPrincipal(r *http.Request, c tokenClaims) (*http.Request, error) {
+	switch c.Kind {
+	case "user":
+		if !store.ValidRole(c.Role) {
+			return nil, errTokenInvalid
+		}
+		return r.WithContext(context.WithValue(r.Context(), userKey{}, &store.User{Name: c.Subject, Role: c.Role})), nil
+	case "api_key":
+		scopes := strings.Fields(c.Scope)
+		if validateScopes(scopes) != nil {
+			return nil, errTokenInvalid
+		}
+		return r.WithContext(context.WithValue(r.Context(), apiKeyKey{}, &store.APIKey{Name: c.Subject, Scopes: scopes})), nil
+	}
+	return nil, errTokenInvalid
+}
+
+// tokenAPIHandler serves POST /api/token. It issues a token for the API key
+// sent the usual way (Authorization: Bearer or X-API-Key), or else for the
+// user named in the body {"username": ..., "password": ...}.
+func (app *App) tokenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	c := tokenClaims{Issuer: jwtIssuer}
+	var target string
+	if secret := requestAPIKey(r); secret != "" {
+		if looksLikeJWT(secret) {
+			writeJSONError(w, http.StatusBadRequest, "exchange a password or an API key, not a token")
+			return
+		}
+		k, err := app.lookupAPIKey(r.Context(), secret)
+		if err != nil {
+			if !errors.Is(err, store.ErrKeyNotFound) {
+				slog.ErrorContext(r.Context(), "looking up API key", "err", err)
+			}
+			writeJSONError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		c.Subject, c.Kind, c.Scope = k.Name, "api_key", strings.Join(k.Scopes, " ")
+		target = "api_key:" + k.Name
+		r = r.WithContext(context.WithValue(r.Context(), apiKeyKey{}, &k))
+	} else {
+		var in struct{ Username, Password string }
+		if !decodeJSON(w, r, &in) {
+			return
+		}
+		if in.Username == "" || in.Password == "" {
+			writeJSONError(w, http.StatusBadRequest, "username and password, or an API key, required")
+			return
+		}
+		u, err := app.authenticate(r.Context(), in.Username, in.Password)
+		if err != nil {
+			if !errors.Is(err, store.ErrUserNotFound) && !errors.Is(err, errInvalidCredentials) && !errors.Is(err, errLocalAccount) {
+				slog.ErrorContext(r.Context(), "token login", "err", err)
+			}
+			app.recordAudit(r.Context(), "login.failed", "user:"+in.Username, nil, nil)
+			writeJSONError(w, http.StatusUnauthorized, "invalid username or password")
+			return
+		}
+		c.Subject, c.Kind, c.Role = u.Name, "user", u.Role
+		target = "user:" + u.Name
+		r = r.WithContext(withAuditActor(r.Context(), u.Name))
+	}
+	now := time.Now()
+	c.IssuedAt, c.ExpiresAt, c.ID = now.Unix(), now.Add(app.jwtTTL).Unix(), randomToken()[:16]
+	token, err := app.signToken(c)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	app.recordAudit(r.Context(), "token.issue", target, nil, map[string]any{"jti": c.ID, "expires": time.Unix(c.ExpiresAt, 0).UTC()})
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(app.jwtTTL.Seconds()),
+	})
+}
@@ -0,0 +1,112 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/store"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const ldapTimeout = 10 * time.Second
+
+// ldapAuthenticator finds a user with a search under baseDN, then binds as
+// that entry with the password given. Its group attribute, mapped through
+// roles by group CN, decides the role.
+type ldapAuthenticator struct {
+	url, serverName      string
+	startTLS             bool
+	bindDN, bindPassword string
+	baseDN, userFilter   string
+	groupAttr            string
+	roles                map[string]string
+}
+
+func newLDAPAuthenticator(cfg config.Config) (*ldapAuthenticator, error) {
+	u, err := url.Parse(cfg.LDAPURL)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := config.ParseRoleMap(cfg.LDAPRoleMap)
+	if err != nil {
+		return nil, err
+	}
+	return &ldapAuthenticator{
+		url:          cfg.LDAPURL,
+		serverName:   u.Hostname(),
+		startTLS:     cfg.LDAPStartTLS,
+		bindDN:       cfg.LDAPBindDN,
+		bindPassword: cfg.LDAPBindPassword,
+		baseDN:       cfg.LDAPBaseDN,
+		userFilter:   cfg.LDAPUserFilter,
+		groupAttr:    cfg.LDAPGroupAttr,
+		roles:        roles,
+	}, nil
+}
+
+// authenticate checks name and password against the directory and returns
+// the user's name and the role their groups map to. Names are lower-cased,
+// as directories compare them case-insensitively.
+func (a *ldapAuthenticator) authenticate(ctx context.Context, name, password string) (user, role string, err error) {
+	// Most servers treat a bind with an empty password as anonymous and
+	// let it succeed.
+	if password == "" {
+		return "", "", errInvalidCredentials
+	}
+	conn, err := ldap.DialURL(a.url, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}))
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+	conn.SetTimeout(ldapTimeout)
+	if a.startTLS {
+		if err := conn.StartTLS(&tls.Config{ServerName: a.serverName}); err != nil {
+			return "", "", fmt.Errorf("ldap start tls: %w", err)
+		}
+	}
+	if a.bindDN != "" {
+		if err := conn.Bind(a.bindDN, a.bindPassword); err != nil {
+			return "", "", fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(ldapTimeout/time.Second), false,
+		fmt.Sprintf(a.userFilter, ldap.EscapeFilter(name)), []string{a.groupAttr}, nil,
+	))
+	if err != nil {
+		return "", "", fmt.Errorf("ldap search: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		// None, or a filter too loose to tell users apart.
+		return "", "", store.ErrUserNotFound
+	}
+	entry := res.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return "", "", errInvalidCredentials
+		}
+		return "", "", fmt.Errorf("ldap bind: %w", err)
+	}
+	var groups []string
+	for _, dn := range entry.GetAttributeValues(a.groupAttr) {
+		groups = append(groups, groupName(dn))
+	}
+	return strings.ToLower(name), mappedRole(a.roles, groups), nil
+}
+
+// groupName is the value of dn's first component, "admins" for
+// "cn=admins,ou=groups,dc=example,dc=com", or dn itself if it is not a DN.
+func groupName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
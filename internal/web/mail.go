@@ -0,0 +1,206 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/store"
+)
+
+// outgoingMail is one email for a mail job.
+type outgoingMail struct {
+	to          string
+	subject     string
+	body        string
+	unsubscribe string // URL for the List-Unsubscribe header, if any
+}
+
+// mailer renders emails from templates/mail and sends each one over SMTP
+// as a job, so neither posting nor subscribing waits on the mail server.
+// It also mails every new message to the confirmed subscribers who want
+// it.
+type mailer struct {
+	app  *App
+	addr string
+	auth smtp.Auth
+	from *mail.Address
+	base string // PublicURL, for links
+	tmpl *template.Template
+
+	// send is smtp.SendMail, swapped out when debugging delivery.
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// startMailer parses the mail templates in fsys and subscribes to hub,
+// missing nothing, mailing subscribers about new messages until the hub
+// closes.
+func (app *App) startMailer(cfg config.Config, fsys fs.FS) (*mailer, error) {
+	tmpl, err := template.New("mail").Funcs(template.FuncMap{"join": strings.Join}).ParseFS(fsys, "mail/*.txt")
+	if err != nil {
+		return nil, err
+	}
+	from, err := mail.ParseAddress(cfg.MailFrom)
+	if err != nil {
+		return nil, err
+	}
+	m := &mailer{
+		app:  app,
+		addr: cfg.SMTPAddr,
+		from: from,
+		base: strings.TrimSuffix(cfg.PublicURL, "/"),
+		tmpl: tmpl,
+		send: smtp.SendMail,
+	}
+	if cfg.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(cfg.SMTPAddr)
+		m.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+	}
+	events := app.hub.SubscribeAll()
+	go func() {
+		for ev := range events {
+			if ev.Type == "created" {
+				m.notify(ev.Message)
+				m.notifyMentions(ev.Message)
+			}
+		}
+	}()
+	return m, nil
+}
+
+// enqueue renders the named template for to and queues a job to send it.
+// The template's first line is the subject, as "Subject: ...".
+func (m *mailer) enqueue(to, name string, data any, unsubscribe string) error {
+	var buf bytes.Buffer
+	if err := m.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	head, body, _ := strings.Cut(buf.String(), "\n\n")
+	subject, ok := strings.CutPrefix(head, "Subject: ")
+	if !ok {
+		return fmt.Errorf("mail template %s does not start with a Subject line", name)
+	}
+	out := outgoingMail{to: to, subject: subject, body: body, unsubscribe: unsubscribe}
+	m.app.jobs.Enqueue("mail", to+": "+subject, func(ctx context.Context) error {
+		return m.deliver(out)
+	})
+	return nil
+}
+
+// notify queues msg for every confirmed subscriber who wants its tags.
+// Subscribers are only email addresses, so messages in private channels
+// are never mailed.
+func (m *mailer) notify(msg store.Message) {
+	ctx := context.Background()
+	if private, err := m.app.inPrivateChannel(ctx, msg); err != nil {
+		slog.Error("mail: looking up channel", "channel", msg.Channel, "err", err)
+		return
+	} else if private {
+		return
+	}
+	subs, err := m.app.subscriptions.ListSubscriptions(ctx)
+	if err != nil {
+		slog.Error("mail: listing subscriptions", "err", err)
+		return
+	}
+	data := struct {
+		store.Message
+		Title, Author, URL, UnsubscribeURL string
+	}{Message: msg, Title: feedItemTitle(msg), Author: authorName(msg), URL: messageURL(m.base, msg.ID)}
+	for _, sub := range subs {
+		if !sub.Confirmed || !sub.Wants(msg.Tags) {
+			continue
+		}
+		data.UnsubscribeURL = m.link("/unsubscribe", sub.Token)
+		if err := m.enqueue(sub.Email, "message.txt", data, data.UnsubscribeURL); err != nil {
+			slog.Warn("mail: dropping notification", "message", msg.ID, "to", sub.Email, "err", err)
+		}
+	}
+}
+
+// notifyMentions mails the users msg mentions, if they gave an email
+// address on their account page. Unlike subscriptions this needs no
+// confirmation: the address is already tied to the account.
+func (m *mailer) notifyMentions(msg store.Message) {
+	us, err := m.app.mentionedUsers(context.Background(), msg)
+	if err != nil {
+		slog.Error("mail: looking up mentions", "message", msg.ID, "err", err)
+		return
+	}
+	data := struct {
+		store.Message
+		Title, Author, URL, AccountURL string
+	}{Message: msg, Title: feedItemTitle(msg), Author: authorName(msg), URL: messageURL(m.base, msg.ID), AccountURL: m.base + "/account"}
+	for _, u := range us {
+		if u.Email == "" {
+			continue
+		}
+		if err := m.enqueue(u.Email, "mention.txt", data, ""); err != nil {
+			slog.Warn("mail: dropping mention", "message", msg.ID, "to", u.Email, "err", err)
+		}
+	}
+}
+
+// link returns the absolute URL of path with token in its query string.
+func (m *mailer) link(path, token string) string {
+	return m.base + path + "?token=" + url.QueryEscape(token)
+}
+
+// deliver sends out once. SMTP 5xx replies are permanent; anything else
+// is worth another attempt.
+func (m *mailer) deliver(out outgoingMail) error {
+	err := m.send(m.addr, m.auth, m.from.Address, []string{out.to}, m.compose(out))
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+		return permanent(err)
+	}
+	return err
+}
+
+// compose builds the RFC 5322 message for out.
+func (m *mailer) compose(out outgoingMail) []byte {
+	var b bytes.Buffer
+	header := func(k, v string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	header("From", m.from.String())
+	header("To", out.to)
+	header("Subject", mime.QEncoding.Encode("utf-8", headerSafe(out.subject)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+randomToken()+"@"+m.from.Address[strings.LastIndex(m.from.Address, "@")+1:]+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	header("Auto-Submitted", "auto-generated")
+	if out.unsubscribe != "" {
+		header("List-Unsubscribe", "<"+out.unsubscribe+">")
+	}
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(out.body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// headerSafe drops control characters, line breaks among them, so text
+// from messages cannot add headers.
+func headerSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maintenanceState is maintenance mode while it is on.
+type maintenanceState struct {
+	Message    string    `json:"message,omitempty"` // shown on the 503 page
+	RetryAfter int       `json:"retry_after"`       // seconds, sent as Retry-After
+	Since      time.Time `json:"since"`
+	By         string    `json:"by"`
+}
+
+// defaultRetryAfter is the Retry-After of maintenance mode switched on
+// without one.
+const defaultRetryAfter = 300
+
+// maintenanceOpenPaths stay reachable for everyone during maintenance:
+// the probes, the assets of the 503 page, and the ways in for admins.
+var maintenanceOpenPaths = []string{"/healthz", "/readyz", "/metrics", "/static/", "/login", "/logout", "/auth/", "/api/token"}
+
+// maintenanceMiddleware answers every request but admins' with a 503
+// while maintenance mode is on. It sits inside sessionMiddleware and looks
+// up API keys and tokens itself, as the API's own middleware comes later.
+func (app *App) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := app.maintenance.Load()
+		if m == nil || app.maintenanceOpen(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(m.RetryAfter))
+		w.Header().Set("Cache-Control", "no-store")
+		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/hooks/") {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "down for maintenance", "message": m.Message})
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		app.renderTemplate(w, r, "maintenance.html", TemplateData{Title: "Down for maintenance", Flash: &Flash{Text: m.Message}, Now: time.Now()})
+	})
+}
+
+// maintenanceOpen reports whether r may pass during maintenance.
+func (app *App) maintenanceOpen(r *http.Request) bool {
+	path := unversionedPath(r.URL.Path)
+	for _, p := range maintenanceOpenPaths {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	if r, err := app.withAPIPrincipal(r); err == nil && can(r, PermAdmin) {
+		return true
+	}
+	return false
+}
+
+// setMaintenance switches maintenance mode on (m non-nil) or off and
+// records who did it.
+func (app *App) setMaintenance(ctx context.Context, m *maintenanceState) {
+	before := app.maintenance.Load()
+	if m != nil {
+		m.Since = time.Now()
+		m.By = auditActor(ctx)
+		if m.RetryAfter <= 0 {
+			m.RetryAfter = defaultRetryAfter
+		}
+	}
+	app.maintenance.Store(m)
+	if m != nil {
+		slog.WarnContext(ctx, "maintenance mode on", "by", m.By)
+		app.recordAudit(ctx, "maintenance.enable", "maintenance", before, m)
+	} else {
+		slog.WarnContext(ctx, "maintenance mode off", "by", auditActor(ctx))
+		app.recordAudit(ctx, "maintenance.disable", "maintenance", before, nil)
+	}
+}
+
+// maintenanceRequest is the body of PUT /api/maintenance.
+type maintenanceRequest struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// maintenanceResponse is what /api/maintenance reports.
+type maintenanceResponse struct {
+	Enabled bool `json:"enabled"`
+	*maintenanceState
+}
+
+func (app *App) currentMaintenance() maintenanceResponse {
+	m := app.maintenance.Load()
+	return maintenanceResponse{Enabled: m != nil, maintenanceState: m}
+}
+
+// getMaintenanceAPIHandler serves GET /api/maintenance, the mode, to
+// admins.
+func (app *App) getMaintenanceAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.currentMaintenance())
+}
+
+// setMaintenanceAPIHandler serves PUT /api/maintenance to admins:
+// {"enabled": true, "message": "...", "retry_after": 600} switches the
+// mode.
+func (app *App) setMaintenanceAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var req maintenanceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validateMaintenance(req); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	if req.Enabled {
+		app.setMaintenance(r.Context(), &maintenanceState{Message: req.Message, RetryAfter: req.RetryAfter})
+	} else {
+		app.setMaintenance(r.Context(), nil)
+	}
+	writeJSON(w, http.StatusOK, app.currentMaintenance())
+}
+
+func validateMaintenance(req maintenanceRequest) error {
+	fe := fieldErrors{}
+	if len(req.Message) > 500 {
+		fe["message"] = "must be at most 500 characters"
+	}
+	if req.RetryAfter < 0 || req.RetryAfter > 86400 {
+		fe["retry_after"] = "must be between 0 and 86400 seconds"
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
+
+// adminMaintenanceHandler serves POST /admin/maintenance, the form on
+// /status and in the admins' banner. enabled=on switches maintenance mode
+// on with the form's message, anything else switches it off.
+func (app *App) adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	req := maintenanceRequest{Enabled: r.FormValue("enabled") == "on", Message: strings.TrimSpace(r.FormValue("message"))}
+	if err := validateMaintenance(req); err != nil {
+		redirectWithFlash(w, r, "/status", flashError, "Not saved: "+err.Error())
+		return
+	}
+	if req.Enabled {
+		app.setMaintenance(r.Context(), &maintenanceState{Message: req.Message})
+		redirectWithFlash(w, r, "/status", flashSuccess, "Maintenance mode is on.")
+	} else {
+		app.setMaintenance(r.Context(), nil)
+		redirectWithFlash(w, r, "/status", flashSuccess, "Maintenance mode is off.")
+	}
+}
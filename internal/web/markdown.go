@@ -0,0 +1,44 @@
+package web
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// md renders message content as GitHub-flavoured Markdown. Raw HTML in
+// the source is dropped rather than passed through, and hard wraps are
+// kept so multi-line deploy notes read as typed. @mentions link to the
+// messages mentioning that user.
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.GFM, mentions),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// mdPolicy is the final word on what reaches the page: even if the
+// renderer let something through, only the usual user-content elements
+// survive, and links get rel="nofollow noopener" and open in a new tab.
+var mdPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	p.AllowAttrs("class").Matching(bluemonday.SpaceSeparatedTokens).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^mention$`)).OnElements("a")
+	return p
+}()
+
+// renderMarkdown turns message content into sanitized HTML.
+func renderMarkdown(content string) template.HTML {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(content), &buf); err != nil {
+		slog.Error("rendering markdown", "err", err)
+		return template.HTML(template.HTMLEscapeString(content))
+	}
+	return template.HTML(mdPolicy.SanitizeBytes(buf.Bytes()))
+}
@@ -0,0 +1,139 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"slices"
+	"unicode"
+
+	"example.com/go-sample-site/internal/store"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// mentionPattern matches an @mention at the start of its input: letters,
+// digits, _ . and -, not ending in . or - so "thanks @alice." works.
+var mentionPattern = regexp.MustCompile(`^@([\p{L}\p{N}_](?:[\p{L}\p{N}_.-]*[\p{L}\p{N}_])?)`)
+
+// mentionTextPattern finds @mentions in plain text, for Slack.
+var mentionTextPattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_@.])@([\p{L}\p{N}_](?:[\p{L}\p{N}_.-]*[\p{L}\p{N}_])?)`)
+
+// mentions is the goldmark extension that turns @name into a link with
+// class "mention". Mentions in code spans and blocks stay as they are.
+var mentions goldmark.Extender = mentionExtension{}
+
+type mentionExtension struct{}
+
+func (mentionExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(mentionParser{}, 500)))
+}
+
+type mentionParser struct{}
+
+func (mentionParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+func (mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if pc.IsInLinkLabel() {
+		return nil
+	}
+	// Leave alone the @ of an email address.
+	if c := block.PrecendingCharacter(); unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.' {
+		return nil
+	}
+	line, seg := block.PeekLine()
+	m := mentionPattern.FindSubmatchIndex(line)
+	if m == nil {
+		return nil
+	}
+	block.Advance(m[1])
+	link := ast.NewLink()
+	link.Destination = []byte(mentionURL(string(line[m[2]:m[3]])))
+	link.SetAttributeString("class", []byte("mention"))
+	link.AppendChild(link, ast.NewTextSegment(text.NewSegment(seg.Start, seg.Start+m[1])))
+	return link
+}
+
+// mentionURL is where a mention of name links to: the messages that
+// mention them.
+func mentionURL(name string) string {
+	return "/?q=" + url.QueryEscape("@"+name)
+}
+
+// parseMentions returns the names content mentions, each once, in the
+// order they first appear. They are not checked against the accounts.
+func parseMentions(content string) []string {
+	src := []byte(content)
+	var names []string
+	ast.Walk(md.Parser().Parse(text.NewReader(src)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		l, ok := n.(*ast.Link)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		if class, ok := l.AttributeString("class"); !ok || string(class.([]byte)) != "mention" {
+			return ast.WalkContinue, nil
+		}
+		if t, ok := l.FirstChild().(*ast.Text); ok {
+			if name := string(t.Segment.Value(src)[1:]); !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	return names
+}
+
+// mentioned reports whether msg mentions the user called name.
+func mentioned(msg store.Message, name string) bool {
+	return slices.Contains(parseMentions(msg.Content), name)
+}
+
+// mentionedUsers returns the accounts msg mentions that may read it. Its
+// author, and names that are not accounts, are left out.
+func (app *App) mentionedUsers(ctx context.Context, msg store.Message) ([]store.User, error) {
+	names := parseMentions(msg.Content)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	c, err := app.channels.GetChannel(ctx, msg.Channel)
+	if err != nil && !errors.Is(err, store.ErrChannelNotFound) {
+		return nil, err
+	}
+	var us []store.User
+	for _, name := range names {
+		if name == msg.Author {
+			continue
+		}
+		u, err := app.users.GetUser(ctx, name)
+		if errors.Is(err, store.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.ReadableBy(u) {
+			us = append(us, u)
+		}
+	}
+	return us, nil
+}
+
+// slackMentions makes the mentions of names in s, already escaped for
+// Slack, bold. Slack accounts are not tied to ours, so this is as close to
+// a ping as a post gets.
+func slackMentions(s string, names []string) string {
+	return mentionTextPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := mentionTextPattern.FindStringSubmatch(m)
+		if !slices.Contains(names, sub[2]) {
+			return m
+		}
+		return sub[1] + "*@" + sub[2] + "*"
+	})
+}
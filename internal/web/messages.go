@@ -0,0 +1,739 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+func (app *App) indexHandler(w http.ResponseWriter, r *http.Request) {
+	app.renderBoard(w, r, nil, nil)
+}
+
+// renderBoard renders the message board: every channel's messages, or
+// only those of c when it is non-nil. form, if not nil, is a post that
+// was turned down, to fill the post form with.
+func (app *App) renderBoard(w http.ResponseWriter, r *http.Request, c *store.Channel, form *postForm) {
+	data, err := app.boardData(r, c)
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	data.Form = form
+	app.renderTemplate(w, r, "index.html", data)
+}
+
+// boardData gathers what the board shows of c, or of every channel when c
+// is nil: its messages, filtered by r's q or tag, and the channels to post
+// to.
+func (app *App) boardData(r *http.Request, c *store.Channel) (TemplateData, error) {
+	opts := store.ListOptions{
+		Query: strings.TrimSpace(r.URL.Query().Get("q")),
+		Tag:   strings.ToLower(r.URL.Query().Get("tag")),
+	}
+	data := TemplateData{Title: "Home", Query: opts.Query, Tag: opts.Tag, Channel: c, Board: "/", Now: time.Now()}
+	if c != nil {
+		opts.Channel = c.Name
+		data.Title = "#" + c.Name
+		data.Board = "/c/" + c.Name
+	}
+	q, tag := opts.Query, opts.Tag
+	var msgs []store.Message
+	var err error
+	opts.Hidden, err = app.hiddenChannels(r)
+	if err == nil {
+		msgs, _, err = app.messages.List(r.Context(), opts)
+	}
+	if err == nil {
+		data.Channels, err = app.readableChannels(r)
+	}
+	if err == nil {
+		err = app.withAttachments(r.Context(), msgs)
+	}
+	if err == nil {
+		err = app.withReactions(r.Context(), msgs)
+	}
+	if err == nil {
+		err = app.withAvatars(r.Context(), msgs)
+	}
+	if err != nil {
+		return data, err
+	}
+	data.Messages = msgs
+	if can(r, PermModerate) && q == "" && tag == "" {
+		if data.Scheduled, _, err = app.messages.List(r.Context(), store.ListOptions{Scheduled: true, Channel: opts.Channel, Hidden: opts.Hidden}); err != nil {
+			return data, err
+		}
+	}
+	if q == "" && tag == "" {
+		data.Threads = buildThreads(msgs)
+	} else {
+		// Filtered results stay flat; a match may sit deep in a thread.
+		for _, m := range msgs {
+			data.Threads = append(data.Threads, &ThreadNode{Message: m})
+		}
+	}
+	return data, nil
+}
+
+func (app *App) aboutHandler(w http.ResponseWriter, r *http.Request) {
+	data := TemplateData{Title: "About", Now: time.Now()}
+	app.renderTemplate(w, r, "about.html", data)
+}
+
+func (app *App) submitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !requirePermissionPage(w, r, PermPost) {
+		return
+	}
+	msg, err := app.postMessage(r)
+	var fe fieldErrors
+	switch {
+	case errors.As(err, &fe) && msg.ParentID != 0:
+		redirectWithFlash(w, r, referringPage(r), flashError, "Your reply was not posted: "+err.Error())
+	case errors.As(err, &fe):
+		app.rejectPost(w, r, fe)
+	case errors.Is(err, store.ErrParentNotFound):
+		redirectWithFlash(w, r, referringPage(r), flashError, errParentGone)
+	case err != nil:
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+	default:
+		redirectWithFlash(w, r, "/c/"+msg.Channel, flashSuccess, postedText(w, r, msg))
+	}
+}
+
+// errParentGone is what a reply to a message deleted meanwhile is told.
+const errParentGone = "The message you replied to no longer exists."
+
+// postMessage creates the message, or reply, that r's post form
+// describes. Problems with the input come back as fieldErrors.
+func (app *App) postMessage(r *http.Request) (store.Message, error) {
+	r.ParseForm()
+	parent, _ := strconv.Atoi(r.PostForm.Get("parent_id"))
+	msg := store.Message{
+		Author:   r.PostForm.Get("author"),
+		Email:    r.PostForm.Get("email"),
+		Content:  r.PostForm.Get("content"),
+		ParentID: parent,
+		Tags:     splitTags(r.PostForm.Get("tags")),
+		Channel:  r.PostForm.Get("channel"),
+	}
+	ups, err := app.checkNewMessage(r, &msg, r.PostForm)
+	if err == nil {
+		err = app.messages.Create(r.Context(), &msg)
+	}
+	if err == nil {
+		err = app.saveAttachments(r.Context(), &msg, ups)
+	}
+	return msg, err
+}
+
+// postedText is the flash for msg, just posted from the post form.
+func postedText(w http.ResponseWriter, r *http.Request, msg store.Message) string {
+	if msg.PublishAt != nil && msg.PublishAt.After(time.Now()) {
+		return "Your message will be posted " + msg.PublishAt.In(pageZone(w, r)).Format("Jan 2, 15:04 MST") + "."
+	}
+	return "Your message was posted."
+}
+
+// postForm is the board's post form as submitted, shown again with what
+// was wrong with it.
+type postForm struct {
+	Values url.Values
+	Errors fieldErrors
+}
+
+// Value is what was entered in field, or "" when there is no form.
+func (f *postForm) Value(field string) string {
+	if f == nil {
+		return ""
+	}
+	return f.Values.Get(field)
+}
+
+// Error is the problem with field, or "" when there is none.
+func (f *postForm) Error(field string) string {
+	if f == nil {
+		return ""
+	}
+	return f.Errors[field]
+}
+
+// rejectPost shows the board again, with the post form filled in as
+// submitted and errs next to the fields they are about, rather than
+// losing what was typed. It is the board of the channel posted to, unless
+// that channel is itself the problem.
+func (app *App) rejectPost(w http.ResponseWriter, r *http.Request, errs fieldErrors) {
+	var board *store.Channel
+	if c, err := app.getChannel(r, r.PostForm.Get("channel")); err == nil && errs["channel"] == "" {
+		board = &c
+	}
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	app.renderBoard(w, r, board, &postForm{Values: r.PostForm, Errors: errs})
+}
+
+// messagePage is messageAPI for the /messages/{id} pages.
+func (app *App) messagePage(h func(w http.ResponseWriter, r *http.Request, id int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if err := app.checkMessageAccess(r, id); errors.Is(err, store.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "store", "err", err)
+			return
+		}
+		h(w, r, id)
+	}
+}
+
+// editMessageHandler serves GET and POST /messages/{id}/edit, the edit
+// form for moderators. The form carries the version it was opened at; if
+// another moderator saved an edit since, it comes back with the text as
+// submitted and the new version, for a second Save to overwrite theirs.
+func (app *App) editMessageHandler(w http.ResponseWriter, r *http.Request, id int) {
+	msg, ok := app.moderatedMessage(w, r, id)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		app.renderTemplate(w, r, "edit.html", TemplateData{Title: "Edit message", Message: &msg, Now: time.Now()})
+		return
+	}
+	r.ParseForm()
+	if v, err := strconv.Atoi(r.PostForm.Get("version")); err == nil {
+		msg.Version = v
+	}
+	msg.Author = r.PostForm.Get("author")
+	msg.Content = r.PostForm.Get("content")
+	msg.Tags = splitTags(r.PostForm.Get("tags"))
+	err := parseSchedule(r.PostForm, &msg)
+	if err == nil {
+		err = validateMessage(&msg)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		app.renderTemplate(w, r, "edit.html", TemplateData{Title: "Edit message", Message: &msg, Flash: errorFlash(err.Error()), Now: time.Now()})
+		return
+	}
+	err = app.messages.Update(r.Context(), &msg)
+	if errors.Is(err, store.ErrVersionConflict) {
+		if current, err := app.messages.Get(r.Context(), id); err == nil {
+			msg.Version = current.Version
+		}
+		w.WriteHeader(http.StatusConflict)
+		app.renderTemplate(w, r, "edit.html", TemplateData{Title: "Edit message", Message: &msg, Flash: errorFlash("Someone else edited this message after you opened it; see its history. Save again to replace their edit with yours."), Now: time.Now()})
+		return
+	}
+	moderated(w, r, "Message updated.", err)
+}
+
+// moderateMessageHandler serves the moderators' buttons on the board:
+// POST /messages/{id}/delete, /pin and /unpin.
+func (app *App) moderateMessageHandler(w http.ResponseWriter, r *http.Request, id int) {
+	action := r.PathValue("action")
+	if action != "delete" && action != "pin" && action != "unpin" {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := app.moderatedMessage(w, r, id); !ok {
+		return
+	}
+	if action == "delete" {
+		moderated(w, r, "Message moved to the trash.", app.messages.Delete(r.Context(), id))
+	} else {
+		done := map[string]string{"pin": "Message pinned.", "unpin": "Message unpinned."}[action]
+		moderated(w, r, done, app.messages.SetPinned(r.Context(), id, action == "pin"))
+	}
+}
+
+// moderatedMessage returns message id for a moderator's form, or answers
+// for itself and reports false.
+func (app *App) moderatedMessage(w http.ResponseWriter, r *http.Request, id int) (store.Message, bool) {
+	if !requirePermissionPage(w, r, PermModerate) {
+		return store.Message{}, false
+	}
+	msg, err := app.messages.Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return msg, false
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return msg, false
+	}
+	return msg, true
+}
+
+// moderated finishes a moderator's form with err, the result of the
+// change: back to the board with done or why it failed, unless the store
+// failed.
+func moderated(w http.ResponseWriter, r *http.Request, done string, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		redirectWithFlash(w, r, "/", flashError, "The message no longer exists.")
+	case err != nil:
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+	default:
+		redirectWithFlash(w, r, "/", flashSuccess, done)
+	}
+}
+
+// maxWait is the longest a long poll of GET /api/messages is held open,
+// well within what proxies allow a quiet request.
+const maxWait = 60 * time.Second
+
+// listMessagesAPIHandler serves GET /api/messages, a page of messages.
+// With ?wait=N and the ETag or Last-Modified of the client's copy, it is a
+// long poll for clients that cannot use the event stream: while the copy
+// is current the request is held open, for up to N seconds, until a
+// change makes it stale, and then answered with the page, or else with 304.
+func (app *App) listMessagesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType := messageType(w, r)
+	if mediaType == "" {
+		return
+	}
+	opts, page, perPage, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	wait, err := parseWait(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Scheduled && !requirePermission(w, r, PermModerate) {
+		return
+	}
+	if opts.Hidden, err = app.hiddenChannels(r); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	var events chan Event
+	var timeout <-chan time.Time
+	if wait > 0 && (r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "") {
+		// Subscribed before the first look, so no change slips in between.
+		events = app.live.Subscribe()
+		defer app.live.Unsubscribe(events)
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+		// The server's WriteTimeout would otherwise cut the wait short.
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+	}
+	for {
+		p, total, err := app.messageListPage(w, r, mediaType, opts)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		if events != nil && p.notModified(r) && awaitEvent(r.Context(), events, timeout) {
+			continue
+		}
+		setPaginationHeaders(w, r, page, perPage, total)
+		p.write(w, r)
+		return
+	}
+}
+
+// messageListPage lists the messages opts asks for, as mediaType.
+func (app *App) messageListPage(w http.ResponseWriter, r *http.Request, mediaType string, opts store.ListOptions) (*messagePage, int, error) {
+	msgs, total, err := app.messages.List(r.Context(), opts)
+	if err == nil {
+		err = app.withAttachments(r.Context(), msgs)
+	}
+	if err == nil {
+		err = app.withReactions(r.Context(), msgs)
+	}
+	if err == nil {
+		err = app.withAvatars(r.Context(), msgs)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	p, err := newMessagePage(w, r, mediaType, msgs, total)
+	return p, total, err
+}
+
+// awaitEvent waits for an event, and reports whether one came before
+// timeout, the hub closing or ctx ending. Events that came with it, say
+// from a bulk import, are taken too, so they make one look at the list.
+func awaitEvent(ctx context.Context, events chan Event, timeout <-chan time.Time) bool {
+	select {
+	case _, ok := <-events:
+		for ok {
+			select {
+			case _, ok = <-events:
+			default:
+				return true
+			}
+		}
+		return false
+	case <-timeout:
+	case <-ctx.Done():
+	}
+	return false
+}
+
+// parseWait reads ?wait=, the seconds a long poll may wait.
+func parseWait(q url.Values) (time.Duration, error) {
+	v := q.Get("wait")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > int(maxWait/time.Second) {
+		return 0, fmt.Errorf("wait must be between 0 and %d seconds", int(maxWait/time.Second))
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+// createMessageAPIHandler serves POST /api/messages, as JSON or, with
+// attachments, as multipart/form-data. With an Idempotency-Key, a retry
+// gets the message the first attempt created; see claimIdempotencyKey.
+func (app *App) createMessageAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Author, Email, Content string
+		ParentID               int `json:"parent_id"`
+		Tags                   []string
+		Channel                string
+		PublishAt              *time.Time `json:"publish_at"`
+		ExpiresAt              *time.Time `json:"expires_at"`
+	}
+	// Attachments come as multipart/form-data with the same fields
+	// plus "files"; everything else is JSON.
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "multipart/form-data" {
+		if err := r.ParseMultipartForm(maxUploadMemory); bodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad multipart form")
+			return
+		}
+		in.Author = r.PostFormValue("author")
+		in.Email = r.PostFormValue("email")
+		in.Content = r.PostFormValue("content")
+		in.ParentID, _ = strconv.Atoi(r.PostFormValue("parent_id"))
+		in.Tags = splitTags(r.PostFormValue("tags"))
+		in.Channel = r.PostFormValue("channel")
+	} else if !decodeJSON(w, r, &in) {
+		return
+	}
+	msg := store.Message{Author: in.Author, Email: in.Email, Content: in.Content, ParentID: in.ParentID, Tags: in.Tags, Channel: in.Channel, PublishAt: in.PublishAt, ExpiresAt: in.ExpiresAt}
+	claim, ok := app.claimIdempotencyKey(w, r, in)
+	if !ok {
+		return
+	}
+	// The key stands for the message only once the post has succeeded;
+	// a failure after Create, say saving an attachment, frees it.
+	var posted int
+	defer func() { claim.finish(r.Context(), posted) }()
+	var form url.Values
+	if r.MultipartForm != nil {
+		form = r.PostForm
+	}
+	ups, err := app.checkNewMessage(r, &msg, form)
+	var fe fieldErrors
+	if errors.As(err, &fe) {
+		writeInputError(w, err)
+		return
+	}
+	if err == nil {
+		err = app.messages.Create(r.Context(), &msg)
+	}
+	if err == nil {
+		err = app.saveAttachments(r.Context(), &msg, ups)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	posted = msg.ID
+	inZone(requestZone(r), &msg)
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+)
+
+// parseListQuery reads page, per_page, sort, author, tag, channel, pinned,
+// since and scheduled from a /api/messages query string.
+func parseListQuery(q url.Values) (opts store.ListOptions, page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	if v := q.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return opts, 0, 0, errors.New("page must be a positive integer")
+		}
+	}
+	if v := q.Get("per_page"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 || perPage > maxPerPage {
+			return opts, 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+	}
+	switch opts.Sort = q.Get("sort"); opts.Sort {
+	case "", "created", "author":
+	default:
+		return opts, 0, 0, errors.New("sort must be created or author")
+	}
+	opts.Author = q.Get("author")
+	opts.Tag = strings.ToLower(q.Get("tag"))
+	opts.Channel = strings.ToLower(q.Get("channel"))
+	if v := q.Get("pinned"); v != "" {
+		pinned, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, 0, 0, errors.New("pinned must be true or false")
+		}
+		opts.Pinned = &pinned
+	}
+	if v := q.Get("since"); v != "" {
+		if opts.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return opts, 0, 0, errors.New("since must be an RFC 3339 timestamp")
+		}
+	}
+	if v := q.Get("scheduled"); v != "" {
+		if opts.Scheduled, err = strconv.ParseBool(v); err != nil {
+			return opts, 0, 0, errors.New("scheduled must be true or false")
+		}
+	}
+	opts.Offset = (page - 1) * perPage
+	opts.Limit = perPage
+	return opts, page, perPage, nil
+}
+
+// setPaginationHeaders writes X-Total-Count and an RFC 8288 Link header with
+// first/prev/next/last relations that keep the caller's other parameters.
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, page, perPage, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	last := (total + perPage - 1) / perPage
+	if last < 1 {
+		last = 1
+	}
+	link := func(p int, rel string) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(p))
+		q.Set("per_page", strconv.Itoa(perPage))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.EscapedPath(), q.Encode(), rel)
+	}
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(page-1, "prev"))
+	}
+	if page < last {
+		links = append(links, link(page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+	w.Header().Add("Link", strings.Join(links, ", "))
+}
+
+// searchAPIHandler serves GET /api/messages/search?q=, a case-insensitive
+// match on author and content. It takes the same paging parameters as
+// GET /api/messages.
+func (app *App) searchAPIHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType := messageType(w, r)
+	if mediaType == "" {
+		return
+	}
+	opts, page, perPage, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Query = strings.TrimSpace(r.URL.Query().Get("q")); opts.Query == "" {
+		writeJSONError(w, http.StatusBadRequest, "q required")
+		return
+	}
+	if opts.Scheduled && !requirePermission(w, r, PermModerate) {
+		return
+	}
+	if opts.Hidden, err = app.hiddenChannels(r); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	msgs, total, err := app.messages.List(r.Context(), opts)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	setPaginationHeaders(w, r, page, perPage, total)
+	writeMessagePage(w, r, mediaType, msgs, total)
+}
+
+// messageAPI adapts h, which serves one message's API routes, to a route
+// with an {id}: it answers 404 unless id is a message the client may see.
+func (app *App) messageAPI(h func(w http.ResponseWriter, r *http.Request, id int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil || id <= 0 {
+			writeJSONError(w, http.StatusNotFound, "message not found")
+			return
+		}
+		if err := app.checkMessageAccess(r, id); err != nil {
+			storeError(w, r, err)
+			return
+		}
+		h(w, r, id)
+	}
+}
+
+// getMessageAPIHandler serves GET /api/messages/{id}.
+func (app *App) getMessageAPIHandler(w http.ResponseWriter, r *http.Request, id int) {
+	mediaType := messageType(w, r)
+	if mediaType == "" {
+		return
+	}
+	msg, err := app.messages.Get(r.Context(), id)
+	if err == nil && !msg.Visible(time.Now()) && !can(r, PermModerate) {
+		err = store.ErrNotFound
+	}
+	if err == nil {
+		msg.Attachments, err = app.attachmentsOf(r.Context(), id)
+	}
+	if err == nil {
+		msg.Reactions, err = app.reactionsOf(r.Context(), id)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	msg.Avatar = app.messageAvatar(r.Context(), msg)
+	inZone(requestZone(r), &msg)
+	w.Header().Set("ETag", messageETag(msg, mediaType))
+	writeMessage(w, mediaType, msg)
+}
+
+// updateMessageAPIHandler serves PUT and PATCH /api/messages/{id} for
+// moderators. PUT replaces the message; PATCH changes the fields given.
+// Either names the version it was made to, as If-Match or in the body, and
+// gets 409 with the message as it now is if someone else's edit came
+// first, rather than overwriting it.
+func (app *App) updateMessageAPIHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if !requirePermission(w, r, PermModerate) {
+		return
+	}
+	// Fields are pointers so PATCH can tell "absent" from "empty".
+	var in struct {
+		ID        *int
+		Version   *int
+		Author    *string
+		Content   *string
+		Tags      *[]string
+		PublishAt optionalTime `json:"publish_at"`
+		ExpiresAt optionalTime `json:"expires_at"`
+	}
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.ID != nil && *in.ID != id {
+		writeJSONError(w, http.StatusConflict, "id in body does not match URL")
+		return
+	}
+	if r.Method == http.MethodPut && (in.Author == nil || in.Content == nil) {
+		writeJSONError(w, http.StatusBadRequest, "PUT requires author and content")
+		return
+	}
+	version, err := editVersion(r, in.Version)
+	if errors.Is(err, errNoVersion) {
+		writeJSONError(w, http.StatusPreconditionRequired, err.Error())
+		return
+	} else if errors.Is(err, errETagMismatch) {
+		writeJSONError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	msg, err := app.messages.Get(r.Context(), id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if version != 0 {
+		msg.Version = version
+	}
+	if in.Author != nil {
+		msg.Author = *in.Author
+	}
+	if in.Content != nil {
+		msg.Content = *in.Content
+	}
+	if in.Tags != nil {
+		msg.Tags = *in.Tags
+	} else if r.Method == http.MethodPut {
+		msg.Tags = nil
+	}
+	if in.PublishAt.Set || r.Method == http.MethodPut {
+		msg.PublishAt = in.PublishAt.Time
+	}
+	if in.ExpiresAt.Set || r.Method == http.MethodPut {
+		msg.ExpiresAt = in.ExpiresAt.Time
+	}
+	if err := validateMessage(&msg); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	err = app.messages.Update(r.Context(), &msg)
+	if errors.Is(err, store.ErrVersionConflict) {
+		app.writeVersionConflict(w, r, id, msg.Version)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	inZone(requestZone(r), &msg)
+	w.Header().Set("ETag", messageETag(msg, "application/json"))
+	writeJSON(w, http.StatusOK, msg)
+}
+
+// writeVersionConflict answers an edit made to version of message id,
+// which has moved on, with 409 and the message as it is now, to merge the
+// edit into.
+func (app *App) writeVersionConflict(w http.ResponseWriter, r *http.Request, id, version int) {
+	msg, err := app.messages.Get(r.Context(), id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	inZone(requestZone(r), &msg)
+	w.Header().Set("ETag", messageETag(msg, "application/json"))
+	writeJSON(w, http.StatusConflict, map[string]any{
+		"error":   fmt.Sprintf("message was edited since version %d; it is at version %d", version, msg.Version),
+		"message": msg,
+	})
+}
+
+// deleteMessageAPIHandler serves DELETE /api/messages/{id} for moderators.
+func (app *App) deleteMessageAPIHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if !requirePermission(w, r, PermModerate) {
+		return
+	}
+	if err := app.messages.Delete(r.Context(), id); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
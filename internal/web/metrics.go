@@ -0,0 +1,191 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	storeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "slrs_store_operation_duration_seconds",
+		Help:    "Message store call latency by operation and result.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"op", "result"})
+
+	monitorUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slrs_monitor_up",
+		Help: "Whether each uptime monitor's last check found its target up (1) or down (0).",
+	}, []string{"monitor"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slrs_response_cache_requests_total",
+		Help: "Requests to cached routes by whether the response cache had them (hit) or not (miss).",
+	}, []string{"result"})
+
+	apiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slrs_api_requests_total",
+		Help: "API requests by version, unversioned for the deprecated /api/... paths.",
+	}, []string{"version"})
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "slrs_messages",
+		Help: "Number of messages on the board.",
+	}, func() float64 {
+		app := exported.Load()
+		if app == nil || app.messages == nil {
+			return 0
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, total, err := app.messages.List(ctx, store.ListOptions{Limit: 1})
+		if err != nil {
+			return 0
+		}
+		return float64(total)
+	})
+}
+
+// tracer starts the spans for store calls and monitor rounds.
+var tracer = otel.Tracer("example.com/go-sample-site")
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// instrumentedStore times and traces every MessageStore call.
+type instrumentedStore struct {
+	store.MessageStore
+}
+
+// storeOp is one MessageStore call in progress.
+type storeOp struct {
+	name  string
+	start time.Time
+	span  trace.Span
+}
+
+// startStoreOp starts timing the call name and a span for it under ctx.
+func startStoreOp(ctx context.Context, name string) (context.Context, storeOp) {
+	ctx, span := tracer.Start(ctx, "store."+name)
+	return ctx, storeOp{name: name, start: time.Now(), span: span}
+}
+
+// end records the call's duration and result. ErrNotFound counts as a
+// success: the store did its job.
+func (op storeOp) end(err error) {
+	result := "ok"
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		result = "error"
+	} else {
+		err = nil
+	}
+	storeDuration.WithLabelValues(op.name, result).Observe(time.Since(op.start).Seconds())
+	endSpan(op.span, err)
+}
+
+func (s instrumentedStore) List(ctx context.Context, opts store.ListOptions) ([]store.Message, int, error) {
+	ctx, op := startStoreOp(ctx, "list")
+	msgs, total, err := s.MessageStore.List(ctx, opts)
+	op.end(err)
+	return msgs, total, err
+}
+
+func (s instrumentedStore) Each(ctx context.Context, fn func(store.Message) error) error {
+	ctx, op := startStoreOp(ctx, "each")
+	err := s.MessageStore.Each(ctx, fn)
+	op.end(err)
+	return err
+}
+
+func (s instrumentedStore) Get(ctx context.Context, id int) (store.Message, error) {
+	ctx, op := startStoreOp(ctx, "get")
+	msg, err := s.MessageStore.Get(ctx, id)
+	op.end(err)
+	return msg, err
+}
+
+func (s instrumentedStore) Thread(ctx context.Context, id int) ([]store.Message, error) {
+	ctx, op := startStoreOp(ctx, "thread")
+	msgs, err := s.MessageStore.Thread(ctx, id)
+	op.end(err)
+	return msgs, err
+}
+
+func (s instrumentedStore) Due(ctx context.Context, after, until time.Time) ([]store.Message, error) {
+	ctx, op := startStoreOp(ctx, "due")
+	msgs, err := s.MessageStore.Due(ctx, after, until)
+	op.end(err)
+	return msgs, err
+}
+
+func (s instrumentedStore) Create(ctx context.Context, msg *store.Message) error {
+	ctx, op := startStoreOp(ctx, "create")
+	err := s.MessageStore.Create(ctx, msg)
+	op.end(err)
+	return err
+}
+
+func (s instrumentedStore) CreateBatch(ctx context.Context, msgs []*store.Message) error {
+	ctx, op := startStoreOp(ctx, "create_batch")
+	err := s.MessageStore.CreateBatch(ctx, msgs)
+	op.end(err)
+	return err
+}
+
+func (s instrumentedStore) Update(ctx context.Context, msg *store.Message) error {
+	ctx, op := startStoreOp(ctx, "update")
+	err := s.MessageStore.Update(ctx, msg)
+	op.end(err)
+	return err
+}
+
+func (s instrumentedStore) Revisions(ctx context.Context, id int) ([]store.Revision, error) {
+	ctx, op := startStoreOp(ctx, "revisions")
+	revs, err := s.MessageStore.Revisions(ctx, id)
+	op.end(err)
+	return revs, err
+}
+
+func (s instrumentedStore) SetPinned(ctx context.Context, id int, pinned bool) error {
+	ctx, op := startStoreOp(ctx, "set_pinned")
+	err := s.MessageStore.SetPinned(ctx, id, pinned)
+	op.end(err)
+	return err
+}
+
+func (s instrumentedStore) Delete(ctx context.Context, id int) error {
+	ctx, op := startStoreOp(ctx, "delete")
+	err := s.MessageStore.Delete(ctx, id)
+	op.end(err)
+	return err
+}
+
+func (s instrumentedStore) Restore(ctx context.Context, id int) error {
+	ctx, op := startStoreOp(ctx, "restore")
+	err := s.MessageStore.Restore(ctx, id)
+	op.end(err)
+	return err
+}
+
+func (s instrumentedStore) Purge(ctx context.Context, id int) error {
+	ctx, op := startStoreOp(ctx, "purge")
+	err := s.MessageStore.Purge(ctx, id)
+	op.end(err)
+	return err
+}
@@ -0,0 +1,300 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/store"
+)
+
+const (
+	monitorTimeout   = 10 * time.Second
+	monitorRetention = 30 * 24 * time.Hour // check results kept in the store
+	sparklineLength  = 60                  // results drawn in each sparkline
+)
+
+// monitorClient follows redirects, so a target is up if wherever it sends
+// the probe answers.
+var monitorClient = &http.Client{Timeout: monitorTimeout}
+
+// setupMonitors sets up config.SelfMonitor and the monitors in cfg.
+func (app *App) setupMonitors(cfg config.Config) error {
+	ms, err := config.ParseMonitors(cfg.Monitors)
+	if err != nil {
+		return err
+	}
+	app.monitors = append([]config.Monitor{{Name: config.SelfMonitor, Target: "health checks"}}, ms...)
+	return nil
+}
+
+// monitorState is what the monitor loop remembers about a monitor between
+// rounds.
+type monitorState struct {
+	up    bool
+	since time.Time // when it went up or down; zero if not seen
+}
+
+// startMonitors probes every monitor each interval until the returned
+// function is called, saving the results and posting to the board when a
+// monitor goes down or comes back up.
+func (app *App) startMonitors(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ctx := context.Background()
+		states := app.lastMonitorStates(ctx)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		var pruned time.Time
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				app.runMonitors(ctx, states)
+				if now.Sub(pruned) >= time.Hour {
+					if err := app.monitorResults.PruneCheckResults(ctx, now.Add(-monitorRetention)); err != nil {
+						slog.ErrorContext(ctx, "monitor", "err", err)
+					}
+					pruned = now
+				}
+			}
+		}
+	}()
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// lastMonitorStates picks up where the last process left off, so a restart
+// does not announce anything. Monitors without results count as up.
+func (app *App) lastMonitorStates(ctx context.Context) map[string]monitorState {
+	states := make(map[string]monitorState)
+	for _, m := range app.monitors {
+		states[m.Name] = monitorState{up: true}
+		rs, err := app.monitorResults.RecentCheckResults(ctx, m.Name, 1)
+		if err != nil {
+			slog.ErrorContext(ctx, "monitor", "monitor", m.Name, "err", err)
+			continue
+		}
+		if len(rs) > 0 {
+			states[m.Name] = monitorState{up: rs[0].Up}
+		}
+	}
+	return states
+}
+
+// runMonitors probes every monitor at once and records the results.
+func (app *App) runMonitors(ctx context.Context, states map[string]monitorState) {
+	ctx, span := tracer.Start(ctx, "monitors")
+	defer span.End()
+	results := make([]store.CheckResult, len(app.monitors))
+	var wg sync.WaitGroup
+	for i, m := range app.monitors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = app.probe(ctx, m)
+		}()
+	}
+	wg.Wait()
+	for i, r := range results {
+		m := app.monitors[i]
+		if err := app.monitorResults.AddCheckResult(ctx, &r); err != nil {
+			slog.ErrorContext(ctx, "monitor", "monitor", m.Name, "err", err)
+		}
+		up := 0.0
+		if r.Up {
+			up = 1
+		}
+		monitorUp.WithLabelValues(m.Name).Set(up)
+		prev := states[m.Name]
+		if prev.up == r.Up {
+			continue
+		}
+		states[m.Name] = monitorState{up: r.Up, since: time.Now()}
+		slog.WarnContext(ctx, "monitor changed state", "monitor", m.Name, "up", r.Up, "err", r.Error)
+		var after time.Duration
+		if !prev.since.IsZero() {
+			after = time.Since(prev.since).Round(time.Second)
+		}
+		app.announceMonitor(ctx, m, r, after)
+	}
+}
+
+// probe checks m once.
+func (app *App) probe(ctx context.Context, m config.Monitor) store.CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, monitorTimeout)
+	defer cancel()
+	start := time.Now()
+	err := app.probeTarget(ctx, m)
+	r := store.CheckResult{Monitor: m.Name, Up: err == nil, Latency: time.Since(start)}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func (app *App) probeTarget(ctx context.Context, m config.Monitor) error {
+	if m.Name == config.SelfMonitor {
+		for _, name := range app.healthCheckNames() {
+			if err := app.runHealthCheck(ctx, name); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return nil
+	}
+	u, err := url.Parse(m.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "tcp" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", u.Host)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.Target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "slrs-monitor")
+	resp, err := monitorClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+// announceMonitor posts to the board that m went down or came back up,
+// tagged monitor and with its name. after is how long it had been in the
+// other state, if known.
+func (app *App) announceMonitor(ctx context.Context, m config.Monitor, r store.CheckResult, after time.Duration) {
+	var content string
+	switch {
+	case !r.Up:
+		content = fmt.Sprintf("**%s is down** · %s · %s", m.Name, m.Target, r.Error)
+	case after > 0:
+		content = fmt.Sprintf("**%s is back up** after %s", m.Name, after)
+	default:
+		content = fmt.Sprintf("**%s is back up**", m.Name)
+	}
+	tags, _ := normalizeTags([]string{"monitor", m.Name})
+	msg := store.Message{Author: "Monitors", Content: content, Tags: tags}
+	if err := app.messages.Create(ctx, &msg); err != nil {
+		slog.ErrorContext(ctx, "monitor message", "monitor", m.Name, "err", err)
+	}
+}
+
+// uptimeWindows are the periods /monitors reports uptime over.
+var uptimeWindows = []struct {
+	Label  string
+	Period time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", monitorRetention},
+}
+
+// monitorView is one monitor on /monitors.
+type monitorView struct {
+	config.Monitor
+	Last      *store.CheckResult
+	Uptime    []uptime // one per uptimeWindows entry
+	Sparkline sparkline
+}
+
+// uptime is the share of checks in a window that found a monitor up.
+type uptime struct {
+	Label   string
+	Percent float64
+	Checks  int
+}
+
+// sparkline is an SVG drawing of the latest latencies, oldest on the left:
+// Points for a polyline and the spots where the monitor was down.
+type sparkline struct {
+	Points string
+	Down   []sparkPoint
+	Max    time.Duration // the latency at the top
+}
+
+type sparkPoint struct{ X, Y float64 }
+
+// Size of the sparkline's viewBox.
+const sparkWidth, sparkHeight = 120.0, 24.0
+
+// newSparkline draws rs, which are newest first.
+func newSparkline(rs []store.CheckResult) sparkline {
+	var s sparkline
+	for _, r := range rs {
+		s.Max = max(s.Max, r.Latency)
+	}
+	s.Max = max(s.Max, time.Millisecond)
+	var b strings.Builder
+	for i, r := range rs {
+		p := sparkPoint{
+			X: sparkWidth - float64(i)*sparkWidth/float64(max(len(rs)-1, 1)),
+			Y: sparkHeight - float64(r.Latency)/float64(s.Max)*sparkHeight,
+		}
+		fmt.Fprintf(&b, "%.1f,%.1f ", p.X, p.Y)
+		if !r.Up {
+			s.Down = append(s.Down, p)
+		}
+	}
+	s.Points = strings.TrimSpace(b.String())
+	return s
+}
+
+// monitorsHandler serves GET /monitors, the uptime dashboard.
+func (app *App) monitorsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	views := make([]monitorView, 0, len(app.monitors))
+	for _, m := range app.monitors {
+		v, err := app.viewMonitor(ctx, m)
+		if err != nil {
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "store", "err", err)
+			return
+		}
+		views = append(views, v)
+	}
+	app.renderTemplate(w, r, "monitors.html", TemplateData{Title: "Monitors", Monitors: views, Now: time.Now()})
+}
+
+func (app *App) viewMonitor(ctx context.Context, m config.Monitor) (monitorView, error) {
+	v := monitorView{Monitor: m}
+	rs, err := app.monitorResults.RecentCheckResults(ctx, m.Name, sparklineLength)
+	if err != nil {
+		return v, err
+	}
+	if len(rs) > 0 {
+		v.Last = &rs[0]
+	}
+	v.Sparkline = newSparkline(rs)
+	for _, win := range uptimeWindows {
+		up, total, err := app.monitorResults.Uptime(ctx, m.Name, time.Now().Add(-win.Period))
+		if err != nil {
+			return v, err
+		}
+		u := uptime{Label: win.Label, Checks: total}
+		if total > 0 {
+			u.Percent = 100 * float64(up) / float64(total)
+		}
+		v.Uptime = append(v.Uptime, u)
+	}
+	return v, nil
+}
@@ -0,0 +1,262 @@
+package web
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// messageTypes are the media types the message API answers in, the
+// default first: JSON, XML for the monitoring tools that read nothing
+// else, and plain text for people and shell scripts.
+var messageTypes = []string{"application/json", "application/xml", "text/plain", "text/xml"}
+
+// negotiate returns the offer r's Accept header ranks highest, the
+// earlier of those it ranks alike, or "" if it accepts none. Without an
+// Accept header it returns the first offer.
+func negotiate(r *http.Request, offers []string) string {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// The most specific range matching the offer sets its quality.
+		q, specificity := 0.0, -1
+		for _, ranges := range accept {
+			for _, rng := range strings.Split(ranges, ",") {
+				mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(rng))
+				if err != nil {
+					continue
+				}
+				s := rangeSpecificity(mediaType, offer)
+				if s <= specificity {
+					continue
+				}
+				specificity, q = s, 1
+				if v, ok := params["q"]; ok {
+					q, _ = strconv.ParseFloat(v, 64)
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// rangeSpecificity is 2 when the media range matches offer exactly, 1 for
+// type/*, 0 for */* and -1 when it does not match.
+func rangeSpecificity(mediaRange, offer string) int {
+	typ, _, _ := strings.Cut(offer, "/")
+	switch mediaRange {
+	case offer:
+		return 2
+	case typ + "/*":
+		return 1
+	case "*/*":
+		return 0
+	}
+	return -1
+}
+
+// messageType picks the media type of messages for r, adding Accept to
+// Vary. A browser, which asks for HTML and ranks XML above anything else,
+// gets JSON as it always has. If r accepts none of messageTypes, it
+// answers 406 and returns "".
+func messageType(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Add("Vary", "Accept")
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return messageTypes[0]
+	}
+	t := negotiate(r, messageTypes)
+	if t == "" {
+		writeJSONError(w, http.StatusNotAcceptable, "messages are served as "+strings.Join(messageTypes, ", "))
+	}
+	return t
+}
+
+// contentType is the Content-Type header of a response of mediaType.
+func contentType(mediaType string) string {
+	if mediaType == "application/json" {
+		return mediaType
+	}
+	return mediaType + "; charset=utf-8"
+}
+
+// encodeMessages renders a page of msgs, of total across all pages, as
+// mediaType.
+func encodeMessages(marshal func(any) ([]byte, error), mediaType string, msgs []store.Message, total int) ([]byte, error) {
+	switch mediaType {
+	case "application/xml", "text/xml":
+		page := xmlMessages{Total: total, Messages: make([]xmlMessage, 0, len(msgs))}
+		for _, m := range msgs {
+			page.Messages = append(page.Messages, xmlMessageOf(m))
+		}
+		return marshalXML(page)
+	case "text/plain":
+		var b bytes.Buffer
+		for _, m := range msgs {
+			writeMessageText(&b, m)
+		}
+		return b.Bytes(), nil
+	}
+	return marshal(msgs)
+}
+
+// writeMessage answers with msg as mediaType.
+func writeMessage(w http.ResponseWriter, mediaType string, msg store.Message) {
+	if mediaType == "application/json" {
+		writeJSON(w, http.StatusOK, msg)
+		return
+	}
+	var body []byte
+	var err error
+	if mediaType == "text/plain" {
+		var b bytes.Buffer
+		writeMessageText(&b, msg)
+		body = b.Bytes()
+	} else {
+		body, err = marshalXML(xmlMessageOf(msg))
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "encoding failed")
+		return
+	}
+	w.Header().Set("Content-Type", contentType(mediaType))
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func marshalXML(v any) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
+
+// xmlMessages and xmlMessage are the XML form of the message API: the JSON
+// fields, named alike, with the ID as an attribute.
+type xmlMessages struct {
+	XMLName  xml.Name     `xml:"messages"`
+	Total    int          `xml:"total,attr"`
+	Messages []xmlMessage `xml:"message"`
+}
+
+type xmlMessage struct {
+	XMLName     xml.Name        `xml:"message"`
+	ID          int             `xml:"id,attr"`
+	Channel     string          `xml:"channel,omitempty"`
+	Author      string          `xml:"author"`
+	Content     string          `xml:"content"`
+	Created     time.Time       `xml:"created"`
+	Updated     *time.Time      `xml:"updated,omitempty"`
+	ParentID    int             `xml:"parent_id,omitempty"`
+	Tags        *xmlTags        `xml:"tags,omitempty"`
+	Pinned      bool            `xml:"pinned,omitempty"`
+	Revisions   int             `xml:"revisions,omitempty"`
+	Version     int             `xml:"version"`
+	Attachments *xmlAttachments `xml:"attachments,omitempty"`
+	Reactions   *xmlReactions   `xml:"reactions,omitempty"`
+	Avatar      string          `xml:"avatar,omitempty"`
+	PublishAt   *time.Time      `xml:"publish_at,omitempty"`
+	ExpiresAt   *time.Time      `xml:"expires_at,omitempty"`
+}
+
+// The lists are wrapped so that, like the JSON fields, they are left out
+// when empty.
+type (
+	xmlTags struct {
+		Tags []string `xml:"tag"`
+	}
+	xmlAttachments struct {
+		Attachments []xmlAttachment `xml:"attachment"`
+	}
+	xmlReactions struct {
+		Reactions []xmlReaction `xml:"reaction"`
+	}
+)
+
+type xmlAttachment struct {
+	ID          int    `xml:"id,attr"`
+	Name        string `xml:"name"`
+	ContentType string `xml:"content_type"`
+	Size        int64  `xml:"size"`
+}
+
+type xmlReaction struct {
+	Reaction string `xml:"name,attr"`
+	Emoji    string `xml:"emoji,attr"`
+	Count    int    `xml:"count,attr"`
+}
+
+func xmlMessageOf(m store.Message) xmlMessage {
+	x := xmlMessage{
+		ID: m.ID, Channel: m.Channel, Author: m.Author, Content: m.Content, Created: m.Created, Updated: m.Updated,
+		ParentID: m.ParentID, Pinned: m.Pinned, Revisions: m.Revisions, Version: m.Version, Avatar: m.Avatar,
+		PublishAt: m.PublishAt, ExpiresAt: m.ExpiresAt,
+	}
+	if len(m.Tags) > 0 {
+		x.Tags = &xmlTags{m.Tags}
+	}
+	if len(m.Attachments) > 0 {
+		x.Attachments = &xmlAttachments{}
+		for _, a := range m.Attachments {
+			x.Attachments.Attachments = append(x.Attachments.Attachments, xmlAttachment{a.ID, a.Name, a.ContentType, a.Size})
+		}
+	}
+	if len(m.Reactions) > 0 {
+		x.Reactions = &xmlReactions{}
+		for _, rc := range m.Reactions {
+			x.Reactions.Reactions = append(x.Reactions.Reactions, xmlReaction{rc.Reaction, rc.Emoji, rc.Count})
+		}
+	}
+	return x
+}
+
+// writeMessageText writes m as plain text: a line of key=value fields,
+// the content indented under it, and a blank line.
+//
+//	id=12 author=ada created=2026-03-01T09:30:00Z channel=ops tags=deploy,ci
+//	  Deployed v1.4.0 to production.
+func writeMessageText(b *bytes.Buffer, m store.Message) {
+	fmt.Fprintf(b, "id=%d author=%s created=%s", m.ID, textValue(m.Author), m.Created.Format(time.RFC3339))
+	if m.Channel != "" {
+		fmt.Fprintf(b, " channel=%s", textValue(m.Channel))
+	}
+	if m.ParentID != 0 {
+		fmt.Fprintf(b, " parent_id=%d", m.ParentID)
+	}
+	if len(m.Tags) > 0 {
+		fmt.Fprintf(b, " tags=%s", textValue(strings.Join(m.Tags, ",")))
+	}
+	if m.Pinned {
+		b.WriteString(" pinned=true")
+	}
+	if m.Updated != nil {
+		fmt.Fprintf(b, " updated=%s", m.Updated.Format(time.RFC3339))
+	}
+	b.WriteByte('\n')
+	for line := range strings.Lines(m.Content) {
+		b.WriteString("  " + strings.TrimRight(line, "\r\n") + "\n")
+	}
+	b.WriteByte('\n')
+}
+
+// textValue quotes s if it would not read back as one value.
+func textValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
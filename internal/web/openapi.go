@@ -0,0 +1,13 @@
+package web
+
+import "net/http"
+
+func (app *App) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(app.openAPI)
+}
+
+func (app *App) apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(app.apiDocs)
+}
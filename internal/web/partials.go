@@ -0,0 +1,94 @@
+package web
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// The /partials routes answer with pieces of the board's HTML, rendered
+// by the same templates as the page, for app.js, or htmx, to swap in
+// without a reload: the message list and the post form. They take the
+// board they are for as ?channel=, the whole board without.
+
+// partialBoard returns the channel r's ?channel= names, or nil for the
+// whole board. It reports false when there is no such channel for r, and
+// it has answered.
+func (app *App) partialBoard(w http.ResponseWriter, r *http.Request) (*store.Channel, bool) {
+	name := r.URL.Query().Get("channel")
+	if name == "" {
+		return nil, true
+	}
+	c, err := app.getChannel(r, name)
+	if errors.Is(err, store.ErrChannelNotFound) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return nil, false
+	}
+	return &c, true
+}
+
+// messagesPartialHandler serves GET /partials/messages, the items of the
+// board's message list, filtered by q or tag like the board.
+func (app *App) messagesPartialHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := app.partialBoard(w, r)
+	if !ok {
+		return
+	}
+	data, err := app.boardData(r, c)
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	app.renderFragment(w, r, "messages", data)
+}
+
+// submitPartialHandler serves POST /partials/submit, /submit for scripts.
+// Rather than redirect, it answers with the board's post form: cleared,
+// under a flash saying the message was posted, or, with a 422, as
+// submitted with what was wrong with it.
+func (app *App) submitPartialHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePermissionPage(w, r, PermPost) {
+		return
+	}
+	c, ok := app.partialBoard(w, r)
+	if !ok {
+		return
+	}
+	msg, err := app.postMessage(r)
+	data := TemplateData{Channel: c, Now: time.Now()}
+	status := http.StatusOK
+	var fe fieldErrors
+	switch {
+	case errors.As(err, &fe) && msg.ParentID != 0:
+		data.Flash, status = errorFlash("Your reply was not posted: "+err.Error()), http.StatusUnprocessableEntity
+	case errors.As(err, &fe):
+		data.Form, status = &postForm{Values: r.PostForm, Errors: fe}, http.StatusUnprocessableEntity
+	case errors.Is(err, store.ErrParentNotFound):
+		data.Flash, status = errorFlash(errParentGone), http.StatusUnprocessableEntity
+	case err != nil:
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	default:
+		data.Flash = &Flash{Level: flashSuccess, Text: postedText(w, r, msg)}
+	}
+	if data.Channels, err = app.readableChannels(r); err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	if status != http.StatusOK {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+	}
+	app.renderFragment(w, r, "post", data)
+}
@@ -0,0 +1,23 @@
+package web
+
+import "net/http"
+
+// pinAPIHandler serves /api/messages/{id}/pin for moderators: PUT or POST pins
+// the message, DELETE unpins it. Either way the message is returned.
+func (app *App) pinAPIHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if !requirePermission(w, r, PermModerate) {
+		return
+	}
+	pinned := r.Method != http.MethodDelete
+	if err := app.messages.SetPinned(r.Context(), id, pinned); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	msg, err := app.messages.Get(r.Context(), id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	inZone(requestZone(r), &msg)
+	writeJSON(w, http.StatusOK, msg)
+}
@@ -0,0 +1,126 @@
+package web
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// profilePerPage is how many messages a /u/{author} page shows.
+const profilePerPage = 20
+
+// profilePage is /u/{author}: whoever posted under that name, with an
+// account or not, and their messages newest first.
+type profilePage struct {
+	Name             string
+	Account          *store.User // nil for names without an account, e.g. deploy bots
+	Avatar           string
+	Messages         []store.Message
+	Page, Total      int
+	PrevURL, NextURL string
+}
+
+// profileURL links to the /u/ page of the author called name.
+func profileURL(name string) string {
+	return "/u/" + url.PathEscape(name)
+}
+
+// profileHandler serves GET /u/{name}, the messages posted under that
+// name that the visitor may read, paged by ?page=. Names nobody has posted
+// under and that are not accounts are 404.
+func (app *App) profileHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ctx := r.Context()
+	p := &profilePage{Name: name, Page: 1}
+	if n, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && n > 1 {
+		p.Page = n
+	}
+	u, err := app.users.GetUser(ctx, name)
+	if err == nil {
+		p.Account = &u
+	} else if !errors.Is(err, store.ErrUserNotFound) {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	opts := store.ListOptions{Author: name, Offset: (p.Page - 1) * profilePerPage, Limit: profilePerPage}
+	opts.Hidden, err = app.hiddenChannels(r)
+	if err == nil {
+		p.Messages, p.Total, err = app.messages.List(ctx, opts)
+	}
+	if err == nil {
+		err = app.withAttachments(ctx, p.Messages)
+	}
+	if err == nil {
+		err = app.withReactions(ctx, p.Messages)
+	}
+	if err == nil {
+		err = app.withAvatars(ctx, p.Messages)
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	if p.Account == nil && p.Total == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if p.Account != nil {
+		p.Avatar = avatarURL(p.Account, "")
+	} else if len(p.Messages) > 0 {
+		p.Avatar = p.Messages[0].Avatar
+	}
+	if p.Page > 1 {
+		p.PrevURL = profileURL(name) + "?page=" + strconv.Itoa(p.Page-1)
+	}
+	if p.Page*profilePerPage < p.Total {
+		p.NextURL = profileURL(name) + "?page=" + strconv.Itoa(p.Page+1)
+	}
+	app.renderTemplate(w, r, "profile.html", TemplateData{Title: name, Profile: p, Now: time.Now()})
+}
+
+// userMessagesAPIHandler serves GET /api/users/{name}/messages, the
+// messages posted under name, paged and filtered like GET /api/messages.
+// Unlike the rest of /api/users it is open to anyone who can read the
+// board, and name need not be an account.
+func (app *App) userMessagesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType := messageType(w, r)
+	if mediaType == "" {
+		return
+	}
+	opts, page, perPage, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Scheduled && !requirePermission(w, r, PermModerate) {
+		return
+	}
+	opts.Author = r.PathValue("name")
+	if opts.Hidden, err = app.hiddenChannels(r); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	msgs, total, err := app.messages.List(r.Context(), opts)
+	if err == nil {
+		err = app.withAttachments(r.Context(), msgs)
+	}
+	if err == nil {
+		err = app.withReactions(r.Context(), msgs)
+	}
+	if err == nil {
+		err = app.withAvatars(r.Context(), msgs)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	setPaginationHeaders(w, r, page, perPage, total)
+	writeMessagePage(w, r, mediaType, msgs, total)
+}
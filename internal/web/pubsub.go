@@ -0,0 +1,160 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+// With several replicas, each one's hub only hears of the writes made
+// there. A broker relays them, so that live pages and sockets on every
+// replica show every message. Only those are fed what other replicas
+// relay, through app.live: the notifiers stay on app.hub, lest a message
+// go out to Slack or subscribers once per replica.
+
+// The channel, or subject, events are relayed on.
+const (
+	redisEventsChannel = redisPrefix + "events"
+	natsEventsSubject  = "slrs.events"
+)
+
+// broker relays events between replicas.
+type broker interface {
+	publish(ctx context.Context, data []byte) error
+	// subscribe calls deliver with everything published, this replica's
+	// own included, until close.
+	subscribe(deliver func(data []byte)) error
+	close() error
+}
+
+// relayedEvent is an Event as a broker carries it.
+type relayedEvent struct {
+	Origin string `json:"origin"` // the replica's app.origin
+	Event  Event  `json:"event"`
+	// Email is the author's address, which Event's JSON leaves out but
+	// avatars need.
+	Email string `json:"email,omitempty"`
+}
+
+// setupPubSub connects to the broker cfg.PubSub names, if any, and starts
+// relaying. Without one, app.live is app.hub.
+func (app *App) setupPubSub(cfg config.Config) error {
+	app.live = app.hub
+	switch cfg.PubSub {
+	case "":
+		return nil
+	case "redis":
+		app.broker = &redisBroker{rdb: app.redis}
+	case "nats":
+		nc, err := nats.Connect(cfg.NATSURL, nats.Name("slrs"), nats.MaxReconnects(-1))
+		if err != nil {
+			return err
+		}
+		app.broker = natsBroker{nc}
+	default:
+		return fmt.Errorf("unknown pubsub %q", cfg.PubSub)
+	}
+	app.live = newHub()
+	app.origin = randomToken()
+	if err := app.broker.subscribe(app.receiveEvent); err != nil {
+		app.broker.close()
+		return err
+	}
+	go app.relayEvents(app.hub.SubscribeAll())
+	return nil
+}
+
+// relayEvents passes this replica's events on to its live pages and to
+// the other replicas, until events is closed. events must miss nothing, or
+// the other replicas would too.
+func (app *App) relayEvents(events <-chan Event) {
+	for ev := range events {
+		app.live.Publish(ev)
+		data, err := json.Marshal(relayedEvent{Origin: app.origin, Event: ev, Email: ev.Message.Email})
+		if err != nil {
+			slog.Error("pubsub", "err", err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := app.broker.publish(ctx, data); err != nil {
+			slog.Error("pubsub publish", "err", err)
+		}
+		cancel()
+	}
+}
+
+// receiveEvent passes an event another replica relayed on to the live
+// pages here.
+func (app *App) receiveEvent(data []byte) {
+	var re relayedEvent
+	if err := json.Unmarshal(data, &re); err != nil {
+		slog.Warn("pubsub: malformed event", "err", err)
+		return
+	}
+	if re.Origin == app.origin {
+		return
+	}
+	re.Event.Message.Email = re.Email
+	app.live.Publish(re.Event)
+}
+
+// redisBroker relays events over Redis pub/sub.
+type redisBroker struct {
+	rdb *redis.Client
+	ps  *redis.PubSub
+}
+
+func (b *redisBroker) publish(ctx context.Context, data []byte) error {
+	return b.rdb.Publish(ctx, redisEventsChannel, data).Err()
+}
+
+func (b *redisBroker) subscribe(deliver func(data []byte)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b.ps = b.rdb.Subscribe(ctx, redisEventsChannel)
+	// Wait for the subscription, which Subscribe does not.
+	if _, err := b.ps.Receive(ctx); err != nil {
+		return err
+	}
+	go func() {
+		for m := range b.ps.Channel() {
+			deliver([]byte(m.Payload))
+		}
+	}()
+	return nil
+}
+
+func (b *redisBroker) close() error {
+	if b.ps == nil {
+		return nil
+	}
+	return b.ps.Close()
+}
+
+// natsBroker relays events over core NATS.
+type natsBroker struct {
+	nc *nats.Conn
+}
+
+func (b natsBroker) publish(ctx context.Context, data []byte) error {
+	return b.nc.Publish(natsEventsSubject, data)
+}
+
+func (b natsBroker) subscribe(deliver func(data []byte)) error {
+	if _, err := b.nc.Subscribe(natsEventsSubject, func(m *nats.Msg) { deliver(m.Data) }); err != nil {
+		return err
+	}
+	// Wait for the server to have the subscription.
+	return b.nc.Flush()
+}
+
+func (b natsBroker) close() error {
+	return b.nc.Drain()
+}
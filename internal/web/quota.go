@@ -0,0 +1,202 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quotaTracker gives every subject limit requests per fixed window, which
+// starts with the subject's first request.
+type quotaTracker interface {
+	// take counts a request for subject unless its budget is spent, and
+	// returns the budget as it stands afterwards.
+	take(ctx context.Context, subject string) (Quota, bool, error)
+	// list returns the subjects with a running window, most used first.
+	list(ctx context.Context) ([]Quota, error)
+	// reset gives subject a full budget again. It reports whether subject
+	// had a running window.
+	reset(ctx context.Context, subject string) (bool, error)
+}
+
+// memoryQuotas keeps the counts in process memory, so a restart hands
+// everyone a fresh budget, and each replica counts for itself.
+type memoryQuotas struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*quotaWindow
+}
+
+type quotaWindow struct {
+	used  int
+	reset time.Time
+}
+
+// Quota is one subject's budget as reported by /api/quotas.
+type Quota struct {
+	Subject   string    `json:"subject"` // user:<name> or key:<name>
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+func newMemoryQuotas(limit int, window time.Duration) *memoryQuotas {
+	return &memoryQuotas{limit: limit, window: window, windows: make(map[string]*quotaWindow)}
+}
+
+func (t *memoryQuotas) take(ctx context.Context, subject string) (Quota, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	qw, ok := t.windows[subject]
+	if !ok || !now.Before(qw.reset) {
+		qw = &quotaWindow{reset: now.Add(t.window)}
+		t.windows[subject] = qw
+	}
+	allowed := qw.used < t.limit
+	if allowed {
+		qw.used++
+	}
+	return newQuota(subject, t.limit, qw.used, qw.reset), allowed, nil
+}
+
+func newQuota(subject string, limit, used int, reset time.Time) Quota {
+	return Quota{
+		Subject:   subject,
+		Limit:     limit,
+		Used:      used,
+		Remaining: limit - used,
+		Reset:     reset.UTC(),
+	}
+}
+
+func (t *memoryQuotas) list(ctx context.Context) ([]Quota, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	list := []Quota{}
+	for subject, qw := range t.windows {
+		if now.Before(qw.reset) {
+			list = append(list, newQuota(subject, t.limit, qw.used, qw.reset))
+		}
+	}
+	sortQuotas(list)
+	return list, nil
+}
+
+// sortQuotas puts the most used first.
+func sortQuotas(list []Quota) {
+	slices.SortFunc(list, func(a, b Quota) int {
+		if a.Used != b.Used {
+			return b.Used - a.Used
+		}
+		return strings.Compare(a.Subject, b.Subject)
+	})
+}
+
+func (t *memoryQuotas) reset(ctx context.Context, subject string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	qw, ok := t.windows[subject]
+	delete(t.windows, subject)
+	return ok && time.Now().Before(qw.reset), nil
+}
+
+// sweep drops windows that have ended; the next request starts a new one
+// anyway.
+func (t *memoryQuotas) sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for subject, qw := range t.windows {
+		if !now.Before(qw.reset) {
+			delete(t.windows, subject)
+		}
+	}
+}
+
+// quotaSubject names whoever r is authenticated as, or "" for anonymous
+// requests.
+func quotaSubject(r *http.Request) string {
+	if k := currentAPIKey(r); k != nil {
+		return "key:" + k.Name
+	}
+	if u := currentUser(r); u != nil {
+		return "user:" + u.Name
+	}
+	return ""
+}
+
+// quotaMiddleware charges authenticated API requests to their user or key,
+// reporting the budget in X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset (Unix seconds) and answering 429 once it is spent. It
+// goes inside apiKeyMiddleware, which establishes who is calling. Should
+// the tracker fail, requests go through uncounted.
+func (app *App) quotaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject := quotaSubject(r)
+		if app.quotas == nil || subject == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		q, ok, err := app.quotas.take(r.Context(), subject)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "quota", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(q.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(q.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(q.Reset.Unix(), 10))
+		if !ok {
+			h.Set("Retry-After", strconv.Itoa(int(time.Until(q.Reset).Seconds())+1))
+			writeJSONError(w, http.StatusTooManyRequests, "API quota exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// quotasAPIHandler serves GET /api/quotas, the running budgets, for admins.
+func (app *App) quotasAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if app.quotas == nil {
+		writeJSON(w, http.StatusOK, []Quota{})
+		return
+	}
+	list, err := app.quotas.list(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "quota store error")
+		slog.ErrorContext(r.Context(), "quota", "err", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// quotaAPIHandler serves DELETE /api/quotas/{subject}, which resets the
+// budget of a user (user:<name>) or API key (key:<name>).
+func (app *App) quotaAPIHandler(w http.ResponseWriter, r *http.Request) {
+	subject := r.PathValue("subject")
+	if app.quotas == nil {
+		writeJSONError(w, http.StatusNotFound, "no running quota for "+subject)
+		return
+	}
+	ok, err := app.quotas.reset(r.Context(), subject)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "quota store error")
+		slog.ErrorContext(r.Context(), "quota", "err", err)
+		return
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no running quota for "+subject)
+		return
+	}
+	app.recordAudit(r.Context(), "quota.reset", subject, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
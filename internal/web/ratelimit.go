@@ -0,0 +1,136 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/go-sample-site/internal/middleware"
+)
+
+// rateLimiter is a set of token buckets keyed by client. Each bucket refills
+// at rate tokens per second up to burst.
+type rateLimiter interface {
+	// allow takes a token for key. When the bucket is empty it reports how
+	// long until the next token is available.
+	allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// memoryRateLimiter keeps the buckets in process memory, a set for each
+// replica.
+type memoryRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newMemoryRateLimiter(perMinute, burst int) *memoryRateLimiter {
+	return &memoryRateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *memoryRateLimiter) allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), nil
+}
+
+// sweep drops buckets that have refilled completely; they are
+// indistinguishable from new ones.
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// sweeper is state kept in process memory that needs clearing of what has
+// expired now and then.
+type sweeper interface {
+	sweep(now time.Time)
+}
+
+// startSweeps sweeps the in-memory limiters and idempotency keys every
+// interval until stopped. Their Redis counterparts expire keys by
+// themselves.
+func (app *App) startSweeps(interval time.Duration) (stop func()) {
+	var sweepers []sweeper
+	for _, v := range []any{app.postLimiter, app.quotas, app.idempotency} {
+		if s, ok := v.(sweeper); ok {
+			sweepers = append(sweepers, s)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				for _, s := range sweepers {
+					s.sweep(now)
+				}
+			}
+		}
+	}()
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// rateLimitMiddleware limits POST requests per client IP, answering 429
+// with Retry-After once a client's bucket is empty. Should the limiter
+// fail, posts go through.
+func (app *App) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.postLimiter == nil || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, wait, err := app.postLimiter.allow(r.Context(), middleware.ClientIP(r))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "rate limit", "err", err)
+			ok = true
+		}
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			} else {
+				http.Error(w, "Too many posts, slow down.", http.StatusTooManyRequests)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
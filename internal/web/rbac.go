@@ -0,0 +1,126 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+)
+
+// Permission is one capability on the board. Users get permissions from
+// their role, API keys from their scopes.
+type Permission string
+
+const (
+	PermRead     Permission = "read"     // view the board and the API
+	PermPost     Permission = "post"     // create messages and replies
+	PermModerate Permission = "moderate" // edit, pin, delete and restore any message
+	PermAdmin    Permission = "admin"    // import, purge, the audit log, users and API keys
+)
+
+var rolePermissions = map[string][]Permission{
+	store.RoleViewer:    {PermRead},
+	store.RolePoster:    {PermRead, PermPost},
+	store.RoleModerator: {PermRead, PermPost, PermModerate},
+	store.RoleAdmin:     {PermRead, PermPost, PermModerate, PermAdmin},
+}
+
+// scopePermissions maps API key scopes to permissions. Scopes do not imply
+// each other: a key that edits messages needs write and moderate.
+var scopePermissions = map[string][]Permission{
+	ScopeRead:     {PermRead},
+	ScopeWrite:    {PermPost},
+	ScopeModerate: {PermModerate},
+	ScopeAdmin:    {PermModerate, PermAdmin},
+}
+
+// permissionScope names the scope to ask for when a key lacks p.
+var permissionScope = map[Permission]string{
+	PermRead:     ScopeRead,
+	PermPost:     ScopeWrite,
+	PermModerate: ScopeModerate,
+	PermAdmin:    ScopeAdmin,
+}
+
+func scopesPermit(scopes []string, p Permission) bool {
+	for _, s := range scopes {
+		if slices.Contains(scopePermissions[s], p) {
+			return true
+		}
+	}
+	return false
+}
+
+// can reports whether r may do what p allows. A request with an API key
+// has only the key's scopes, and a signed-in user only their role's
+// permissions: a viewer cannot post because visitors may. Everyone else
+// has what the App's anonScopes grant.
+func can(r *http.Request, p Permission) bool {
+	if k := currentAPIKey(r); k != nil {
+		return scopesPermit(k.Scopes, p)
+	}
+	if u := currentUser(r); u != nil {
+		return slices.Contains(rolePermissions[u.Role], p)
+	}
+	return scopesPermit(anonymousScopes(r), p)
+}
+
+// requirePermission reports whether r has p. If not it writes a JSON 401
+// (no credentials) or 403 (credentials without the permission).
+func requirePermission(w http.ResponseWriter, r *http.Request, p Permission) bool {
+	if can(r, p) {
+		return true
+	}
+	switch {
+	case currentAPIKey(r) != nil:
+		writeJSONError(w, http.StatusForbidden, "API key lacks the "+permissionScope[p]+" scope")
+	case currentUser(r) == nil:
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+	default:
+		writeJSONError(w, http.StatusForbidden, string(p)+" permission required")
+	}
+	return false
+}
+
+// requirePermissionPage is requirePermission for HTML pages: anonymous
+// visitors are sent to the login form and signed-in users get a plain 403.
+func requirePermissionPage(w http.ResponseWriter, r *http.Request, p Permission) bool {
+	if can(r, p) {
+		return true
+	}
+	if currentUser(r) == nil {
+		next := r.URL.Path
+		if r.Method != http.MethodGet {
+			next = "/"
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(next), http.StatusSeeOther)
+		return false
+	}
+	http.Error(w, "Your role does not allow this.", http.StatusForbidden)
+	return false
+}
+
+// requiresPage guards a page route with p, as requirePermissionPage does.
+func requiresPage(p Permission) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requirePermissionPage(w, r, p) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// requires guards an API route with p, as requirePermission does. It goes
+// after apiKeyMiddleware, which puts the key in the context.
+func requires(p Permission) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requirePermission(w, r, p) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
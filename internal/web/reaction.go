@@ -0,0 +1,193 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// reactionKind is a reaction users can pick.
+type reactionKind struct {
+	Name  string // as stored and in the API
+	Emoji string
+}
+
+// reactionKinds are the reactions on offer, in the order they are shown.
+// ack is the one to use for "I have seen this".
+var reactionKinds = []reactionKind{
+	{"ack", "✅"},
+	{"thumbsup", "👍"},
+	{"eyes", "👀"},
+	{"tada", "🎉"},
+	{"rocket", "🚀"},
+	{"heart", "❤️"},
+}
+
+func reactionEmoji(name string) (string, bool) {
+	for _, k := range reactionKinds {
+		if k.Name == name {
+			return k.Emoji, true
+		}
+	}
+	return "", false
+}
+
+// countReactions groups rs by reaction, in reactionKinds order.
+func countReactions(rs []store.Reaction) []store.ReactionCount {
+	var counts []store.ReactionCount
+	for _, k := range reactionKinds {
+		c := store.ReactionCount{Reaction: k.Name, Emoji: k.Emoji}
+		for _, r := range rs {
+			if r.Reaction == k.Name {
+				c.Count++
+				c.Users = append(c.Users, r.User)
+			}
+		}
+		if c.Count > 0 {
+			counts = append(counts, c)
+		}
+	}
+	return counts
+}
+
+// withReactions fills in Reactions for msgs.
+func (app *App) withReactions(ctx context.Context, msgs []store.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]int, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	byMessage, err := app.reactions.Reactions(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range msgs {
+		msgs[i].Reactions = countReactions(byMessage[msgs[i].ID])
+	}
+	return nil
+}
+
+// reactionsOf returns the summed-up reactions to message id.
+func (app *App) reactionsOf(ctx context.Context, id int) ([]store.ReactionCount, error) {
+	byMessage, err := app.reactions.Reactions(ctx, []int{id})
+	return countReactions(byMessage[id]), err
+}
+
+// reactor names who reacts through r: the signed-in user, or "key:" and
+// the API key's name as in the audit log. Anonymous visitors cannot react,
+// as there would be nobody to count once.
+func reactor(r *http.Request) string {
+	if k := currentAPIKey(r); k != nil {
+		return "key:" + k.Name
+	}
+	if u := currentUser(r); u != nil {
+		return u.Name
+	}
+	return ""
+}
+
+// reactionsAPIHandler serves /api/messages/{id}/reactions: GET sums up the
+// reactions, POST {"reaction": "ack"} adds the caller's and DELETE
+// /api/messages/{id}/reactions/{reaction} takes it back. POST and DELETE
+// answer with the new sums; reacting twice the same way changes nothing.
+func (app *App) reactionsAPIHandler(w http.ResponseWriter, r *http.Request, id int) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodPost:
+		var in struct {
+			Reaction string `json:"reaction"`
+		}
+		if !decodeJSON(w, r, &in) {
+			return
+		}
+		if _, ok := reactionEmoji(in.Reaction); !ok {
+			writeInputError(w, fieldErrors{"reaction": "must be one of " + reactionNames()})
+			return
+		}
+		who := reactor(r)
+		if who == "" {
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		err := app.reactions.AddReaction(ctx, &store.Reaction{MessageID: id, Reaction: in.Reaction, User: who})
+		if err != nil && !errors.Is(err, store.ErrDuplicate) {
+			storeError(w, r, err)
+			return
+		}
+	case http.MethodDelete:
+		who := reactor(r)
+		if who == "" {
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if err := app.reactions.RemoveReaction(ctx, id, r.PathValue("reaction"), who); errors.Is(err, store.ErrReactionNotFound) {
+			writeJSONError(w, http.StatusNotFound, "reaction not found")
+			return
+		} else if err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
+	if _, err := app.messages.Get(ctx, id); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	counts, err := app.reactionsOf(ctx, id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if counts == nil {
+		counts = []store.ReactionCount{}
+	}
+	writeJSON(w, http.StatusOK, counts)
+}
+
+func reactionNames() string {
+	names := make([]string, len(reactionKinds))
+	for i, k := range reactionKinds {
+		names[i] = k.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// reactHandler serves POST /messages/{id}/react, the reaction buttons on
+// the board: it adds the signed-in user's reaction, or takes it back if
+// they had already made it.
+func (app *App) reactHandler(w http.ResponseWriter, r *http.Request, id int) {
+	who := reactor(r)
+	if who == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	name := r.FormValue("reaction")
+	if _, ok := reactionEmoji(name); !ok {
+		http.Error(w, "Unknown reaction", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	msg, err := app.messages.Get(ctx, id)
+	if err == nil {
+		err = app.reactions.AddReaction(ctx, &store.Reaction{MessageID: id, Reaction: name, User: who})
+	}
+	if errors.Is(err, store.ErrDuplicate) {
+		err = app.reactions.RemoveReaction(ctx, id, name, who)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	http.Redirect(w, r, "/c/"+msg.Channel+"#message-"+strconv.Itoa(id), http.StatusSeeOther)
+}
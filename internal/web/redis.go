@@ -0,0 +1,334 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// With redis_url set, the state replicas would otherwise each keep for
+// themselves lives in Redis instead: sessions, the post rate limit, API
+// quotas and the response cache. Keys start with redisPrefix; those made
+// from secrets or long strings are hashed.
+const redisPrefix = "slrs:"
+
+// openRedis connects to the Redis at cfg.RedisURL and checks that it
+// answers.
+func openRedis(ctx context.Context, cfg config.Config) (*redis.Client, error) {
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RedisPassword != "" {
+		opts.Password = cfg.RedisPassword
+	}
+	rdb := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+	return rdb, nil
+}
+
+// redisHash shortens s for use in a key, and keeps a token out of it.
+func redisHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// redisSessions keeps each session in a hash that expires with it.
+type redisSessions struct {
+	rdb *redis.Client
+}
+
+func (s redisSessions) key(token string) string {
+	return redisPrefix + "session:" + redisHash(token)
+}
+
+func (s redisSessions) create(ctx context.Context, sess session) (token string, expires time.Time, err error) {
+	token = randomToken()
+	expires = time.Now().Add(sessionTTL)
+	key := s.key(token)
+	_, err = s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, key, "user", sess.user, "provider", sess.provider, "id_token", sess.idToken, "expires", expires.Unix())
+		p.ExpireAt(ctx, key, expires)
+		return nil
+	})
+	return token, expires, err
+}
+
+func (s redisSessions) lookup(ctx context.Context, token string) (session, error) {
+	m, err := s.rdb.HGetAll(ctx, s.key(token)).Result()
+	if err != nil {
+		return session{}, err
+	}
+	if m["user"] == "" {
+		return session{}, errNoSession
+	}
+	expires, _ := strconv.ParseInt(m["expires"], 10, 64)
+	return session{user: m["user"], expires: time.Unix(expires, 0), provider: m["provider"], idToken: m["id_token"]}, nil
+}
+
+func (s redisSessions) delete(ctx context.Context, token string) error {
+	return s.rdb.Del(ctx, s.key(token)).Err()
+}
+
+// tokenBucket is memoryRateLimiter's bucket as a script, so that taking a
+// token is one step however many replicas share the bucket. ARGV are the
+// rate per millisecond, the burst, the time in milliseconds and how long
+// the bucket takes to refill; it returns whether a token was taken and
+// those left, as a string since Redis truncates numbers.
+var tokenBucket = redis.NewScript(`
+local rate, burst, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens, last = tonumber(b[1]) or burst, tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+local taken = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	taken = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {taken, tostring(tokens)}
+`)
+
+// redisRateLimiter keeps one token bucket per client for all replicas.
+type redisRateLimiter struct {
+	rdb   *redis.Client
+	rate  float64 // tokens per second
+	burst float64
+}
+
+func (l redisRateLimiter) allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	full := time.Duration(l.burst/l.rate*float64(time.Second)) + time.Second
+	res, err := tokenBucket.Run(ctx, l.rdb, []string{redisPrefix + "ratelimit:" + key},
+		l.rate/1000, l.burst, time.Now().UnixMilli(), full.Milliseconds()).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("rate limit script returned %v", res)
+	}
+	if taken, _ := res[0].(int64); taken == 1 {
+		return true, 0, nil
+	}
+	s, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return false, 0, err
+	}
+	return false, time.Duration((1 - tokens) / l.rate * float64(time.Second)), nil
+}
+
+// quotaTake counts a request against the quota in KEYS[1] unless ARGV[2]
+// are used up, starting a window of ARGV[1] milliseconds with the first.
+// It returns the count, whether it went up and the milliseconds left.
+var quotaTake = redis.NewScript(`
+local used = tonumber(redis.call('GET', KEYS[1]) or 0)
+local taken = 0
+if used < tonumber(ARGV[2]) then
+	used = redis.call('INCR', KEYS[1])
+	taken = 1
+	if used == 1 then
+		redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	end
+end
+return {used, taken, redis.call('PTTL', KEYS[1])}
+`)
+
+// redisQuotas keeps each subject's count in a key that expires with its
+// window.
+type redisQuotas struct {
+	rdb    *redis.Client
+	limit  int
+	window time.Duration
+}
+
+const quotaKeyPrefix = redisPrefix + "quota:"
+
+func (t redisQuotas) take(ctx context.Context, subject string) (Quota, bool, error) {
+	res, err := quotaTake.Run(ctx, t.rdb, []string{quotaKeyPrefix + subject}, t.window.Milliseconds(), t.limit).Int64Slice()
+	if err != nil {
+		return Quota{}, false, err
+	}
+	if len(res) != 3 {
+		return Quota{}, false, fmt.Errorf("quota script returned %v", res)
+	}
+	reset := time.Now().Add(time.Duration(max(res[2], 0)) * time.Millisecond)
+	return newQuota(subject, t.limit, int(res[0]), reset), res[1] == 1, nil
+}
+
+func (t redisQuotas) list(ctx context.Context) ([]Quota, error) {
+	list := []Quota{}
+	iter := t.rdb.Scan(ctx, 0, quotaKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		p := t.rdb.Pipeline()
+		used, ttl := p.Get(ctx, key), p.PTTL(ctx, key)
+		if _, err := p.Exec(ctx); errors.Is(err, redis.Nil) {
+			continue // ended since the scan
+		} else if err != nil {
+			return nil, err
+		}
+		n, err := used.Int()
+		if err != nil || ttl.Val() <= 0 {
+			continue
+		}
+		list = append(list, newQuota(strings.TrimPrefix(key, quotaKeyPrefix), t.limit, n, time.Now().Add(ttl.Val())))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sortQuotas(list)
+	return list, nil
+}
+
+func (t redisQuotas) reset(ctx context.Context, subject string) (bool, error) {
+	n, err := t.rdb.Del(ctx, quotaKeyPrefix+subject).Result()
+	return n > 0, err
+}
+
+// redisCache is a cacheStore for all replicas. The generation is a
+// counter in Redis and part of every response's key, so emptying the
+// cache is one INCR, and what was kept before simply expires.
+type redisCache struct {
+	rdb *redis.Client
+}
+
+const cacheGenKey = redisPrefix + "cache:gen"
+
+func (c redisCache) key(gen uint64, key string) string {
+	return redisPrefix + "cache:" + strconv.FormatUint(gen, 10) + ":" + redisHash(key)
+}
+
+func (c redisCache) get(ctx context.Context, key string) (*cachedResponse, uint64, error) {
+	gen, err := c.rdb.Get(ctx, cacheGenKey).Uint64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, err
+	}
+	b, err := c.rdb.Get(ctx, c.key(gen, key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, gen, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var e cachedResponse
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, 0, err
+	}
+	return &e, gen, nil
+}
+
+func (c redisCache) put(ctx context.Context, key string, gen uint64, e *cachedResponse) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, c.key(gen, key), b, time.Until(e.Expires)).Err()
+}
+
+func (c redisCache) clear(ctx context.Context) error {
+	return c.rdb.Incr(ctx, cacheGenKey).Err()
+}
+
+// idempotencyClaimScript sets KEYS[1] to ARGV[1] for ARGV[2] milliseconds unless
+// it is set, and returns what it was set to, or false.
+var idempotencyClaimScript = redis.NewScript(`
+local prev = redis.call('GET', KEYS[1])
+if not prev then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+end
+return prev
+`)
+
+// redisIdempotency keeps each key's record as JSON in a key that expires
+// with it.
+type redisIdempotency struct {
+	rdb *redis.Client
+}
+
+func (s redisIdempotency) key(key string) string {
+	return redisPrefix + "idempotency:" + redisHash(key)
+}
+
+func (s redisIdempotency) claim(ctx context.Context, key, fingerprint string) (*idempotencyRecord, error) {
+	b, err := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+	prev, err := idempotencyClaimScript.Run(ctx, s.rdb, []string{s.key(key)}, b, idempotencyClaimTTL.Milliseconds()).Text()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec idempotencyRecord
+	if err := json.Unmarshal([]byte(prev), &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s redisIdempotency) complete(ctx context.Context, key string, rec idempotencyRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, s.key(key), b, idempotencyTTL).Err()
+}
+
+func (s redisIdempotency) release(ctx context.Context, key string) error {
+	return s.rdb.Del(ctx, s.key(key)).Err()
+}
+
+// setupRedis connects to the Redis cfg names, if any, and sets up the
+// rate limit, quotas, response cache and idempotency keys cfg asks for in
+// it, or else in memory.
+func (app *App) setupRedis(cfg config.Config) error {
+	if cfg.RedisURL != "" {
+		rdb, err := openRedis(context.Background(), cfg)
+		if err != nil {
+			return err
+		}
+		app.redis = rdb
+		app.sessions = redisSessions{rdb}
+		app.healthChecks["redis"] = func(ctx context.Context) error { return rdb.Ping(ctx).Err() }
+	}
+	if cfg.RateLimit > 0 {
+		if app.redis != nil {
+			app.postLimiter = redisRateLimiter{app.redis, float64(cfg.RateLimit) / 60, float64(cfg.RateBurst)}
+		} else {
+			app.postLimiter = newMemoryRateLimiter(cfg.RateLimit, cfg.RateBurst)
+		}
+	}
+	if cfg.APIQuota > 0 {
+		if app.redis != nil {
+			app.quotas = redisQuotas{app.redis, cfg.APIQuota, cfg.APIQuotaWindow}
+		} else {
+			app.quotas = newMemoryQuotas(cfg.APIQuota, cfg.APIQuotaWindow)
+		}
+	}
+	if app.redis != nil {
+		app.cache = newResponseCache(cfg.CacheTTL, redisCache{app.redis})
+		app.idempotency = redisIdempotency{app.redis}
+	} else {
+		app.cache = newResponseCache(cfg.CacheTTL, newMemoryCache())
+		app.idempotency = newMemoryIdempotency()
+	}
+	return nil
+}
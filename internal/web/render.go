@@ -0,0 +1,184 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/go-sample-site/internal/i18n"
+	"example.com/go-sample-site/internal/store"
+)
+
+type TemplateData struct {
+	Title        string
+	Flash        *Flash // set by the handler, or else by renderTemplate from the last redirect
+	Messages     []store.Message
+	Threads      []*ThreadNode   // Messages nested by reply, flat for search results
+	Query        string          // search terms, highlighted in the message list
+	Tag          string          // tag the message list is filtered by
+	Board        string          // path of the board being shown: / or /c/{name}
+	Channel      *store.Channel  // channel the board is narrowed to
+	Channels     []store.Channel // for the channel bar and the post form
+	ChannelList  *channelsPage   // the /channels page
+	Message      *store.Message  // the message being edited
+	Form         *postForm       // the post form as submitted, shown again when it was turned down
+	Scheduled    []store.Message // waiting for their PublishAt, shown to moderators
+	Import       *ImportResult
+	History      []historyEntry // versions of Message, newest first
+	Audit        *auditPage
+	Jobs         *jobsPage
+	Webhooks     *webhooksPage
+	Backups      *backupsPage
+	Builds       []buildJob
+	Monitors     []monitorView
+	Environments *environmentsPage
+	Incidents    *incidentsPage
+	Status       *statusPage
+	Subscribe    *subscribePage
+	Profile      *profilePage
+	Dashboard    *dashboardPage
+	SSO          []*ssoProvider    // login buttons
+	Maintenance  *maintenanceState // for the admins' banner, set by renderTemplate
+	Banner       *store.Banner     // announcement atop the page, set by renderTemplate
+	User         *store.User       // signed-in user, set by renderTemplate
+	IsAdmin      bool              // set by renderTemplate
+	// CanPost and CanModerate are the signed-in user's or anonymous
+	// visitor's permissions, set by renderTemplate.
+	CanPost, CanModerate bool
+	Uploads              bool         // attachments are enabled, set by renderTemplate
+	Subscriptions        bool         // email subscriptions are enabled, set by renderTemplate
+	CI                   bool         // /hooks/jenkins is enabled, set by renderTemplate
+	CSRFToken            string       // set by renderTemplate
+	Theme                string       // set by renderTemplate
+	Themes               []string     // for the theme picker, set by renderTemplate
+	ColorScheme          string       // light, dark or "" for the browser's, set by renderTemplate
+	Locale               *i18n.Locale // the page's language, set by renderTemplate
+	Next                 string       // where the login form redirects afterwards
+	Now                  time.Time
+}
+
+func (app *App) loadTemplates(fsys fs.FS) error {
+	pages, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return err
+	}
+	t := make(map[string]*template.Template)
+	for _, page := range pages {
+		if page == "layout.html" {
+			continue
+		}
+		tmpl, err := app.parsePage(fsys, page)
+		if err != nil {
+			return err
+		}
+		t[page] = tmpl
+	}
+	app.templates = t
+	app.templateFS = fsys
+	return nil
+}
+
+// parsePage parses a page together with the shared layout and the
+// partials it and the pages build on.
+func (app *App) parsePage(fsys fs.FS, page string) (*template.Template, error) {
+	return template.New(page).Funcs(app.templateFuncs()).ParseFS(fsys, "layout.html", "partials/*.html", page)
+}
+
+// lookupTemplate returns the template set for a page, re-parsing it from
+// disk in development mode.
+func (app *App) lookupTemplate(name string) (*template.Template, error) {
+	if app.devMode {
+		return app.parsePage(app.templateFS, name)
+	}
+	t, ok := app.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("no such page %q", name)
+	}
+	return t, nil
+}
+
+// renderTemplate executes the named page with the signed-in user filled in.
+func (app *App) renderTemplate(w http.ResponseWriter, r *http.Request, name string, data TemplateData) {
+	app.execute(w, r, name, name, data)
+}
+
+// renderFragment executes the partial named name on its own, for scripts
+// that swap a piece of the page rather than load all of it. Every page
+// has the partials; they are taken from the board's.
+func (app *App) renderFragment(w http.ResponseWriter, r *http.Request, name string, data TemplateData) {
+	app.execute(w, r, "index.html", name, data)
+}
+
+// execute executes the template name in page's set with data and what
+// every page is given.
+func (app *App) execute(w http.ResponseWriter, r *http.Request, page, name string, data TemplateData) {
+	t, err := app.lookupTemplate(page)
+	if err != nil {
+		msg := "Template error"
+		if app.devMode {
+			msg += ": " + err.Error()
+		}
+		http.Error(w, msg, http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "template", "page", name, "err", err)
+		return
+	}
+	if f := takeFlash(w, r); data.Flash == nil {
+		data.Flash = f
+	}
+	data.User = currentUser(r)
+	data.IsAdmin = can(r, PermAdmin)
+	data.CanPost = can(r, PermPost)
+	data.CanModerate = can(r, PermModerate)
+	data.Uploads = app.attachmentLimits.maxFiles > 0
+	data.Subscriptions = app.mails != nil
+	data.CI = app.jenkinsToken != ""
+	data.CSRFToken = csrfToken(r)
+	data.Theme, data.Themes = app.pageTheme(r), app.themes
+	data.ColorScheme = pageColorScheme(r)
+	data.Locale = pageLocale(w, r).In(pageZone(w, r))
+	w.Header().Set("Content-Language", data.Locale.Code())
+	if data.IsAdmin {
+		data.Maintenance = app.maintenance.Load()
+	}
+	data.Banner = app.pageBanner(r)
+	// Set rather than sniffed: templates write in small pieces, too small
+	// for middleware.Compress to recognise as HTML, and the access log
+	// records the type before net/http would sniff it.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "template", "page", name, "err", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, _ := marshalOf(w)(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeJSONError sends {"error": msg}, the error shape used by every /api route.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// storeError reports a MessageStore failure to an API client.
+func storeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "message not found")
+		return
+	}
+	if errors.Is(err, store.ErrParentNotFound) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slog.ErrorContext(r.Context(), "store", "err", err)
+	writeJSONError(w, http.StatusInternalServerError, "Store error")
+}
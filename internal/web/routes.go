@@ -0,0 +1,234 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"example.com/go-sample-site/internal/middleware"
+)
+
+// routeMethods are the methods noRoute tries when it looks for the ones a
+// path does take.
+var routeMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// routeGroup registers routes on a mux behind a shared middleware chain.
+type routeGroup struct {
+	mux    *http.ServeMux
+	chain  middleware.Chain
+	prefix string
+}
+
+// Use returns a group that registers on the same mux with mws added to
+// the chain, inside what g already has.
+func (g routeGroup) Use(mws ...middleware.Middleware) routeGroup {
+	return routeGroup{mux: g.mux, chain: g.chain.Use(mws...), prefix: g.prefix}
+}
+
+// Mount returns a group that registers its patterns' paths under prefix,
+// within g's own prefix, as the API versions are.
+func (g routeGroup) Mount(prefix string) routeGroup {
+	return routeGroup{mux: g.mux, chain: g.chain, prefix: g.prefix + prefix}
+}
+
+// Handle registers h for pattern behind the group's chain and then mws,
+// the route's own.
+func (g routeGroup) Handle(pattern string, h http.HandlerFunc, mws ...middleware.Middleware) {
+	if method, path, ok := strings.Cut(pattern, " "); ok {
+		pattern = method + " " + g.prefix + path
+	} else {
+		pattern = g.prefix + pattern
+	}
+	g.mux.Handle(pattern, g.chain.Use(mws...).Then(h))
+}
+
+// Handler returns the board's routes behind the middleware that needs the
+// board: auditing, body limits, CSRF checks, sessions and maintenance
+// mode. The generic middleware (client addresses, tracing, request IDs,
+// panics, HSTS, compression and CORS) is the caller's to add.
+//
+// Routes are method and wildcard patterns, so a request for a path that
+// exists with the wrong method is answered 405 with an Allow header
+// before any handler runs, and handlers read their {id} or {name} with
+// r.PathValue. Routes are registered through groups that share a chain,
+// pages, readers, admins, api and so on, rather than wrapped one by one.
+func (app *App) Handler() http.Handler {
+	mux := http.NewServeMux()
+	// Every route but the static files is logged.
+	pages := routeGroup{mux: mux, chain: middleware.NewChain(middleware.Logging(app.accessLog, accessUser))}
+	readers, moderators, admins := pages.Use(requiresPage(PermRead)), pages.Use(requiresPage(PermModerate)), pages.Use(requiresPage(PermAdmin))
+	limited := pages.Use(app.rateLimitMiddleware)
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(app.static))))
+	readers.Handle("GET /{$}", app.indexHandler, app.cache.cached)
+	readers.Handle("GET /c/{name}", app.channelHandler, app.cache.cached)
+	readers.Handle("GET /u/{name}", app.profileHandler)
+	readers.Handle("GET /channels", app.channelsHandler)
+	admins.Handle("POST /channels", app.channelsHandler)
+	admins.Handle("POST /channels/{name}/{action}", app.channelsHandler)
+	pages.Handle("GET /about", app.aboutHandler)
+	// Feed readers may authenticate like API clients.
+	pages.Handle("GET /feed.xml", app.feedHandler, app.apiKeyMiddleware)
+	pages.Handle("GET /atom.xml", app.feedHandler, app.apiKeyMiddleware)
+	// Any method, so that a GET after a failed login is sent to the board.
+	limited.Handle("/submit", app.submitHandler)
+	readers.Handle("GET /partials/messages", app.messagesPartialHandler, app.cache.cached)
+	limited.Handle("POST /partials/submit", app.submitPartialHandler)
+	readers.Handle("GET /messages/{id}/history", app.messagePage(app.historyHandler))
+	readers.Handle("POST /messages/{id}/react", app.messagePage(app.reactHandler))
+	readers.Handle("GET /messages/{id}/edit", app.messagePage(app.editMessageHandler))
+	readers.Handle("POST /messages/{id}/edit", app.messagePage(app.editMessageHandler))
+	readers.Handle("POST /messages/{id}/{action}", app.messagePage(app.moderateMessageHandler))
+	readers.Handle("GET /attachments/{id}", app.attachmentHandler)
+	readers.Handle("GET /avatars/{name}", app.avatarHandler)
+	pages.Handle("GET /status", app.statusHandler)
+	admins.Handle("POST /status/components", app.statusHandler)
+	admins.Handle("POST /status/components/{id}", app.statusHandler)
+	admins.Handle("POST /status/components/{id}/{action}", app.statusHandler)
+	readers.Handle("GET /incidents", app.incidentsHandler)
+	moderators.Handle("POST /incidents", app.incidentsHandler)
+	moderators.Handle("POST /incidents/{id}", app.incidentsHandler)
+	readers.Handle("GET /environments", app.environmentsHandler)
+	readers.Handle("GET /monitors", app.monitorsHandler)
+	readers.Handle("GET /builds", app.buildsHandler)
+	limited.Handle("GET /subscribe", app.subscribeHandler, requiresPage(PermRead))
+	limited.Handle("POST /subscribe", app.subscribeHandler, requiresPage(PermRead))
+	limited.Handle("GET /subscribe/confirm", app.confirmSubscriptionHandler)
+	limited.Handle("POST /subscribe/confirm", app.confirmSubscriptionHandler)
+	limited.Handle("GET /unsubscribe", app.unsubscribeHandler)
+	limited.Handle("POST /unsubscribe", app.unsubscribeHandler)
+	pages.Handle("GET /account", app.accountHandler)
+	pages.Handle("POST /account", app.accountHandler)
+	admins.Handle("GET /admin", app.adminDashboardHandler)
+	admins.Handle("GET /admin/import", app.adminImportHandler)
+	admins.Handle("POST /admin/import", app.adminImportHandler)
+	moderators.Handle("GET /admin/trash", app.adminTrashHandler)
+	moderators.Handle("POST /admin/trash/{id}/restore", app.restoreTrashHandler)
+	admins.Handle("POST /admin/trash/{id}/purge", app.purgeTrashHandler)
+	admins.Handle("GET /admin/jobs", app.adminJobsHandler)
+	admins.Handle("POST /admin/jobs/{id}/retry", app.retryJobHandler)
+	admins.Handle("GET /admin/webhooks", app.adminWebhooksHandler)
+	admins.Handle("POST /admin/webhooks", app.adminWebhooksHandler)
+	admins.Handle("POST /admin/webhooks/{id}/delete", app.deleteWebhookHandler)
+	admins.Handle("GET /admin/audit", app.adminAuditHandler)
+	admins.Handle("GET /admin/backups", app.adminBackupsHandler)
+	admins.Handle("POST /admin/backups", app.adminBackupsHandler)
+	admins.Handle("POST /admin/backups/{name}/restore", app.adminRestoreHandler)
+	admins.Handle("POST /admin/maintenance", app.adminMaintenanceHandler)
+	admins.Handle("POST /admin/banner", app.adminBannerHandler)
+
+	app.handleAPI(pages, limited)
+
+	readers.Handle("GET /ws", app.wsHandler)
+	pages.Handle("GET /login", app.loginHandler)
+	pages.Handle("POST /login", app.loginHandler)
+	pages.Handle("/logout", app.logoutHandler)
+	pages.Handle("POST /theme", app.themeHandler)
+	pages.Handle("POST /color-scheme", app.colorSchemeHandler)
+	pages.Handle("GET /auth/{provider}/{step}", app.ssoHandler)
+	pages.Handle("POST /hooks/github", app.githubHookHandler)
+	pages.Handle("POST /hooks/jenkins", app.jenkinsHookHandler)
+	if app.internalAddr == "" {
+		pages.Handle("/debug/", debugHandler, app.apiKeyMiddleware)
+		app.handleOps(mux)
+	}
+	unrouted := pages.chain.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		noRoute(mux, w, r)
+	})
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			unrouted.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+	return middleware.NewChain(auditMiddleware, bodyLimitMiddleware, csrfMiddleware, app.sessionMiddleware, app.maintenanceMiddleware, app.cache.invalidating).Then(routed)
+}
+
+// apiV1Routes registers v1 of the API. The API authenticates API keys and
+// counts them against their quota before the handler, or a route's
+// permission check, runs.
+func (app *App) apiV1Routes(g apiGroups) {
+	g.api.Handle("GET /messages", app.listMessagesAPIHandler, app.cache.cached)
+	g.limited.Use(app.apiKeyMiddleware, app.quotaMiddleware, apiTimeZone).Handle("POST /messages", app.createMessageAPIHandler)
+	g.limited.Use(app.apiKeyMiddleware, app.quotaMiddleware, apiTimeZone).Handle("POST /messages/bulk", app.bulkCreateAPIHandler)
+	g.api.Handle("DELETE /messages", app.bulkDeleteAPIHandler)
+	g.api.Handle("GET /messages/stream", app.streamHandler)
+	g.api.Handle("GET /messages/search", app.searchAPIHandler)
+	g.api.Handle("GET /messages/export", app.exportHandler)
+	g.api.Handle("GET /messages/{id}", app.messageAPI(app.getMessageAPIHandler))
+	g.api.Handle("PUT /messages/{id}", app.messageAPI(app.updateMessageAPIHandler))
+	g.api.Handle("PATCH /messages/{id}", app.messageAPI(app.updateMessageAPIHandler))
+	g.api.Handle("DELETE /messages/{id}", app.messageAPI(app.deleteMessageAPIHandler))
+	g.api.Handle("GET /messages/{id}/thread", app.messageAPI(app.threadAPIHandler))
+	g.api.Handle("GET /messages/{id}/revisions", app.messageAPI(app.revisionsAPIHandler))
+	g.api.Handle("PUT /messages/{id}/pin", app.messageAPI(app.pinAPIHandler))
+	g.api.Handle("POST /messages/{id}/pin", app.messageAPI(app.pinAPIHandler))
+	g.api.Handle("DELETE /messages/{id}/pin", app.messageAPI(app.pinAPIHandler))
+	g.api.Handle("GET /messages/{id}/reactions", app.messageAPI(app.reactionsAPIHandler))
+	g.api.Handle("POST /messages/{id}/reactions", app.messageAPI(app.reactionsAPIHandler))
+	g.api.Handle("DELETE /messages/{id}/reactions/{reaction}", app.messageAPI(app.reactionsAPIHandler))
+	g.limited.Handle("POST /token", app.tokenAPIHandler)
+	g.adminAPI.Handle("GET /keys", app.listKeysAPIHandler)
+	g.adminAPI.Handle("POST /keys", app.createKeyAPIHandler)
+	g.adminAPI.Handle("DELETE /keys/{name}", app.deleteKeyAPIHandler)
+	g.api.Handle("GET /stats", app.statsAPIHandler)
+	g.api.Handle("GET /version", app.versionAPIHandler)
+	g.adminAPI.Handle("GET /users", app.listUsersAPIHandler)
+	g.adminAPI.Handle("POST /users", app.createUserAPIHandler)
+	g.adminAPI.Handle("PUT /users/{name}", app.updateUserAPIHandler)
+	g.adminAPI.Handle("PATCH /users/{name}", app.updateUserAPIHandler)
+	g.adminAPI.Handle("DELETE /users/{name}", app.deleteUserAPIHandler)
+	g.api.Handle("GET /users/{name}/messages", app.userMessagesAPIHandler)
+	g.adminAPI.Handle("GET /quotas", app.quotasAPIHandler)
+	g.adminAPI.Handle("DELETE /quotas/{subject}", app.quotaAPIHandler)
+	g.api.Handle("GET /deployments", app.listDeploymentsAPIHandler, requires(PermRead))
+	g.api.Handle("POST /deployments", app.createDeploymentAPIHandler, requires(PermPost))
+	g.api.Handle("GET /deployments/{id}", app.getDeploymentAPIHandler, requires(PermRead))
+	g.api.Handle("POST /deployments/{id}/finish", app.finishDeploymentAPIHandler, requires(PermPost))
+	g.api.Handle("GET /status", app.statusAPIHandler)
+	g.api.Handle("GET /incidents", app.listIncidentsAPIHandler, requires(PermRead))
+	g.api.Handle("POST /incidents", app.openIncidentAPIHandler, requires(PermModerate))
+	g.api.Handle("GET /incidents/{id}", app.getIncidentAPIHandler, requires(PermRead))
+	g.api.Handle("PATCH /incidents/{id}", app.updateIncidentAPIHandler, requires(PermModerate))
+	g.api.Handle("POST /incidents/{id}/resolve", app.resolveIncidentAPIHandler, requires(PermModerate))
+	g.adminAPI.Handle("GET /audit", app.auditAPIHandler)
+	g.adminAPI.Handle("GET /audit/export", app.auditExportHandler)
+	g.adminAPI.Handle("GET /backups", app.backupsAPIHandler)
+	g.adminAPI.Handle("POST /backups", app.createBackupAPIHandler)
+	g.adminAPI.Handle("GET /backups/{name}", app.backupAPIHandler)
+	g.adminAPI.Handle("POST /backups/{name}/restore", app.restoreBackupAPIHandler)
+	g.adminAPI.Handle("GET /maintenance", app.getMaintenanceAPIHandler)
+	g.adminAPI.Handle("PUT /maintenance", app.setMaintenanceAPIHandler)
+	g.api.Handle("GET /banner", app.getBannerAPIHandler)
+	g.adminAPI.Handle("PUT /banner", app.setBannerAPIHandler)
+	g.adminAPI.Handle("DELETE /banner", app.clearBannerAPIHandler)
+	g.api.Handle("GET /channels", app.listChannelsAPIHandler)
+	g.adminAPI.Handle("POST /channels", app.createChannelAPIHandler)
+	g.api.Handle("GET /channels/{name}", app.getChannelAPIHandler)
+	g.adminAPI.Handle("PATCH /channels/{name}", app.updateChannelAPIHandler)
+	g.pages.Handle("GET /openapi.json", app.openAPIHandler)
+	g.pages.Handle("GET /docs", app.apiDocsHandler)
+}
+
+// noRoute answers a request that no route of mux takes: 405 with an Allow
+// header if the path takes other methods, 404 otherwise. The API and the
+// webhooks answer in JSON like their handlers; pages get mux's plain text.
+func noRoute(mux *http.ServeMux, w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/hooks/") {
+		mux.ServeHTTP(w, r)
+		return
+	}
+	var allow []string
+	for _, m := range routeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = m
+		if _, pattern := mux.Handler(probe); pattern != "" {
+			allow = append(allow, m)
+		}
+	}
+	if len(allow) == 0 {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// errNoSuchObject is returned by s3Bucket.open for a key not in the
+// bucket; its callers turn it into their own not-found error.
+var errNoSuchObject = errors.New("no such object")
+
+// s3PartSize is the part size of uploads whose size is not known ahead,
+// such as exports; each part is buffered in memory.
+const s3PartSize = 16 << 20
+
+// s3Bucket is a bucket on any S3-compatible service (AWS, MinIO, Ceph,
+// R2, ...). The attachments, backups and exports keep their objects in it
+// under a prefix each, written with the server-side encryption configured.
+type s3Bucket struct {
+	client *minio.Client
+	name   string
+	prefix string             // prepended to keys
+	sse    encrypt.ServerSide // nil for the bucket's default
+}
+
+// openS3 connects to the service cfg configures and checks that bucket
+// exists. Objects are named prefix+key.
+func openS3(cfg config.Config, bucket, prefix string) (*s3Bucket, error) {
+	creds := credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, "")
+	if cfg.S3AccessKey == "" {
+		// As the AWS SDKs look for them.
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+	sse, err := s3Encryption(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !cfg.S3Insecure,
+		Region: cfg.S3Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ok, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("checking bucket %q: %w", bucket, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("bucket %q does not exist", bucket)
+	}
+	return &s3Bucket{client: client, name: bucket, prefix: prefix, sse: sse}, nil
+}
+
+// s3Encryption returns the server-side encryption cfg.S3SSE asks for.
+func s3Encryption(cfg config.Config) (encrypt.ServerSide, error) {
+	switch cfg.S3SSE {
+	case "s3":
+		return encrypt.NewSSE(), nil
+	case "kms":
+		return encrypt.NewSSEKMS(cfg.S3SSEKMSKey, nil)
+	case "c":
+		key, err := base64.StdEncoding.DecodeString(cfg.S3SSECustomerKey)
+		if err != nil {
+			return nil, fmt.Errorf("S3_SSE_CUSTOMER_KEY: %w", err)
+		}
+		return encrypt.NewSSEC(key)
+	}
+	return nil, nil
+}
+
+func (b *s3Bucket) put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	opts := minio.PutObjectOptions{ContentType: contentType, ServerSideEncryption: b.sse}
+	if size < 0 {
+		opts.PartSize = s3PartSize
+	}
+	_, err := b.client.PutObject(ctx, b.name, b.prefix+key, r, size, opts)
+	return err
+}
+
+// open returns the object key, or errNoSuchObject.
+func (b *s3Bucket) open(ctx context.Context, key string) (*minio.Object, error) {
+	var opts minio.GetObjectOptions
+	if b.sse != nil && b.sse.Type() == encrypt.SSEC {
+		// Only a customer key has to be sent again to read.
+		opts.ServerSideEncryption = b.sse
+	}
+	obj, err := b.client.GetObject(ctx, b.name, b.prefix+key, opts)
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; Stat makes a missing object an error here rather
+	// than on the first read.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, errNoSuchObject
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (b *s3Bucket) remove(ctx context.Context, key string) error {
+	return b.client.RemoveObject(ctx, b.name, b.prefix+key, minio.RemoveObjectOptions{})
+}
+
+// list calls fn with the key, prefix trimmed, and size of each object.
+func (b *s3Bucket) list(ctx context.Context, fn func(key string, size int64)) error {
+	for obj := range b.client.ListObjects(ctx, b.name, minio.ListObjectsOptions{Prefix: b.prefix}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		fn(strings.TrimPrefix(obj.Key, b.prefix), obj.Size)
+	}
+	return nil
+}
+
+// parseS3URL splits an s3://bucket/key URL.
+func parseS3URL(s string) (bucket, key string, ok bool) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	return bucket, key, bucket != "" && key != "" && !strings.HasSuffix(key, "/")
+}
@@ -0,0 +1,120 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// scheduleInterval is how often the scheduler looks for messages whose
+// PublishAt or ExpiresAt has passed. Listings apply the schedule on every
+// query; the scheduler only tells open pages, Slack and subscribers.
+const scheduleInterval = 15 * time.Second
+
+// formTimeLayout is what <input type="datetime-local"> sends. Times in
+// that layout are taken as UTC.
+const formTimeLayout = "2006-01-02T15:04"
+
+// startScheduler announces messages as they are published or expire until
+// the returned function is called. Changes that fall into a time the
+// process was not running are not announced, but still take effect.
+func (app *App) startScheduler() (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(scheduleInterval)
+		defer t.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				app.announceDue(context.Background(), last, now)
+				last = now
+			}
+		}
+	}()
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// announceDue publishes "created" for messages whose PublishAt falls in
+// (after, until] and "deleted" for those whose ExpiresAt does.
+func (app *App) announceDue(ctx context.Context, after, until time.Time) {
+	msgs, err := app.messages.Due(ctx, after, until)
+	if err != nil {
+		slog.ErrorContext(ctx, "scheduler", "err", err)
+		return
+	}
+	for _, m := range msgs {
+		if !m.Visible(until) {
+			app.hub.Publish(Event{Type: "deleted", Message: store.Message{ID: m.ID}})
+			continue
+		}
+		// Attachments were saved while the message was hidden.
+		if m.Attachments, err = app.attachmentsOf(ctx, m.ID); err != nil {
+			slog.ErrorContext(ctx, "scheduler", "message", m.ID, "err", err)
+		}
+		app.hub.Publish(Event{Type: "created", Message: m})
+	}
+}
+
+// parseSchedule reads publish_at and expires_at from a form into msg. They
+// may be RFC 3339 or, as the site's forms send them, formTimeLayout in UTC;
+// empty means none.
+func parseSchedule(form url.Values, msg *store.Message) error {
+	errs := fieldErrors{}
+	for _, f := range []struct {
+		name string
+		dst  **time.Time
+	}{{"publish_at", &msg.PublishAt}, {"expires_at", &msg.ExpiresAt}} {
+		v := form.Get(f.name)
+		if v == "" {
+			*f.dst = nil
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.Parse(formTimeLayout, v)
+		}
+		if err != nil {
+			errs.add(f.name, "must be a date and time such as 2024-05-01T18:00:00Z")
+			continue
+		}
+		*f.dst = &t
+	}
+	return errs.err()
+}
+
+// formTime formats t for a datetime-local input, or "" for nil.
+func formTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(formTimeLayout)
+}
+
+// optionalTime is a JSON time that tells an absent field (Set false) from
+// an explicit null, so PATCH can clear a schedule.
+type optionalTime struct {
+	Set  bool
+	Time *time.Time
+}
+
+func (o *optionalTime) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	if string(b) == "null" {
+		o.Time = nil
+		return nil
+	}
+	var t time.Time
+	if err := json.Unmarshal(b, &t); err != nil {
+		return err
+	}
+	o.Time = &t
+	return nil
+}
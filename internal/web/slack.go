@@ -0,0 +1,84 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// slackNotifier posts every new message to a Slack incoming webhook. Each
+// post is a job, so posting to the board never waits on Slack.
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// startSlackNotifier subscribes to hub, missing nothing, and queues a job
+// for every new message outside the private channels until the hub closes.
+func (app *App) startSlackNotifier(url string) {
+	n := &slackNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+	events := app.hub.SubscribeAll()
+	go func() {
+		for ev := range events {
+			if ev.Type != "created" {
+				continue
+			}
+			if private, err := app.inPrivateChannel(context.Background(), ev.Message); err != nil {
+				slog.Error("slack: looking up channel", "channel", ev.Message.Channel, "err", err)
+				continue
+			} else if private {
+				continue
+			}
+			body, _ := json.Marshal(map[string]string{"text": slackText(ev.Message)})
+			app.jobs.Enqueue("slack", fmt.Sprintf("message %d", ev.Message.ID), func(ctx context.Context) error {
+				return n.post(ctx, body)
+			})
+		}
+	}()
+}
+
+// post sends one request. Rate limits are retried after the delay Slack
+// asks for and server errors with the usual backoff; anything else is
+// permanent.
+func (n *slackNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return retryAfter(time.Duration(secs)*time.Second, fmt.Errorf("rate limited (%s)", resp.Status))
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("server error (%s)", resp.Status)
+	default:
+		return permanent(fmt.Errorf("rejected (%s)", resp.Status))
+	}
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackText(msg store.Message) string {
+	author := msg.Author
+	if author == "" {
+		author = "Anonymous"
+	}
+	content := slackMentions(slackEscaper.Replace(msg.Content), parseMentions(msg.Content))
+	return fmt.Sprintf("*%s*: %s", slackEscaper.Replace(author), content)
+}
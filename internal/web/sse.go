@@ -0,0 +1,86 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// sseKeepAlive is how often an idle stream gets a comment line, so proxies
+// do not time the connection out.
+const sseKeepAlive = 30 * time.Second
+
+// streamHandler serves GET /api/messages/stream as Server-Sent Events. Each
+// event is named after Event.Type and carries the message as JSON; with
+// ?html=1 it also has content_html, the content rendered as on the index
+// page. Messages from private channels the caller may not see are left
+// out, and ?channel= leaves out other channels' messages too, though not
+// deletes, which carry only an ID. A signed-in user also gets a mention
+// event for each new message that mentions them, whatever the channel.
+func (app *App) streamHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The server's WriteTimeout would otherwise cut the stream off.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	withHTML := r.URL.Query().Get("html") != ""
+	channel := strings.ToLower(r.URL.Query().Get("channel"))
+	var me string
+	if u := currentUser(r); u != nil {
+		me = u.Name
+	}
+	events := app.live.Subscribe()
+	defer app.live.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ok, _ := app.canReadMessage(r, ev.Message); !ok {
+				continue
+			}
+			ev.Message.Avatar = app.messageAvatar(r.Context(), ev.Message)
+			if channel == "" || ev.Message.Channel == "" || ev.Message.Channel == channel {
+				var v any = ev.Message
+				if withHTML {
+					v = struct {
+						store.Message
+						ContentHTML template.HTML `json:"content_html"`
+					}{ev.Message, renderMarkdown(ev.Message.Content)}
+				}
+				if data, err := json.Marshal(v); err == nil {
+					fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Message.ID, ev.Type, data)
+				}
+			}
+			// After the message itself, so the page already shows it.
+			if ev.Type == "created" && me != "" && ev.Message.Author != me && mentioned(ev.Message, me) {
+				if data, err := json.Marshal(ev.Message); err == nil {
+					fmt.Fprintf(w, "event: mention\ndata: %s\n\n", data)
+				}
+			}
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
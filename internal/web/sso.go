@@ -0,0 +1,391 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+const (
+	ssoStateCookie = "slrs_sso"
+	ssoLoginTTL    = 10 * time.Minute
+)
+
+// ssoProvider is a single sign-on provider users can log in with at
+// /auth/{Name}/login.
+type ssoProvider struct {
+	Name  string // in URLs and User.Provider
+	Label string // on the login button
+	oauth oauth2.Config
+	// identify turns the token from the code exchange into an identity.
+	identify func(ctx context.Context, tok *oauth2.Token, nonce string) (ssoIdentity, error)
+	// oidc providers check ID token nonces; endSession is their
+	// RP-initiated logout endpoint, if they advertise one.
+	oidc       bool
+	endSession string
+}
+
+type ssoIdentity struct {
+	name    string
+	roles   []string // values of the role claim
+	idToken string
+}
+
+func (app *App) ssoProviderNamed(name string) *ssoProvider {
+	for _, p := range app.ssoProviders {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// setupSSO configures the providers in cfg. OpenID Connect discovery needs
+// the issuer to be reachable at startup.
+func (app *App) setupSSO(ctx context.Context, cfg config.Config) error {
+	app.ssoPublicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+	app.ssoDefaultRole = cfg.SSODefaultRole
+	var err error
+	if app.ssoRoles, err = config.ParseRoleMap(cfg.SSORoleMap); err != nil {
+		return err
+	}
+	if cfg.OIDCIssuer != "" {
+		p, err := newOIDCProvider(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("oidc: %w", err)
+		}
+		app.ssoProviders = append(app.ssoProviders, p)
+	}
+	if cfg.GitHubClientID != "" {
+		app.ssoProviders = append(app.ssoProviders, &ssoProvider{
+			Name:  "github",
+			Label: "GitHub",
+			oauth: oauth2.Config{
+				ClientID:     cfg.GitHubClientID,
+				ClientSecret: cfg.GitHubClientSecret,
+				Endpoint:     github.Endpoint,
+				Scopes:       []string{"read:user"},
+			},
+			identify: app.githubIdentity,
+		})
+	}
+	return nil
+}
+
+func newOIDCProvider(ctx context.Context, cfg config.Config) (*ssoProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.OIDCIssuer)
+	if err != nil {
+		return nil, err
+	}
+	var meta struct {
+		EndSession string `json:"end_session_endpoint"`
+	}
+	provider.Claims(&meta)
+	label := cfg.OIDCName
+	if label == "" {
+		label = "single sign-on"
+		if strings.Contains(cfg.OIDCIssuer, "accounts.google.com") {
+			label = "Google"
+		}
+	}
+	verifier := provider.Verifier(&oidc.Config{ClientID: cfg.OIDCClientID})
+	usernameClaim, roleClaim := cfg.OIDCUsernameClaim, cfg.OIDCRoleClaim
+	return &ssoProvider{
+		Name:  "oidc",
+		Label: label,
+		oauth: oauth2.Config{
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		oidc:       true,
+		endSession: meta.EndSession,
+		identify: func(ctx context.Context, tok *oauth2.Token, nonce string) (ssoIdentity, error) {
+			raw, _ := tok.Extra("id_token").(string)
+			if raw == "" {
+				return ssoIdentity{}, errors.New("no id_token in token response")
+			}
+			idt, err := verifier.Verify(ctx, raw)
+			if err != nil {
+				return ssoIdentity{}, err
+			}
+			if idt.Nonce != nonce {
+				return ssoIdentity{}, errors.New("ID token nonce does not match")
+			}
+			var claims map[string]any
+			if err := idt.Claims(&claims); err != nil {
+				return ssoIdentity{}, err
+			}
+			id := ssoIdentity{idToken: raw, roles: claimStrings(claims[roleClaim])}
+			for _, c := range []string{usernameClaim, "email", "sub"} {
+				if s, _ := claims[c].(string); s != "" {
+					id.name = s
+					break
+				}
+			}
+			return id, nil
+		},
+	}, nil
+}
+
+// claimStrings reads a claim that may be a string or a list of strings.
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func (app *App) githubIdentity(ctx context.Context, tok *oauth2.Token, _ string) (ssoIdentity, error) {
+	resp, err := app.ssoProviderNamed("github").oauth.Client(ctx, tok).Get("https://api.github.com/user")
+	if err != nil {
+		return ssoIdentity{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ssoIdentity{}, fmt.Errorf("GitHub user API: %s", resp.Status)
+	}
+	var user struct{ Login string }
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return ssoIdentity{}, err
+	}
+	return ssoIdentity{name: user.Login}, nil
+}
+
+// baseURL is PublicURL, or the scheme and host r arrived on.
+func (app *App) baseURL(r *http.Request) string {
+	if app.ssoPublicURL != "" {
+		return app.ssoPublicURL
+	}
+	scheme := "http"
+	if r.TLS != nil || middleware.ForwardedTLS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// config is p's OAuth configuration for the board at base.
+func (p *ssoProvider) config(base string) *oauth2.Config {
+	c := p.oauth
+	c.RedirectURL = base + "/auth/" + p.Name + "/callback"
+	return &c
+}
+
+// logoutURL is the provider's end-session endpoint, sending the browser
+// back to the board at base afterwards.
+func (p *ssoProvider) logoutURL(base, idToken string) string {
+	q := url.Values{"client_id": {p.oauth.ClientID}, "post_logout_redirect_uri": {base + "/"}}
+	if idToken != "" {
+		q.Set("id_token_hint", idToken)
+	}
+	sep := "?"
+	if strings.Contains(p.endSession, "?") {
+		sep = "&"
+	}
+	return p.endSession + sep + q.Encode()
+}
+
+// pendingLogin is a login that has gone to the provider and not come back
+// yet, keyed by its OAuth state.
+type pendingLogin struct {
+	provider string
+	nonce    string
+	verifier string // PKCE
+	next     string
+	expires  time.Time
+}
+
+type pendingLogins struct {
+	mu sync.Mutex
+	m  map[string]pendingLogin
+}
+
+func (s *pendingLogins) put(state string, l pendingLogin) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, v := range s.m {
+		if now.After(v.expires) {
+			delete(s.m, k)
+		}
+	}
+	l.expires = now.Add(ssoLoginTTL)
+	s.m[state] = l
+}
+
+// take removes and returns the login for state; each state works once.
+func (s *pendingLogins) take(state string) (pendingLogin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.m[state]
+	delete(s.m, state)
+	if !ok || time.Now().After(l.expires) {
+		return pendingLogin{}, false
+	}
+	return l, true
+}
+
+// ssoHandler serves /auth/{provider}/login, which sends the browser to the
+// provider, and /auth/{provider}/callback, where it comes back with a code.
+func (app *App) ssoHandler(w http.ResponseWriter, r *http.Request) {
+	step := r.PathValue("step")
+	p := app.ssoProviderNamed(r.PathValue("provider"))
+	if p == nil || (step != "login" && step != "callback") {
+		http.NotFound(w, r)
+		return
+	}
+	if step == "login" {
+		app.ssoLogin(w, r, p)
+	} else {
+		app.ssoCallback(w, r, p)
+	}
+}
+
+func (app *App) ssoLogin(w http.ResponseWriter, r *http.Request, p *ssoProvider) {
+	next := safeRedirect(r.URL.Query().Get("next"))
+	state, nonce, verifier := randomToken(), randomToken(), oauth2.GenerateVerifier()
+	app.ssoPending.put(state, pendingLogin{provider: p.Name, nonce: nonce, verifier: verifier, next: next})
+	// The cookie ties the callback to this browser, so nobody can finish a
+	// login they started into someone else's session.
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/auth/",
+		MaxAge:   int(ssoLoginTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if p.oidc {
+		opts = append(opts, oidc.Nonce(nonce))
+	}
+	http.Redirect(w, r, p.config(app.baseURL(r)).AuthCodeURL(state, opts...), http.StatusFound)
+}
+
+func (app *App) ssoCallback(w http.ResponseWriter, r *http.Request, p *ssoProvider) {
+	fail := func(status int, flash string, err error) {
+		if err != nil {
+			slog.WarnContext(r.Context(), "sso login", "provider", p.Name, "err", err)
+		}
+		app.recordAudit(r.Context(), "login.failed", "sso:"+p.Name, nil, nil)
+		w.WriteHeader(status)
+		app.renderTemplate(w, r, "login.html", TemplateData{Title: "Log in", Flash: errorFlash(flash), Next: "/", SSO: app.ssoProviders, Now: time.Now()})
+	}
+	q := r.URL.Query()
+	state := q.Get("state")
+	c, err := r.Cookie(ssoStateCookie)
+	http.SetCookie(w, &http.Cookie{Name: ssoStateCookie, Path: "/auth/", MaxAge: -1})
+	if err != nil || state == "" || c.Value != state {
+		fail(http.StatusBadRequest, "That login link has expired. Please try again.", nil)
+		return
+	}
+	pending, ok := app.ssoPending.take(state)
+	if !ok || pending.provider != p.Name {
+		fail(http.StatusBadRequest, "That login link has expired. Please try again.", nil)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		fail(http.StatusUnauthorized, p.Label+" did not sign you in: "+e, nil)
+		return
+	}
+	tok, err := p.config(app.baseURL(r)).Exchange(r.Context(), q.Get("code"), oauth2.VerifierOption(pending.verifier))
+	if err != nil {
+		fail(http.StatusBadGateway, "Could not complete the login with "+p.Label+".", err)
+		return
+	}
+	id, err := p.identify(r.Context(), tok, pending.nonce)
+	if err == nil && id.name == "" {
+		err = errors.New("no user name in the identity")
+	}
+	if err != nil {
+		fail(http.StatusUnauthorized, "Could not complete the login with "+p.Label+".", err)
+		return
+	}
+	u, err := app.externalUser(r.Context(), p.Name, id.name, mappedRole(app.ssoRoles, id.roles))
+	if errors.Is(err, errLocalAccount) {
+		fail(http.StatusForbidden, "An account named "+id.name+" already exists here; ask an admin to sort it out.", err)
+		return
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, "Could not complete the login.", err)
+		return
+	}
+	if !app.startSession(w, r, session{user: u.Name, provider: p.Name, idToken: id.idToken}) {
+		return
+	}
+	redirectWithFlash(w, r, pending.next, flashSuccess, "Signed in as "+u.Name+".")
+}
+
+var errLocalAccount = errors.New("user name belongs to another provider or a local account")
+
+// externalUser finds or creates the account for a user that provider
+// vouches for, such as a single sign-on or LDAP login. A role mapped from
+// the provider's groups or claims is applied on every login; otherwise new
+// users get ssoDefaultRole and existing ones keep what an admin gave them.
+func (app *App) externalUser(ctx context.Context, provider, name, role string) (store.User, error) {
+	u, err := app.users.GetUser(ctx, name)
+	var before any
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		u = store.User{Name: name, Role: app.ssoDefaultRole, Provider: provider}
+	case err != nil:
+		return store.User{}, err
+	case u.Provider != provider:
+		return store.User{}, errLocalAccount
+	default:
+		before = u
+	}
+	if before != nil && (role == "" || role == u.Role) {
+		return u, nil
+	}
+	if role != "" {
+		u.Role = role
+	}
+	if err := app.users.SaveUser(ctx, u); err != nil {
+		return store.User{}, err
+	}
+	app.recordAudit(withAuditActor(ctx, provider), "user.save", "user:"+u.Name, before, u)
+	return u, nil
+}
+
+// mappedRole is the most capable role m gives any of values, or "".
+func mappedRole(m map[string]string, values []string) string {
+	order := []string{store.RoleViewer, store.RolePoster, store.RoleModerator, store.RoleAdmin}
+	best := -1
+	for _, v := range values {
+		if i := slices.Index(order, m[v]); i > best {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return order[best]
+}
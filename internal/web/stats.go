@@ -0,0 +1,280 @@
+package web
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// statsInterval is how often the aggregator samples.
+	statsInterval = time.Minute
+	// statsHistory is how many samples of request rates are kept: a day's.
+	statsHistory = 24 * 60
+	// statsDays is how far back messages per day and top authors look.
+	statsDays = 14
+	// statsTopAuthors is how many authors the dashboard ranks.
+	statsTopAuthors = 10
+)
+
+// dayCount is the number of messages posted on one UTC day.
+type dayCount struct {
+	Day   time.Time `json:"day"`
+	Count int       `json:"count"`
+}
+
+// authorCount is the number of messages posted under one name.
+type authorCount struct {
+	Author string `json:"author"`
+	Count  int    `json:"count"`
+}
+
+// rateSample is the traffic in the statsInterval up to Time.
+type rateSample struct {
+	Time     time.Time `json:"time"`
+	Requests int       `json:"requests"`
+	Errors   int       `json:"errors"` // 5xx answers
+}
+
+// statsSnapshot is what the aggregator knew at its last sample.
+type statsSnapshot struct {
+	Sampled        time.Time     `json:"sampled"`
+	Messages       int           `json:"messages"` // on the board
+	Trash          int           `json:"trash"`
+	StoreBytes     int64         `json:"store_bytes"`
+	MessagesPerDay []dayCount    `json:"messages_per_day"` // oldest first, statsDays of them
+	TopAuthors     []authorCount `json:"top_authors"`      // most messages first
+	Rates          []rateSample  `json:"rates"`            // oldest first
+}
+
+// statsAggregator samples the store and the request counters every
+// statsInterval, so pages showing usage read a snapshot instead of
+// scanning the store or the raw metrics themselves.
+type statsAggregator struct {
+	app  *App
+	mu   sync.Mutex
+	snap statsSnapshot
+	// Request and 5xx counts since start at the previous sample, to turn
+	// the counters into per-interval rates.
+	lastRequests, lastErrors float64
+}
+
+// startStats samples once straight away and then every statsInterval
+// until stopped.
+func (app *App) startStats() (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		app.stats.sample(context.Background(), time.Now())
+		t := time.NewTicker(statsInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				app.stats.sample(context.Background(), now)
+			}
+		}
+	}()
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// snapshot returns the latest sample.
+func (a *statsAggregator) snapshot() statsSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snap
+}
+
+// sample takes a new snapshot. A store error keeps the previous figures
+// from the store; the request rates go on regardless.
+func (a *statsAggregator) sample(ctx context.Context, now time.Time) {
+	requests, errs := requestCounts()
+	a.mu.Lock()
+	rate := rateSample{Time: now, Requests: int(requests - a.lastRequests), Errors: int(errs - a.lastErrors)}
+	a.lastRequests, a.lastErrors = requests, errs
+	a.mu.Unlock()
+
+	snap, err := a.app.storeStats(ctx, now)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil {
+		slog.ErrorContext(ctx, "stats", "err", err)
+		snap = a.snap
+	}
+	snap.Sampled = now
+	snap.Rates = append(a.snap.Rates, rate)
+	if n := len(snap.Rates); n > statsHistory {
+		snap.Rates = slices.Clone(snap.Rates[n-statsHistory:])
+	}
+	a.snap = snap
+}
+
+// storeStats counts what is in the store as of now.
+func (app *App) storeStats(ctx context.Context, now time.Time) (statsSnapshot, error) {
+	var s statsSnapshot
+	var err error
+	if _, s.Messages, err = app.messages.List(ctx, store.ListOptions{Limit: 1}); err != nil {
+		return s, err
+	}
+	if _, s.Trash, err = app.messages.List(ctx, store.ListOptions{Trash: true, Limit: 1}); err != nil {
+		return s, err
+	}
+	if s.StoreBytes, err = app.messages.Size(ctx); err != nil {
+		return s, err
+	}
+	today := now.UTC().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, -(statsDays - 1))
+	msgs, _, err := app.messages.List(ctx, store.ListOptions{Since: from})
+	if err != nil {
+		return s, err
+	}
+	s.MessagesPerDay = make([]dayCount, statsDays)
+	for i := range s.MessagesPerDay {
+		s.MessagesPerDay[i].Day = from.AddDate(0, 0, i)
+	}
+	byAuthor := map[string]int{}
+	for _, m := range msgs {
+		if i := int(m.Created.UTC().Sub(from) / (24 * time.Hour)); i >= 0 && i < statsDays {
+			s.MessagesPerDay[i].Count++
+		}
+		byAuthor[authorName(m)]++
+	}
+	for author, n := range byAuthor {
+		s.TopAuthors = append(s.TopAuthors, authorCount{author, n})
+	}
+	slices.SortFunc(s.TopAuthors, func(a, b authorCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Author, b.Author))
+	})
+	if len(s.TopAuthors) > statsTopAuthors {
+		s.TopAuthors = s.TopAuthors[:statsTopAuthors]
+	}
+	return s, nil
+}
+
+// requestCounts reads slrs_http_requests_total: all requests answered so
+// far, and those answered with a 5xx.
+func requestCounts() (requests, serverErrors float64) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return 0, 0
+	}
+	for _, f := range families {
+		if f.GetName() != "slrs_http_requests_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			v := m.GetCounter().GetValue()
+			requests += v
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "status" && strings.HasPrefix(l.GetValue(), "5") {
+					serverErrors += v
+				}
+			}
+		}
+	}
+	return requests, serverErrors
+}
+
+// apiStats is the body of GET /api/stats.
+type apiStats struct {
+	Messages      int       `json:"messages"`
+	MessagesToday int       `json:"messages_24h"`
+	Authors       int       `json:"authors"` // distinct names messages are posted under
+	Started       time.Time `json:"started"`
+	Uptime        float64   `json:"uptime_seconds"`
+	Build         BuildInfo `json:"build"`
+}
+
+// statsAPIHandler serves GET /api/stats, a few counts for external
+// dashboards and smoke tests. Unlike /admin the counts are taken on the
+// spot, so a message just posted shows up, and leave out private channels
+// the caller may not see.
+func (app *App) statsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	now := time.Now()
+	s := apiStats{Started: startTime.UTC(), Uptime: now.Sub(startTime).Seconds(), Build: app.build}
+	hidden, err := app.hiddenChannels(r)
+	if err == nil {
+		_, s.Messages, err = app.messages.List(ctx, store.ListOptions{Hidden: hidden, Limit: 1})
+	}
+	if err == nil {
+		_, s.MessagesToday, err = app.messages.List(ctx, store.ListOptions{Hidden: hidden, Since: now.Add(-24 * time.Hour), Limit: 1})
+	}
+	if err == nil {
+		authors := map[string]bool{}
+		err = app.messages.Each(ctx, func(m store.Message) error {
+			if m.Visible(now) && !slices.Contains(hidden, m.Channel) {
+				authors[m.Author] = true
+			}
+			return nil
+		})
+		s.Authors = len(authors)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// dashboardPage is /admin: the latest snapshot, laid out for the charts.
+type dashboardPage struct {
+	statsSnapshot
+	MaxPerDay, MaxAuthor int
+	// Hours are the rate samples summed up by hour, oldest first, for the
+	// last 24 hours.
+	Hours                   []rateSample
+	MaxRequests             int
+	LastRequests, LastError int     // in the latest interval
+	ErrorPercent            float64 // of the requests in Rates
+}
+
+// newDashboardPage prepares s for the template.
+func newDashboardPage(s statsSnapshot) *dashboardPage {
+	p := &dashboardPage{statsSnapshot: s}
+	for _, d := range s.MessagesPerDay {
+		p.MaxPerDay = max(p.MaxPerDay, d.Count)
+	}
+	for _, a := range s.TopAuthors {
+		p.MaxAuthor = max(p.MaxAuthor, a.Count)
+	}
+	var total, errs int
+	for _, r := range s.Rates {
+		hour := r.Time.UTC().Truncate(time.Hour)
+		if n := len(p.Hours); n > 0 && p.Hours[n-1].Time.Equal(hour) {
+			p.Hours[n-1].Requests += r.Requests
+			p.Hours[n-1].Errors += r.Errors
+		} else {
+			p.Hours = append(p.Hours, rateSample{Time: hour, Requests: r.Requests, Errors: r.Errors})
+		}
+		total += r.Requests
+		errs += r.Errors
+	}
+	for _, h := range p.Hours {
+		p.MaxRequests = max(p.MaxRequests, h.Requests)
+	}
+	if n := len(s.Rates); n > 0 {
+		p.LastRequests, p.LastError = s.Rates[n-1].Requests, s.Rates[n-1].Errors
+	}
+	if total > 0 {
+		p.ErrorPercent = 100 * float64(errs) / float64(total)
+	}
+	return p
+}
+
+// adminDashboardHandler serves GET /admin, usage at a glance for admins.
+// The figures are the aggregator's latest sample, up to statsInterval old.
+func (app *App) adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	data := TemplateData{Title: "Dashboard", Dashboard: newDashboardPage(app.stats.snapshot()), Now: time.Now()}
+	app.renderTemplate(w, r, "dashboard.html", data)
+}
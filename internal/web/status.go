@@ -0,0 +1,230 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// componentStates are the states a component can be in, best first.
+var componentStates = []string{"operational", "degraded", "down"}
+
+const (
+	maxComponentName        = 80
+	maxComponentDescription = 200
+	statusHistory           = 14 * 24 * time.Hour // resolved incidents shown on /status
+)
+
+// serviceStatus is what /status shows and /api/status returns.
+type serviceStatus struct {
+	Status     string            `json:"status"` // the worst component state
+	Components []store.Component `json:"components"`
+	Incidents  []store.Incident  `json:"incidents"` // open ones, then those resolved within statusHistory
+}
+
+// currentStatus works out the state of every component, running the health
+// checks that components follow.
+func (app *App) currentStatus(ctx context.Context) (serviceStatus, error) {
+	cs, err := app.components.ListComponents(ctx)
+	if err != nil {
+		return serviceStatus{}, err
+	}
+	st := serviceStatus{Status: "operational", Components: cs}
+	for i := range cs {
+		c := &cs[i]
+		if c.Check != "" {
+			c.State = "operational"
+			if err := app.runHealthCheck(ctx, c.Check); err != nil {
+				c.State = "down"
+				slog.WarnContext(ctx, "status: check failed", "component", c.Name, "check", c.Check, "err", err)
+			}
+		}
+		if slices.Index(componentStates, c.State) > slices.Index(componentStates, st.Status) {
+			st.Status = c.State
+		}
+	}
+	open, err := app.incidents.ListIncidents(ctx, store.IncidentFilter{State: "open"})
+	if err != nil {
+		return serviceStatus{}, err
+	}
+	resolved, err := app.incidents.ListIncidents(ctx, store.IncidentFilter{State: "resolved", Limit: resolvedIncidents})
+	if err != nil {
+		return serviceStatus{}, err
+	}
+	st.Incidents = open
+	for _, inc := range resolved {
+		if time.Since(*inc.Resolved) < statusHistory {
+			st.Incidents = append(st.Incidents, inc)
+		}
+	}
+	return st, nil
+}
+
+// statusAPIHandler serves GET /api/status for external monitors. Like the
+// page, it is public.
+func (app *App) statusAPIHandler(w http.ResponseWriter, r *http.Request) {
+	st, err := app.currentStatus(r.Context())
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, st)
+}
+
+// statusPage is the data behind status.html.
+type statusPage struct {
+	serviceStatus
+	States []string
+	Checks []string
+	// For the admins' forms for site notices.
+	Banner           *store.Banner
+	BannerSeverities []string
+	BannerDurations  []bannerDuration
+}
+
+// statusHandler serves GET /status, the public status page. Admins manage
+// the components on it: POST /status/components adds one, POST
+// /status/components/{id} changes one and POST
+// /status/components/{id}/delete removes it.
+func (app *App) statusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	data := TemplateData{Title: "Status", Now: time.Now()}
+	if r.Method == http.MethodPost {
+		err := app.saveComponentForm(r)
+		var fe fieldErrors
+		switch {
+		case errors.As(err, &fe):
+			data.Flash = errorFlash("Not saved: " + fe.Error())
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		case errors.Is(err, store.ErrComponentNotFound):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "store", "err", err)
+			return
+		default:
+			redirectWithFlash(w, r, "/status", flashSuccess, "Component saved.")
+			return
+		}
+	}
+	st, err := app.currentStatus(ctx)
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	data.Status = &statusPage{serviceStatus: st, States: componentStates, Checks: app.healthCheckNames()}
+	if can(r, PermAdmin) {
+		if data.Status.Banner, err = app.activeBanner(ctx); err != nil {
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "store", "err", err)
+			return
+		}
+		data.Status.BannerSeverities, data.Status.BannerDurations = bannerSeverities, bannerDurations
+	}
+	app.renderTemplate(w, r, "status.html", data)
+}
+
+// saveComponentForm handles the admin forms on /status, POSTed to
+// /status/components[/{id}[/{action}]].
+func (app *App) saveComponentForm(r *http.Request) error {
+	ctx := r.Context()
+	idStr, action := r.PathValue("id"), r.PathValue("action")
+	if idStr == "" {
+		c := store.Component{}
+		if err := app.componentFromForm(r, &c); err != nil {
+			return err
+		}
+		if err := componentSaveError(app.components.SaveComponent(ctx, &c)); err != nil {
+			return err
+		}
+		app.recordAudit(ctx, "component.create", componentTarget(c.ID), nil, c)
+		return nil
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return store.ErrComponentNotFound
+	}
+	cs, err := app.components.ListComponents(ctx)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(cs, func(c store.Component) bool { return c.ID == id })
+	if i < 0 {
+		return store.ErrComponentNotFound
+	}
+	before := cs[i]
+	switch action {
+	case "":
+		c := before
+		if err := app.componentFromForm(r, &c); err != nil {
+			return err
+		}
+		if err := componentSaveError(app.components.SaveComponent(ctx, &c)); err != nil {
+			return err
+		}
+		app.recordAudit(ctx, "component.update", componentTarget(id), before, c)
+	case "delete":
+		if err := app.components.DeleteComponent(ctx, id); err != nil {
+			return err
+		}
+		app.recordAudit(ctx, "component.delete", componentTarget(id), before, nil)
+	default:
+		return store.ErrComponentNotFound
+	}
+	return nil
+}
+
+// componentSaveError turns a name clash from SaveComponent into a field
+// error.
+func componentSaveError(err error) error {
+	if errors.Is(err, store.ErrDuplicate) {
+		return fieldErrors{"name": "is taken by another component"}
+	}
+	return err
+}
+
+// componentFromForm reads the fields present in the form into c.
+func (app *App) componentFromForm(r *http.Request, c *store.Component) error {
+	errs := fieldErrors{}
+	if _, ok := r.PostForm["name"]; ok {
+		c.Name = strings.TrimSpace(r.PostFormValue("name"))
+		errs.checkText("name", c.Name, maxComponentName, false)
+	}
+	if c.Name == "" {
+		errs.add("name", "is required")
+	}
+	if _, ok := r.PostForm["description"]; ok {
+		c.Description = strings.TrimSpace(r.PostFormValue("description"))
+		errs.checkText("description", c.Description, maxComponentDescription, false)
+	}
+	if _, ok := r.PostForm["state"]; ok {
+		c.State = r.PostFormValue("state")
+	}
+	if c.State == "" {
+		c.State = "operational"
+	}
+	if !slices.Contains(componentStates, c.State) {
+		errs.add("state", "must be one of "+strings.Join(componentStates, ", "))
+	}
+	if _, ok := r.PostForm["check"]; ok {
+		c.Check = r.PostFormValue("check")
+		if _, known := app.healthChecks[c.Check]; c.Check != "" && !known {
+			errs.add("check", "must be one of "+strings.Join(app.healthCheckNames(), ", "))
+		}
+	}
+	return errs.err()
+}
+
+func componentTarget(id int) string {
+	return "component:" + strconv.Itoa(id)
+}
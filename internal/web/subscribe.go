@@ -0,0 +1,144 @@
+package web
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// subscribePage drives subscribe.html through the opt-in flow. Step is one
+// of "form", "sent", "confirm", "confirmed", "unsubscribe", "unsubscribed"
+// or "invalid".
+type subscribePage struct {
+	Step  string
+	Email string
+	Tags  string
+	Token string
+}
+
+func subscriptionTarget(email string) string {
+	return "subscription:" + email
+}
+
+// subscribeHandler serves /subscribe. Posting the form mails a confirmation
+// link; nothing is sent about messages until it is followed. The answer is
+// the same whether or not the address was already subscribed, so the form
+// does not reveal who is.
+func (app *App) subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if app.mails == nil {
+		http.NotFound(w, r)
+		return
+	}
+	data := TemplateData{Title: "Subscribe", Now: time.Now(), Subscribe: &subscribePage{Step: "form"}}
+	switch r.Method {
+	case http.MethodPost:
+		page := data.Subscribe
+		page.Email = strings.ToLower(strings.TrimSpace(r.PostFormValue("email")))
+		page.Tags = r.PostFormValue("tags")
+		errs := fieldErrors{}
+		if page.Email == "" {
+			errs.add("email", "is required")
+		}
+		errs.checkEmail("email", page.Email)
+		tags, err := normalizeTags(splitTags(page.Tags))
+		if err != nil {
+			errs.add("tags", err.Error())
+		}
+		if len(errs) > 0 {
+			data.Flash = errorFlash("Not subscribed: " + errs.Error())
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			break
+		}
+		ctx := r.Context()
+		sub := store.Subscription{Email: page.Email, Tags: tags, Token: randomToken()}
+		err = app.subscriptions.CreateSubscription(ctx, &sub)
+		if errors.Is(err, store.ErrDuplicate) {
+			sub, err = app.subscriptions.GetSubscription(ctx, page.Email)
+			if err == nil {
+				err = app.mails.enqueue(sub.Email, "already.txt", struct {
+					Tags           []string
+					UnsubscribeURL string
+				}{sub.Tags, app.mails.link("/unsubscribe", sub.Token)}, "")
+			}
+		} else if err == nil {
+			app.recordAudit(ctx, "subscription.create", subscriptionTarget(sub.Email), nil, sub)
+			err = app.mails.enqueue(sub.Email, "confirm.txt", struct {
+				Tags       []string
+				ConfirmURL string
+			}{sub.Tags, app.mails.link("/subscribe/confirm", sub.Token)}, "")
+		}
+		if err != nil {
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "subscribe", "email", page.Email, "err", err)
+			return
+		}
+		page.Step = "sent"
+	}
+	app.renderTemplate(w, r, "subscribe.html", data)
+}
+
+// confirmSubscriptionHandler serves /subscribe/confirm?token=. A GET only
+// shows a button, since mail scanners follow links; posting it confirms.
+func (app *App) confirmSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	app.tokenHandler(w, r, "confirm", "confirmed", func(sub store.Subscription) error {
+		if sub.Confirmed {
+			return nil
+		}
+		if err := app.subscriptions.ConfirmSubscription(r.Context(), sub.Token); err != nil {
+			return err
+		}
+		after := sub
+		after.Confirmed = true
+		app.recordAudit(r.Context(), "subscription.confirm", subscriptionTarget(sub.Email), sub, after)
+		return nil
+	})
+}
+
+// unsubscribeHandler serves /unsubscribe?token=, the link at the bottom of
+// every notification. Like confirming, it takes a POST.
+func (app *App) unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	app.tokenHandler(w, r, "unsubscribe", "unsubscribed", func(sub store.Subscription) error {
+		if err := app.subscriptions.DeleteSubscription(r.Context(), sub.Token); err != nil {
+			return err
+		}
+		app.recordAudit(r.Context(), "subscription.delete", subscriptionTarget(sub.Email), sub, nil)
+		return nil
+	})
+}
+
+// tokenHandler looks up the subscription named by the token parameter and
+// shows the ask step for a GET, or runs apply and shows the done step for
+// a POST. Unknown tokens get the "invalid" step with 404.
+func (app *App) tokenHandler(w http.ResponseWriter, r *http.Request, ask, done string, apply func(store.Subscription) error) {
+	if app.mails == nil {
+		http.NotFound(w, r)
+		return
+	}
+	title := "Subscription"
+	if ask == "unsubscribe" {
+		title = "Unsubscribe"
+	}
+	data := TemplateData{Title: title, Now: time.Now()}
+	sub, err := app.subscriptions.SubscriptionByToken(r.Context(), r.FormValue("token"))
+	if err == nil && r.Method == http.MethodPost {
+		err = apply(sub)
+	}
+	switch {
+	case errors.Is(err, store.ErrSubscriptionNotFound):
+		data.Subscribe = &subscribePage{Step: "invalid"}
+		w.WriteHeader(http.StatusNotFound)
+	case err != nil:
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "subscription", "err", err)
+		return
+	case r.Method == http.MethodPost:
+		data.Subscribe = &subscribePage{Step: done, Email: sub.Email}
+	default:
+		data.Subscribe = &subscribePage{Step: ask, Email: sub.Email, Tags: strings.Join(sub.Tags, ", "), Token: sub.Token}
+	}
+	app.renderTemplate(w, r, "subscribe.html", data)
+}
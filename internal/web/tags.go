@@ -0,0 +1,38 @@
+package web
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxTags caps how many tags one message may carry.
+const maxTags = 10
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// normalizeTags lower-cases tags, drops a leading '#' and duplicates, and
+// rejects anything that would not make a clean /?tag= link.
+func normalizeTags(in []string) ([]string, error) {
+	tags := []string{}
+	for _, t := range in {
+		t = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), "#"))
+		if t == "" || slices.Contains(tags, t) {
+			continue
+		}
+		if !tagPattern.MatchString(t) {
+			return nil, fmt.Errorf("invalid tag %q: use up to 32 letters, digits, '.', '_' or '-'", t)
+		}
+		tags = append(tags, t)
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("at most %d tags per message", maxTags)
+	}
+	return tags, nil
+}
+
+// splitTags splits the tags form field, which takes commas or spaces.
+func splitTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
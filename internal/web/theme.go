@@ -0,0 +1,127 @@
+package web
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// themeCookie holds the theme a visitor picked over the site's default,
+// colorSchemeCookie "light" or "dark" from the toggle in the header.
+const (
+	themeCookie       = "slrs_theme"
+	colorSchemeCookie = "slrs_color_scheme"
+)
+
+// defaultTheme is style.css on its own; every other theme is a
+// stylesheet in static/themes loaded after it.
+const defaultTheme = "default"
+
+// loadThemes lists the themes in the static files and makes name the
+// site's default.
+func (app *App) loadThemes(name string) error {
+	themes := []string{defaultTheme}
+	if app.static != nil {
+		files, err := fs.Glob(app.static, "themes/*.css")
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			themes = append(themes, strings.TrimSuffix(path.Base(f), ".css"))
+		}
+	}
+	if name == "" {
+		name = defaultTheme
+	}
+	if !slices.Contains(themes, name) {
+		return fmt.Errorf("unknown theme %q (have %s)", name, strings.Join(themes, ", "))
+	}
+	app.themes, app.theme = themes, name
+	return nil
+}
+
+// pageTheme returns the theme r's page is shown in: the visitor's pick if
+// it still exists, otherwise the site's default.
+func (app *App) pageTheme(r *http.Request) string {
+	if c, err := r.Cookie(themeCookie); err == nil && slices.Contains(app.themes, c.Value) {
+		return c.Value
+	}
+	return app.theme
+}
+
+// themeHandler serves POST /theme from the picker in the page footer. It
+// remembers the pick in a cookie and sends the visitor back to the page
+// they were on.
+func (app *App) themeHandler(w http.ResponseWriter, r *http.Request) {
+	theme := r.PostFormValue("theme")
+	if !slices.Contains(app.themes, theme) {
+		http.Error(w, "Unknown theme", http.StatusBadRequest)
+		return
+	}
+	setPreferenceCookie(w, r, themeCookie, theme)
+	http.Redirect(w, r, referringPage(r), http.StatusSeeOther)
+}
+
+// pageColorScheme returns "light" or "dark" when r's page is to be shown
+// in that scheme, or "" to leave it to the browser: the signed-in user's
+// choice, otherwise the visitor's cookie.
+func pageColorScheme(r *http.Request) string {
+	if u := currentUser(r); u != nil && u.ColorScheme != "" {
+		return u.ColorScheme
+	}
+	if c, err := r.Cookie(colorSchemeCookie); err == nil && validColorScheme(c.Value) {
+		return c.Value
+	}
+	return ""
+}
+
+func validColorScheme(s string) bool {
+	return s == "light" || s == "dark"
+}
+
+// colorSchemeHandler serves POST /color-scheme from the toggle in the page
+// header. The choice goes in a cookie and, for signed-in users, in their
+// account so that it follows them to other browsers.
+func (app *App) colorSchemeHandler(w http.ResponseWriter, r *http.Request) {
+	scheme := r.PostFormValue("scheme")
+	if !validColorScheme(scheme) {
+		http.Error(w, "Unknown color scheme", http.StatusBadRequest)
+		return
+	}
+	if u := currentUser(r); u != nil {
+		if err := app.users.SetColorScheme(r.Context(), u.Name, scheme); err != nil {
+			slog.ErrorContext(r.Context(), "store", "err", err)
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			return
+		}
+	}
+	setPreferenceCookie(w, r, colorSchemeCookie, scheme)
+	http.Redirect(w, r, referringPage(r), http.StatusSeeOther)
+}
+
+// setPreferenceCookie remembers a display preference for a year.
+func setPreferenceCookie(w http.ResponseWriter, r *http.Request, name, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// referringPage is where a preference form sends the visitor back to: the
+// page they were on if it is on this site, otherwise the board.
+func referringPage(r *http.Request) string {
+	if u, err := url.Parse(r.Referer()); err == nil && u.Host == r.Host && strings.HasPrefix(u.Path, "/") {
+		return u.RequestURI()
+	}
+	return "/"
+}
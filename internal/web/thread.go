@@ -0,0 +1,57 @@
+package web
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// ThreadNode is a message with its replies, for nested rendering.
+type ThreadNode struct {
+	store.Message
+	Replies []*ThreadNode
+}
+
+type threadView struct {
+	*ThreadNode
+	Page TemplateData
+}
+
+// buildThreads nests msgs, in List order, under their parents. Threads
+// keep that order while replies read oldest first; a reply whose parent is
+// not in msgs starts a thread of its own.
+func buildThreads(msgs []store.Message) []*ThreadNode {
+	nodes := make(map[int]*ThreadNode, len(msgs))
+	for _, m := range msgs {
+		nodes[m.ID] = &ThreadNode{Message: m}
+	}
+	var roots []*ThreadNode
+	for _, m := range msgs {
+		n := nodes[m.ID]
+		if parent, ok := nodes[m.ParentID]; ok {
+			parent.Replies = append(parent.Replies, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+	for _, n := range nodes {
+		slices.SortFunc(n.Replies, func(a, b *ThreadNode) int {
+			return cmp.Or(a.Created.Compare(b.Created), cmp.Compare(a.ID, b.ID))
+		})
+	}
+	return roots
+}
+
+// threadAPIHandler serves GET /api/messages/{id}/thread: the conversation
+// id belongs to, from its root message down, oldest first.
+func (app *App) threadAPIHandler(w http.ResponseWriter, r *http.Request, id int) {
+	msgs, err := app.messages.Thread(r.Context(), id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	messagesInZone(requestZone(r), msgs)
+	writeJSON(w, http.StatusOK, msgs)
+}
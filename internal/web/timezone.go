@@ -0,0 +1,96 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"time"
+	// The runtime image has no zoneinfo of its own.
+	_ "time/tzdata"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// tzCookie holds the viewer's time zone: one picked with ?tz=, or the
+// browser's as app.js reports it.
+const tzCookie = "slrs_tz"
+
+// loadZone returns the location of an IANA zone name such as
+// Europe/Berlin. Unlike time.LoadLocation it refuses "" and "Local", which
+// would mean the server's zone.
+func loadZone(name string) (*time.Location, bool) {
+	if name == "" || name == "Local" {
+		return nil, false
+	}
+	loc, err := time.LoadLocation(name)
+	return loc, err == nil
+}
+
+// pageZone returns the time zone r's page shows times in: the one asked
+// for with ?tz=, which is remembered; otherwise the signed-in user's;
+// otherwise the browser's; otherwise UTC.
+func pageZone(w http.ResponseWriter, r *http.Request) *time.Location {
+	if loc, ok := loadZone(r.URL.Query().Get("tz")); ok {
+		setPreferenceCookie(w, r, tzCookie, loc.String())
+		return loc
+	}
+	if u := currentUser(r); u != nil {
+		if loc, ok := loadZone(u.TimeZone); ok {
+			return loc
+		}
+	}
+	if c, err := r.Cookie(tzCookie); err == nil {
+		if loc, ok := loadZone(c.Value); ok {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+type zoneKey struct{}
+
+// apiTimeZone reads the tz parameter API clients may add to have message
+// times written in a zone of theirs rather than UTC. A zone it does not
+// know is a 400.
+func apiTimeZone(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := r.URL.Query().Get("tz"); name != "" {
+			loc, ok := loadZone(name)
+			if !ok {
+				writeJSONError(w, http.StatusBadRequest, "unknown time zone "+name)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), zoneKey{}, loc))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestZone returns the zone apiTimeZone found for r, or UTC.
+func requestZone(r *http.Request) *time.Location {
+	if loc, ok := r.Context().Value(zoneKey{}).(*time.Location); ok {
+		return loc
+	}
+	return time.UTC
+}
+
+// inZone converts the times of m to loc, which is how they are then
+// written out as JSON.
+func inZone(loc *time.Location, m *store.Message) {
+	at := func(t *time.Time) *time.Time {
+		if t == nil {
+			return nil
+		}
+		in := t.In(loc)
+		return &in
+	}
+	m.Created = m.Created.In(loc)
+	m.Updated, m.Deleted = at(m.Updated), at(m.Deleted)
+	m.PublishAt, m.ExpiresAt = at(m.PublishAt), at(m.ExpiresAt)
+}
+
+// messagesInZone is inZone for a list of messages.
+func messagesInZone(loc *time.Location, msgs []store.Message) {
+	for i := range msgs {
+		inZone(loc, &msgs[i])
+	}
+}
@@ -0,0 +1,68 @@
+package web
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// adminTrashHandler serves GET /admin/trash, the deleted messages, to
+// moderators.
+func (app *App) adminTrashHandler(w http.ResponseWriter, r *http.Request) {
+	msgs, _, err := app.messages.List(r.Context(), store.ListOptions{Trash: true})
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	app.renderTemplate(w, r, "trash.html", TemplateData{Title: "Trash", Messages: msgs, Now: time.Now()})
+}
+
+// restoreTrashHandler serves POST /admin/trash/{id}/restore, which brings a
+// deleted message back, to moderators.
+func (app *App) restoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	app.trashed(w, r, "restore", id, app.messages.Restore(r.Context(), id))
+}
+
+// purgeTrashHandler serves POST /admin/trash/{id}/purge, which removes a
+// deleted message and its attachments for good, to admins.
+func (app *App) purgeTrashHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var atts []store.Attachment
+	if atts, err = app.attachmentsOf(r.Context(), id); err == nil {
+		if err = app.messages.Purge(r.Context(), id); err == nil {
+			app.deleteBlobs(r.Context(), atts)
+		}
+	}
+	app.trashed(w, r, "purge", id, err)
+}
+
+// trashed logs action on message id and goes back to the trash, unless err
+// is a store error. A message that is no longer there is not one.
+func (app *App) trashed(w http.ResponseWriter, r *http.Request, action string, id int, err error) {
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "store", "err", err)
+		return
+	}
+	if err != nil {
+		redirectWithFlash(w, r, "/admin/trash", flashError, "Message "+strconv.Itoa(id)+" is no longer in the trash.")
+		return
+	}
+	slog.InfoContext(r.Context(), "trash", "action", action, "message", id, "user", currentUser(r).Name)
+	done := map[string]string{"restore": "restored", "purge": "deleted for good"}[action]
+	redirectWithFlash(w, r, "/admin/trash", flashSuccess, "Message "+strconv.Itoa(id)+" "+done+".")
+}
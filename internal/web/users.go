@@ -0,0 +1,180 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"example.com/go-sample-site/internal/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength applies to accounts created or changed through the API.
+const minPasswordLength = 8
+
+// userInput is the body of POST /api/users and PUT/PATCH /api/users/{name}.
+// Fields are pointers so an update can leave either one alone.
+type userInput struct {
+	Name     string
+	Password *string
+	Role     *string
+}
+
+// validate checks the fields given; creating a user needs all of them.
+func (in *userInput) validate(create bool) error {
+	errs := fieldErrors{}
+	if create {
+		in.Name = strings.TrimSpace(in.Name)
+		if in.Name == "" {
+			errs.add("name", "is required")
+		}
+		errs.checkText("name", in.Name, maxAuthorLength, false)
+		if in.Password == nil {
+			errs.add("password", "is required")
+		}
+		if in.Role == nil {
+			errs.add("role", "is required")
+		}
+	}
+	if in.Role != nil && !store.ValidRole(*in.Role) {
+		errs.add("role", "must be viewer, poster, moderator or admin")
+	}
+	if in.Password != nil && len(*in.Password) < minPasswordLength {
+		errs.add("password", "must be at least 8 characters")
+	}
+	return errs.err()
+}
+
+// listUsersAPIHandler serves GET /api/users, the accounts, for admins.
+func (app *App) listUsersAPIHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := app.users.ListUsers(r.Context())
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// createUserAPIHandler serves POST /api/users for admins:
+// {"name", "password", "role"} creates an account.
+func (app *App) createUserAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var in userInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.validate(true); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	if _, err := app.users.GetUser(r.Context(), in.Name); err == nil {
+		writeJSONError(w, http.StatusConflict, "a user with that name exists")
+		return
+	} else if !errors.Is(err, store.ErrUserNotFound) {
+		storeError(w, r, err)
+		return
+	}
+	u := store.User{Name: in.Name, Role: *in.Role}
+	if !app.saveUser(w, r, &u, in.Password, nil) {
+		return
+	}
+	writeJSON(w, http.StatusCreated, u)
+}
+
+// namedUser looks up the account {name} of a route, answering 404 when
+// there is none.
+func (app *App) namedUser(w http.ResponseWriter, r *http.Request) (store.User, bool) {
+	u, err := app.users.GetUser(r.Context(), r.PathValue("name"))
+	if errors.Is(err, store.ErrUserNotFound) {
+		writeJSONError(w, http.StatusNotFound, "user not found")
+		return u, false
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return u, false
+	}
+	return u, true
+}
+
+// updateUserAPIHandler serves PUT and PATCH /api/users/{name} for admins,
+// which change the role and/or password. Changes apply to the user's open
+// sessions at once.
+func (app *App) updateUserAPIHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := app.namedUser(w, r)
+	if !ok {
+		return
+	}
+	var in userInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.validate(false); err != nil {
+		writeInputError(w, err)
+		return
+	}
+	before := u
+	if in.Role != nil {
+		u.Role = *in.Role
+	}
+	if !app.saveUser(w, r, &u, in.Password, &before) {
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// deleteUserAPIHandler serves DELETE /api/users/{name} for admins. The
+// user's open sessions end at once.
+func (app *App) deleteUserAPIHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := app.namedUser(w, r)
+	if !ok {
+		return
+	}
+	if me := currentUser(r); me != nil && me.Name == u.Name {
+		writeJSONError(w, http.StatusBadRequest, "you cannot delete your own account")
+		return
+	}
+	if err := app.users.DeleteUser(r.Context(), u.Name); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if u.Avatar != "" {
+		app.blobs.Delete(r.Context(), u.Avatar)
+	}
+	app.recordAudit(r.Context(), "user.delete", "user:"+u.Name, u, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveUser hashes password, if given, into u and stores it, writing the
+// error response itself on failure.
+func (app *App) saveUser(w http.ResponseWriter, r *http.Request, u *store.User, password *string, before *store.User) bool {
+	if err := app.storeUser(r.Context(), u, password, before); err != nil {
+		storeError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// storeUser hashes password, if given, into u, stores it and audits the
+// change from before, nil for a new account.
+func (app *App) storeUser(ctx context.Context, u *store.User, password *string, before *store.User) error {
+	if password != nil {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		u.PasswordHash = hash
+	}
+	if err := app.users.SaveUser(ctx, *u); err != nil {
+		return err
+	}
+	if saved, err := app.users.GetUser(ctx, u.Name); err == nil {
+		*u = saved
+	}
+	var b any
+	if before != nil {
+		b = before
+	}
+	app.recordAudit(ctx, "user.save", "user:"+u.Name, b, u)
+	return nil
+}
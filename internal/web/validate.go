@@ -0,0 +1,218 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// Limits on message fields, in characters. They apply to the form, the API
+// and imports alike.
+const (
+	maxAuthorLength  = 80
+	maxContentLength = 10000
+	maxEmailLength   = 254
+)
+
+// maxRequestBody caps request bodies unless bodyLimits has a larger limit
+// for the path; a full-length message fits with plenty to spare.
+const maxRequestBody = 128 << 10
+
+var bodyLimits = map[string]int64{
+	"/admin/import":      maxImportSize,
+	"/api/messages/bulk": maxImportSize,
+	"/hooks/github":      maxWebhookBody,
+	"/hooks/jenkins":     maxWebhookBody,
+	"/account":           maxAvatarUpload + maxRequestBody,
+}
+
+// bodyLimitMiddleware wraps every request body in http.MaxBytesReader, so
+// reading past the limit fails and the connection is closed.
+func bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := bodyLimits[unversionedPath(r.URL.Path)]
+		if !ok {
+			limit = maxRequestBody
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLarge reports whether err came from reading past the body limit.
+func bodyTooLarge(err error) bool {
+	var mbe *http.MaxBytesError
+	return errors.As(err, &mbe)
+}
+
+// fieldErrors maps input field names to what is wrong with them. API
+// clients get it as 422 {"error": ..., "errors": {field: problem}}.
+type fieldErrors map[string]string
+
+func (e fieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	slices.Sort(fields)
+	for i, f := range fields {
+		fields[i] = f + ": " + e[f]
+	}
+	return strings.Join(fields, "; ")
+}
+
+// add records problem for field unless it already has one.
+func (e fieldErrors) add(field, problem string) {
+	if _, ok := e[field]; !ok {
+		e[field] = problem
+	}
+}
+
+// err returns e as an error, or nil when there are no problems.
+func (e fieldErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// checkText adds a problem for field if value is longer than max
+// characters, not UTF-8 or holds control characters. Multiline fields may
+// contain newlines and tabs.
+func (e fieldErrors) checkText(field, value string, max int, multiline bool) {
+	if !utf8.ValidString(value) {
+		e.add(field, "is not valid UTF-8")
+		return
+	}
+	if n := utf8.RuneCountInString(value); n > max {
+		e.add(field, fmt.Sprintf("must be at most %d characters, not %d", max, n))
+		return
+	}
+	for _, c := range value {
+		if unicode.IsControl(c) && !(multiline && (c == '\n' || c == '\r' || c == '\t')) {
+			e.add(field, "must not contain control characters")
+			return
+		}
+	}
+}
+
+// checkEmail adds a problem for field unless value is empty or a bare
+// address such as ops@example.com.
+func (e fieldErrors) checkEmail(field, value string) {
+	if value == "" {
+		return
+	}
+	if len(value) > maxEmailLength {
+		e.add(field, fmt.Sprintf("must be at most %d characters", maxEmailLength))
+		return
+	}
+	if a, err := mail.ParseAddress(value); err != nil || a.Address != value {
+		e.add(field, "is not an email address")
+	}
+}
+
+// validateMessage trims msg's author, email and content, normalizes its
+// tags and checks them all, returning fieldErrors when something is wrong.
+func validateMessage(msg *store.Message) error {
+	errs := fieldErrors{}
+	msg.Author = strings.TrimSpace(msg.Author)
+	msg.Content = strings.TrimSpace(msg.Content)
+	errs.checkText("author", msg.Author, maxAuthorLength, false)
+	msg.Email = strings.TrimSpace(msg.Email)
+	errs.checkEmail("email", msg.Email)
+	if msg.Content == "" {
+		errs.add("content", "is required")
+	}
+	errs.checkText("content", msg.Content, maxContentLength, true)
+	tags, err := normalizeTags(msg.Tags)
+	if err != nil {
+		errs.add("tags", err.Error())
+	}
+	msg.Tags = tags
+	for _, t := range []**time.Time{&msg.PublishAt, &msg.ExpiresAt} {
+		if *t != nil {
+			utc := (*t).UTC()
+			*t = &utc
+		}
+	}
+	start := msg.Created
+	if msg.PublishAt != nil {
+		start = *msg.PublishAt
+	} else if start.IsZero() {
+		start = time.Now()
+	}
+	if msg.ExpiresAt != nil && !msg.ExpiresAt.After(start) {
+		errs.add("expires_at", "must be after the message is published")
+	}
+	return errs.err()
+}
+
+// checkNewMessage runs the checks a new message gets from the post form
+// and the API alike: its schedule as form fields, when form is not nil,
+// its own fields, the files attached in r's multipart form and its
+// channel. Problems with the input from every check come back together as
+// fieldErrors; any other error ends the checks.
+func (app *App) checkNewMessage(r *http.Request, msg *store.Message, form url.Values) ([]upload, error) {
+	errs := fieldErrors{}
+	collect := func(err error) error {
+		var fe fieldErrors
+		if !errors.As(err, &fe) {
+			return err
+		}
+		for field, problem := range fe {
+			errs.add(field, problem)
+		}
+		return nil
+	}
+	if form != nil {
+		collect(parseSchedule(form, msg))
+	}
+	collect(validateMessage(msg))
+	ups, err := app.checkUploads(r.MultipartForm)
+	if err = collect(err); err != nil {
+		return nil, err
+	}
+	if err := collect(app.checkChannel(r, msg)); err != nil {
+		return nil, err
+	}
+	return ups, errs.err()
+}
+
+// decodeJSON reads r's body into v, answering 413 or 400 itself when the
+// body is too large or not JSON.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	switch {
+	case err == nil:
+		return true
+	case bodyTooLarge(err):
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "request body too large")
+	default:
+		writeJSONError(w, http.StatusBadRequest, "Bad JSON")
+	}
+	return false
+}
+
+// writeInputError sends err to an API client: fieldErrors as a 422 with the
+// per-field problems, anything else as a plain 400.
+func writeInputError(w http.ResponseWriter, err error) {
+	var fe fieldErrors
+	if !errors.As(err, &fe) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+		"error":  "invalid input",
+		"errors": fe,
+	})
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// startTime is when the process started, for uptime.
+var startTime = time.Now()
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	Modified   bool   `json:"modified,omitempty"` // built with uncommitted changes
+	BuildTime  string `json:"build_time,omitempty"`
+	GoVersion  string `json:"go_version"`
+}
+
+// ReadBuildInfo returns the version, commit and build time stamped in
+// with ldflags, filled in from the module version and VCS stamp that go
+// build records when they are empty: the module version for go install
+// builds, and the commit for builds from a git checkout.
+func ReadBuildInfo(version, commit, buildTime string) BuildInfo {
+	b := BuildInfo{Version: "(devel)", GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			b.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				b.Commit = s.Value
+			case "vcs.time":
+				b.CommitTime = s.Value
+			case "vcs.modified":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	if version != "" {
+		b.Version = version
+	}
+	if commit != "" {
+		b.Commit, b.CommitTime, b.Modified = commit, "", false
+	}
+	b.BuildTime = buildTime
+	return b
+}
+
+// Stamp is the short form shown in the page footer, e.g. "1.4.0 (3f2a9c1)".
+// Go's pseudo-versions repeat the commit, so they are shortened to devel.
+func (b BuildInfo) Stamp() string {
+	s := b.Version
+	if len(b.Commit) >= 12 && strings.Contains(s, b.Commit[:12]) {
+		s = "devel"
+	}
+	if c := b.Commit; c != "" {
+		s += " (" + c[:min(len(c), 7)]
+		if b.Modified {
+			s += "+dirty"
+		}
+		s += ")"
+	}
+	return s
+}
+
+// versionAPIHandler serves GET /api/version, which build is running.
+func (app *App) versionAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.build)
+}
@@ -0,0 +1,235 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// webhookLogShown is how many deliveries /admin/webhooks lists.
+const webhookLogShown = 100
+
+// webhookEvents are the events a webhook can subscribe to.
+var webhookEvents = []string{"message.created", "message.updated", "message.deleted", "message.restored"}
+
+// webhookClient does not follow redirects: a redirected POST turns into a
+// GET, which no receiver wants.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookPayload is the JSON body of every delivery. Message is the whole
+// message, except for message.deleted, which only carries its id.
+type webhookPayload struct {
+	ID      string    `json:"id"` // same for every attempt, for deduplication
+	Event   string    `json:"event"`
+	Created time.Time `json:"created"`
+	Message any       `json:"message"`
+}
+
+// startWebhooks subscribes to hub, missing nothing, and queues a job per
+// webhook for every message event until the hub closes.
+func (app *App) startWebhooks() {
+	events := app.hub.SubscribeAll()
+	go func() {
+		for ev := range events {
+			app.fanOut(context.Background(), ev)
+		}
+	}()
+}
+
+// fanOut queues a delivery of ev to each webhook that wants it, unless ev
+// is about a message in a private channel.
+func (app *App) fanOut(ctx context.Context, ev Event) {
+	if private, err := app.inPrivateChannel(ctx, ev.Message); err != nil {
+		slog.ErrorContext(ctx, "webhooks: looking up channel", "channel", ev.Message.Channel, "err", err)
+		return
+	} else if private {
+		return
+	}
+	hooks, err := app.webhooks.ListWebhooks(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "webhooks: listing", "err", err)
+		return
+	}
+	name := "message." + ev.Type
+	p := webhookPayload{Event: name, Created: time.Now().UTC(), Message: ev.Message}
+	if ev.Type == "deleted" {
+		p.Message = map[string]int{"id": ev.Message.ID}
+	}
+	for _, h := range hooks {
+		if len(h.Events) > 0 && !slices.Contains(h.Events, name) {
+			continue
+		}
+		id := randomToken()
+		p.ID = id
+		body, err := json.Marshal(p)
+		if err != nil {
+			slog.ErrorContext(ctx, "webhooks: encoding", "err", err)
+			return
+		}
+		d := store.WebhookDelivery{WebhookID: h.ID, URL: h.URL, Event: name, MessageID: ev.Message.ID}
+		app.jobs.Enqueue("webhook", fmt.Sprintf("%s #%d → %s", name, ev.Message.ID, h.URL), func(ctx context.Context) error {
+			d.Attempt++
+			return app.deliverWebhook(ctx, h, id, body, d)
+		})
+	}
+}
+
+// deliverWebhook posts body to h once and logs the attempt. Rate limits
+// are retried after the delay the receiver asks for, timeouts and server
+// errors with the usual backoff; any other refusal is permanent.
+func (app *App) deliverWebhook(ctx context.Context, h store.Webhook, id string, body []byte, d store.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "slrs-webhooks")
+	req.Header.Set("X-SLRS-Event", d.Event)
+	req.Header.Set("X-SLRS-Delivery", id)
+	req.Header.Set("X-SLRS-Signature", "sha256="+signWebhook(h.Secret, body))
+	start := time.Now()
+	resp, err := webhookClient.Do(req)
+	d.Duration = time.Since(start)
+	if err == nil {
+		resp.Body.Close()
+		d.Status = resp.StatusCode
+		switch {
+		case resp.StatusCode < 300:
+		case resp.StatusCode == http.StatusTooManyRequests:
+			secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			err = retryAfter(time.Duration(secs)*time.Second, fmt.Errorf("rate limited (%s)", resp.Status))
+		case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+			err = fmt.Errorf("server error (%s)", resp.Status)
+		default:
+			err = permanent(fmt.Errorf("rejected (%s)", resp.Status))
+		}
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if lerr := app.webhooks.AppendDelivery(context.WithoutCancel(ctx), &d); lerr != nil {
+		slog.ErrorContext(ctx, "webhooks: logging delivery", "err", lerr)
+	}
+	return err
+}
+
+// signWebhook returns the hex HMAC-SHA256 of body under secret, as sent in
+// X-SLRS-Signature.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookTarget(id int) string {
+	return "webhook:" + strconv.Itoa(id)
+}
+
+// webhooksPage is the data behind webhooks.html.
+type webhooksPage struct {
+	Hooks      []store.Webhook
+	Deliveries []store.WebhookDelivery
+	Events     []string
+	URL        string   // form values kept after an error
+	Selected   []string // events ticked in the form
+}
+
+// adminWebhooksHandler serves /admin/webhooks: GET lists webhooks and the
+// latest deliveries and POST registers a webhook.
+func (app *App) adminWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	data := TemplateData{Title: "Webhooks", Now: time.Now(), Webhooks: &webhooksPage{Events: webhookEvents}}
+	switch r.Method {
+	case http.MethodPost:
+		page := data.Webhooks
+		page.URL = strings.TrimSpace(r.PostFormValue("url"))
+		page.Selected = r.PostForm["events"]
+		errs := fieldErrors{}
+		if u, err := url.Parse(page.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("url", "must be an http or https URL")
+		}
+		for _, e := range page.Selected {
+			if !slices.Contains(webhookEvents, e) {
+				errs.add("events", "unknown event "+e)
+			}
+		}
+		if len(errs) > 0 {
+			data.Flash = errorFlash("Not added: " + errs.Error())
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			break
+		}
+		h := store.Webhook{URL: page.URL, Secret: r.PostFormValue("secret"), Events: page.Selected}
+		if h.Secret == "" {
+			h.Secret = randomToken()
+			data.Flash = &Flash{Level: flashSuccess, Text: "Webhook added. Its secret is " + h.Secret + " — it will not be shown again."}
+		} else {
+			data.Flash = &Flash{Level: flashSuccess, Text: "Webhook added."}
+		}
+		if err := app.webhooks.CreateWebhook(ctx, &h); err != nil {
+			http.Error(w, "Store error", http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "store", "err", err)
+			return
+		}
+		app.recordAudit(ctx, "webhook.create", webhookTarget(h.ID), nil, h)
+		page.URL, page.Selected = "", nil
+	}
+	var err error
+	if data.Webhooks.Hooks, err = app.webhooks.ListWebhooks(ctx); err == nil {
+		data.Webhooks.Deliveries, err = app.webhooks.ListDeliveries(ctx, webhookLogShown)
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	app.renderTemplate(w, r, "webhooks.html", data)
+}
+
+// deleteWebhookHandler serves POST /admin/webhooks/{id}/delete, which
+// removes a webhook.
+func (app *App) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	hooks, err := app.webhooks.ListWebhooks(ctx)
+	if err == nil {
+		err = app.webhooks.DeleteWebhook(ctx, id)
+	}
+	if errors.Is(err, store.ErrWebhookNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Store error", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "store", "err", err)
+		return
+	}
+	var before any
+	if i := slices.IndexFunc(hooks, func(h store.Webhook) bool { return h.ID == id }); i >= 0 {
+		before = hooks[i]
+	}
+	app.recordAudit(ctx, "webhook.delete", webhookTarget(id), before, nil)
+	redirectWithFlash(w, r, "/admin/webhooks", flashSuccess, "Webhook removed.")
+}
@@ -0,0 +1,162 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	wsMaxMessage = 8 << 10
+)
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// wsClient is one WebSocket connection. Hub events arrive on its own
+// buffered subscription, and replies to its submissions on replies, so a
+// slow client never blocks the rest of the board.
+type wsClient struct {
+	app     *App
+	conn    *websocket.Conn
+	req     *http.Request // the upgrade request, for the caller's permissions
+	events  chan Event
+	replies chan any
+}
+
+// wsHandler upgrades /ws to a WebSocket. The server sends every Event from
+// a channel the caller may see as JSON and accepts {"author": ...,
+// "content": ..., "channel": ...} objects to post.
+func (app *App) wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.WarnContext(r.Context(), "websocket upgrade", "err", err)
+		return
+	}
+	app.wsConns.Add(1)
+	defer app.wsConns.Done()
+	c := &wsClient{app: app, conn: conn, req: r, events: app.live.Subscribe(), replies: make(chan any, 4)}
+	go c.writePump()
+	c.readPump(r.Context())
+}
+
+// readPump turns incoming frames into new messages until the peer goes away.
+func (c *wsClient) readPump(ctx context.Context) {
+	defer func() {
+		c.app.live.Unsubscribe(c.events)
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(wsMaxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		var in struct{ Author, Content, Channel string }
+		if err := c.conn.ReadJSON(&in); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				c.reply(map[string]string{"type": "error", "error": "Bad JSON"})
+				continue
+			}
+			return
+		}
+		if !can(c.req, PermPost) {
+			c.reply(map[string]string{"type": "error", "error": "post permission required"})
+			continue
+		}
+		if !c.allowPost(ctx) {
+			c.reply(map[string]string{"type": "error", "error": "rate limit exceeded"})
+			continue
+		}
+		msg := store.Message{Author: in.Author, Content: in.Content, Channel: in.Channel}
+		_, err := c.app.checkNewMessage(c.req, &msg, nil)
+		if fe, ok := err.(fieldErrors); ok {
+			c.reply(map[string]string{"type": "error", "error": fe.Error()})
+			continue
+		}
+		if err == nil {
+			err = c.app.messages.Create(ctx, &msg)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "store", "err", err)
+			c.reply(map[string]string{"type": "error", "error": "Store error"})
+		}
+	}
+}
+
+// allowPost takes a token from postLimiter for a post, as POST /submit and
+// the API do, so the socket is no way round the rate limit. Should the
+// limiter fail, the post goes through.
+func (c *wsClient) allowPost(ctx context.Context) bool {
+	if c.app.postLimiter == nil {
+		return true
+	}
+	ok, _, err := c.app.postLimiter.allow(ctx, middleware.ClientIP(c.req))
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limit", "err", err)
+		return true
+	}
+	return ok
+}
+
+func (c *wsClient) reply(v any) {
+	select {
+	case c.replies <- v:
+	default:
+	}
+}
+
+// writePump is the only goroutine writing to conn. It exits, closing the
+// socket, when the hub closes the client's subscription on shutdown.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		var err error
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		select {
+		case ev, ok := <-c.events:
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+				return
+			}
+			if ok, _ := c.app.canReadMessage(c.req, ev.Message); !ok {
+				continue
+			}
+			err = c.conn.WriteJSON(ev)
+		case v := <-c.replies:
+			err = c.conn.WriteJSON(v)
+		case <-ticker.C:
+			err = c.conn.WriteMessage(websocket.PingMessage, nil)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// waitForWebSockets blocks until every socket has closed or ctx expires.
+func (app *App) waitForWebSockets(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		app.wsConns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
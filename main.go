@@ -2,177 +2,90 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"html/template"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"os/signal"
-	"path/filepath"
-	"strings"
-	"sync"
-	"syscall"
-	"time"
-)
-
-var templates *template.Template
 
-type Message struct {
-	ID      int       `json:"id"`
-	Author  string    `json:"author"`
-	Content string    `json:"content"`
-	Created time.Time `json:"created"`
-}
-
-var (
-	storeMu  sync.RWMutex
-	messages = []Message{{ID: 1, Author: "System", Content: "Welcome to the SLRS-Admin devops web testing! -V1", Created: time.Now()}}
-	nextID   = 2
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/server"
+	"example.com/go-sample-site/internal/store"
 )
 
-type TemplateData struct {
-	Title    string
-	Flash    string
-	Messages []Message
-	Now      time.Time
-}
+// version, commit and buildTime are stamped in at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Left empty, they fall back on what the Go toolchain recorded.
+var version, commit, buildTime string
 
 func main() {
-	if err := loadTemplates("templates"); err != nil {
-		log.Fatalf("loading templates: %v", err)
+	cmd, args := findCommand(os.Args[1:])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		printUsage()
+		os.Exit(2)
 	}
-	mux := http.NewServeMux()
-	staticDir := http.Dir("static")
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(staticDir)))
-	mux.Handle("/", loggingMiddleware(http.HandlerFunc(indexHandler)))
-	mux.Handle("/about", loggingMiddleware(http.HandlerFunc(aboutHandler)))
-	mux.Handle("/submit", loggingMiddleware(http.HandlerFunc(submitHandler)))
-	mux.Handle("/api/messages", loggingMiddleware(http.HandlerFunc(messagesAPIHandler)))
-
-	srv := &http.Server{Addr: ":8080", Handler: mux, ReadTimeout: 10 * time.Second, WriteTimeout: 15 * time.Second, IdleTimeout: 60 * time.Second}
-	idle := make(chan struct{})
-	go func() {
-		quit := make(chan os.Signal, 1)
-		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-		<-quit
-		log.Println("Shutting down...")
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("Shutdown: %v", err)
-		}
-		close(idle)
-	}()
-	log.Printf("Server running on %s", srv.Addr)
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-		log.Fatalf("ListenAndServe: %v", err)
+	err := cmd.run(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", cmd.name, err)
 	}
-	<-idle
-	log.Println("Server stopped.")
 }
 
-func loadTemplates(dir string) error {
-	t, err := template.ParseGlob(filepath.Join(dir, "*.html"))
+// runServe is the serve command, which runs the server until it is told
+// to stop.
+func runServe(args []string) error {
+	cfg, err := loadConfig("serve", args)
 	if err != nil {
 		return err
 	}
-	templates = t
-	return nil
-}
-
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+	accessLog, err := middleware.OpenAccessLog(cfg.AccessLog, cfg.AccessLogFormat, int64(cfg.AccessLogMaxMB)<<20, cfg.AccessLogRotate, cfg.AccessLogKeep)
+	if err != nil {
+		return fmt.Errorf("access log: %w", err)
 	}
-	storeMu.RLock()
-	msgs := make([]Message, len(messages))
-	copy(msgs, messages)
-	storeMu.RUnlock()
-	data := TemplateData{Title: "Home", Messages: msgs, Now: time.Now()}
-	if err := templates.ExecuteTemplate(w, "index.html", data); err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		log.Println("template:", err)
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		return fmt.Errorf("tracing: %w", err)
 	}
-}
-
-func aboutHandler(w http.ResponseWriter, r *http.Request) {
-	data := TemplateData{Title: "About", Now: time.Now()}
-	if err := templates.ExecuteTemplate(w, "about.html", data); err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		log.Println("template:", err)
+	flushErrors, err := setupErrorReporting(cfg)
+	if err != nil {
+		return fmt.Errorf("error reporting: %w", err)
 	}
-}
 
-func submitHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+	migrate := store.MigrateUp
+	if !cfg.AutoMigrate {
+		migrate = store.MigrateCheck
 	}
-	r.ParseForm()
-	author := strings.TrimSpace(r.PostForm.Get("author"))
-	content := strings.TrimSpace(r.PostForm.Get("content"))
-	if content == "" {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+	s, err := openStore(cfg, migrate)
+	if err != nil {
+		return err
 	}
-	storeMu.Lock()
-	id := nextID
-	nextID++
-	msg := Message{ID: id, Author: author, Content: content, Created: time.Now()}
-	messages = append([]Message{msg}, messages...)
-	storeMu.Unlock()
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
-
-func messagesAPIHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		storeMu.RLock()
-		msgs := make([]Message, len(messages))
-		copy(msgs, messages)
-		storeMu.RUnlock()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(msgs)
-	case http.MethodPost:
-		var in struct{ Author, Content string }
-		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-			http.Error(w, "Bad JSON", http.StatusBadRequest)
-			return
-		}
-		if strings.TrimSpace(in.Content) == "" {
-			http.Error(w, "content required", http.StatusBadRequest)
-			return
+	defer s.Close()
+	app, err := newApp(cfg, s, accessLog)
+	if err != nil {
+		return err
+	}
+	srv, err := server.New(cfg, handler(cfg, app), app.InternalHandler())
+	if err != nil {
+		return err
+	}
+	if cfg.GRPCAddr != "" {
+		if err := srv.ServeGRPC(app.RegisterGRPC); err != nil {
+			return err
 		}
-		storeMu.Lock()
-		id := nextID
-		nextID++
-		msg := Message{ID: id, Author: in.Author, Content: in.Content, Created: time.Now()}
-		messages = append([]Message{msg}, messages...)
-		storeMu.Unlock()
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(msg)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
-}
-
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		lrw := &loggingResponseWriter{ResponseWriter: w, status: 200}
-		next.ServeHTTP(lrw, r)
-		log.Printf("%s %s %d %s", r.Method, r.URL.Path, lrw.status, time.Since(start))
+	srv.RegisterOnShutdown(app.Start())
+	srv.AfterShutdown(app.Shutdown)
+	srv.AfterShutdown(func(ctx context.Context) {
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Flushing traces", "err", err)
+		}
 	})
-}
-
-type loggingResponseWriter struct {
-	http.ResponseWriter
-	status int
-}
-
-func (lrw *loggingResponseWriter) WriteHeader(code int) {
-	lrw.status = code
-	lrw.ResponseWriter.WriteHeader(code)
+	srv.AfterShutdown(flushErrors)
+	return srv.Run()
 }
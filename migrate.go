@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"example.com/go-sample-site/internal/store"
+)
+
+// runMigrate is the migrate command, which shows or changes the version of
+// the database schema:
+//
+//	go-sample-site migrate status        list the migrations and which are applied (the default)
+//	go-sample-site migrate up            apply those pending
+//	go-sample-site migrate down [n]      roll back the last n, 1 unless given
+//	go-sample-site migrate to <version>  apply or roll back until the schema is at version
+//
+// Unlike the other commands, it opens the database as it finds it.
+func runMigrate(args []string) error {
+	pos, args := positional(args)
+	action := "status"
+	if len(pos) > 0 {
+		action = pos[0]
+	}
+	n := -1
+	if len(pos) > 1 {
+		v, err := strconv.Atoi(pos[1])
+		if err != nil || v < 0 || len(pos) > 2 || (action != "down" && action != "to") {
+			return usageError("migrate")
+		}
+		n = v
+	}
+	cfg, err := loadConfig("migrate", args)
+	if err != nil {
+		return err
+	}
+	s, err := openStore(cfg, store.MigrateNone)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	m, ok := s.(store.Migrator)
+	if !ok {
+		return fmt.Errorf("the %s store has no schema to migrate", cfg.StoreBackend)
+	}
+
+	ctx := context.Background()
+	list, err := m.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	current, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	var target int
+	switch action {
+	case "status":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, mig := range list {
+			applied := "pending"
+			if mig.Applied != nil {
+				applied = mig.Applied.UTC().Format("2006-01-02 15:04:05Z")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", mig.Version, mig.Name, applied)
+		}
+		return w.Flush()
+	case "up":
+		target = len(list)
+	case "down":
+		if n < 0 {
+			n = 1
+		}
+		target = current - n
+	case "to":
+		if n < 0 {
+			return usageError("migrate")
+		}
+		target = n
+	default:
+		return usageError("migrate")
+	}
+	if err := m.MigrateTo(ctx, max(target, 0)); err != nil {
+		return err
+	}
+	fmt.Printf("schema at version %d (was %d)\n", max(target, 0), current)
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"example.com/go-sample-site/internal/config"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// setupErrorReporting sends recovered panics to Sentry when cfg has a DSN.
+// The SDK reads SENTRY_ENVIRONMENT and SENTRY_RELEASE itself. The returned
+// function waits, until ctx is done, for events still being sent.
+func setupErrorReporting(cfg config.Config) (flush func(context.Context), err error) {
+	if cfg.SentryDSN == "" {
+		return func(context.Context) {}, nil
+	}
+	if err := sentry.Init(sentry.ClientOptions{Dsn: cfg.SentryDSN}); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) {
+		timeout := 5 * time.Second
+		if d, ok := ctx.Deadline(); ok {
+			timeout = time.Until(d)
+		}
+		sentry.Flush(timeout)
+	}, nil
+}
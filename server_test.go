@@ -0,0 +1,2102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	slrsv1 "example.com/go-sample-site/api/proto/slrs/v1"
+	"example.com/go-sample-site/client"
+	"example.com/go-sample-site/internal/config"
+	"example.com/go-sample-site/internal/middleware"
+	"example.com/go-sample-site/internal/store"
+	"example.com/go-sample-site/internal/testfixtures"
+	"example.com/go-sample-site/internal/web"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The API keys newTestServer configures.
+const (
+	adminKey  = "test-admin-key-0123456789"
+	readerKey = "test-reader-key-0123456789"
+)
+
+// testServer is the board behind an httptest.Server, seeded with the
+// fixtures.
+type testServer struct {
+	*httptest.Server
+	t        *testing.T
+	app      *web.App
+	messages []store.Message // as seeded, with their IDs
+}
+
+func TestMain(m *testing.M) {
+	// TestS3's fake S3 speaks HTTPS with httptest's certificate, which is
+	// trusted through SSL_CERT_FILE. Go reads that once, when it first
+	// checks a certificate, so it is set before any test runs.
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	f, err := os.CreateTemp("", "httptest-*.pem")
+	if err == nil {
+		err = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+		f.Close()
+	}
+	srv.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("SSL_CERT_FILE", f.Name())
+	code := m.Run()
+	os.Remove(f.Name())
+	os.Exit(code)
+}
+
+func newTestServer(t *testing.T, opts ...func(*config.Config)) *testServer {
+	t.Helper()
+	t.Setenv("API_KEYS", "admin:read+write+admin:"+adminKey+",reader:read:"+readerKey)
+	cfg := config.Default()
+	cfg.AttachmentDir = t.TempDir()
+	cfg.BackupDir = t.TempDir()
+	cfg.RateLimit = 0
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	s, err := store.Open("memory", "", "", store.MigrateUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	msgs := testfixtures.Seed(t, s)
+	accessLog, err := middleware.OpenAccessLog(cfg.AccessLog, cfg.AccessLogFormat, int64(cfg.AccessLogMaxMB)<<20, cfg.AccessLogRotate, cfg.AccessLogKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := newApp(cfg, s, accessLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler(cfg, app))
+	t.Cleanup(ts.Close)
+	// Redirects are part of what the tests check.
+	ts.Client().CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &testServer{Server: ts, t: t, app: app, messages: msgs}
+}
+
+// do sends method to path with body, if any, as JSON and key as the
+// bearer token if set. It returns the response with its body
+// read.
+func (ts *testServer) do(method, path, key string, body any) (*http.Response, string) {
+	ts.t.Helper()
+	var r io.Reader
+	contentType := ""
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		r, contentType = strings.NewReader(string(data)), "application/json"
+	}
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+// decode unmarshals a JSON response body into v.
+func (ts *testServer) decode(body string, v any) {
+	ts.t.Helper()
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		ts.t.Fatalf("decoding %q: %v", body, err)
+	}
+}
+
+// csrfToken is the token postForm sends in both the cookie and the form,
+// as a browser does after loading the form.
+const csrfToken = "csrf-token-for-the-test"
+
+// postForm posts form to path like a browser submitting a page's form,
+// with cookies. It returns the response with its body read.
+func (ts *testServer) postForm(path string, form url.Values, cookies ...*http.Cookie) (*http.Response, string) {
+	ts.t.Helper()
+	form.Set("csrf_token", csrfToken)
+	req, err := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "slrs_csrf", Value: csrfToken})
+	return ts.send(req, cookies)
+}
+
+// get fetches path with cookies, like a browser following a link.
+func (ts *testServer) get(path string, cookies ...*http.Cookie) (*http.Response, string) {
+	ts.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return ts.send(req, cookies)
+}
+
+func (ts *testServer) send(req *http.Request, cookies []*http.Cookie) (*http.Response, string) {
+	ts.t.Helper()
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+// cookie returns the cookie named name that resp sets, or nil.
+func cookie(resp *http.Response, name string) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestPages(t *testing.T) {
+	ts := newTestServer(t)
+	for _, tc := range []struct {
+		path   string
+		status int
+		ctype  string
+	}{
+		{"/", http.StatusOK, "text/html"},
+		{"/about", http.StatusOK, "text/html"},
+		{"/status", http.StatusOK, "text/html"},
+		{"/channels", http.StatusOK, "text/html"},
+		{"/c/general", http.StatusOK, "text/html"},
+		{"/c/nope", http.StatusNotFound, "text/plain"},
+		{"/u/pat", http.StatusOK, "text/html"},
+		{"/u/nobody", http.StatusNotFound, "text/plain"},
+		{"/incidents", http.StatusOK, "text/html"},
+		{"/environments", http.StatusOK, "text/html"},
+		{"/login", http.StatusOK, "text/html"},
+		{"/feed.xml", http.StatusOK, "application/rss+xml"},
+		{"/atom.xml", http.StatusOK, "application/atom+xml"},
+		{"/api/openapi.json", http.StatusOK, "application/json"},
+		{"/api/docs", http.StatusOK, "text/html"},
+		{"/healthz", http.StatusOK, ""},
+		{"/static/themes/ocean.css", http.StatusOK, "text/css"},
+		{"/nope", http.StatusNotFound, "text/plain"},
+		{"/admin", http.StatusSeeOther, ""},
+	} {
+		resp, _ := ts.do(http.MethodGet, tc.path, "", nil)
+		if resp.StatusCode != tc.status {
+			t.Errorf("GET %s: status %d, want %d", tc.path, resp.StatusCode, tc.status)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, tc.ctype) {
+			t.Errorf("GET %s: Content-Type %q, want %s", tc.path, ct, tc.ctype)
+		}
+	}
+}
+
+func TestBoardShowsSeededMessages(t *testing.T) {
+	ts := newTestServer(t)
+	_, body := ts.do(http.MethodGet, "/", "", nil)
+	for _, m := range ts.messages {
+		if m.ParentID == 0 && !strings.Contains(body, m.Content) {
+			t.Errorf("board lacks %q", m.Content)
+		}
+	}
+	if !strings.Contains(body, `<time datetime="`) || !strings.Contains(body, "just now</time>") {
+		t.Error("board lacks relative message times")
+	}
+}
+
+func TestMessagesAPI(t *testing.T) {
+	ts := newTestServer(t)
+	resp, body := ts.do(http.MethodGet, "/api/messages", "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/messages: status %d: %s", resp.StatusCode, body)
+	}
+	var list []store.Message
+	ts.decode(body, &list)
+	listed := make(map[int]bool)
+	for _, m := range list {
+		listed[m.ID] = true
+	}
+	for _, m := range ts.messages {
+		if m.ParentID == 0 && !listed[m.ID] {
+			t.Errorf("GET /api/messages lacks message %d", m.ID)
+		}
+	}
+
+	first := ts.messages[0]
+	resp, body = ts.do(http.MethodGet, "/api/messages/"+strconv.Itoa(first.ID), "", nil)
+	var got store.Message
+	ts.decode(body, &got)
+	if resp.StatusCode != http.StatusOK || got.Content != first.Content {
+		t.Errorf("GET message %d: status %d, content %q", first.ID, resp.StatusCode, got.Content)
+	}
+
+	resp, body = ts.do(http.MethodGet, "/api/messages/"+strconv.Itoa(first.ID)+"/thread", "", nil)
+	var thread []store.Message
+	ts.decode(body, &thread)
+	if resp.StatusCode != http.StatusOK || len(thread) != 2 {
+		t.Errorf("GET thread: status %d, %d messages, want 2", resp.StatusCode, len(thread))
+	}
+
+	resp, body = ts.do(http.MethodGet, "/api/messages/9999", "", nil)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		t.Errorf("GET missing message: status %d, %s", resp.StatusCode, body)
+	}
+}
+
+func TestCreateAndDeleteMessage(t *testing.T) {
+	ts := newTestServer(t)
+	resp, body := ts.do(http.MethodPost, "/api/messages", adminKey, map[string]any{"author": "ada", "content": "from the tests"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/messages: status %d: %s", resp.StatusCode, body)
+	}
+	var created store.Message
+	ts.decode(body, &created)
+	path := "/api/messages/" + strconv.Itoa(created.ID)
+
+	if resp, body := ts.do(http.MethodDelete, path, readerKey, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("DELETE with a read-only key: status %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := ts.do(http.MethodDelete, path, adminKey, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE: status %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := ts.do(http.MethodGet, path, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after DELETE: status %d", resp.StatusCode)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	ts := newTestServer(t)
+	for _, tc := range []struct {
+		method, path, allow string
+	}{
+		{http.MethodPost, "/api/stats", "GET, HEAD"},
+		{http.MethodPut, "/api/messages", "GET, HEAD, POST, DELETE"},
+		{http.MethodGet, "/hooks/github", "POST"},
+	} {
+		resp, body := ts.do(tc.method, tc.path, "", nil)
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: status %d, want 405", tc.method, tc.path, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Allow"); got != tc.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tc.method, tc.path, got, tc.allow)
+		}
+		var e struct{ Error string }
+		ts.decode(body, &e)
+	}
+	if resp, _ := ts.postForm("/about", url.Values{}); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /about: status %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestAdminAPIPermissions(t *testing.T) {
+	ts := newTestServer(t)
+	for _, tc := range []struct {
+		key    string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{readerKey, http.StatusForbidden},
+		{adminKey, http.StatusOK},
+	} {
+		resp, body := ts.do(http.MethodGet, "/api/users", tc.key, nil)
+		if resp.StatusCode != tc.status {
+			t.Errorf("GET /api/users with key %q: status %d, want %d: %s", tc.key, resp.StatusCode, tc.status, body)
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		var users []store.User
+		ts.decode(body, &users)
+		names := make(map[string]bool)
+		for _, u := range users {
+			names[u.Name] = true
+		}
+		for _, u := range testfixtures.Users {
+			if !names[u.Name] {
+				t.Errorf("GET /api/users lacks %s", u.Name)
+			}
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTestServer(t)
+	if resp, _ := ts.postForm("/login", url.Values{"username": {"ada"}, "password": {"wrong"}}); resp.StatusCode == http.StatusSeeOther {
+		t.Error("login with a wrong password redirected")
+	}
+	resp, _ := ts.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("login: status %d, want 303", resp.StatusCode)
+	}
+	session := cookie(resp, "slrs_session")
+	if session == nil {
+		t.Fatal("login set no session cookie")
+	}
+	if resp, _ := ts.get("/admin", session); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /admin signed in as an admin: status %d", resp.StatusCode)
+	}
+}
+
+func TestAccessLogRedactsSecrets(t *testing.T) {
+	path := t.TempDir() + "/access.log"
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.AccessLog = path })
+	ts.do(http.MethodPost, "/hooks/jenkins?token=jenkins-secret", "", nil)
+	ts.get("/unsubscribe?token=subscriber-secret&lang=de")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	if strings.Contains(log, "secret") || !strings.Contains(log, "/unsubscribe?token=REDACTED&lang=de") {
+		t.Errorf("access log keeps query secrets:\n%s", log)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	ts := newTestServer(t)
+	for _, c := range []struct {
+		name, contentType, body, header string
+		want                            int
+	}{
+		{"missing token", "application/x-www-form-urlencoded", "scheme=dark", "", http.StatusForbidden},
+		{"bad token", "application/x-www-form-urlencoded", "scheme=dark&csrf_token=guessed", "", http.StatusForbidden},
+		{"text/plain body", "text/plain", "scheme=dark&csrf_token=" + csrfToken, "", http.StatusForbidden},
+		{"no body", "", "", "", http.StatusForbidden},
+		{"form token", "application/x-www-form-urlencoded", "scheme=dark&csrf_token=" + csrfToken, "", http.StatusSeeOther},
+		{"header token", "application/x-www-form-urlencoded", "scheme=dark", csrfToken, http.StatusSeeOther},
+	} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/color-scheme", strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		if c.header != "" {
+			req.Header.Set("X-CSRF-Token", c.header)
+		}
+		req.AddCookie(&http.Cookie{Name: "slrs_csrf", Value: csrfToken})
+		if resp, _ := ts.send(req, nil); resp.StatusCode != c.want {
+			t.Errorf("POST /color-scheme with %s: status %d, want %d", c.name, resp.StatusCode, c.want)
+		}
+	}
+	// Deleting needs the token like posting a form does.
+	if resp, _ := ts.do(http.MethodPost, "/messages/"+strconv.Itoa(ts.messages[0].ID)+"/delete", "", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST /messages/{id}/delete without a token: status %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestLoginRedirect(t *testing.T) {
+	ts := newTestServer(t)
+	for _, c := range []struct{ next, want string }{
+		{"/c/general", "/c/general"},
+		{"/messages/1/edit?from=board", "/messages/1/edit?from=board"},
+		{"//evil.example", "/"},
+		{"/\\evil.example", "/"},
+		{"/%5Cevil.example", "/"},
+		{"/%2F/evil.example", "/"},
+		{"/\tevil", "/"},
+		{"https://evil.example", "/"},
+		{"javascript:alert(1)", "/"},
+		{"", "/"},
+	} {
+		resp, _ := ts.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}, "next": {c.next}})
+		if loc := resp.Header.Get("Location"); resp.StatusCode != http.StatusSeeOther || loc != c.want {
+			t.Errorf("login with next=%q: %d to %q, want %q", c.next, resp.StatusCode, loc, c.want)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	ts := newTestServer(t)
+	post := url.Values{"content": {"hello"}, "channel": {"general"}}
+	if resp, _ := ts.do(http.MethodPost, "/api/v1/messages", "", map[string]string{"content": "hello"}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous API post: status %d, want 401", resp.StatusCode)
+	}
+	for _, c := range []struct {
+		user string
+		want int
+	}{
+		{"vic", http.StatusForbidden},
+		{"pat", http.StatusSeeOther},
+	} {
+		resp, _ := ts.postForm("/login", url.Values{"username": {c.user}, "password": {testfixtures.Password}})
+		if resp, _ := ts.postForm("/submit", post, cookie(resp, "slrs_session")); resp.StatusCode != c.want {
+			t.Errorf("post as %s: status %d, want %d", c.user, resp.StatusCode, c.want)
+		}
+	}
+
+	// Visitors may post where API_ANONYMOUS_SCOPES says so, on that board
+	// alone; viewers still may not.
+	t.Setenv("API_ANONYMOUS_SCOPES", "read,write")
+	open := newTestServer(t)
+	os.Unsetenv("API_ANONYMOUS_SCOPES")
+	closed := newTestServer(t)
+	if resp, _ := open.do(http.MethodPost, "/api/v1/messages", "", map[string]string{"content": "hello"}); resp.StatusCode != http.StatusCreated {
+		t.Errorf("anonymous API post with write granted: status %d, want 201", resp.StatusCode)
+	}
+	if resp, _ := closed.do(http.MethodPost, "/api/v1/messages", "", map[string]string{"content": "hello"}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous API post on another board: status %d, want 401", resp.StatusCode)
+	}
+	resp, _ := open.postForm("/login", url.Values{"username": {"vic"}, "password": {testfixtures.Password}})
+	if resp, _ := open.postForm("/submit", post, cookie(resp, "slrs_session")); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("post as a viewer with write granted to visitors: status %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestGitHubSignature(t *testing.T) {
+	const secret = "github-hook-secret"
+	body := `{"zen": "Keep it logically awesome."}`
+	sign := func(key, body string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.GitHubWebhookSecret = secret })
+	for _, c := range []struct {
+		name, signature string
+		want            int
+	}{
+		{"no signature", "", http.StatusUnauthorized},
+		{"a signature with another secret", sign("guessed", body), http.StatusUnauthorized},
+		{"the signature of another body", sign(secret, body+" "), http.StatusUnauthorized},
+		{"a SHA-1 signature", "sha1=" + strings.TrimPrefix(sign(secret, body), "sha256="), http.StatusUnauthorized},
+		{"not hex", "sha256=zz", http.StatusUnauthorized},
+		{"the right signature", sign(secret, body), http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/hooks/github", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", "ping")
+		if c.signature != "" {
+			req.Header.Set("X-Hub-Signature-256", c.signature)
+		}
+		if resp, body := ts.send(req, nil); resp.StatusCode != c.want {
+			t.Errorf("ping with %s: status %d, want %d: %s", c.name, resp.StatusCode, c.want, body)
+		}
+	}
+	// Without a secret the hook is off rather than open.
+	if resp, _ := newTestServer(t).do(http.MethodPost, "/hooks/github", "", map[string]string{}); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("hook without a secret configured: status %d, want 503", resp.StatusCode)
+	}
+}
+
+func TestJWT(t *testing.T) {
+	const secret = "a-signing-secret-of-at-least-32-chars"
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.JWTKeys = "k1:" + secret })
+	enc := base64.RawURLEncoding
+	forge := func(header, claims map[string]any, key string) string {
+		h, _ := json.Marshal(header)
+		c, _ := json.Marshal(claims)
+		signed := enc.EncodeToString(h) + "." + enc.EncodeToString(c)
+		if key == "" {
+			return signed + "."
+		}
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(signed))
+		return signed + "." + enc.EncodeToString(mac.Sum(nil))
+	}
+	hs256 := map[string]any{"alg": "HS256", "typ": "JWT", "kid": "k1"}
+	admin := func(exp time.Time) map[string]any {
+		return map[string]any{"iss": "slrs", "sub": "ada", "kind": "user", "role": "admin", "iat": time.Now().Unix(), "exp": exp.Unix(), "jti": "t"}
+	}
+	valid := forge(hs256, admin(time.Now().Add(time.Minute)), secret)
+	parts := strings.Split(valid, ".")
+	viewer := forge(hs256, map[string]any{"iss": "slrs", "sub": "vic", "kind": "user", "role": "viewer", "exp": time.Now().Add(time.Minute).Unix()}, secret)
+	for _, c := range []struct {
+		name, token string
+		want        int
+	}{
+		{"a valid token", valid, http.StatusOK},
+		{"an expired token", forge(hs256, admin(time.Now().Add(-time.Second)), secret), http.StatusUnauthorized},
+		{"alg none", forge(map[string]any{"alg": "none", "typ": "JWT", "kid": "k1"}, admin(time.Now().Add(time.Minute)), ""), http.StatusUnauthorized},
+		{"alg none with a signature", forge(map[string]any{"alg": "none", "kid": "k1"}, admin(time.Now().Add(time.Minute)), secret), http.StatusUnauthorized},
+		{"another secret", forge(hs256, admin(time.Now().Add(time.Minute)), "a-guessed-secret-of-at-least-32-chars"), http.StatusUnauthorized},
+		{"an unknown kid", forge(map[string]any{"alg": "HS256", "kid": "k2"}, admin(time.Now().Add(time.Minute)), secret), http.StatusUnauthorized},
+		{"a viewer's signature on an admin's claims", strings.Split(viewer, ".")[0] + "." + parts[1] + "." + strings.Split(viewer, ".")[2], http.StatusUnauthorized},
+		{"a viewer's token", viewer, http.StatusForbidden},
+	} {
+		if resp, body := ts.do(http.MethodGet, "/api/v1/keys", c.token, nil); resp.StatusCode != c.want {
+			t.Errorf("GET /api/v1/keys with %s: status %d, want %d: %s", c.name, resp.StatusCode, c.want, body)
+		}
+	}
+	// And the tokens the board issues itself.
+	_, body := ts.do(http.MethodPost, "/api/v1/token", "", map[string]string{"username": "ada", "password": testfixtures.Password})
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	ts.decode(body, &tok)
+	if resp, _ := ts.do(http.MethodGet, "/api/v1/keys", tok.AccessToken, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/v1/keys with an issued token: status %d (token response %s)", resp.StatusCode, body)
+	}
+}
+
+func TestPrivateChannels(t *testing.T) {
+	slack, slackPosts := countingReceiver(t)
+	receiver, webhookPosts := countingReceiver(t)
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.SlackWebhookURL = slack.URL })
+	resp, _ := ts.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}})
+	if resp, body := ts.postForm("/admin/webhooks", url.Values{"url": {receiver.URL}}, cookie(resp, "slrs_session")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("adding the webhook: status %d: %s", resp.StatusCode, body)
+	}
+	const secret = "rotate the ops root password"
+	if resp, body := ts.do(http.MethodPost, "/api/v1/channels", adminKey, map[string]any{"name": "ops", "private": true, "users": []string{"pat"}}); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating the channel: status %d: %s", resp.StatusCode, body)
+	}
+	for channel, content := range map[string]string{"ops": secret, "general": "maintenance tonight"} {
+		if resp, body := ts.do(http.MethodPost, "/api/v1/messages", adminKey, map[string]string{"content": content, "channel": channel}); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("posting to #%s: status %d: %s", channel, resp.StatusCode, body)
+		}
+	}
+	// Slack and webhooks, like email, hear of the public post only.
+	waitForCount(t, "slack", slackPosts, 1)
+	waitForCount(t, "webhook", webhookPosts, 1)
+
+	// Each place messages are read must hide the channel from a key not
+	// on its allowlist and show it to an admin.
+	for _, path := range []string{
+		"/api/v1/messages",
+		"/api/v1/messages/search?q=password",
+		"/api/v1/messages/export?format=ndjson",
+		"/api/v1/messages/export?format=csv",
+		"/feed.xml",
+		"/atom.xml",
+	} {
+		if _, body := ts.do(http.MethodGet, path, readerKey, nil); strings.Contains(body, secret) {
+			t.Errorf("%s shows the private message to a reader", path)
+		}
+		if _, body := ts.do(http.MethodGet, path, adminKey, nil); !strings.Contains(body, secret) {
+			t.Errorf("%s hides the private message from an admin", path)
+		}
+	}
+	if _, body := ts.do(http.MethodGet, "/api/v1/channels", readerKey, nil); strings.Contains(body, `"ops"`) {
+		t.Error("the channel list shows the private channel to a reader")
+	}
+	if resp, _ := ts.do(http.MethodGet, "/api/v1/channels/ops", readerKey, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET the private channel as a reader: status %d, want 404", resp.StatusCode)
+	}
+
+	// On the pages, the allowlist lets pat in and keeps vic out. Search
+	// marks the words it matched, so look for the ones it did not.
+	for _, c := range []struct {
+		user string
+		sees bool
+	}{
+		{"vic", false},
+		{"pat", true},
+	} {
+		resp, _ := ts.postForm("/login", url.Values{"username": {c.user}, "password": {testfixtures.Password}})
+		session := cookie(resp, "slrs_session")
+		for _, path := range []string{"/", "/?q=password", "/c/ops"} {
+			resp, body := ts.get(path, session)
+			if sees := resp.StatusCode == http.StatusOK && strings.Contains(body, "the ops root"); sees != c.sees {
+				t.Errorf("%s as %s: status %d, shows the message %v, want %v", path, c.user, resp.StatusCode, sees, c.sees)
+			}
+		}
+	}
+}
+
+func TestMarkdownSanitized(t *testing.T) {
+	ts := newTestServer(t)
+	for _, c := range []struct {
+		content, bad, good string
+	}{
+		{"<script>alert(1)</script>\n\nplain", "<script>alert(1)", "<p>plain</p>"},
+		{"<img src=x onerror=alert(2)>", "onerror", ""},
+		{"[click](javascript:alert(3))", "javascript:", "click"},
+		{`<a href="#" onclick="alert(4)">raw</a>`, "onclick", ""},
+		{"**bold** and `<script>`", "<code><script>", "<strong>bold</strong>"},
+	} {
+		resp, body := ts.do(http.MethodPost, "/api/v1/messages", adminKey, map[string]string{"content": c.content})
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("posting %q: status %d: %s", c.content, resp.StatusCode, body)
+		}
+		// The newest message comes first; its raw source, escaped, follows
+		// the rendered content.
+		_, page := ts.get("/")
+		_, rendered, _ := strings.Cut(page, `<div class="content">`)
+		rendered, _, _ = strings.Cut(rendered, "</div>")
+		if strings.Contains(rendered, c.bad) {
+			t.Errorf("%q renders %q", c.content, c.bad)
+		}
+		if !strings.Contains(rendered, c.good) {
+			t.Errorf("%q does not render %q", c.content, c.good)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.RateLimit, cfg.RateBurst = 1, 2 })
+	for i, want := range []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests} {
+		resp, body := ts.do(http.MethodPost, "/api/v1/messages", adminKey, map[string]string{"content": "post " + strconv.Itoa(i)})
+		if resp.StatusCode != want {
+			t.Fatalf("post %d: status %d, want %d: %s", i, resp.StatusCode, want, body)
+		}
+		if want == http.StatusTooManyRequests && (resp.Header.Get("Retry-After") == "" || !strings.Contains(body, `"error"`)) {
+			t.Errorf("429 without Retry-After or a JSON error: %q %s", resp.Header.Get("Retry-After"), body)
+		}
+	}
+	// Reading is not limited.
+	if resp, _ := ts.do(http.MethodGet, "/api/v1/messages", readerKey, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET after the limit: status %d", resp.StatusCode)
+	}
+
+	// A client cannot shed the limit by claiming another address; only a
+	// trusted proxy's X-Forwarded-For names the client.
+	proxied := newTestServer(t, func(cfg *config.Config) {
+		cfg.RateLimit, cfg.RateBurst = 1, 1
+		cfg.TrustedProxies = "127.0.0.1/32"
+	})
+	for _, c := range []struct {
+		ts   *testServer
+		from string
+		want int
+	}{
+		{ts, "203.0.113.7", http.StatusTooManyRequests},
+		{proxied, "203.0.113.7", http.StatusCreated},
+		{proxied, "203.0.113.7", http.StatusTooManyRequests},
+		{proxied, "203.0.113.8", http.StatusCreated},
+	} {
+		req, err := http.NewRequest(http.MethodPost, c.ts.URL+"/api/v1/messages", strings.NewReader(`{"content":"hello"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+		req.Header.Set("X-Forwarded-For", c.from)
+		if resp, body := c.ts.send(req, nil); resp.StatusCode != c.want {
+			t.Errorf("post for %s, proxy trusted %v: status %d, want %d: %s", c.from, c.ts == proxied, resp.StatusCode, c.want, body)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.CORSOrigins = "https://dash.example.com" })
+	request := func(method, path, origin string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "Authorization, Idempotency-Key")
+		}
+		resp, _ := ts.send(req, nil)
+		return resp
+	}
+	resp := request(http.MethodOptions, "/api/v1/messages", "https://dash.example.com")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "https://dash.example.com" ||
+		!strings.Contains(resp.Header.Get("Access-Control-Allow-Methods"), "POST") || !strings.Contains(resp.Header.Get("Access-Control-Allow-Headers"), "Idempotency-Key") {
+		t.Errorf("preflight from an allowed origin: %d %v", resp.StatusCode, resp.Header)
+	}
+	resp = request(http.MethodOptions, "/api/v1/messages", "https://evil.example")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight from another origin: %d, Allow-Origin %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+	resp = request(http.MethodGet, "/api/v1/messages", "https://dash.example.com")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "https://dash.example.com" || !strings.Contains(resp.Header.Get("Access-Control-Expose-Headers"), "Link") {
+		t.Errorf("GET from an allowed origin: Allow-Origin %q, Expose-Headers %q", resp.Header.Get("Access-Control-Allow-Origin"), resp.Header.Get("Access-Control-Expose-Headers"))
+	}
+	if resp := request(http.MethodGet, "/about", "https://dash.example.com"); resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Error("a page allowed a cross-origin read")
+	}
+}
+
+func TestWebSocketPosts(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.RateLimit, cfg.RateBurst = 1, 2 })
+	dial := func(user string) *websocket.Conn {
+		t.Helper()
+		resp, _ := ts.postForm("/login", url.Values{"username": {user}, "password": {testfixtures.Password}})
+		header := http.Header{"Cookie": {cookie(resp, "slrs_session").String()}}
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	// post sends content and returns the reply to it: an error, or the
+	// event of the message it created.
+	post := func(conn *websocket.Conn, content string) map[string]any {
+		t.Helper()
+		if err := conn.WriteJSON(map[string]string{"content": content, "channel": "general"}); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var reply map[string]any
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	if reply := post(dial("vic"), "from a viewer"); reply["error"] != "post permission required" {
+		t.Errorf("post as a viewer: %v", reply)
+	}
+	pat := dial("pat")
+	if reply := post(pat, "over the socket"); reply["type"] != "created" {
+		t.Errorf("post as a poster: %v", reply)
+	}
+	if reply := post(pat, "bell\a"); reply["type"] != "error" || !strings.Contains(reply["error"].(string), "content") {
+		t.Errorf("post with a control character: %v", reply)
+	}
+	if reply := post(pat, "one too many"); reply["error"] != "rate limit exceeded" {
+		t.Errorf("post past the burst: %v", reply)
+	}
+}
+
+func TestTheme(t *testing.T) {
+	ts := newTestServer(t)
+	if resp, _ := ts.postForm("/theme", url.Values{"theme": {"nope"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown theme: status %d, want 400", resp.StatusCode)
+	}
+	resp, _ := ts.postForm("/theme", url.Values{"theme": {"ocean"}})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("POST /theme: status %d, want 303", resp.StatusCode)
+	}
+	theme := cookie(resp, "slrs_theme")
+	if theme == nil {
+		t.Fatal("POST /theme set no cookie")
+	}
+	if _, body := ts.get("/about", theme); !strings.Contains(body, `href="/static/themes/ocean.css"`) {
+		t.Error("page lacks the picked theme's stylesheet")
+	}
+}
+
+func TestColorScheme(t *testing.T) {
+	ts := newTestServer(t)
+	const (
+		auto   = `href="/static/dark.css" media="(prefers-color-scheme: dark)"`
+		always = `href="/static/dark.css">`
+	)
+	if _, body := ts.get("/about"); !strings.Contains(body, auto) {
+		t.Error("dark stylesheet not left to the browser by default")
+	}
+
+	resp, _ := ts.postForm("/color-scheme", url.Values{"scheme": {"dark"}})
+	scheme := cookie(resp, "slrs_color_scheme")
+	if resp.StatusCode != http.StatusSeeOther || scheme == nil {
+		t.Fatalf("POST /color-scheme: status %d, cookie %v", resp.StatusCode, scheme)
+	}
+	if _, body := ts.get("/about", scheme); !strings.Contains(body, always) {
+		t.Error("dark mode from the cookie not applied")
+	}
+
+	// A signed-in user's choice is kept with the account.
+	resp, _ = ts.postForm("/login", url.Values{"username": {"pat"}, "password": {testfixtures.Password}})
+	session := cookie(resp, "slrs_session")
+	if session == nil {
+		t.Fatal("login set no session cookie")
+	}
+	ts.postForm("/color-scheme", url.Values{"scheme": {"dark"}}, session)
+	if _, body := ts.get("/about", session); !strings.Contains(body, always) {
+		t.Error("dark mode from the account not applied")
+	}
+	ts.postForm("/color-scheme", url.Values{"scheme": {"light"}}, session)
+	if _, body := ts.get("/about", session, scheme); strings.Contains(body, "dark.css") {
+		t.Error("light mode from the account does not win over the cookie")
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	ts := newTestServer(t)
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+	req.Header.Set("Accept-Language", "de-CH, en;q=0.5")
+	resp, body := ts.send(req, nil)
+	if got := resp.Header.Get("Content-Language"); got != "de" || !strings.Contains(body, "Willkommen") || !strings.Contains(body, "gerade eben") {
+		t.Errorf("Accept-Language de: Content-Language %q, page not in German", got)
+	}
+
+	resp, body = ts.get("/about?lang=fr")
+	lang := cookie(resp, "slrs_lang")
+	if !strings.Contains(body, "À propos") || lang == nil {
+		t.Fatalf("?lang=fr: page not in French or no cookie (%v)", lang)
+	}
+	req, _ = http.NewRequest(http.MethodGet, ts.URL+"/about", nil)
+	req.Header.Set("Accept-Language", "de")
+	if _, body := ts.send(req, []*http.Cookie{lang}); !strings.Contains(body, `<html lang="fr">`) {
+		t.Error("language picked with ?lang not kept over Accept-Language")
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	ts := newTestServer(t)
+	path := "/api/messages/" + strconv.Itoa(ts.messages[0].ID)
+	_, body := ts.do(http.MethodGet, path+"?tz=Asia/Tokyo", "", nil)
+	if !strings.Contains(body, `+09:00"`) {
+		t.Errorf("?tz=Asia/Tokyo: times not in Tokyo: %s", body)
+	}
+	if resp, _ := ts.do(http.MethodGet, path+"?tz=Nope", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown tz: status %d, want 400", resp.StatusCode)
+	}
+
+	resp, body := ts.get("/?tz=Asia/Tokyo")
+	tz := cookie(resp, "slrs_tz")
+	if !strings.Contains(body, " JST") || tz == nil {
+		t.Fatalf("?tz=Asia/Tokyo: page times not in JST or no cookie (%v)", tz)
+	}
+	if _, body := ts.get("/", tz); !strings.Contains(body, " JST") {
+		t.Error("time zone picked with ?tz not kept")
+	}
+}
+
+func TestFlash(t *testing.T) {
+	ts := newTestServer(t)
+	resp, _ := ts.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}})
+	session := cookie(resp, "slrs_session")
+	if flash := cookie(resp, "slrs_flash"); flash == nil || !strings.Contains(flash.Value, "Signed+in+as+ada") {
+		t.Errorf("login left no flash: %v", flash)
+	}
+
+	resp, _ = ts.postForm("/submit", url.Values{"author": {"ada"}, "content": {"flashed"}, "channel": {"general"}}, session)
+	flash := cookie(resp, "slrs_flash")
+	if resp.StatusCode != http.StatusSeeOther || flash == nil {
+		t.Fatalf("POST /submit: status %d, flash %v", resp.StatusCode, flash)
+	}
+	resp, body := ts.get("/c/general", session, flash)
+	if !strings.Contains(body, `<p class="flash flash-success" role="status">Your message was posted.</p>`) {
+		t.Error("board lacks the flash left by the post")
+	}
+	if c := cookie(resp, "slrs_flash"); c == nil || c.MaxAge >= 0 {
+		t.Error("flash not cleared once shown")
+	}
+}
+
+func TestPostFormErrors(t *testing.T) {
+	ts := newTestServer(t)
+	resp, _ := ts.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}})
+	session := cookie(resp, "slrs_session")
+	resp, body := ts.postForm("/submit", url.Values{"author": {"ada"}, "content": {"  "}, "tags": {"deploy"}, "channel": {"general"}, "expires_at": {"soon"}}, session)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid post: status %d, want 422", resp.StatusCode)
+	}
+	for _, want := range []string{
+		`<input type="hidden" name="channel" value="general">`,
+		`name="author" value="ada"`,
+		`name="tags" value="deploy"`,
+		`<small class="field-error">is required</small>`,
+		`name="expires_at" value="soon" aria-invalid="true"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("re-shown form lacks %s", want)
+		}
+	}
+
+	_, body = ts.postForm("/submit", url.Values{"content": {"hi"}, "channel": {"nope"}}, session)
+	if !strings.Contains(body, `<small class="field-error">does not exist</small>`) || !strings.Contains(body, `>hi</textarea>`) {
+		t.Error("re-shown form lacks the channel's problem or the content")
+	}
+}
+
+func TestPartials(t *testing.T) {
+	ts := newTestServer(t)
+	resp, body := ts.get("/partials/messages?channel=general")
+	if resp.StatusCode != http.StatusOK || strings.Contains(body, "<html") || !strings.HasPrefix(strings.TrimSpace(body), "<li id=\"message-") {
+		t.Fatalf("GET /partials/messages: status %d, not the list's items: %.80q", resp.StatusCode, body)
+	}
+	if resp, _ := ts.get("/partials/messages?channel=nope"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown channel: status %d, want 404", resp.StatusCode)
+	}
+
+	resp, _ = ts.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}})
+	session := cookie(resp, "slrs_session")
+	resp, body = ts.postForm("/partials/submit?channel=general", url.Values{"content": {"without a reload"}, "channel": {"general"}}, session)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Your message was posted.") || !strings.Contains(body, `<form id="post-form"`) || strings.Contains(body, "<html") {
+		t.Fatalf("POST /partials/submit: status %d: %s", resp.StatusCode, body)
+	}
+	if _, body := ts.get("/partials/messages?channel=general"); !strings.Contains(body, "without a reload") {
+		t.Error("list fragment lacks the new message")
+	}
+	resp, body = ts.postForm("/partials/submit", url.Values{"author": {"ada"}, "content": {""}}, session)
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(body, `name="author" value="ada"`) {
+		t.Errorf("invalid POST /partials/submit: status %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.CacheTTL = time.Minute })
+	alice := &http.Cookie{Name: "slrs_csrf", Value: "token-of-alice"}
+	bob := &http.Cookie{Name: "slrs_csrf", Value: "token-of-bob"}
+	if resp, _ := ts.get("/", alice); resp.Header.Get("X-Cache") != "MISS" {
+		t.Fatalf("first GET /: X-Cache %q, want MISS", resp.Header.Get("X-Cache"))
+	}
+	resp, body := ts.get("/", bob)
+	if resp.Header.Get("X-Cache") != "HIT" {
+		t.Fatalf("second GET /: X-Cache %q, want HIT", resp.Header.Get("X-Cache"))
+	}
+	if !strings.Contains(body, "token-of-bob") || strings.Contains(body, "token-of-alice") {
+		t.Error("cached page carries another browser's CSRF token")
+	}
+
+	ts.do(http.MethodGet, "/api/messages", readerKey, nil)
+	if resp, _ := ts.do(http.MethodGet, "/api/messages", readerKey, nil); resp.Header.Get("X-Cache") != "HIT" {
+		t.Fatalf("second GET /api/messages: X-Cache %q, want HIT", resp.Header.Get("X-Cache"))
+	}
+	ts.do(http.MethodPost, "/api/messages", adminKey, map[string]string{"author": "ada", "content": "fresh off the press"})
+	resp, body = ts.do(http.MethodGet, "/api/messages", readerKey, nil)
+	if resp.Header.Get("X-Cache") != "MISS" || !strings.Contains(body, "fresh off the press") {
+		t.Errorf("GET /api/messages after a post: X-Cache %q, new message missing: %t", resp.Header.Get("X-Cache"), !strings.Contains(body, "fresh off the press"))
+	}
+	if _, body := ts.get("/", alice); !strings.Contains(body, "fresh off the press") {
+		t.Error("board still served from before the post")
+	}
+}
+
+func TestBackups(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.BackupKeep = 2 })
+	if resp, _ := ts.do(http.MethodPost, "/api/backups", readerKey, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST /api/backups as a reader: %d, want 403", resp.StatusCode)
+	}
+	var taken []web.Backup
+	for range 3 {
+		resp, body := ts.do(http.MethodPost, "/api/backups", adminKey, nil)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("POST /api/backups: %d %s", resp.StatusCode, body)
+		}
+		var b web.Backup
+		ts.decode(body, &b)
+		taken = append(taken, b)
+		time.Sleep(5 * time.Millisecond) // names are to the millisecond
+	}
+	var list []web.Backup
+	_, body := ts.do(http.MethodGet, "/api/backups", adminKey, nil)
+	ts.decode(body, &list)
+	if len(list) != 2 || list[0].Name != taken[2].Name || list[1].Name != taken[1].Name {
+		t.Fatalf("backups kept: %+v, want the last two of %+v", list, taken)
+	}
+
+	resp, body := ts.do(http.MethodGet, "/api/backups/"+list[0].Name, adminKey, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/backups/{name}: %d", resp.StatusCode)
+	}
+	zr, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap store.Snapshot
+	if err := json.NewDecoder(zr).Decode(&snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != store.SnapshotVersion {
+		t.Errorf("snapshot version %d, want %d", snap.Version, store.SnapshotVersion)
+	}
+	for _, m := range ts.messages {
+		if !slices.ContainsFunc(snap.Messages, func(sm store.SnapshotMessage) bool { return sm.ID == m.ID && sm.Content == m.Content }) {
+			t.Errorf("snapshot is missing message %d %q", m.ID, m.Content)
+		}
+	}
+	if resp, _ := ts.do(http.MethodGet, "/api/backups/"+taken[0].Name, adminKey, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET a pruned backup: %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := ts.do(http.MethodGet, "/api/backups/..%2Fmessages.db", adminKey, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET a backup outside the directory: %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ts := newTestServer(t)
+	resp, body := ts.do(http.MethodPost, "/api/backups", adminKey, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/backups: %d %s", resp.StatusCode, body)
+	}
+	var b web.Backup
+	ts.decode(body, &b)
+
+	_, body = ts.do(http.MethodPost, "/api/messages", adminKey, map[string]string{"author": "ada", "content": "after the backup"})
+	var added store.Message
+	ts.decode(body, &added)
+	deleted := ts.messages[0]
+	ts.do(http.MethodDelete, "/api/messages/"+strconv.Itoa(deleted.ID), adminKey, nil)
+
+	if resp, _ := ts.do(http.MethodPost, "/api/backups/"+b.Name+"/restore", readerKey, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("restore as a reader: %d, want 403", resp.StatusCode)
+	}
+	resp, body = ts.do(http.MethodPost, "/api/backups/"+b.Name+"/restore", adminKey, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /api/backups/{name}/restore: %d %s", resp.StatusCode, body)
+	}
+	var res web.RestoreResult
+	ts.decode(body, &res)
+	if res.Restored != b.Name || res.Before.Name == "" || res.Before.Name == b.Name {
+		t.Errorf("restore result %+v, want %s restored and a backup taken before", res, b.Name)
+	}
+	if resp, _ := ts.do(http.MethodGet, "/api/messages/"+strconv.Itoa(added.ID), readerKey, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("message posted after the backup: %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := ts.do(http.MethodGet, "/api/messages/"+strconv.Itoa(deleted.ID), readerKey, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("message deleted after the backup: %d, want it back", resp.StatusCode)
+	}
+	_, body = ts.do(http.MethodGet, "/api/maintenance", adminKey, nil)
+	var m struct{ Enabled bool }
+	ts.decode(body, &m)
+	if m.Enabled {
+		t.Error("still in maintenance mode after the restore")
+	}
+	if resp, _ := ts.do(http.MethodPost, "/api/backups/backup-20000101-000000.000.json.gz/restore", adminKey, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("restore an unknown backup: %d, want 404", resp.StatusCode)
+	}
+}
+
+// fakeS3 is a bucket named slrs kept in memory, with as much of the S3
+// API as the s3 backends use: the bucket's HEAD, and PUT, GET, HEAD and
+// DELETE of objects. With sseKey, a base64 SSE-C key, it insists on that
+// key for every write and read of an object, as S3 does.
+type fakeS3 struct {
+	sseKey  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/slrs/")
+	if !ok || key == "" {
+		return // the bucket exists
+	}
+	if s.sseKey != "" && r.Method != http.MethodDelete {
+		if r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "AES256" || r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key") != s.sseKey {
+			s.error(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		w.Header().Set("X-Amz-Server-Side-Encryption-Customer-Algorithm", "AES256")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, err := readS3Payload(r)
+		if err != nil {
+			s.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.objects[key] = body
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case http.MethodGet, http.MethodHead:
+		body, ok := s.objects[key]
+		if !ok {
+			s.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		http.ServeContent(w, r, key, time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC), strings.NewReader(string(body)))
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// count is how many objects the bucket holds.
+func (s *fakeS3) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+func (s *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// readS3Payload reads the body of a PUT, taking apart the aws-chunked
+// encoding clients use to sign the payload as it streams.
+func readS3Payload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var body []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return body, nil
+		}
+		chunk := make([]byte, n+2) // and its CRLF
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		body = append(body, chunk[:n]...)
+	}
+}
+
+func TestS3(t *testing.T) {
+	sseKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	for _, c := range []struct {
+		name string
+		sse  string
+	}{
+		{"plain", ""},
+		{"customer key", "c"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			bucket := &fakeS3{objects: make(map[string][]byte)}
+			srv := httptest.NewUnstartedServer(bucket)
+			if c.sse == "c" {
+				// S3 takes customer keys over HTTPS only.
+				bucket.sseKey = sseKey
+				srv.StartTLS()
+			} else {
+				srv.Start()
+			}
+			t.Cleanup(srv.Close)
+			ts := newTestServer(t, func(cfg *config.Config) {
+				cfg.BlobBackend = "s3"
+				cfg.S3Endpoint = srv.Listener.Addr().String()
+				cfg.S3Insecure = srv.TLS == nil
+				cfg.S3Bucket, cfg.S3Region = "slrs", "us-east-1"
+				cfg.S3AccessKey, cfg.S3SecretKey = "slrs", "slrs-secret"
+				cfg.S3SSE, cfg.S3SSECustomerKey = c.sse, sseKey
+			})
+
+			var b bytes.Buffer
+			mw := multipart.NewWriter(&b)
+			mw.WriteField("content", "deploy log attached")
+			fw, _ := mw.CreateFormFile("files", "deploy.log")
+			fw.Write([]byte("all good\n"))
+			mw.Close()
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/messages", &b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			req.Header.Set("Authorization", "Bearer "+adminKey)
+			resp, body := ts.send(req, nil)
+			var m store.Message
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("post with an attachment: status %d: %s", resp.StatusCode, body)
+			}
+			ts.decode(body, &m)
+			if len(m.Attachments) != 1 || bucket.count() != 1 {
+				t.Fatalf("attachments %v, objects in the bucket %d", m.Attachments, bucket.count())
+			}
+
+			if resp, body := ts.get("/attachments/" + strconv.Itoa(m.Attachments[0].ID)); resp.StatusCode != http.StatusOK || body != "all good\n" {
+				t.Errorf("GET the attachment: status %d: %q", resp.StatusCode, body)
+			}
+
+			if resp, _ := ts.do(http.MethodDelete, "/api/v1/messages/"+strconv.Itoa(m.ID), adminKey, nil); resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("deleting the message: status %d", resp.StatusCode)
+			}
+			resp, _ = ts.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}})
+			if resp, _ := ts.postForm("/admin/trash/"+strconv.Itoa(m.ID)+"/purge", url.Values{}, cookie(resp, "slrs_session")); resp.StatusCode != http.StatusSeeOther || bucket.count() != 0 {
+				t.Errorf("purging the message: status %d, objects left %d", resp.StatusCode, bucket.count())
+			}
+		})
+	}
+
+	// A customer key must be one, and go over HTTPS.
+	for _, c := range []struct {
+		key      string
+		args     []string
+		mentions string
+	}{
+		{"", []string{"-s3-sse", "c"}, "32 bytes"},
+		{base64.StdEncoding.EncodeToString([]byte("short")), []string{"-s3-sse", "c"}, "32 bytes"},
+		{sseKey, []string{"-s3-sse", "c", "-s3-insecure"}, "HTTPS"},
+	} {
+		t.Setenv("S3_SSE_CUSTOMER_KEY", c.key)
+		if _, err := config.Load(c.args); err == nil || !strings.Contains(err.Error(), c.mentions) {
+			t.Errorf("config %v with key %q: %v, want an error about %s", c.args, c.key, err, c.mentions)
+		}
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	ts := newTestServer(t)
+	srv := grpc.NewServer()
+	ts.app.RegisterGRPC(srv)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	svc := slrsv1.NewMessageServiceClient(conn)
+	as := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+	}
+
+	if _, err := svc.ListMessages(as("wrong"), &slrsv1.ListMessagesRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ListMessages with a bad key: %v, want Unauthenticated", err)
+	}
+	page, err := svc.ListMessages(as(readerKey), &slrsv1.ListMessagesRequest{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 2 || page.NextPageToken == "" || int(page.TotalSize) < len(ts.messages) {
+		t.Errorf("first page: %d messages of %d, next %q", len(page.Messages), page.TotalSize, page.NextPageToken)
+	}
+	want := ts.messages[0]
+	got, err := svc.GetMessage(as(readerKey), &slrsv1.GetMessageRequest{Id: int64(want.ID)})
+	if err != nil || got.Content != want.Content || got.Author != want.Author {
+		t.Errorf("GetMessage(%d): %v, %v; want %q by %s", want.ID, got, err, want.Content, want.Author)
+	}
+	if _, err := svc.GetMessage(as(readerKey), &slrsv1.GetMessageRequest{Id: 99999}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetMessage of a missing message: %v, want NotFound", err)
+	}
+
+	ctx, cancel := context.WithCancel(as(readerKey))
+	defer cancel()
+	watch, err := svc.WatchMessages(ctx, &slrsv1.WatchMessagesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Header(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.CreateMessage(as(readerKey), &slrsv1.CreateMessageRequest{Author: "ada", Content: "hi"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("CreateMessage with a read-only key: %v, want PermissionDenied", err)
+	}
+	_, err = svc.CreateMessage(as(adminKey), &slrsv1.CreateMessageRequest{Author: "ada"})
+	var fields []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+		}
+	}
+	if status.Code(err) != codes.InvalidArgument || !slices.Contains(fields, "content") {
+		t.Errorf("CreateMessage without content: %v, fields %v; want InvalidArgument on content", err, fields)
+	}
+	created, err := svc.CreateMessage(as(adminKey), &slrsv1.CreateMessageRequest{Author: "ada", Content: "over gRPC", Tags: []string{"grpc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := watch.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != slrsv1.MessageEvent_TYPE_CREATED || ev.Message.Id != created.Id || ev.Message.Content != "over gRPC" {
+		t.Errorf("watched %v, want the message created", ev)
+	}
+	if resp, _ := ts.do(http.MethodGet, "/api/messages/"+strconv.Itoa(int(created.Id)), readerKey, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/messages/{id} of the message created over gRPC: %d", resp.StatusCode)
+	}
+}
+
+func TestClient(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	reader, err := client.New(ts.URL, client.WithAPIKey(readerKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := client.New(ts.URL, client.WithAPIKey(adminKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := reader.ListMessages(ctx, client.ListOptions{PerPage: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 2 || !page.HasNext() || page.Total < len(ts.messages) {
+		t.Fatalf("first page: %d messages of %d, next %v", len(page.Messages), page.Total, page.HasNext())
+	}
+	var all []int
+	for m, err := range reader.ListAllMessages(ctx, client.ListOptions{PerPage: 2}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, m.ID)
+	}
+	if len(all) != page.Total || len(slices.Compact(slices.Sorted(slices.Values(all)))) != len(all) {
+		t.Errorf("ListAllMessages gave %d messages, %v, want the %d listed once each", len(all), all, page.Total)
+	}
+
+	if _, err := reader.GetMessage(ctx, 99999); !client.IsNotFound(err) {
+		t.Errorf("GetMessage of a missing message: %v, want not found", err)
+	}
+	_, err = admin.CreateMessage(ctx, client.NewMessage{Author: "ada"})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Fields["content"] == "" {
+		t.Errorf("CreateMessage without content: %v, want 422 on content", err)
+	}
+
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan client.Event, 1)
+	go func() {
+		for ev, err := range reader.Subscribe(sctx, "") {
+			if err != nil {
+				t.Error(err)
+			}
+			events <- ev
+			return
+		}
+	}()
+	// Messages posted before the stream is open are not sent, so post
+	// until one is.
+	var created *client.Message
+	var ev client.Event
+	received := false
+	for range 50 {
+		if created, err = admin.CreateMessage(ctx, client.NewMessage{Author: "ada", Content: "from the client"}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ev = <-events:
+			received = true
+		case <-time.After(100 * time.Millisecond):
+		}
+		if received {
+			break
+		}
+	}
+	if !received {
+		t.Fatal("no event for the messages created")
+	}
+	if ev.Type != "created" || ev.Message.Author != "ada" || ev.Message.Content != "from the client" {
+		t.Errorf("subscribed %+v, want a message created", ev)
+	}
+	cancel()
+
+	// A board briefly unavailable is retried.
+	failures := 2
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": "down for maintenance"}`))
+			return
+		}
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+	c, err := client.New(flaky.URL, client.WithAPIKey(readerKey), client.WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := c.GetMessage(ctx, created.ID); err != nil || m.Content != "from the client" {
+		t.Errorf("GetMessage through two 503s: %v, %v", m, err)
+	}
+}
+
+func TestBulkMessages(t *testing.T) {
+	ts := newTestServer(t)
+	parent := ts.messages[0]
+	batch := map[string]any{"messages": []map[string]any{
+		{"author": "ada", "content": "first of the batch", "tags": []string{"migrated"}},
+		{"author": "ada", "content": "a reply", "parent_id": parent.ID},
+	}}
+	if resp, _ := ts.do(http.MethodPost, "/api/messages/bulk", readerKey, batch); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("bulk create with a read-only key: %d, want 403", resp.StatusCode)
+	}
+	resp, body := ts.do(http.MethodPost, "/api/messages/bulk", adminKey, batch)
+	var created struct {
+		Created int
+		Results []struct {
+			Index, ID, Status int
+			Message           store.Message
+		}
+	}
+	ts.decode(body, &created)
+	if resp.StatusCode != http.StatusCreated || created.Created != 2 || len(created.Results) != 2 {
+		t.Fatalf("bulk create: %d %s", resp.StatusCode, body)
+	}
+	if r := created.Results[1]; r.Index != 1 || r.ID == 0 || r.Status != http.StatusCreated || r.Message.ParentID != parent.ID || r.Message.Channel != parent.Channel {
+		t.Errorf("the reply created: %+v", r)
+	}
+
+	// One bad message keeps the rest out too.
+	c, err := client.New(ts.URL, client.WithAPIKey(adminKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.CreateMessages(context.Background(), []client.NewMessage{
+		{Author: "ada", Content: "fine on its own"},
+		{Author: "ada"},
+		{Author: "ada", Content: "orphan", ParentID: 99999},
+	})
+	if !errors.As(err, new(*client.Error)) || res == nil || res.Created != 0 {
+		t.Fatalf("bulk create with invalid messages: %+v, %v", res, err)
+	}
+	if r := res.Results; r[0].Status != http.StatusFailedDependency || r[1].Status != http.StatusUnprocessableEntity || r[1].Errors["content"] == "" || r[2].Errors["parent_id"] == "" {
+		t.Errorf("results of the invalid batch: %+v", r)
+	}
+	if resp, body := ts.do(http.MethodGet, "/api/messages/search?q=fine+on+its+own", readerKey, nil); strings.TrimSpace(body) != "[]" {
+		t.Errorf("search for the valid message of the rejected batch: %d %s", resp.StatusCode, body)
+	}
+
+	ids := strconv.Itoa(created.Results[0].ID) + "," + strconv.Itoa(created.Results[1].ID) + ",99999"
+	if resp, _ := ts.do(http.MethodDelete, "/api/messages?ids="+ids, readerKey, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("bulk delete with a read-only key: %d, want 403", resp.StatusCode)
+	}
+	del, err := c.DeleteMessages(context.Background(), []int{created.Results[0].ID, created.Results[1].ID, 99999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if del.Deleted != 2 || del.Results[0].Status != http.StatusNoContent || del.Results[2].Status != http.StatusNotFound {
+		t.Errorf("bulk delete: %+v", del)
+	}
+	if resp, _ := ts.do(http.MethodGet, "/api/messages/"+strconv.Itoa(created.Results[0].ID), readerKey, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of a message deleted in bulk: %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := ts.do(http.MethodDelete, "/api/messages?ids=1,x", adminKey, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bulk delete of a bad ID: %d, want 400", resp.StatusCode)
+	}
+}
+
+// bulkPost creates n messages in one bulk request, faster than any
+// subscriber keeps up with.
+func (ts *testServer) bulkPost(n int) {
+	ts.t.Helper()
+	msgs := make([]map[string]any, n)
+	for i := range msgs {
+		msgs[i] = map[string]any{"content": "deploy " + strconv.Itoa(i)}
+	}
+	if resp, body := ts.do(http.MethodPost, "/api/v1/messages/bulk", adminKey, map[string]any{"messages": msgs}); resp.StatusCode != http.StatusCreated {
+		ts.t.Fatalf("bulk create: status %d: %s", resp.StatusCode, body)
+	}
+}
+
+// countingReceiver is an HTTP endpoint that counts the posts it is sent.
+func countingReceiver(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	var n atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &n
+}
+
+// waitForCount waits for n to reach want, and a moment longer to see it
+// go no further.
+func waitForCount(t *testing.T, what string, n *atomic.Int64, want int64) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); n.Load() < want && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := n.Load(); got != want {
+		t.Errorf("%s: %d deliveries, want %d", what, got, want)
+	}
+}
+
+func TestSlackGetsEveryMessage(t *testing.T) {
+	slack, posts := countingReceiver(t)
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.SlackWebhookURL = slack.URL })
+	ts.bulkPost(200)
+	waitForCount(t, "slack", posts, 200)
+}
+
+func TestWebhooksGetEveryMessage(t *testing.T) {
+	receiver, posts := countingReceiver(t)
+	ts := newTestServer(t)
+	resp, _ := ts.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}})
+	hook := url.Values{"url": {receiver.URL}, "events": {"message.created"}}
+	if resp, body := ts.postForm("/admin/webhooks", hook, cookie(resp, "slrs_session")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("adding the webhook: status %d: %s", resp.StatusCode, body)
+	}
+	ts.bulkPost(200)
+	waitForCount(t, "webhook", posts, 200)
+}
+
+func TestLongPoll(t *testing.T) {
+	// With the response cache on, which must not answer long polls.
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.CacheTTL = time.Minute })
+	poll := func(query, etag string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/messages"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+readerKey)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		return ts.send(req, nil)
+	}
+	for _, q := range []string{"?wait=soon", "?wait=61", "?wait=-1"} {
+		if resp, _ := poll(q, ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET /api/messages%s: %d, want 400", q, resp.StatusCode)
+		}
+	}
+	resp, _ := poll("?wait=30", "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("a long poll without a copy to compare: %d, ETag %q", resp.StatusCode, etag)
+	}
+
+	start := time.Now()
+	if resp, _ := poll("?wait=1", etag); resp.StatusCode != http.StatusNotModified || time.Since(start) < time.Second {
+		t.Errorf("a long poll with nothing new: %d after %v, want 304 after 1s", resp.StatusCode, time.Since(start))
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ts.do(http.MethodPost, "/api/messages", adminKey, map[string]string{"author": "ada", "content": "worth the wait"})
+	}()
+	start = time.Now()
+	resp, body := poll("?wait=30", etag)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "worth the wait") || time.Since(start) > 10*time.Second {
+		t.Errorf("a long poll with a post during it: %d after %v", resp.StatusCode, time.Since(start))
+	}
+
+	c, err := client.New(ts.URL, client.WithAPIKey(readerKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	p, err := c.ListMessages(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same, err := c.WaitMessages(ctx, client.ListOptions{}, p, time.Second); same != p || err != nil {
+		t.Errorf("WaitMessages with nothing new: %v, want the same page", err)
+	}
+}
+
+func TestAPIVersions(t *testing.T) {
+	ts := newTestServer(t)
+	resp, v1 := ts.do(http.MethodGet, "/api/v1/messages?per_page=1", readerKey, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Deprecation") != "" || resp.Header.Get("Sunset") != "" {
+		t.Fatalf("GET /api/v1/messages: %d, Deprecation %q, Sunset %q", resp.StatusCode, resp.Header.Get("Deprecation"), resp.Header.Get("Sunset"))
+	}
+	if link := resp.Header.Get("Link"); !strings.Contains(link, "</api/v1/messages?") {
+		t.Errorf("v1 pagination links: %q", link)
+	}
+	resp, legacy := ts.do(http.MethodGet, "/api/messages?per_page=1", readerKey, nil)
+	if resp.StatusCode != http.StatusOK || legacy != v1 {
+		t.Errorf("GET /api/messages: %d %s, want v1's %s", resp.StatusCode, legacy, v1)
+	}
+	if d := resp.Header.Get("Deprecation"); !strings.HasPrefix(d, "@") {
+		t.Errorf("Deprecation of an unversioned path: %q", d)
+	}
+	if _, err := http.ParseTime(resp.Header.Get("Sunset")); err != nil {
+		t.Errorf("Sunset of an unversioned path: %v", err)
+	}
+	links := strings.Join(resp.Header.Values("Link"), ", ")
+	if !strings.Contains(links, `</api/v1/messages>; rel="successor-version"`) || !strings.Contains(links, `rel="next"`) {
+		t.Errorf("Link of an unversioned path: %q", links)
+	}
+	// Locations stay in the version that was asked.
+	resp, body := ts.do(http.MethodPost, "/api/v1/channels", adminKey, map[string]string{"name": "releases"})
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") != "/api/v1/channels/releases" {
+		t.Errorf("POST /api/v1/channels: %d, Location %q: %s", resp.StatusCode, resp.Header.Get("Location"), body)
+	}
+	if resp, body := ts.do(http.MethodGet, "/api/v2/messages", readerKey, nil); resp.StatusCode != http.StatusNotFound || !strings.Contains(body, `"error"`) {
+		t.Errorf("GET /api/v2/messages: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestEditConflict(t *testing.T) {
+	ts := newTestServer(t)
+	m := ts.messages[0]
+	path := "/api/messages/" + strconv.Itoa(m.ID)
+	patch := func(ifMatch string, body map[string]any) (*http.Response, string) {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, err := http.NewRequest(http.MethodPatch, ts.URL+path, strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		return ts.send(req, nil)
+	}
+	// The ETag is strong, one for each representation of the version.
+	resp, _ := ts.do(http.MethodGet, path, readerKey, nil)
+	etag := resp.Header.Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("ETag of an unedited message: %q", etag)
+	}
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	req.Header.Set("Accept", "application/xml")
+	req.Header.Set("Authorization", "Bearer "+readerKey)
+	if resp, _ := ts.send(req, nil); resp.Header.Get("ETag") != `"1-xml"` {
+		t.Errorf("ETag of the XML: %q, want %q", resp.Header.Get("ETag"), `"1-xml"`)
+	}
+	if resp, _ := patch("", map[string]any{"content": "no version"}); resp.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("PATCH without a version: %d, want 428", resp.StatusCode)
+	}
+	// If-Match compares strongly: only the JSON's ETag names a version.
+	for _, ifMatch := range []string{`W/"1"`, `"1-xml"`, "1", `"01"`} {
+		if resp, _ := patch(ifMatch, map[string]any{"content": "weak"}); resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("PATCH with If-Match: %s: %d, want 412", ifMatch, resp.StatusCode)
+		}
+	}
+	// Two moderators open version 1; the first to save wins.
+	resp, body := patch(etag, map[string]any{"content": "first edit"})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != `"2"` {
+		t.Fatalf("PATCH with If-Match: %d, ETag %q: %s", resp.StatusCode, resp.Header.Get("ETag"), body)
+	}
+	resp, body = patch("", map[string]any{"content": "second edit", "version": 1})
+	var conflict struct {
+		Error   string
+		Message store.Message
+	}
+	ts.decode(body, &conflict)
+	if resp.StatusCode != http.StatusConflict || conflict.Message.Content != "first edit" || conflict.Message.Version != 2 {
+		t.Errorf("PATCH of version 1 after another edit: %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := patch("*", map[string]any{"content": "second edit"}); resp.StatusCode != http.StatusOK {
+		t.Errorf("PATCH with If-Match: *: %d, want 200", resp.StatusCode)
+	}
+
+	// The edit form says so rather than overwriting.
+	resp, _ = ts.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}})
+	session := cookie(resp, "slrs_session")
+	resp, body = ts.postForm("/messages/"+strconv.Itoa(m.ID)+"/edit", url.Values{"author": {m.Author}, "content": {"from the form"}, "version": {"1"}}, session)
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(body, "from the form") || !strings.Contains(body, `name="version" value="3"`) {
+		t.Errorf("saving the edit form of version 1: %d", resp.StatusCode)
+	}
+
+	c, err := client.New(ts.URL, client.WithAPIKey(adminKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "from the client"
+	_, err = c.UpdateMessage(context.Background(), m.ID, client.MessageUpdate{Version: 2, Content: &content})
+	if cur := client.EditedMessage(err); cur == nil || cur.Version != 3 {
+		t.Errorf("UpdateMessage of version 2: %v, current %+v", err, cur)
+	}
+
+	// SQL stores check the version in the same transaction as the edit.
+	s, err := store.Open("sqlite", t.TempDir()+"/messages.db", "", store.MigrateUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	msg := store.Message{Author: "ada", Content: "posted"}
+	if err := s.Create(ctx, &msg); err != nil {
+		t.Fatal(err)
+	}
+	stale := msg
+	msg.Content = "edited"
+	if err := s.Update(ctx, &msg); err != nil || msg.Version != 2 {
+		t.Fatalf("Update: %v, version %d", err, msg.Version)
+	}
+	stale.Content = "edited too"
+	if err := s.Update(ctx, &stale); !errors.Is(err, store.ErrVersionConflict) {
+		t.Errorf("Update of version 1 after another edit: %v, want ErrVersionConflict", err)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestServer(t, func(cfg *config.Config) { cfg.RedisURL = "redis://" + mr.Addr() })
+	post := func(ts *testServer, key string, msg map[string]string) (*http.Response, store.Message) {
+		t.Helper()
+		data, _ := json.Marshal(msg)
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/messages", strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+		req.Header.Set("Idempotency-Key", key)
+		resp, body := ts.send(req, nil)
+		var m store.Message
+		if resp.StatusCode == http.StatusCreated {
+			ts.decode(body, &m)
+		}
+		return resp, m
+	}
+	msg := map[string]string{"author": "deploy-bot", "content": "Deployed v2.0.0"}
+	resp, first := post(a, "build-1234", msg)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first post: %d, replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	resp, again := post(a, "build-1234", map[string]string{"content": "Deployed v2.0.0", "author": "deploy-bot"})
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "true" || again.ID != first.ID {
+		t.Errorf("retry: %d, replayed %q, message %d, want %d", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"), again.ID, first.ID)
+	}
+	if resp, _ := post(a, "build-1234", map[string]string{"author": "deploy-bot", "content": "Deployed v2.0.1"}); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("the key with another message: %d, want 422", resp.StatusCode)
+	}
+	// A rejected post leaves the key free for the corrected one.
+	if resp, _ := post(a, "build-1235", map[string]string{"author": "deploy-bot"}); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("invalid post: %d, want 422", resp.StatusCode)
+	}
+	if resp, _ := post(a, "build-1235", msg); resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("the corrected post: %d, replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	var list []store.Message
+	_, body := a.do(http.MethodGet, "/api/messages?author=deploy-bot", readerKey, nil)
+	a.decode(body, &list)
+	if len(list) != 2 {
+		t.Errorf("deploy-bot has %d messages, want 2", len(list))
+	}
+
+	// Without Redis the keys are kept in memory; the client sends one.
+	ts := newTestServer(t)
+	c, err := client.New(ts.URL, client.WithAPIKey(adminKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm := client.NewMessage{Author: "deploy-bot", Content: "Deployed v2.0.0", IdempotencyKey: "build-1234"}
+	m1, err1 := c.CreateMessage(context.Background(), nm)
+	m2, err2 := c.CreateMessage(context.Background(), nm)
+	if err1 != nil || err2 != nil || m1.ID != m2.ID {
+		t.Errorf("CreateMessage twice with a key: %v %v, %v %v", m1, err1, m2, err2)
+	}
+
+	// A post that fails after the message is created, here saving its
+	// attachment, is not replayed: the retry posts again.
+	dir := t.TempDir() + "/attachments"
+	ts = newTestServer(t, func(cfg *config.Config) { cfg.AttachmentDir = dir })
+	os.RemoveAll(dir)
+	os.WriteFile(dir, nil, 0o600)
+	postFile := func() *http.Response {
+		t.Helper()
+		var b strings.Builder
+		mw := multipart.NewWriter(&b)
+		mw.WriteField("content", "deploy log attached")
+		fw, _ := mw.CreateFormFile("files", "deploy.log")
+		fw.Write([]byte("all good\n"))
+		mw.Close()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/messages", strings.NewReader(b.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+		req.Header.Set("Idempotency-Key", "build-1236")
+		resp, _ := ts.send(req, nil)
+		return resp
+	}
+	if resp := postFile(); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("post with an attachment that cannot be saved: %d, want 500", resp.StatusCode)
+	}
+	if resp := postFile(); resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry of the failed post: %d, replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.CacheTTL = time.Minute })
+	get := func(path, accept string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+readerKey)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	// JSON first, so that the cached page is not what XML clients get.
+	if resp, _ := get("/api/messages?per_page=2", ""); resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("without Accept: Content-Type %q, want JSON", resp.Header.Get("Content-Type"))
+	}
+	resp, body := get("/api/messages?per_page=2", "application/xml")
+	if ct := resp.Header.Get("Content-Type"); ct != "application/xml; charset=utf-8" || !strings.Contains(resp.Header.Get("Vary"), "Accept") {
+		t.Errorf("Accept: application/xml: Content-Type %q, Vary %q", ct, resp.Header.Get("Vary"))
+	}
+	var page struct {
+		Total    int `xml:"total,attr"`
+		Messages []struct {
+			ID      int    `xml:"id,attr"`
+			Author  string `xml:"author"`
+			Content string `xml:"content"`
+		} `xml:"message"`
+	}
+	if err := xml.Unmarshal([]byte(body), &page); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+	if len(page.Messages) != 2 || page.Total < len(ts.messages) || page.Messages[0].ID == 0 || page.Messages[0].Content == "" {
+		t.Errorf("XML page: %+v", page)
+	}
+
+	want := ts.messages[0]
+	resp, body = get("/api/messages/"+strconv.Itoa(want.ID), "text/plain")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" || !strings.HasPrefix(body, "id="+strconv.Itoa(want.ID)+" ") || !strings.Contains(body, "\n  "+strings.Split(want.Content, "\n")[0]) {
+		t.Errorf("Accept: text/plain: %q, %q", ct, body)
+	}
+	if resp, _ := get("/api/messages/search?q=a", "text/xml;q=0.5, text/plain;q=0.1"); resp.Header.Get("Content-Type") != "text/xml; charset=utf-8" {
+		t.Errorf("search preferring text/xml: Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+	if resp, _ := get("/api/messages", "image/png"); resp.StatusCode != http.StatusNotAcceptable || resp.Header.Get("X-Total-Count") != "" {
+		t.Errorf("Accept: image/png: status %d, X-Total-Count %q; want 406 alone", resp.StatusCode, resp.Header.Get("X-Total-Count"))
+	}
+	// Browsers rank XML above */* but have always been given JSON.
+	if resp, _ := get("/api/messages", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"); resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("a browser's Accept: Content-Type %q, want JSON", resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	shared := func(cfg *config.Config) {
+		cfg.RedisURL = "redis://" + mr.Addr()
+		cfg.CacheTTL = time.Minute
+		cfg.RateLimit, cfg.RateBurst = 1, 2
+	}
+	// Two replicas behind a load balancer without sticky sessions.
+	a, b := newTestServer(t, shared), newTestServer(t, shared)
+
+	resp, _ := a.postForm("/login", url.Values{"username": {"ada"}, "password": {testfixtures.Password}})
+	session := cookie(resp, "slrs_session")
+	if session == nil {
+		t.Fatal("login set no session cookie")
+	}
+	if resp, _ := b.get("/admin", session); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /admin on the other replica: status %d, want the session to hold", resp.StatusCode)
+	}
+
+	a.do(http.MethodGet, "/api/messages", readerKey, nil)
+	if resp, _ := b.do(http.MethodGet, "/api/messages", readerKey, nil); resp.Header.Get("X-Cache") != "HIT" {
+		t.Errorf("GET /api/messages on the other replica: X-Cache %q, want HIT", resp.Header.Get("X-Cache"))
+	}
+	post := map[string]string{"author": "ada", "content": "from either replica"}
+	b.do(http.MethodPost, "/api/messages", adminKey, post)
+	if resp, _ := a.do(http.MethodGet, "/api/messages", readerKey, nil); resp.Header.Get("X-Cache") != "MISS" {
+		t.Errorf("GET /api/messages after a write elsewhere: X-Cache %q, want MISS", resp.Header.Get("X-Cache"))
+	}
+
+	// The post above and this one spend the burst of 2 for this address.
+	a.do(http.MethodPost, "/api/messages", adminKey, post)
+	if resp, _ := b.do(http.MethodPost, "/api/messages", adminKey, post); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("third post across replicas: status %d, want 429", resp.StatusCode)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	mr := miniredis.RunT(t)
+	relay := func(cfg *config.Config) {
+		cfg.RedisURL = "redis://" + mr.Addr()
+		cfg.PubSub = "redis"
+	}
+	a, b := newTestServer(t, relay), newTestServer(t, relay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL+"/api/messages/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+readerKey)
+	resp, err := a.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b.do(http.MethodPost, "/api/messages", adminKey, map[string]string{"author": "ada", "content": "posted on the other replica"})
+	events := bufio.NewScanner(resp.Body)
+	for events.Scan() {
+		if strings.HasPrefix(events.Text(), "data: ") && strings.Contains(events.Text(), "posted on the other replica") {
+			return
+		}
+	}
+	t.Errorf("the stream on one replica never showed a message posted on the other: %v", events.Err())
+}
+
+func TestPubSubRelaysEveryMessage(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	ps := rdb.Subscribe(context.Background(), "slrs:events")
+	t.Cleanup(func() { ps.Close() })
+	if _, err := ps.Receive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var relayed atomic.Int64
+	go func() {
+		for range ps.Channel() {
+			relayed.Add(1)
+		}
+	}()
+
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.RedisURL = "redis://" + mr.Addr()
+		cfg.PubSub = "redis"
+	})
+	ts.bulkPost(200)
+	waitForCount(t, "relay", &relayed, 200)
+}
+
+func TestMigrations(t *testing.T) {
+	path := t.TempDir() + "/messages.db"
+	if _, err := store.Open("sqlite", path, "", store.MigrateCheck); err == nil {
+		t.Fatal("opened an empty database without migrating it")
+	}
+	s, err := store.Open("sqlite", path, "", store.MigrateUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	m := s.(store.Migrator)
+	ctx := context.Background()
+	list, err := m.Migrations(ctx)
+	if err != nil || len(list) == 0 || list[len(list)-1].Applied == nil {
+		t.Fatalf("migrations after opening: %+v, %v", list, err)
+	}
+
+	if err := m.MigrateTo(ctx, 0); err != nil {
+		t.Fatalf("rolling back: %v", err)
+	}
+	if v, _ := m.SchemaVersion(ctx); v != 0 {
+		t.Errorf("schema version after rolling back: %d", v)
+	}
+	if _, _, err := s.List(ctx, store.ListOptions{}); err == nil {
+		t.Error("messages table survived rolling back every migration")
+	}
+	if err := m.MigrateTo(ctx, len(list)); err != nil {
+		t.Fatalf("migrating up again: %v", err)
+	}
+	if err := s.Create(ctx, &store.Message{Author: "ada", Content: "after the round trip"}); err != nil {
+		t.Errorf("creating a message after migrating up again: %v", err)
+	}
+	if err := s.CreateChannel(ctx, &store.Channel{Name: "ops", Private: true, Users: []string{"ada"}}); err != nil {
+		t.Errorf("creating a private channel after migrating up again: %v", err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	db := []string{"-db", dir + "/messages.db"}
+	if cmd, args := findCommand([]string{"-db", "x"}); cmd == nil || cmd.name != "serve" || len(args) != 2 {
+		t.Errorf("flags alone should run serve, got %v %v", cmd, args)
+	}
+	if cmd, _ := findCommand([]string{"bogus"}); cmd != nil {
+		t.Errorf("found a command for bogus: %v", cmd.name)
+	}
+
+	in := dir + "/in.csv"
+	if err := os.WriteFile(in, []byte("author,content\nada,seeded from the command line\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSeed(append([]string{in}, db...)); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	out := dir + "/out.csv"
+	if err := runExport(append([]string{"csv", out}, db...)); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if b, _ := os.ReadFile(out); !strings.Contains(string(b), "seeded from the command line") {
+		t.Errorf("export missed the seeded message:\n%s", b)
+	}
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	user := func(password string, args ...string) error {
+		f, err := os.CreateTemp(dir, "stdin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		f.WriteString(password + "\n")
+		f.Seek(0, io.SeekStart)
+		os.Stdin = f
+		return runUser(append(args, db...))
+	}
+	if err := user("grace-hopper", "add", "grace", "moderator"); err != nil {
+		t.Fatalf("user add: %v", err)
+	}
+	if err := user("grace-hopper", "add", "grace", "moderator"); err == nil {
+		t.Error("added grace twice")
+	}
+	if err := user("short", "passwd", "grace"); err == nil {
+		t.Error("changed the password to one too short")
+	}
+	if err := user("a-new-password", "passwd", "grace"); err != nil {
+		t.Errorf("user passwd: %v", err)
+	}
+
+	s, err := store.Open("sqlite", dir+"/messages.db", "", store.MigrateCheck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	u, err := s.GetUser(context.Background(), "grace")
+	if err != nil || u.Role != store.RoleModerator || bcrypt.CompareHashAndPassword(u.PasswordHash, []byte("a-new-password")) != nil {
+		t.Errorf("grace after the user commands: %+v, %v", u, err)
+	}
+}
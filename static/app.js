@@ -1 +1,250 @@
-console.log("Go sample site loaded");
\ No newline at end of file
+// Ask before submitting forms marked with data-confirm (e.g. deletes).
+document.addEventListener("submit", function (e) {
+  var msg = e.target.dataset && e.target.dataset.confirm;
+  if (msg && !window.confirm(msg)) e.preventDefault();
+});
+
+// Dismiss the announcement banner. The cookie names the banner, so the
+// next one shows up again.
+document.addEventListener("click", function (e) {
+  var btn = e.target.closest && e.target.closest("button.banner-dismiss");
+  if (!btn) return;
+  document.cookie = "slrs_banner_dismissed=" + btn.dataset.banner + "; path=/; max-age=31536000; samesite=lax";
+  btn.parentNode.remove();
+});
+
+// Tell the server the browser's time zone so that the pages that follow
+// show times in it. A zone picked with ?tz= is in an HttpOnly cookie of
+// the same name, which this can neither see nor replace.
+(function () {
+  if (!window.Intl || /(^|; )slrs_tz=/.test(document.cookie)) return;
+  var tz = Intl.DateTimeFormat().resolvedOptions().timeZone;
+  if (tz) document.cookie = "slrs_tz=" + tz + "; path=/; max-age=31536000; samesite=lax";
+})();
+
+// Switch a message between rendered Markdown and its source.
+document.addEventListener("click", function (e) {
+  var btn = e.target.closest && e.target.closest("button.raw-toggle");
+  if (!btn) return;
+  var li = btn.parentNode;
+  var raw = btn.getAttribute("aria-pressed") !== "true";
+  li.querySelector(":scope > .content").hidden = raw;
+  li.querySelector(":scope > pre.raw").hidden = !raw;
+  btn.setAttribute("aria-pressed", raw);
+  btn.textContent = raw ? "Formatted" : "Raw";
+});
+
+// Live updates: keep the message list on the index page in sync with the
+// server via /api/v1/messages/stream.
+(function () {
+  var list = document.getElementById("messages");
+  if (!list || !window.EventSource) return;
+  // Search and tag results are a filtered snapshot; don't mix live posts
+  // into them.
+  var params = new URLSearchParams(location.search);
+  if (params.get("q") || params.get("tag")) return;
+  // Set on /c/{channel}; the whole board otherwise.
+  var channel = list.dataset.channel;
+
+  function render(msg) {
+    var li = document.createElement("li");
+    li.id = "message-" + msg.id;
+    li.dataset.id = msg.id;
+    if (msg.pinned) {
+      li.className = "pinned";
+      var pin = document.createElement("small");
+      pin.className = "pin";
+      pin.textContent = "📌 Pinned ";
+      li.appendChild(pin);
+    }
+    if (msg.avatar) {
+      var avatar = document.createElement("img");
+      avatar.className = "avatar";
+      avatar.src = msg.avatar;
+      avatar.alt = "";
+      avatar.width = avatar.height = 32;
+      li.appendChild(avatar);
+      li.appendChild(document.createTextNode(" "));
+    }
+    var author = document.createElement("strong");
+    if (msg.author) {
+      var a = document.createElement("a");
+      a.className = "author";
+      a.href = "/u/" + encodeURIComponent(msg.author);
+      a.textContent = msg.author;
+      author.appendChild(a);
+    } else {
+      author.textContent = "Anonymous";
+    }
+    li.appendChild(author);
+    li.appendChild(document.createTextNode(": "));
+    var toggle = document.createElement("button");
+    toggle.type = "button";
+    toggle.className = "raw-toggle";
+    toggle.setAttribute("aria-pressed", "false");
+    toggle.textContent = "Raw";
+    li.appendChild(toggle);
+    // content_html is sanitized on the server.
+    var content = document.createElement("div");
+    content.className = "content";
+    content.innerHTML = msg.content_html;
+    li.appendChild(content);
+    var raw = document.createElement("pre");
+    raw.className = "raw";
+    raw.hidden = true;
+    raw.textContent = msg.content;
+    li.appendChild(raw);
+    if (!channel && !msg.parent_id) {
+      var where = document.createElement("a");
+      where.className = "channel";
+      where.href = "/c/" + encodeURIComponent(msg.channel);
+      where.textContent = "in #" + msg.channel;
+      li.appendChild(where);
+    }
+    (msg.tags || []).forEach(function (tag) {
+      var a = document.createElement("a");
+      a.className = "tag";
+      a.href = location.pathname + "?tag=" + encodeURIComponent(tag);
+      a.textContent = "#" + tag;
+      li.appendChild(a);
+    });
+    if (msg.expires_at) {
+      var expires = document.createElement("small");
+      expires.className = "edited";
+      expires.textContent = " expires " + new Date(msg.expires_at).toISOString().slice(0, 16).replace("T", " ") + " UTC";
+      li.appendChild(expires);
+    }
+    if (msg.attachments) {
+      var files = document.createElement("ul");
+      files.className = "attachments";
+      msg.attachments.forEach(function (att) {
+        var item = document.createElement("li");
+        var a = document.createElement("a");
+        a.href = "/attachments/" + att.id;
+        if (/^image\//.test(att.content_type) && att.content_type !== "image/svg+xml") {
+          var img = document.createElement("img");
+          img.src = a.href;
+          img.alt = "";
+          img.loading = "lazy";
+          a.appendChild(img);
+        }
+        a.appendChild(document.createTextNode(att.name));
+        item.appendChild(a);
+        var size = document.createElement("small");
+        size.textContent = " " + byteSize(att.size);
+        item.appendChild(size);
+        files.appendChild(item);
+      });
+      li.appendChild(files);
+    }
+    return li;
+  }
+
+  function byteSize(n) {
+    if (n >= 1 << 20) return (n / (1 << 20)).toFixed(1) + " MB";
+    if (n >= 1 << 10) return (n / (1 << 10)).toFixed(1) + " KB";
+    return n + " B";
+  }
+
+  function find(id) {
+    return list.querySelector('li[data-id="' + id + '"]');
+  }
+
+  // Replies go at the end of their parent's nested list; new threads go on top.
+  function add(msg) {
+    var parent = msg.parent_id && find(msg.parent_id);
+    if (!parent) {
+      list.insertBefore(render(msg), list.firstChild);
+      return;
+    }
+    var replies = parent.querySelector(":scope > ul.replies");
+    if (!replies) {
+      replies = document.createElement("ul");
+      replies.className = "replies";
+      parent.appendChild(replies);
+    }
+    replies.appendChild(render(msg));
+  }
+
+  var source = new EventSource("/api/v1/messages/stream?html=1" + (channel ? "&channel=" + encodeURIComponent(channel) : ""));
+  function created(e) {
+    var msg = JSON.parse(e.data);
+    if (!find(msg.id)) add(msg);
+  }
+  source.addEventListener("created", created);
+  source.addEventListener("restored", created);
+  source.addEventListener("updated", function (e) {
+    var msg = JSON.parse(e.data);
+    var old = find(msg.id);
+    if (!old) return;
+    var li = render(msg);
+    // Edits arrive without the attachments; keep the ones shown.
+    var files = old.querySelector(":scope > ul.attachments");
+    if (files && !msg.attachments) li.appendChild(files);
+    var replies = old.querySelector(":scope > ul.replies");
+    if (replies) li.appendChild(replies);
+    old.parentNode.replaceChild(li, old);
+  });
+  // A new message mentions the signed-in user: mark it and, once they
+  // allow it, raise a desktop notification.
+  source.addEventListener("mention", function (e) {
+    var msg = JSON.parse(e.data);
+    var li = find(msg.id);
+    if (li) li.classList.add("mentioned");
+    if (!window.Notification || Notification.permission === "denied") return;
+    function show() {
+      var n = new Notification((msg.author || "Anonymous") + " mentioned you", { body: msg.content, tag: "message-" + msg.id });
+      n.onclick = function () {
+        window.focus();
+        location.href = "/c/" + encodeURIComponent(msg.channel) + "#message-" + msg.id;
+      };
+    }
+    if (Notification.permission === "granted") show();
+    else Notification.requestPermission().then(function (p) { if (p === "granted") show(); });
+  });
+  source.addEventListener("deleted", function (e) {
+    var old = find(JSON.parse(e.data).id);
+    if (!old) return;
+    // The server moves replies up a level; mirror that.
+    var replies = old.querySelector(":scope > ul.replies");
+    while (replies && replies.firstChild) old.parentNode.insertBefore(replies.firstChild, old);
+    old.remove();
+  });
+})();
+
+// Post from the board without a reload: /partials/submit answers with the
+// post form again, cleared or showing what was wrong, and the list is
+// then fetched again from /partials/messages. Without fetch, or if the
+// request fails, the form is submitted as usual.
+(function () {
+  var post = document.getElementById("post");
+  var list = document.getElementById("messages");
+  if (!post || !list || !window.fetch || !window.FormData) return;
+  var channel = list.dataset.channel;
+  var board = channel ? "?channel=" + encodeURIComponent(channel) : "";
+
+  function refresh() {
+    var params = new URLSearchParams(location.search);
+    params.delete("lang");
+    params.delete("tz");
+    if (channel) params.set("channel", channel);
+    fetch("/partials/messages?" + params, { credentials: "same-origin" }).then(function (resp) {
+      if (resp.ok) return resp.text().then(function (html) { list.innerHTML = html; });
+    });
+  }
+
+  post.addEventListener("submit", function (e) {
+    var form = e.target;
+    if (form.id !== "post-form" || e.defaultPrevented) return;
+    e.preventDefault();
+    fetch("/partials/submit" + board, { method: "POST", body: new FormData(form), credentials: "same-origin" })
+      .then(function (resp) {
+        if (!resp.ok && resp.status !== 422) throw new Error("status " + resp.status);
+        return resp.text().then(function (html) {
+          post.innerHTML = html;
+          if (resp.ok) refresh();
+        });
+      })
+      .catch(function () { form.submit(); });
+  });
+})();